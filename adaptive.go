@@ -0,0 +1,73 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// adaptiveMinSamples is the number of requests a route must have served before its timeout adapts.
+	adaptiveMinSamples = 20
+	// adaptiveWindow is the number of samples after which the latency histogram of a route is halved, so that the
+	// older samples weigh exponentially less than the recent ones.
+	adaptiveWindow = 1000
+)
+
+// adaptive computes the timeout of each route from a percentile of its recent latency, see WithAdaptive.
+type adaptive struct {
+	routes     sync.Map
+	percentile float64
+	multiplier float64
+	floor      time.Duration
+	ceiling    time.Duration
+}
+
+// adaptiveRoute is the decaying latency histogram of a route and the timeout derived from it.
+type adaptiveRoute struct {
+	// dt is the adapted timeout, or zero until the route served enough requests.
+	dt      atomic.Int64
+	mu      sync.Mutex
+	latency [latencyBuckets]uint64
+	total   uint64
+}
+
+// lookup returns the adapted timeout of the given route pattern key, if the route served enough requests.
+func (a *adaptive) lookup(key string) (time.Duration, bool) {
+	r, ok := a.routes.Load(key)
+	if !ok {
+		return 0, false
+	}
+	dt := time.Duration(r.(*adaptiveRoute).dt.Load())
+	return dt, dt > 0
+}
+
+// observe records the latency of a request of the given route pattern key and updates the adapted timeout.
+func (a *adaptive) observe(key string, elapsed time.Duration) {
+	r, ok := a.routes.Load(key)
+	if !ok {
+		r, _ = a.routes.LoadOrStore(key, new(adaptiveRoute))
+	}
+	ar := r.(*adaptiveRoute)
+
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	ar.latency[latencyBucket(elapsed)]++
+	ar.total++
+	if ar.total >= adaptiveWindow {
+		ar.total = 0
+		for i := range ar.latency {
+			ar.latency[i] /= 2
+			ar.total += ar.latency[i]
+		}
+	}
+	if ar.total < adaptiveMinSamples && ar.dt.Load() == 0 {
+		return
+	}
+	dt := time.Duration(float64(percentile(&ar.latency, ar.total, a.percentile)) * a.multiplier)
+	ar.dt.Store(int64(min(max(dt, a.floor), a.ceiling)))
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptive_Observe(t *testing.T) {
+	a := &adaptive{percentile: 0.99, multiplier: 2, floor: time.Millisecond, ceiling: time.Second}
+
+	for range adaptiveMinSamples - 1 {
+		a.observe("/foo", 10*time.Millisecond)
+	}
+	_, ok := a.lookup("/foo")
+	assert.False(t, ok)
+
+	a.observe("/foo", 10*time.Millisecond)
+	dt, ok := a.lookup("/foo")
+	require.True(t, ok)
+	// The percentile is approximated within 10%.
+	assert.InDelta(t, 20*time.Millisecond, dt, float64(2*time.Millisecond))
+
+	// The older samples decay until they fall beyond the percentile.
+	for range 3 * adaptiveWindow {
+		a.observe("/foo", 50*time.Microsecond)
+	}
+	dt, _ = a.lookup("/foo")
+	assert.Equal(t, time.Millisecond, dt)
+
+	for range adaptiveWindow {
+		a.observe("/foo", time.Minute)
+	}
+	dt, _ = a.lookup("/foo")
+	assert.Equal(t, time.Second, dt)
+
+	_, ok = a.lookup("/bar")
+	assert.False(t, ok)
+}
+
+func TestMiddleware_WithAdaptive(t *testing.T) {
+	explained := make(chan []Step, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithAdaptive(0.99, 2, 5*time.Millisecond, 500*time.Millisecond))))
+	require.NoError(t, err)
+	slow := false
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		if slow {
+			time.Sleep(50 * time.Millisecond)
+		}
+		explained <- ResolveExplain(c)
+		c.Writer().WriteHeader(http.StatusOK)
+	})
+
+	for range adaptiveMinSamples {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		steps := <-explained
+		require.Len(t, steps, 5)
+		assert.Equal(t, SourceAdaptive, steps[2].Source)
+		assert.False(t, steps[2].Found)
+		assert.True(t, steps[4].Applied)
+	}
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+	steps := <-explained
+	assert.Equal(t, Step{Source: SourceAdaptive, Timeout: 5 * time.Millisecond, Found: true, Applied: true}, steps[2])
+
+	// The fast route adapted to the floor, so a slow request now times out.
+	slow = true
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestTimeout_InvalidateAdaptive(t *testing.T) {
+	tm := New(time.Second, WithAdaptive(0.99, 2, time.Millisecond, time.Second))
+	for range adaptiveMinSamples {
+		tm.cfg.adaptive.observe("/foo", time.Millisecond)
+	}
+	_, ok := tm.cfg.adaptive.lookup("/foo")
+	require.True(t, ok)
+
+	tm.Invalidate("/foo")
+	_, ok = tm.cfg.adaptive.lookup("/foo")
+	assert.False(t, ok)
+}
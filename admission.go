@@ -0,0 +1,233 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// The parameters of the CoDel queue policy, as commonly used for request queues.
+const (
+	// codelTarget is how long a request may wait once the queue is congested.
+	codelTarget = 5 * time.Millisecond
+	// codelInterval is how long a request may wait while the queue is not congested, and for how long the queue
+	// must not have been empty to be considered congested.
+	codelInterval = 100 * time.Millisecond
+)
+
+// QueuePolicy controls the order in which the requests waiting in the admission queue are admitted, and how long
+// they may wait, see [WithAdmissionQueue].
+type QueuePolicy uint8
+
+const (
+	// QueueFIFO admits the requests in their order of arrival. They wait until admitted or canceled by the client.
+	QueueFIFO QueuePolicy = iota + 1
+	// QueueCoDel admits the requests in their order of arrival while the queue drains regularly, but once the queue
+	// has not been empty for 100ms, it is considered congested: the freshest requests are admitted first (adaptive
+	// LIFO), whose client is the most likely to still be waiting, and the requests that waited for longer than 5ms
+	// are dropped. Otherwise, requests are dropped after waiting for 100ms. This is the standard remedy for the
+	// congestion collapse where the server only serves requests whose client already timed out.
+	QueueCoDel
+)
+
+// String returns the name of the queue policy.
+func (p QueuePolicy) String() string {
+	switch p {
+	case QueueFIFO:
+		return "fifo"
+	case QueueCoDel:
+		return "codel"
+	default:
+		return "unknown"
+	}
+}
+
+// ShedReason is the reason why a request is rejected by the admission control.
+type ShedReason uint8
+
+const (
+	// ShedAge is the reason of the requests older than the maximum set with [WithMaxRequestAge].
+	ShedAge ShedReason = iota + 1
+	// ShedQueueFull is the reason of the requests arriving while the admission queue is full, see
+	// [WithAdmissionQueue].
+	ShedQueueFull
+	// ShedQueueTimeout is the reason of the requests that waited too long in the admission queue, see [QueueCoDel]
+	// and [WithMaxConcurrent], or whose client went away meanwhile.
+	ShedQueueTimeout
+	// ShedDraining is the reason of the requests arriving during the graceful shutdown of the server whose timeout
+	// would run past the drain deadline, see [WithShutdownContext].
+	ShedDraining
+)
+
+// String returns the name of the shed reason.
+func (r ShedReason) String() string {
+	switch r {
+	case ShedAge:
+		return "age"
+	case ShedQueueFull:
+		return "queue_full"
+	case ShedQueueTimeout:
+		return "queue_timeout"
+	case ShedDraining:
+		return "draining"
+	default:
+		return "unknown"
+	}
+}
+
+// ShedCounters are the numbers of requests rejected by the admission control, by reason, see [Timeout.ShedCounts].
+type ShedCounters struct {
+	// Age is the number of requests rejected with [ShedAge].
+	Age uint64
+	// QueueFull is the number of requests rejected with [ShedQueueFull].
+	QueueFull uint64
+	// QueueTimeout is the number of requests rejected with [ShedQueueTimeout].
+	QueueTimeout uint64
+	// Draining is the number of requests rejected with [ShedDraining].
+	Draining uint64
+}
+
+// ShedCounts returns the numbers of requests rejected by the admission control since the middleware was created, by
+// reason. The rejected requests are also counted by route in [RouteCounters.Shed].
+func (t *Timeout) ShedCounts() ShedCounters {
+	return ShedCounters{
+		Age:          t.shedCounts[ShedAge].Load(),
+		QueueFull:    t.shedCounts[ShedQueueFull].Load(),
+		QueueTimeout: t.shedCounts[ShedQueueTimeout].Load(),
+		Draining:     t.shedCounts[ShedDraining].Load(),
+	}
+}
+
+// admission limits the number of requests served concurrently, queuing the others, see WithAdmissionQueue.
+type admission struct {
+	limit  int
+	size   int
+	policy QueuePolicy
+	// wait is how long a request may wait in the queue with QueueFIFO, if positive, see WithMaxConcurrent.
+	wait time.Duration
+	mu   sync.Mutex
+	// running is the number of requests admitted and not yet released.
+	running int
+	// queue holds the waiting requests, in their order of arrival.
+	queue []*queued
+	// lastEmpty is when the queue was last seen empty.
+	lastEmpty time.Time
+}
+
+type queued struct {
+	// admitted is closed once the request is admitted, the slot of a released request being handed over.
+	admitted chan struct{}
+}
+
+// acquire admits the request, possibly after waiting in the queue, or returns the reason why it is rejected. The
+// admitted request must be released with release once served. The queue is drained according to the clock.
+func (a *admission) acquire(ctx context.Context, clock Clock) ShedReason {
+	now := clock.Now()
+	a.mu.Lock()
+	if len(a.queue) == 0 {
+		a.lastEmpty = now
+		if a.running < a.limit {
+			a.running++
+			a.mu.Unlock()
+			return 0
+		}
+	}
+	if len(a.queue) >= a.size {
+		a.mu.Unlock()
+		return ShedQueueFull
+	}
+	q := &queued{admitted: make(chan struct{})}
+	a.queue = append(a.queue, q)
+	var expired <-chan time.Time
+	wait := a.wait
+	if a.policy == QueueCoDel {
+		wait = codelInterval
+		if a.congested(now) {
+			wait = codelTarget
+		}
+	}
+	if wait > 0 {
+		timer := clock.NewTimer(wait)
+		defer timer.Stop()
+		expired = timer.C()
+	}
+	a.mu.Unlock()
+
+	select {
+	case <-q.admitted:
+		return 0
+	case <-expired:
+	case <-ctx.Done():
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-q.admitted:
+		// The request was admitted meanwhile.
+		return 0
+	default:
+	}
+	a.queue = slices.DeleteFunc(a.queue, func(other *queued) bool { return other == q })
+	return ShedQueueTimeout
+}
+
+// newMaxConcurrent returns the admission of at most n concurrent requests, the others waiting for up to wait, see
+// WithMaxConcurrent. It returns nil if n <= 0.
+func newMaxConcurrent(n int, wait time.Duration) *admission {
+	if n <= 0 {
+		return nil
+	}
+	a := &admission{limit: n, policy: QueueFIFO, wait: wait}
+	if wait > 0 {
+		a.size = math.MaxInt
+	}
+	return a
+}
+
+// routeAdmission returns the admission of the route set with OverrideMaxConcurrent, if any.
+func (t *Timeout) routeAdmission(c *fox.Context) *admission {
+	if t.outer {
+		return nil
+	}
+	r := t.route(c.Route())
+	if r == nil {
+		return nil
+	}
+	a, _ := r.Annotation(lKey{}).(*admission)
+	return a
+}
+
+// release releases the slot of an admitted request, handing it over to a waiting request if any.
+func (a *admission) release(clock Clock) {
+	now := clock.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.queue) == 0 {
+		a.lastEmpty = now
+		a.running--
+		return
+	}
+	var q *queued
+	if a.policy == QueueCoDel && a.congested(now) {
+		q, a.queue = a.queue[len(a.queue)-1], a.queue[:len(a.queue)-1]
+	} else {
+		q, a.queue = a.queue[0], a.queue[1:]
+	}
+	if len(a.queue) == 0 {
+		a.lastEmpty = now
+	}
+	close(q.admitted)
+}
+
+// congested reports whether the queue has not been empty for longer than the CoDel interval.
+func (a *admission) congested(now time.Time) bool {
+	return now.Sub(a.lastEmpty) > codelInterval
+}
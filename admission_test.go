@@ -0,0 +1,240 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queueLen(a *admission) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.queue)
+}
+
+func TestAdmission_FIFO(t *testing.T) {
+	a := &admission{limit: 1, size: 2, policy: QueueFIFO}
+	clock := realClock{}
+	require.Zero(t, a.acquire(context.Background(), clock))
+
+	admitted := make(chan int, 2)
+	for i := range 2 {
+		go func() {
+			if a.acquire(context.Background(), clock) == 0 {
+				admitted <- i
+			}
+		}()
+		require.Eventually(t, func() bool { return queueLen(a) == i+1 }, time.Second, time.Millisecond)
+	}
+	assert.Equal(t, ShedQueueFull, a.acquire(context.Background(), clock))
+
+	a.release(clock)
+	assert.Equal(t, 0, <-admitted)
+	a.release(clock)
+	assert.Equal(t, 1, <-admitted)
+	a.release(clock)
+	assert.Zero(t, a.running)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.Zero(t, a.acquire(ctx, clock))
+	cancel()
+	assert.Equal(t, ShedQueueTimeout, a.acquire(ctx, clock))
+	assert.Zero(t, queueLen(a))
+}
+
+func TestAdmission_CoDel(t *testing.T) {
+	clock := realClock{}
+
+	t.Run("drop when congested", func(t *testing.T) {
+		a := &admission{limit: 1, size: 2, policy: QueueCoDel}
+		require.Zero(t, a.acquire(context.Background(), clock))
+		// The queue is congested once it has not been empty for longer than the interval.
+		ctx, cancel := context.WithCancel(context.Background())
+		waiting := make(chan ShedReason)
+		go func() {
+			waiting <- a.acquire(ctx, clock)
+		}()
+		require.Eventually(t, func() bool { return queueLen(a) == 1 }, time.Second, time.Millisecond)
+		a.mu.Lock()
+		a.lastEmpty = clock.Now().Add(-time.Second)
+		a.mu.Unlock()
+		start := time.Now()
+		assert.Equal(t, ShedQueueTimeout, a.acquire(context.Background(), clock))
+		assert.Less(t, time.Since(start), codelInterval)
+		cancel()
+		assert.Equal(t, ShedQueueTimeout, <-waiting)
+		assert.Zero(t, queueLen(a))
+	})
+
+	t.Run("drop when not congested", func(t *testing.T) {
+		a := &admission{limit: 1, size: 1, policy: QueueCoDel}
+		require.Zero(t, a.acquire(context.Background(), clock))
+		start := time.Now()
+		assert.Equal(t, ShedQueueTimeout, a.acquire(context.Background(), clock))
+		assert.GreaterOrEqual(t, time.Since(start), codelInterval)
+	})
+
+	t.Run("adaptive lifo", func(t *testing.T) {
+		first, last := &queued{admitted: make(chan struct{})}, &queued{admitted: make(chan struct{})}
+		a := &admission{
+			limit:     1,
+			size:      2,
+			policy:    QueueCoDel,
+			running:   1,
+			queue:     []*queued{first, last},
+			lastEmpty: clock.Now().Add(-time.Second),
+		}
+		a.release(clock)
+		assert.Equal(t, []*queued{first}, a.queue)
+		assert.Equal(t, 1, a.running)
+		select {
+		case <-last.admitted:
+		default:
+			t.Fatal("the freshest request was not admitted")
+		}
+	})
+}
+
+func TestMiddleware_WithAdmissionQueue(t *testing.T) {
+	tm := New(time.Second, WithAdmissionQueue(1, 0, QueueFIFO))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	started, unblock := make(chan struct{}, 1), make(chan struct{})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-unblock
+		_ = c.String(http.StatusOK, "ok")
+	})
+	f.MustAdd(fox.MethodGet, "/fast", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, "ok")
+	}, OverrideHandler(NoTimeout))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// The requests served without a timeout are not queued.
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	close(unblock)
+	<-done
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, ShedCounters{QueueFull: 1}, tm.ShedCounts())
+	assert.Equal(t, uint64(1), tm.Stats()["/slow"].Shed)
+}
+
+func TestShedReason_String(t *testing.T) {
+	assert.Equal(t, "age", ShedAge.String())
+	assert.Equal(t, "queue_full", ShedQueueFull.String())
+	assert.Equal(t, "queue_timeout", ShedQueueTimeout.String())
+	assert.Equal(t, "draining", ShedDraining.String())
+	assert.Equal(t, "unknown", ShedReason(0).String())
+	assert.Equal(t, "codel", QueueCoDel.String())
+}
+
+func TestMiddleware_WithMaxConcurrent(t *testing.T) {
+	tm := New(time.Second, WithMaxConcurrent(1, 50*time.Millisecond))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		started <- struct{}{}
+		<-unblock
+		_ = c.String(http.StatusOK, "ok")
+	})
+	f.MustAdd(fox.MethodGet, "/fast", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, "ok")
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+	<-started
+
+	// The request waits for the queue timeout, then gives up.
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, ShedCounters{QueueTimeout: 1}, tm.ShedCounts())
+
+	// The request is admitted once the slot is released.
+	queued := httptest.NewRecorder()
+	admitted := make(chan struct{})
+	go func() {
+		defer close(admitted)
+		f.ServeHTTP(queued, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	}()
+	a := tm.cfg.admission
+	require.Eventually(t, func() bool { return queueLen(a) == 1 }, time.Second, time.Millisecond)
+	close(unblock)
+	<-done
+	<-admitted
+	assert.Equal(t, http.StatusOK, queued.Code)
+}
+
+func TestOverrideMaxConcurrent(t *testing.T) {
+	tm := New(time.Second)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	f.MustAdd(fox.MethodGet, "/limited", func(c *fox.Context) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-unblock
+		_ = c.String(http.StatusOK, "ok")
+	}, OverrideMaxConcurrent(1, 0))
+	f.MustAdd(fox.MethodGet, "/other", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, "ok")
+	}, OverrideMaxConcurrent(0, 0))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/limited", nil))
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/limited", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	close(unblock)
+	<-done
+	assert.Equal(t, ShedCounters{QueueFull: 1}, tm.ShedCounts())
+	assert.Equal(t, uint64(1), tm.Stats()["/limited"].Shed)
+}
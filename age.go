@@ -0,0 +1,62 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestAge rejects the requests older than max, as told by the start timestamp an upstream proxy sets in a
+// request header.
+type requestAge struct {
+	name string
+	max  time.Duration
+}
+
+// expired reports whether the request with the given header started more than max before now.
+func (ra *requestAge) expired(h http.Header, now time.Time) bool {
+	v := h.Get(ra.name)
+	if v == "" {
+		return false
+	}
+	start, ok := parseRequestStart(v)
+	if !ok {
+		return false
+	}
+	return now.Sub(start) > ra.max
+}
+
+// parseRequestStart parses a request start timestamp as set by proxies, either as a number of seconds since the
+// epoch with a fractional part (e.g. "t=1700000000.123" as set by NGINX with "t=${msec}"), or as an integer number
+// of seconds, milliseconds, microseconds or nanoseconds since the epoch, told apart by their magnitude. The "t="
+// prefix is optional.
+func parseRequestStart(v string) (time.Time, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "t=")
+	if strings.Contains(v, ".") {
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(secs > 0) || secs > float64(math.MaxInt64/time.Second) {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(secs*float64(time.Second))), true
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, false
+	}
+	switch {
+	case n < 1e11:
+		return time.Unix(n, 0), true
+	case n < 1e14:
+		return time.UnixMilli(n), true
+	case n < 1e17:
+		return time.UnixMicro(n), true
+	default:
+		return time.Unix(0, n), true
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithMaxRequestAge(t *testing.T) {
+	tm := New(time.Second, WithMaxRequestAge("X-Request-Start", 5*time.Second))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	now := time.Now()
+	cases := []struct {
+		name  string
+		start string
+		want  int
+	}{
+		{name: "no header", want: http.StatusCreated},
+		{name: "fresh", start: "t=" + strconv.FormatFloat(float64(now.UnixMilli())/1e3, 'f', 3, 64), want: http.StatusCreated},
+		{name: "stale seconds", start: "t=" + strconv.FormatFloat(float64(now.Add(-time.Minute).UnixMilli())/1e3, 'f', 3, 64), want: http.StatusTooManyRequests},
+		{name: "stale milliseconds", start: strconv.FormatInt(now.Add(-time.Minute).UnixMilli(), 10), want: http.StatusTooManyRequests},
+		{name: "invalid", start: "yesterday", want: http.StatusCreated},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			if tc.start != "" {
+				req.Header.Set("X-Request-Start", tc.start)
+			}
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+			assert.Equal(t, tc.want, w.Code)
+		})
+	}
+	rc := tm.Snapshot().Routes["/foo"]
+	assert.Equal(t, uint64(2), rc.Shed)
+	assert.Equal(t, uint64(3), rc.Requests)
+}
+
+func TestParseRequestStart(t *testing.T) {
+	want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, v := range []string{
+		"t=1704164645.000",
+		"1704164645.0",
+		"1704164645",
+		"1704164645000",
+		"1704164645000000",
+		"1704164645000000000",
+	} {
+		start, ok := parseRequestStart(v)
+		require.True(t, ok, v)
+		assert.True(t, want.Equal(start), v)
+	}
+	for _, v := range []string{"", "t=", "-1", "abc", "1e400"} {
+		_, ok := parseRequestStart(v)
+		assert.False(t, ok, v)
+	}
+}
+
+func FuzzParseRequestStart(f *testing.F) {
+	for _, v := range []string{"t=1700000000.123", "1700000000", "1700000000123", "1700000000123456", "1700000000123456789", "t=-1", "1e308"} {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, v string) {
+		start, ok := parseRequestStart(v)
+		if ok && start.Before(time.Unix(0, 0)) {
+			t.Fatalf("parseRequestStart(%q) = %s, want a time after the epoch", v, start)
+		}
+	})
+}
@@ -14,10 +14,93 @@ type (
 	hKey struct{}
 	rKey struct{}
 	wKey struct{}
+	sKey struct{}
+	fKey struct{}
+	aKey struct{}
+	mKey struct{}
+	bKey struct{}
+	eKey struct{}
+	iKey struct{}
+	pKey struct{}
+	dKey struct{}
+	oKey struct{}
+	qKey struct{}
+	cKey struct{}
+	tKey struct{}
+	lKey struct{}
+	kKey struct{}
+	xKey struct{}
+	// yKey is the key of the first-byte timeout, which is only set with OverrideProfile.
+	yKey struct{}
+	// methodKey is the key of the timeout set for a request method with OverrideMethod.
+	methodKey struct {
+		method string
+	}
 )
 
 const NoTimeout = time.Duration(0)
 
+// Strictness is a named level bundling how aggressively the middleware deals with a handler that exceeded its
+// deadline.
+type Strictness uint8
+
+const (
+	// Lenient lets the handler run undisturbed after a timeout: the request body is not canceled and panics
+	// occurring in the handler after the timeout are silently discarded.
+	Lenient Strictness = iota + 1
+	// Standard cancels the request body after a timeout, and silently discards panics occurring in the handler after
+	// the timeout. This is the default behavior of the middleware.
+	Standard
+	// Strict cancels the request body after a timeout, asks the server to close the connection once the timeout
+	// response is sent, logs panics occurring in the handler after the timeout along with their stack trace, and
+	// captures the stack trace of the handler still running after the timeout, as with [WithStackDump].
+	Strict
+)
+
+// AbandonPolicy controls how the middleware treats a handler that is still running after its deadline.
+type AbandonPolicy uint8
+
+const (
+	// Immediate actively tries to unblock the handler: its context is canceled and its request body is canceled.
+	// This is the default behavior, unless the [Strictness] level is [Lenient].
+	Immediate AbandonPolicy = iota + 1
+	// Graceful cancels the handler context, but does not cancel the request body, letting the handler wind down
+	// on its own terms.
+	Graceful
+	// Never lets the handler run undisturbed: its context is neither canceled at the deadline nor when the request
+	// completes, and its request body is not canceled. This suits handlers holding locks or running transactions
+	// that must be allowed to finish cleanly.
+	Never
+)
+
+// String returns the name of the abandon policy.
+func (a AbandonPolicy) String() string {
+	switch a {
+	case Immediate:
+		return "immediate"
+	case Graceful:
+		return "graceful"
+	case Never:
+		return "never"
+	default:
+		return "unknown"
+	}
+}
+
+// String returns the name of the strictness level.
+func (s Strictness) String() string {
+	switch s {
+	case Lenient:
+		return "lenient"
+	case Standard:
+		return "standard"
+	case Strict:
+		return "strict"
+	default:
+		return "unknown"
+	}
+}
+
 // OverrideHandler returns a RouteOption that sets a custom timeout duration for a specific route.
 // This allows individual routes to have different timeout values than the global timeout.
 // Passing a value <= 0 (or NoTimeout) disables the timeout for this route.
@@ -25,18 +108,153 @@ func OverrideHandler(dt time.Duration) fox.RouteOption {
 	return fox.WithAnnotation(hKey{}, dt)
 }
 
+// OverrideMethod returns a RouteOption that sets a custom timeout duration for the requests of a specific route with
+// the given method, e.g. so that POST and PUT requests doing heavy validation get a longer budget than GET requests,
+// without registering the route twice. It can be repeated for several methods, and takes precedence over the
+// [OverrideHandler] route option, which applies to the other methods. Passing a value <= 0 (or NoTimeout) disables
+// the timeout for the requests with this method.
+func OverrideMethod(method string, dt time.Duration) fox.RouteOption {
+	return fox.WithAnnotation(methodKey{method: method}, dt)
+}
+
 // OverrideRead returns a RouteOption that sets the read deadline for the underlying connection.
-// This controls how long the server will wait before timing out while reading the request body.
+// This controls how long the server will wait before timing out while reading the request body. The value must be
+// positive, see [ValidateRoute].
 func OverrideRead(dt time.Duration) fox.RouteOption {
 	return fox.WithAnnotation(rKey{}, dt)
 }
 
 // OverrideWrite returns a RouteOption that sets the write deadline for the underlying connection.
-// This controls how long the server will wait before timing out writes to the client.
+// This controls how long the server will wait before timing out writes to the client. The value must be positive and
+// not shorter than the timeout of the route, so that the timeout response can be sent, see [ValidateRoute].
 func OverrideWrite(dt time.Duration) fox.RouteOption {
 	return fox.WithAnnotation(wKey{}, dt)
 }
 
+// OverrideBody returns a RouteOption that bounds the time taken to read the entire request body of a specific route
+// to dt, measured from the first read, so that handlers reading the body lazily are not constrained by the handler
+// execution time. Once dt is exceeded, reads return a [*BodyTimeoutError]. It takes precedence over the
+// [WithBodyTimeout] option, and a value <= 0 disables the body timeout for the route.
+func OverrideBody(dt time.Duration) fox.RouteOption {
+	return fox.WithAnnotation(dKey{}, dt)
+}
+
+// OverrideWriteIdle returns a RouteOption that sets a sliding write deadline for the underlying connection: the
+// deadline is advanced to dt from now each time the handler writes, so that large responses making steady progress
+// are not cut off, but only clients that stall for longer than dt. It takes precedence over the [OverrideWrite] route
+// option and the [WithWriteDeadline] and [WithIdleWriteTimeout] options.
+func OverrideWriteIdle(dt time.Duration) fox.RouteOption {
+	return fox.WithAnnotation(iKey{}, dt)
+}
+
+// OverrideWritePerMB returns a RouteOption that derives the write deadline for the underlying connection from the
+// size of the response, allowing dt per megabyte, rounded up to the next megabyte. The size is known once the handler
+// sets the Content-Length header and writes the response header or, for a buffered response, once the handler is
+// done. Until then, and if the size remains unknown, the write deadline set with [OverrideWrite] or
+// [WithWriteDeadline] applies. The [OverrideWriteIdle] route option and the [WithIdleWriteTimeout] option take
+// precedence over this one.
+func OverrideWritePerMB(dt time.Duration) fox.RouteOption {
+	return fox.WithAnnotation(pKey{}, dt)
+}
+
+// OverrideStrictness returns a RouteOption that sets the [Strictness] level for a specific route. The level takes
+// precedence over the equivalent middleware options, such as [WithBodyCancellation].
+func OverrideStrictness(level Strictness) fox.RouteOption {
+	return fox.WithAnnotation(sKey{}, level)
+}
+
+// OverrideFallbackRoute returns a RouteOption that, when the handler of a specific route times out, dispatches the
+// request to the handler of another registered route instead of sending the timeout response. The fallback route
+// is looked up by pattern and must handle the request method, and it is typically a fast variant of the original
+// route, such as a cached or simplified version of a page. The fallback handler is bounded by the budget set with
+// [WithFallbackBudget], after which the configured timeout response is sent. Note that the fallback handler does
+// not run the route-specific middleware of the fallback route, and the request parameters are those of the
+// original route. If no matching route is found, the configured timeout response is sent.
+func OverrideFallbackRoute(pattern string) fox.RouteOption {
+	return fox.WithAnnotation(fKey{}, pattern)
+}
+
+// OverrideAbandon returns a RouteOption that sets the [AbandonPolicy] for a specific route, controlling whether the
+// middleware actively tries to unblock the handler once it timed out. It takes precedence over the [Strictness]
+// level regarding the cancellation of the handler context and request body. In every case, the timeout response
+// is sent on time, and writes to the response by the handler return http.ErrHandlerTimeout.
+func OverrideAbandon(policy AbandonPolicy) fox.RouteOption {
+	return fox.WithAnnotation(aKey{}, policy)
+}
+
+// OverrideStreaming returns a RouteOption that enables or disables the streaming mode for a specific route. It
+// takes precedence over the [WithStreaming] option.
+func OverrideStreaming(enable bool) fox.RouteOption {
+	return fox.WithAnnotation(mKey{}, enable)
+}
+
+// OverrideEventSink returns a RouteOption that sets the [EventSink] receiving the events of a specific route instead
+// of the one configured with [WithEventSink], so that sensitive or high-volume routes can send their events
+// elsewhere. A nil sink discards the events of the route.
+func OverrideEventSink(sink EventSink) fox.RouteOption {
+	if sink == nil {
+		sink = EventSinkFunc(func(Event) {})
+	}
+	return fox.WithAnnotation(eKey{}, sink)
+}
+
+// OverrideTrusted returns a RouteOption that sets the timeout of a specific route for the requests from trusted
+// callers, as told by the predicate set with [WithTrustedBypass], taking precedence over any other timeout. Passing
+// a value <= 0 (or NoTimeout) exempts the trusted requests from the timeout, while a value longer than the timeout
+// of the route extends it. The other requests keep the timeout of the route.
+func OverrideTrusted(dt time.Duration) fox.RouteOption {
+	return fox.WithAnnotation(tKey{}, dt)
+}
+
+// OverrideMaxConcurrent returns a RouteOption that limits the number of requests of a specific route served
+// concurrently to n, on top of the limit of the middleware, if any, as [WithMaxConcurrent] does for the whole
+// middleware. The limit is held by the returned option, so the routes registered with the same option value share
+// it. A n <= 0 disables the limit of the route.
+func OverrideMaxConcurrent(n int, queueTimeout time.Duration) fox.RouteOption {
+	if a := newMaxConcurrent(n, queueTimeout); a != nil {
+		return fox.WithAnnotation(lKey{}, a)
+	}
+	// Annotated as untyped nil, so that the route is not limited.
+	return fox.WithAnnotation(lKey{}, nil)
+}
+
+// OverrideHeartbeat returns a RouteOption that turns the timeout of a specific route into an idle timeout: the
+// deadline of the handler is set to idle from the start of the request, and reset to idle from now each time the
+// handler calls [Heartbeat], so that long-poll and queue-consumer handlers stay alive as long as they periodically
+// tick. The timeout of the route still bounds the request as a whole, so it should be set accordingly, e.g. with
+// [OverrideHandler]. A value <= 0, or not shorter than the timeout of the route, disables the idle timeout.
+func OverrideHeartbeat(idle time.Duration) fox.RouteOption {
+	return fox.WithAnnotation(kKey{}, idle)
+}
+
+// OverrideResponse returns a RouteOption that sets the handler writing the timeout response of a specific route
+// instead of the one configured with [WithResponse], so that routes sharing the middleware can render their own
+// timeout payload, e.g. JSON for an API and HTML for pages. The fallback route set with [OverrideFallbackRoute], if
+// any, takes precedence. A nil h is ignored.
+func OverrideResponse(h fox.HandlerFunc) fox.RouteOption {
+	if h == nil {
+		// Annotated as untyped nil, so that the route falls back to the configured response.
+		return fox.WithAnnotation(oKey{}, nil)
+	}
+	return fox.WithAnnotation(oKey{}, h)
+}
+
+// OverrideSpillQuota returns a RouteOption that limits the disk space used altogether by the responses of a specific
+// route spilled with [OverflowSpill] to n bytes, on top of the quota of the middleware set with [WithSpillQuota].
+// The responses that would exceed it are sent through to the client instead.
+func OverrideSpillQuota(n int64) fox.RouteOption {
+	return fox.WithAnnotation(qKey{}, n)
+}
+
+// ObserveBudget returns a RouteOption that sets an observe-only latency budget for a specific route, typically a
+// stricter internal objective than the enforced timeout. The budget is never enforced, but the requests whose
+// handler runs for longer than d are counted in [RouteCounters.OverBudget], and the budget is reported in
+// [Observation.Budget], giving an early warning before the timeout is reached. Only the requests served under a
+// timeout are observed.
+func ObserveBudget(d time.Duration) fox.RouteOption {
+	return fox.WithAnnotation(bKey{}, d)
+}
+
 func unwrapRouteTimeout[T comparable](r *fox.Route, k T) (time.Duration, bool) {
 	if r != nil {
 		dt := r.Annotation(k)
@@ -44,5 +262,5 @@ func unwrapRouteTimeout[T comparable](r *fox.Route, k T) (time.Duration, bool) {
 			return dt.(time.Duration), true
 		}
 	}
-	return 0, false
+	return profileTimeout(r, k)
 }
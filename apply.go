@@ -0,0 +1,151 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// ErrNoRouteMatched is returned by [Timeout.Apply] when a [Rule] does not match any registered route.
+var ErrNoRouteMatched = errors.New("timeout: rule matches no route")
+
+// annotationKeys are the keys of the annotations set by the route options of this package, which Apply merges with
+// the options of the rules.
+var annotationKeys = [...]any{hKey{}, rKey{}, wKey{}, sKey{}, fKey{}, aKey{}, mKey{}, eKey{}, bKey{}, iKey{}, pKey{}, dKey{}, oKey{}, qKey{}, cKey{}, tKey{}, lKey{}, kKey{}, xKey{}}
+
+// Rule pairs a route matcher with route options, as applied by [Timeout.Apply].
+type Rule struct {
+	// Pattern matches the routes with the same pattern, regardless of the name of the parameters (e.g. "/users/{id}"
+	// matches "/users/{uid}"). A pattern ending with "/*" matches all routes starting with the prefix before the
+	// "*", e.g. "/api/*" matches "/api/users" and "/api/users/{id}".
+	Pattern string
+	// Methods restricts the rule to the routes handling at least one of the given methods. If empty, the rule
+	// applies regardless of the method.
+	Methods []string
+	// Options are the route options applied to the matching routes, such as [OverrideHandler] or [OverrideWrite].
+	Options []fox.RouteOption
+}
+
+func (r *Rule) match(route *fox.Route) bool {
+	if len(r.Methods) > 0 && !slices.ContainsFunc(slices.Collect(route.Methods()), func(m string) bool {
+		return slices.Contains(r.Methods, m)
+	}) {
+		return false
+	}
+	pattern := normalizeParams(route.Pattern())
+	rule := normalizeParams(r.Pattern)
+	if prefix, ok := strings.CutSuffix(rule, "*"); ok && strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(pattern, prefix)
+	}
+	return pattern == rule
+}
+
+// Apply applies the route options of the rules to the routes registered with f and served by t, which is useful when
+// routes are registered across many packages and their timeouts are configured in one place. Rules are applied in
+// order, so the options of a later rule take precedence over the options of an earlier one when both match a route,
+// and the options of the rules take precedence over the options the route was registered with. Apply can be called
+// again, e.g. when the configuration changes, in which case the new rules replace the previous ones.
+//
+// The routes themselves are left untouched, so their middleware and the annotations of other packages are kept: the
+// rules are resolved at request time by t, and reported by [Timeout.Audit], but not by [RouteSettings]. Only the
+// route options of this package take effect, any other option of a rule is ignored. The rules also apply to the
+// routes registered after Apply is called. It returns an error wrapping [ErrNoRouteMatched] if a rule does not match
+// any route of f, in which case the rules in place are kept. Likewise, it returns an error wrapping
+// [ErrInvalidRouteOption] if the rules make a route invalid, see [ValidateRoute]. The change is recorded, see
+// [Timeout.Changes].
+func (t *Timeout) Apply(f *fox.Router, rules []Rule) error {
+	ar := &appliedRules{f: f, rules: slices.Clone(rules)}
+	matched := make([]bool, len(rules))
+	for route := range f.Iter().All() {
+		for i := range ar.rules {
+			if ar.rules[i].match(route) {
+				matched[i] = true
+			}
+		}
+		r, err := ar.merge(route)
+		if err != nil {
+			return fmt.Errorf("route %q: %w", route.Pattern(), err)
+		}
+		if err = ValidateRoute(r); err != nil {
+			return fmt.Errorf("route %q: %w", route.Pattern(), err)
+		}
+	}
+	if i := slices.Index(matched, false); i >= 0 {
+		return fmt.Errorf("%w: %q", ErrNoRouteMatched, rules[i].Pattern)
+	}
+	old := ""
+	if prev := t.applied.Swap(ar); prev != nil {
+		old = formatRules(prev.rules)
+	}
+	t.changes.record(t.now(), "rules", old, formatRules(ar.rules), changeSource())
+	return nil
+}
+
+// appliedRules are the rules applied with Timeout.Apply.
+type appliedRules struct {
+	f     *fox.Router
+	rules []Rule
+	// routes holds the *fox.Route merging the options of the rules into the options of this package of each route
+	// matched by the rules, see merge.
+	routes sync.Map
+}
+
+// merge returns a route bearing the options of this package of r, overridden by the options of the rules matching
+// r, or r itself if no rule matches. The route is built apart from the router, since only its annotations are read.
+func (ar *appliedRules) merge(r *fox.Route) (*fox.Route, error) {
+	var opts []fox.RouteOption
+	for i := range ar.rules {
+		if ar.rules[i].match(r) {
+			opts = append(opts, ar.rules[i].Options...)
+		}
+	}
+	if opts == nil {
+		return r, nil
+	}
+	return ar.f.NewRoute(slices.Collect(r.Methods()), r.Pattern(), r.Handle, append(routeOptions(r), opts...)...)
+}
+
+// route returns the route whose annotations hold the route options of r for the requests served by t, i.e. with the
+// rules applied with Apply, if any.
+func (t *Timeout) route(r *fox.Route) *fox.Route {
+	ar := t.applied.Load()
+	if ar == nil || r == nil {
+		return r
+	}
+	if m, ok := ar.routes.Load(r); ok {
+		return m.(*fox.Route)
+	}
+	m, err := ar.merge(r)
+	if err != nil {
+		// The rules were valid for the routes registered when they were applied, but not for this one.
+		m = r
+	}
+	ar.routes.Store(r, m)
+	return m
+}
+
+// routeOptions returns the options setting the annotations of this package of the given route.
+func routeOptions(r *fox.Route) []fox.RouteOption {
+	var opts []fox.RouteOption
+	for _, key := range annotationKeys {
+		if v := r.Annotation(key); v != nil {
+			opts = append(opts, fox.WithAnnotation(key, v))
+		}
+	}
+	// The method timeouts are keyed by method, and only those of the methods handled by the route matter.
+	for method := range r.Methods() {
+		key := methodKey{method: method}
+		if v := r.Annotation(key); v != nil {
+			opts = append(opts, fox.WithAnnotation(key, v))
+		}
+	}
+	return opts
+}
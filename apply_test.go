@@ -0,0 +1,109 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	tm := New(time.Second)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	slow := func(c *fox.Context) {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			c.Writer().WriteHeader(http.StatusOK)
+		case <-c.Request().Context().Done():
+		}
+	}
+	auth := func(next fox.HandlerFunc) fox.HandlerFunc {
+		return func(c *fox.Context) {
+			if c.Header("Authorization") == "" {
+				c.Writer().WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next(c)
+		}
+	}
+	type fooKey struct{}
+	f.MustAdd(fox.MethodGet, "/api/users/{id}", slow, fox.WithName("user"), OverrideWrite(time.Minute), OverrideMethod(http.MethodGet, time.Minute))
+	f.MustAdd(fox.MethodPost, "/api/users", slow, fox.WithMiddleware(auth), fox.WithAnnotation(fooKey{}, "bar"))
+	f.MustAdd(fox.MethodGet, "/health", slow, fox.WithHeaderMatcher("X-Probe", "1"))
+	f.MustAdd(fox.MethodGet, "/health", slow)
+
+	require.NoError(t, tm.Apply(f, []Rule{
+		{Pattern: "/api/*", Options: []fox.RouteOption{OverrideHandler(10 * time.Millisecond)}},
+		{Pattern: "/api/users/{uid}", Methods: []string{http.MethodGet, http.MethodPut}, Options: []fox.RouteOption{OverrideHandler(time.Second)}},
+		{Pattern: "/health", Options: []fox.RouteOption{OverrideHandler(10 * time.Millisecond)}},
+	}))
+
+	// The routes are left untouched, with their middleware and the annotations of other packages.
+	users := f.Route(fox.MethodPost, "/api/users")
+	assert.Nil(t, users.Annotation(hKey{}))
+	assert.Equal(t, "bar", users.Annotation(fooKey{}))
+
+	user := tm.route(f.Name("user"))
+	assert.Equal(t, time.Second, user.Annotation(hKey{}))
+	assert.Equal(t, time.Minute, user.Annotation(wKey{}))
+	assert.Equal(t, time.Minute, user.Annotation(methodKey{method: http.MethodGet}))
+	assert.Equal(t, 10*time.Millisecond, tm.route(users).Annotation(hKey{}))
+	probe, err := fox.MatchHeader("X-Probe", "1")
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Millisecond, tm.route(f.Route(fox.MethodGet, "/health", probe)).Annotation(hKey{}))
+
+	serve := func(method, path string, header ...string) int {
+		req := httptest.NewRequest(method, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/users/1"))
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/api/users"))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodPost, "/api/users", "Authorization", "Bearer foo"))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodGet, "/health"))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodGet, "/health", "X-Probe", "1"))
+
+	audits := tm.Audit(f)
+	require.Len(t, audits, 4)
+	assert.Equal(t, "/api/users", audits[0].Pattern)
+	assert.Equal(t, 10*time.Millisecond, audits[0].Handler)
+	assert.Equal(t, "user", audits[1].Name)
+	// The method timeout of the route takes precedence over the handler timeout of the rule.
+	assert.Equal(t, time.Minute, audits[1].Handler)
+
+	// The rules apply to the routes registered afterward.
+	f.MustAdd(fox.MethodGet, "/api/orders", slow)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodGet, "/api/orders"))
+
+	// Applying rules again replaces the previous ones.
+	require.NoError(t, tm.Apply(f, []Rule{{Pattern: "/health", Options: []fox.RouteOption{OverrideHandler(time.Second)}}}))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/health"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/users", "Authorization", "Bearer foo"))
+}
+
+func TestApply_NoRouteMatched(t *testing.T) {
+	tm := New(time.Second)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {})
+
+	err = tm.Apply(f, []Rule{
+		{Pattern: "/foo", Options: []fox.RouteOption{OverrideHandler(time.Second)}},
+		{Pattern: "/foo", Methods: []string{http.MethodPost}, Options: []fox.RouteOption{OverrideHandler(time.Second)}},
+	})
+	require.ErrorIs(t, err, ErrNoRouteMatched)
+	assert.Nil(t, tm.route(f.Route(fox.MethodGet, "/foo")).Annotation(hKey{}))
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ErrCommitted is returned by [Arbiter.Acquire] once the response has been committed.
+var ErrCommitted = errors.New("timeout: response already committed")
+
+const (
+	arbiterOpen uint8 = iota
+	arbiterCommitted
+	arbiterAbandoned
+)
+
+// Arbiter settles the race between a handler completing its response and the deadline of the handler expiring.
+// It is the synchronization primitive behind the writer of the middleware, and can be reused to build custom
+// writers with the same semantics. The zero value is ready to use, and an Arbiter must not be copied after first use.
+//
+// An Arbiter guarantees that:
+//   - It is settled at most once, either by [Arbiter.Commit] or by [Arbiter.Abandon]: the first call wins and the
+//     other one reports false.
+//   - The critical sections started by [Arbiter.Acquire], [Arbiter.Commit] and [Arbiter.Abandon] are mutually
+//     exclusive, so once Commit or Abandon returns true, no handler write is in progress, and none can start
+//     before Release is called.
+//   - Once settled, [Arbiter.Acquire] fails without entering the critical section: with the error passed to
+//     Abandon, or with [ErrCommitted].
+type Arbiter struct {
+	err   error
+	mu    sync.RWMutex
+	state uint8
+}
+
+// Acquire enters the critical section for a handler write. It returns a non-nil error, without entering the critical
+// section, if the arbiter is already settled. Otherwise, the caller must call [Arbiter.Release] once done.
+func (a *Arbiter) Acquire() error {
+	a.mu.Lock()
+	switch a.state {
+	case arbiterCommitted:
+		a.mu.Unlock()
+		return ErrCommitted
+	case arbiterAbandoned:
+		a.mu.Unlock()
+		return a.err
+	}
+	return nil
+}
+
+// Release leaves the critical section entered with [Arbiter.Acquire], or by a successful call to [Arbiter.Commit]
+// or [Arbiter.Abandon].
+func (a *Arbiter) Release() {
+	a.mu.Unlock()
+}
+
+// Commit settles the arbiter in favor of the handler response. It reports false, without entering the critical
+// section, if the arbiter is already settled. Otherwise, the caller holds the critical section, typically to write
+// the response to the client, and must call [Arbiter.Release] once done.
+func (a *Arbiter) Commit() bool {
+	return a.settle(arbiterCommitted, nil)
+}
+
+// Abandon settles the arbiter in favor of the timeout, and makes every subsequent [Arbiter.Acquire] fail with err,
+// or with [http.ErrHandlerTimeout] if err is nil. It reports false, without entering the critical section, if the arbiter is already
+// settled. Otherwise, the caller holds the critical section, typically to write the timeout response, and must
+// call [Arbiter.Release] once done.
+func (a *Arbiter) Abandon(err error) bool {
+	return a.settle(arbiterAbandoned, err)
+}
+
+// Abandoned reports whether the arbiter was settled by [Arbiter.Abandon].
+func (a *Arbiter) Abandoned() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.state == arbiterAbandoned
+}
+
+// abandonIf is like Abandon, but only settles the arbiter if cond, evaluated within the critical section, reports
+// true.
+func (a *Arbiter) abandonIf(cond func() bool, err error) bool {
+	a.mu.Lock()
+	if a.state != arbiterOpen || !cond() {
+		a.mu.Unlock()
+		return false
+	}
+	a.state, a.err = arbiterAbandoned, abandonErr(err)
+	return true
+}
+
+// commitLocked settles the arbiter in favor of the handler from within the critical section entered with
+// Acquire, which the caller still has to leave with Release.
+func (a *Arbiter) commitLocked() {
+	a.state = arbiterCommitted
+}
+
+func (a *Arbiter) settle(state uint8, err error) bool {
+	a.mu.Lock()
+	if a.state != arbiterOpen {
+		a.mu.Unlock()
+		return false
+	}
+	if state == arbiterAbandoned {
+		err = abandonErr(err)
+	}
+	a.state, a.err = state, err
+	return true
+}
+
+// abandonErr returns the error Acquire fails with once the arbiter is abandoned with err. A nil error would let
+// Acquire report success without holding the lock, and the following Release unlock an unlocked mutex.
+func abandonErr(err error) error {
+	if err == nil {
+		return http.ErrHandlerTimeout
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArbiter(t *testing.T) {
+	t.Run("commit", func(t *testing.T) {
+		var arb Arbiter
+		require.NoError(t, arb.Acquire())
+		arb.Release()
+
+		require.True(t, arb.Commit())
+		arb.Release()
+		assert.False(t, arb.Abandon(http.ErrHandlerTimeout))
+		assert.False(t, arb.Commit())
+		assert.False(t, arb.Abandoned())
+		assert.ErrorIs(t, arb.Acquire(), ErrCommitted)
+	})
+
+	t.Run("abandon", func(t *testing.T) {
+		var arb Arbiter
+		require.True(t, arb.Abandon(http.ErrHandlerTimeout))
+		arb.Release()
+		assert.False(t, arb.Commit())
+		assert.True(t, arb.Abandoned())
+		assert.ErrorIs(t, arb.Acquire(), http.ErrHandlerTimeout)
+	})
+
+	t.Run("abandon with nil error", func(t *testing.T) {
+		var arb Arbiter
+		require.True(t, arb.Abandon(nil))
+		arb.Release()
+		assert.True(t, arb.Abandoned())
+		assert.ErrorIs(t, arb.Acquire(), http.ErrHandlerTimeout)
+
+		arb = Arbiter{}
+		require.True(t, arb.abandonIf(func() bool { return true }, nil))
+		arb.Release()
+		assert.ErrorIs(t, arb.Acquire(), http.ErrHandlerTimeout)
+	})
+
+	t.Run("settled once under contention", func(t *testing.T) {
+		var (
+			arb    Arbiter
+			wg     sync.WaitGroup
+			mu     sync.Mutex
+			wins   int
+			inside int
+		)
+		for i := range 16 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if err := arb.Acquire(); err == nil {
+					inside++
+					arb.Release()
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				settled := arb.Commit
+				if i%2 == 0 {
+					settled = func() bool { return arb.Abandon(http.ErrHandlerTimeout) }
+				}
+				if settled() {
+					mu.Lock()
+					wins++
+					mu.Unlock()
+					arb.Release()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, wins)
+		assert.LessOrEqual(t, inside, 16)
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// RouteAudit is the effective timeout policy of a route for a request method, as returned by [Timeout.Audit].
+type RouteAudit struct {
+	// Pattern is the pattern of the route.
+	Pattern string
+	// Name is the name of the route, if any.
+	Name string
+	// Method is the request method.
+	Method string
+	// Handler is the handler timeout, clamped to the bounds set with [WithMinTimeout] and [WithMaxTimeout]. A value
+	// <= 0 means no timeout.
+	Handler time.Duration
+	// Source is the source of the handler timeout, see [ResolveExplain].
+	Source Source
+	// Clamp reports whether the handler timeout was clamped.
+	Clamp Clamp
+	// Dynamic lists the configured sources that depend on the request, such as the host set with [WithHostTimeouts],
+	// and that take precedence over Source when they have a timeout for the request, from the highest to the lowest
+	// precedence. The client header set with [WithHeaderTimeout] comes last, since it can only shorten the timeout.
+	Dynamic []Source
+	// Read and Write are the read and write deadlines of the connection, as set with [OverrideRead] and
+	// [OverrideWrite] or else with [WithReadDeadline] and [WithWriteDeadline], or zero if none.
+	Read  time.Duration
+	Write time.Duration
+}
+
+// Audit walks the routes registered with f and returns, for each route and method, the effective timeouts of the
+// requests served by t, after applying the route options, the rules applied with [Timeout.Apply], the pattern and
+// method timeouts, the bounds and the defaults, so that the timeout policy scattered across the registration sites
+// and the middleware options can be reviewed in one place. The results are sorted by pattern, method and name. Since the sources depending on the
+// request cannot be resolved ahead of time, they are listed in [RouteAudit.Dynamic] instead. The timeouts set with
+// [WithFlagProvider] and the rules of the policy set with [WithPolicy] are resolved with their current value.
+func (t *Timeout) Audit(f *fox.Router) []RouteAudit {
+	var audits []RouteAudit
+	for route := range f.Iter().All() {
+		for method := range route.Methods() {
+			audits = append(audits, t.audit(route, method))
+		}
+	}
+	slices.SortFunc(audits, func(a, b RouteAudit) int {
+		return cmp.Or(
+			cmp.Compare(a.Pattern, b.Pattern),
+			cmp.Compare(a.Method, b.Method),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+	return audits
+}
+
+// audit returns the effective timeouts of the requests with the given method served by route.
+func (t *Timeout) audit(route *fox.Route, method string) RouteAudit {
+	a := RouteAudit{Pattern: route.Pattern(), Name: route.Name(), Method: method}
+	route = t.route(route)
+	for _, src := range precedence {
+		if t.dynamic(route, src) {
+			a.Dynamic = append(a.Dynamic, src)
+			continue
+		}
+		if dt, ok := t.lookupRouteTimeout(route, a.Pattern, method, fox.RouteHandler, src); ok {
+			a.Handler, a.Source = dt, src
+			break
+		}
+	}
+	if t.dynamic(route, SourceHeader) {
+		a.Dynamic = append(a.Dynamic, SourceHeader)
+	}
+	a.Handler, a.Clamp = t.clamp(a.Handler)
+	if !t.outer {
+		a.Read, _ = t.routeDeadline(route, a.Pattern, method, rKey{}, t.cfg.readDeadline)
+		a.Write, _ = t.routeDeadline(route, a.Pattern, method, wKey{}, t.cfg.writeDeadline)
+	}
+	return a
+}
+
+// dynamic reports whether the source src of the timeout of route is configured and depends on the request.
+func (t *Timeout) dynamic(route *fox.Route, src Source) bool {
+	switch src {
+	case SourceTrusted:
+		return !t.outer && (t.cfg.trusted != nil || t.cfg.trustedNets != nil) && route.Annotation(tKey{}) != nil
+	case SourceHeader:
+		return t.cfg.headerTimeout != nil
+	case SourceResolver:
+		return t.cfg.resolver != nil
+	case SourceAdaptive:
+		return !t.outer && t.cfg.adaptive != nil
+	case SourceHost:
+		return t.cfg.hosts != nil
+	case SourceProtocol:
+		return t.cfg.protocols != nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout_Audit(t *testing.T) {
+	tm := New(time.Second,
+		WithPatternTimeouts(map[string]time.Duration{"/api/v2/export": 30 * time.Second}),
+		WithMethodTimeout(http.MethodPost, 5*time.Second),
+		WithMaxTimeout(20*time.Second),
+		WithWriteDeadline(time.Minute),
+		WithHostTimeouts(map[string]time.Duration{"internal.example.com": 2 * time.Second}),
+	)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	noop := func(c *fox.Context) {}
+	f.MustAdd([]string{http.MethodGet, http.MethodPost}, "/api/v2/export", noop)
+	f.MustAdd([]string{http.MethodGet, http.MethodPost}, "/users", noop,
+		fox.WithName("users"),
+		OverrideHandler(3*time.Second),
+		OverrideMethod(http.MethodPost, 4*time.Second),
+		OverrideRead(10*time.Second),
+	)
+	f.MustAdd(fox.MethodGet, "/health", noop)
+
+	assert.Equal(t, []RouteAudit{
+		{Pattern: "/api/v2/export", Method: http.MethodGet, Handler: 20 * time.Second, Source: SourcePattern, Clamp: ClampMax, Write: time.Minute},
+		{Pattern: "/api/v2/export", Method: http.MethodPost, Handler: 20 * time.Second, Source: SourcePattern, Clamp: ClampMax, Write: time.Minute},
+		{Pattern: "/health", Method: http.MethodGet, Handler: time.Second, Source: SourceGlobal, Dynamic: []Source{SourceHost}, Write: time.Minute},
+		{Pattern: "/users", Name: "users", Method: http.MethodGet, Handler: 3 * time.Second, Source: SourceRoute, Read: 10 * time.Second, Write: time.Minute},
+		{Pattern: "/users", Name: "users", Method: http.MethodPost, Handler: 4 * time.Second, Source: SourceRoute, Read: 10 * time.Second, Write: time.Minute},
+	}, tm.Audit(f))
+}
@@ -0,0 +1,310 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+var (
+	errReplayTooLarge = errors.New("timeout: request body too large to be replayed")
+	errReplayed       = errors.New("timeout: request body replayed by another attempt")
+)
+
+// poisonBody wraps a request body so that it can be invalidated once the handler timed out. After being poisoned,
+// every read returns [http.ErrHandlerTimeout].
+type poisonBody struct {
+	io.ReadCloser
+	poisoned atomic.Bool
+	// mu is held by the reads of the handler, so that the body is only drained while no read is in progress.
+	mu sync.Mutex
+}
+
+func (b *poisonBody) Read(p []byte) (int, error) {
+	if b.poisoned.Load() {
+		return 0, http.ErrHandlerTimeout
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.poisoned.Load() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return b.ReadCloser.Read(p)
+}
+
+func (b *poisonBody) poison() {
+	b.poisoned.Store(true)
+}
+
+// drain discards the rest of the poisoned body, up to d.limit bytes read within d.timeout, so that the connection
+// can be reused. It reports whether the body was read to its end, which is not attempted while a read of the handler
+// is in progress. The read deadline of the connection is cleared once the body is drained.
+func (b *poisonBody) drain(w fox.ResponseWriter, d *bodyDrain) bool {
+	if !b.mu.TryLock() {
+		return false
+	}
+	defer b.mu.Unlock()
+	// Errors are ignored for the same reason as in setDeadline.
+	_ = w.SetReadDeadline(time.Now().Add(d.timeout))
+	n, err := io.CopyN(io.Discard, b.ReadCloser, d.limit+1)
+	if n > d.limit || err != io.EOF {
+		return false
+	}
+	_ = w.SetReadDeadline(time.Time{})
+	return true
+}
+
+// bodyDrain is the limit of the body drained after a timeout, see WithBodyDrain.
+type bodyDrain struct {
+	limit   int64
+	timeout time.Duration
+}
+
+// idleBody wraps a request body to detect whether the read deadline expired before any byte of the body arrived.
+// It is read by the handler and must only be inspected once the handler is done.
+type idleBody struct {
+	io.ReadCloser
+	n       int64
+	expired bool
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil && b.n == 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+		b.expired = true
+	}
+	return n, err
+}
+
+// BodyTimeoutError is the error returned when reading the request body once the body timeout set with
+// [WithBodyTimeout] or [OverrideBody] is exceeded. It matches [os.ErrDeadlineExceeded] with [errors.Is].
+type BodyTimeoutError struct {
+	// Duration is the time allowed to read the entire body.
+	Duration time.Duration
+}
+
+// Error returns the error message.
+func (e *BodyTimeoutError) Error() string {
+	return "timeout: request body read timeout"
+}
+
+// Timeout reports whether the error is a timeout, which is always true. It satisfies [net.Error].
+func (e *BodyTimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary reports whether the error is temporary, which is never true. It satisfies [net.Error].
+func (e *BodyTimeoutError) Temporary() bool {
+	return false
+}
+
+// Is reports whether target is [os.ErrDeadlineExceeded].
+func (e *BodyTimeoutError) Is(target error) bool {
+	return target == os.ErrDeadlineExceeded
+}
+
+// timedBody wraps a request body so that it must be read entirely within dt from the first read. The read deadline
+// of the connection is set at the first read, so that a read blocked on a slow client is unblocked.
+type timedBody struct {
+	io.ReadCloser
+	w        fox.ResponseWriter
+	deadline time.Time
+	dt       time.Duration
+	eof      bool
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	if b.eof {
+		return b.ReadCloser.Read(p)
+	}
+	if b.deadline.IsZero() {
+		b.deadline = time.Now().Add(b.dt)
+		// Errors are ignored for the same reason as in setDeadline.
+		_ = b.w.SetReadDeadline(b.deadline)
+	} else if !time.Now().Before(b.deadline) {
+		return 0, &BodyTimeoutError{Duration: b.dt}
+	}
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	} else if err != nil && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(b.deadline) {
+		err = &BodyTimeoutError{Duration: b.dt}
+	}
+	return n, err
+}
+
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody
+}
+
+// replayBody records the bytes read from a request body, in memory up to memLimit bytes and in a temporary file
+// beyond, so that a later attempt can read the body again from the start. Each call to getBody returns a new
+// reader that takes over the body: reads from the readers returned earlier fail with errReplayed. Reads from the
+// underlying body are serialized, and the bytes are recorded as they are read, regardless of which reader reads
+// them. Once more than maxSize bytes are read, recording stops and getBody fails.
+//
+// A read from the underlying body blocks for as long as the client does not send more bytes, which is typically
+// where a timed out handler is stuck, so it is made without holding mu: getBody and release never wait for it, and
+// the bytes it returns once the body was taken over are recorded for the new reader.
+type replayBody struct {
+	body  io.ReadCloser
+	spill *os.File
+	err   error
+	mem   bytes.Buffer
+	// mu guards the state of the body, and readMu serializes the reads from the underlying body.
+	mu       sync.Mutex
+	readMu   sync.Mutex
+	memLimit int64
+	maxSize  int64
+	size     int64
+	gen      int
+	overflow bool
+	released bool
+}
+
+func newReplayBody(body io.ReadCloser, memLimit, maxSize int64) *replayBody {
+	return &replayBody{body: body, memLimit: memLimit, maxSize: maxSize}
+}
+
+// reader returns the reader of the first attempt.
+func (b *replayBody) reader() io.ReadCloser {
+	return &replayReader{b: b}
+}
+
+// getBody implements the http.Request.GetBody contract.
+func (b *replayBody) getBody() (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.overflow || b.released {
+		return nil, errReplayTooLarge
+	}
+	b.gen++
+	return &replayReader{b: b, gen: b.gen}, nil
+}
+
+// release invalidates every reader and removes the spill file, if any.
+func (b *replayBody) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.released = true
+	b.gen++
+	if b.spill != nil {
+		_ = b.spill.Close()
+		_ = os.Remove(b.spill.Name())
+		b.spill = nil
+	}
+}
+
+func (b *replayBody) record(p []byte) {
+	if b.overflow {
+		return
+	}
+	if b.size+int64(len(p)) > b.maxSize {
+		b.overflow = true
+		return
+	}
+	if room := b.memLimit - int64(b.mem.Len()); room > 0 {
+		k := min(room, int64(len(p)))
+		b.mem.Write(p[:k])
+		b.size += k
+		p = p[k:]
+	}
+	if len(p) == 0 {
+		return
+	}
+	if b.spill == nil {
+		f, err := os.CreateTemp("", "timeout-body-*")
+		if err != nil {
+			b.overflow = true
+			return
+		}
+		b.spill = f
+	}
+	if _, err := b.spill.WriteAt(p, b.size-int64(b.mem.Len())); err != nil {
+		b.overflow = true
+		return
+	}
+	b.size += int64(len(p))
+}
+
+func (b *replayBody) readRecorded(p []byte, off int64) (int, error) {
+	if memLen := int64(b.mem.Len()); off < memLen {
+		return copy(p, b.mem.Bytes()[off:]), nil
+	}
+	n, err := b.spill.ReadAt(p[:min(int64(len(p)), b.size-off)], off-int64(b.mem.Len()))
+	if n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+type replayReader struct {
+	b   *replayBody
+	off int64
+	gen int
+}
+
+func (r *replayReader) Read(p []byte) (int, error) {
+	b := r.b
+	if n, done, err := r.readRecorded(p); done {
+		return n, err
+	}
+
+	b.readMu.Lock()
+	defer b.readMu.Unlock()
+	// The underlying body may have been read by another reader while waiting.
+	if n, done, err := r.readRecorded(p); done {
+		return n, err
+	}
+	n, err := b.body.Read(p)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.released {
+		b.record(p[:n])
+	}
+	b.err = err
+	if r.gen != b.gen {
+		// The body was taken over during the read, its bytes are left to the new reader.
+		return 0, errReplayed
+	}
+	r.off += int64(n)
+	return n, err
+}
+
+// readRecorded reads the bytes recorded past the offset of r, if any, and reports whether the read is done without
+// reading from the underlying body.
+func (r *replayReader) readRecorded(p []byte) (int, bool, error) {
+	b := r.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.gen != b.gen {
+		return 0, true, errReplayed
+	}
+	if r.off < b.size {
+		n, err := b.readRecorded(p, r.off)
+		r.off += int64(n)
+		return n, true, err
+	}
+	if b.err != nil {
+		return 0, true, b.err
+	}
+	return 0, false, nil
+}
+
+// Close is a no-op, the underlying body is closed by the server.
+func (r *replayReader) Close() error {
+	return nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// ErrCircuitOpen is the cause of the timeout responses of the requests rejected because the circuit breaker of their
+// route is open, as reported to the handler set with [WithErrorResponse]. See [WithCircuitBreaker].
+var ErrCircuitOpen = errors.New("timeout: circuit open")
+
+// BreakerState is the state of the circuit breaker of a route, see [WithCircuitBreaker].
+type BreakerState uint8
+
+const (
+	// BreakerClosed is the state of a route whose requests are served.
+	BreakerClosed BreakerState = iota + 1
+	// BreakerOpen is the state of a route whose requests are rejected until the end of the cooldown.
+	BreakerOpen
+	// BreakerHalfOpen is the state of a route whose cooldown is over, and whose next request is a probe deciding
+	// whether the breaker closes, or opens again. The other requests are rejected meanwhile.
+	BreakerHalfOpen
+)
+
+// String returns the name of the breaker state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BrokenRoute is a route whose circuit breaker is not closed, see [WithCircuitBreaker].
+type BrokenRoute struct {
+	// Since is when the breaker of the route last opened.
+	Since time.Time
+	// Pattern is the route pattern, rewritten by the [WithRouteNormalizer] function.
+	Pattern string
+	// State is the state of the breaker, [BreakerOpen] or [BreakerHalfOpen].
+	State BreakerState
+	// Rejected is the number of requests rejected since the breaker last opened.
+	Rejected uint64
+}
+
+// circuitBreaker rejects the requests of the routes timing out repeatedly, see WithCircuitBreaker.
+type circuitBreaker struct {
+	routes    sync.Map
+	threshold uint64
+	window    time.Duration
+	cooldown  time.Duration
+}
+
+// breakerRoute is the state of a route of circuitBreaker.
+type breakerRoute struct {
+	rejected atomic.Uint64
+	mu       sync.Mutex
+	state    BreakerState
+	// start is the start of the current window, and timeouts the timeouts it counted.
+	start    time.Time
+	timeouts uint64
+	// since is when the breaker last opened, and probing reports whether the probe of the half-open breaker is being
+	// served.
+	since   time.Time
+	probing bool
+}
+
+// WithCircuitBreaker stops a degraded dependency from tying up a full timeout worth of server resources on every
+// request: when a route accumulates threshold timeouts within a window of the given duration, its breaker opens, and
+// its requests are rejected right away with the timeout response, whose cause is [ErrCircuitOpen], for the cooldown.
+// The breaker is then half-open: the next request is served as a probe, while the others are still rejected, and the
+// breaker closes if the probe completes in time, or opens for another cooldown if it times out. An [EventBreakerOpen]
+// is emitted when a breaker opens, and the routes whose breaker is not closed are reported by [Timeout.Broken]. The
+// requests canceled by the client are not counted. A threshold <= 0, a window <= 0 or a cooldown <= 0 disables the
+// breaker, which is the default.
+func WithCircuitBreaker(threshold int, window, cooldown time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if threshold <= 0 || window <= 0 || cooldown <= 0 {
+			c.breaker = nil
+			return
+		}
+		c.breaker = &circuitBreaker{threshold: uint64(threshold), window: window, cooldown: cooldown}
+	})
+}
+
+// route returns the state of the route of the given pattern key.
+func (b *circuitBreaker) route(key string) *breakerRoute {
+	r, ok := b.routes.Load(key)
+	if !ok {
+		r, _ = b.routes.LoadOrStore(key, &breakerRoute{state: BreakerClosed})
+	}
+	return r.(*breakerRoute)
+}
+
+// allow reports whether a request of the route of the given pattern key is served, and whether it is the probe of the
+// half-open breaker, which must then be settled with observe or release.
+func (b *circuitBreaker) allow(key string, now time.Time) (ok, probe bool) {
+	r, found := b.routes.Load(key)
+	if !found {
+		return true, false
+	}
+	br := r.(*breakerRoute)
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	switch br.state {
+	case BreakerOpen:
+		if now.Sub(br.since) < b.cooldown {
+			break
+		}
+		br.state = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		if !br.probing {
+			br.probing = true
+			return true, true
+		}
+	default:
+		return true, false
+	}
+	br.rejected.Add(1)
+	return false, false
+}
+
+// observe records the outcome of a request of the route of the given pattern key, and reports whether the breaker
+// of the route opened as a result. The timeouts are counted in windows starting with the first timeout.
+func (b *circuitBreaker) observe(key string, probe, timedOut bool, now time.Time) bool {
+	if !timedOut && !probe {
+		return false
+	}
+	r := b.route(key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if probe {
+		r.probing = false
+		if !timedOut {
+			r.state, r.start, r.timeouts = BreakerClosed, time.Time{}, 0
+			return false
+		}
+		r.open(now)
+		return true
+	}
+	if r.state != BreakerClosed {
+		// A request served before the breaker opened.
+		return false
+	}
+	if r.start.IsZero() || now.Sub(r.start) >= b.window {
+		r.start, r.timeouts = now, 0
+	}
+	r.timeouts++
+	if r.timeouts >= b.threshold {
+		r.open(now)
+		return true
+	}
+	return false
+}
+
+// release gives up the probe of the half-open breaker of the route of the given pattern key, e.g. because the client
+// went away or the handler panicked, so that the next request probes the route instead.
+func (b *circuitBreaker) release(key string) {
+	if r, ok := b.routes.Load(key); ok {
+		br := r.(*breakerRoute)
+		br.mu.Lock()
+		defer br.mu.Unlock()
+		br.probing = false
+	}
+}
+
+// open opens the breaker at now, for a cooldown.
+func (r *breakerRoute) open(now time.Time) {
+	r.state, r.since, r.start, r.timeouts = BreakerOpen, now, time.Time{}, 0
+	r.rejected.Store(0)
+}
+
+// reject writes the timeout response of a request rejected because the breaker of its route is open.
+func (t *Timeout) reject(c *fox.Context, dt time.Duration) {
+	now := t.now()
+	t.writeTimeout(c, t.respond, now, now, dt, ErrCircuitOpen)
+}
+
+// Broken returns the routes whose circuit breaker is open or half-open, see [WithCircuitBreaker], sorted by pattern.
+// It returns nil if the middleware is not configured with [WithCircuitBreaker].
+func (t *Timeout) Broken() []BrokenRoute {
+	b := t.cfg.breaker
+	if b == nil {
+		return nil
+	}
+	var routes []BrokenRoute
+	b.routes.Range(func(key, value any) bool {
+		r := value.(*breakerRoute)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		state := r.state
+		if state == BreakerOpen && t.since(r.since) >= b.cooldown {
+			// The next request will probe the route.
+			state = BreakerHalfOpen
+		}
+		if state != BreakerClosed {
+			routes = append(routes, BrokenRoute{
+				Pattern:  key.(string),
+				Since:    r.since,
+				State:    state,
+				Rejected: r.rejected.Load(),
+			})
+		}
+		return true
+	})
+	slices.SortFunc(routes, func(a, b BrokenRoute) int {
+		return cmp.Compare(a.Pattern, b.Pattern)
+	})
+	return routes
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker_Observe(t *testing.T) {
+	b := &circuitBreaker{threshold: 2, window: time.Minute, cooldown: 10 * time.Second}
+	now := time.Now()
+	allow := func(at time.Time) (bool, bool) {
+		return b.allow("/foo", at)
+	}
+
+	// The timeouts of different windows do not add up.
+	assert.False(t, b.observe("/foo", false, true, now))
+	assert.False(t, b.observe("/foo", false, false, now.Add(time.Second)))
+	assert.False(t, b.observe("/foo", false, true, now.Add(time.Minute)))
+	ok, probe := allow(now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.False(t, probe)
+
+	assert.True(t, b.observe("/foo", false, true, now.Add(time.Minute+time.Second)))
+	opened := now.Add(time.Minute + time.Second)
+	ok, _ = allow(opened.Add(time.Second))
+	assert.False(t, ok)
+
+	// Once the cooldown is over, a single probe is served.
+	ok, probe = allow(opened.Add(10 * time.Second))
+	assert.True(t, ok)
+	assert.True(t, probe)
+	ok, _ = allow(opened.Add(10 * time.Second))
+	assert.False(t, ok)
+
+	// The probe timed out, so the breaker opens for another cooldown.
+	assert.True(t, b.observe("/foo", true, true, opened.Add(11*time.Second)))
+	ok, _ = allow(opened.Add(12 * time.Second))
+	assert.False(t, ok)
+
+	// A released probe lets the next request probe the route.
+	_, probe = allow(opened.Add(21 * time.Second))
+	assert.True(t, probe)
+	b.release("/foo")
+	_, probe = allow(opened.Add(21 * time.Second))
+	assert.True(t, probe)
+
+	// The probe completed in time, so the breaker closes.
+	assert.False(t, b.observe("/foo", true, false, opened.Add(22*time.Second)))
+	ok, probe = allow(opened.Add(22 * time.Second))
+	assert.True(t, ok)
+	assert.False(t, probe)
+}
+
+func TestMiddleware_WithCircuitBreaker(t *testing.T) {
+	var (
+		events []Event
+		errs   []error
+		slow   atomic.Bool
+	)
+	slow.Store(true)
+	tm := New(5*time.Millisecond,
+		WithCircuitBreaker(2, time.Minute, 50*time.Millisecond),
+		WithEventSink(EventSinkFunc(func(e Event) { events = append(events, e) })),
+		WithErrorResponse(func(c *fox.Context, err error) {
+			errs = append(errs, err)
+			c.Writer().WriteHeader(http.StatusServiceUnavailable)
+		}),
+	)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	var calls atomic.Int32
+	f.MustAdd(fox.MethodGet, "/flaky", func(c *fox.Context) {
+		calls.Add(1)
+		if slow.Load() {
+			<-c.Request().Context().Done()
+			return
+		}
+		c.Writer().WriteHeader(http.StatusOK)
+	})
+	serve := func() int {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/flaky", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	assert.Empty(t, tm.Broken())
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	require.NotEmpty(t, events)
+	assert.Equal(t, EventBreakerOpen, events[len(events)-1].Kind)
+	assert.Equal(t, "/flaky", events[len(events)-1].Pattern)
+
+	// The requests are rejected without calling the handler.
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	assert.Equal(t, int32(2), calls.Load())
+	assert.ErrorIs(t, errs[len(errs)-1], ErrCircuitOpen)
+	broken := tm.Broken()
+	require.Len(t, broken, 1)
+	assert.Equal(t, "/flaky", broken[0].Pattern)
+	assert.Equal(t, BreakerOpen, broken[0].State)
+	assert.Equal(t, uint64(1), broken[0].Rejected)
+
+	// The dependency recovered, so the probe closes the breaker.
+	slow.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	require.Len(t, tm.Broken(), 1)
+	assert.Equal(t, BreakerHalfOpen, tm.Broken()[0].State)
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Empty(t, tm.Broken())
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, int32(4), calls.Load())
+
+	slow.Store(true)
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	require.Len(t, tm.Broken(), 1)
+	tm.Invalidate("/flaky")
+	assert.Empty(t, tm.Broken())
+
+	assert.Equal(t, "breaker_open", EventBreakerOpen.String())
+	assert.Equal(t, "half-open", BreakerHalfOpen.String())
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// Remaining returns the time left before the deadline of the handler serving c, as enforced by the middleware, or
+// zero if the deadline has passed. It reports false if the request is not served under a timeout. Handlers can use
+// it to derive the sub-deadlines of their downstream calls, see also [BudgetTransport].
+func Remaining(c *fox.Context) (time.Duration, bool) {
+	return RemainingFromContext(c.Request().Context())
+}
+
+// RemainingFromContext is like [Remaining], but reads the deadline from ctx, which must be the request context of
+// the handler or derived from it. This allows code that only has access to a context, such as a database driver or
+// an RPC client, to adapt to the remaining budget.
+func RemainingFromContext(ctx context.Context) (time.Duration, bool) {
+	s, ok := ctx.Value(scopeKey{}).(*scope)
+	if !ok {
+		return 0, false
+	}
+	return max(s.t.until(s.end()), 0), true
+}
+
+// BudgetTransport returns an [http.RoundTripper] propagating the remaining budget of the handler to the downstream
+// services: the header name (e.g. "X-Timeout-Budget") of each outbound request is set to the time left before the
+// deadline of the handler, in milliseconds (e.g. "1234.567ms"), which [WithHeaderTimeout] understands. Once the
+// budget is exhausted, requests fail with a [*DeadlineError] without being sent. The budget is read from the
+// context of the outbound request, so it must be created with the request context of the handler, or a context
+// derived from it, e.g. with [http.NewRequestWithContext]. Requests issued outside of a handler served under a
+// timeout are sent unchanged. If rt is nil, [http.DefaultTransport] is used.
+func BudgetTransport(rt http.RoundTripper, header string) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &budgetTransport{rt: rt, header: header}
+}
+
+type budgetTransport struct {
+	rt     http.RoundTripper
+	header string
+}
+
+func (bt *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s, ok := req.Context().Value(scopeKey{}).(*scope)
+	if !ok {
+		return bt.rt.RoundTrip(req)
+	}
+	deadline := s.end()
+	remaining := s.t.until(deadline)
+	if remaining <= 0 {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, &DeadlineError{Deadline: deadline}
+	}
+	// A RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set(bt.header, formatMillis(remaining))
+	return bt.rt.RoundTrip(req)
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemaining(t *testing.T) {
+	remaining := make(chan time.Duration, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		dt, ok := Remaining(c)
+		assert.True(t, ok)
+		remaining <- dt
+	})
+	f.MustAdd(fox.MethodGet, "/none", func(c *fox.Context) {
+		_, ok := Remaining(c)
+		assert.False(t, ok)
+	}, OverrideHandler(NoTimeout))
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+	dt := <-remaining
+	assert.LessOrEqual(t, dt, time.Second)
+	assert.Greater(t, dt, 900*time.Millisecond)
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/none", nil))
+
+	_, ok := RemainingFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestBudgetTransport(t *testing.T) {
+	budgets := make(chan string, 1)
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budgets <- r.Header.Get("X-Timeout-Budget")
+	}))
+	defer downstream.Close()
+	client := &http.Client{Transport: BudgetTransport(nil, "X-Timeout-Budget")}
+
+	errs := make(chan error, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		req, err := http.NewRequestWithContext(c.Request().Context(), http.MethodGet, downstream.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		errs <- err
+	})
+	f.MustAdd(fox.MethodGet, "/exhausted", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+		req, err := http.NewRequestWithContext(context.WithoutCancel(c.Request().Context()), http.MethodGet, downstream.URL, nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		errs <- err
+	}, OverrideHandler(time.Millisecond))
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+	require.NoError(t, <-errs)
+	dt, ok := parseTimeoutHeader(<-budgets, false)
+	require.True(t, ok)
+	assert.LessOrEqual(t, dt, time.Second)
+	assert.Greater(t, dt, 500*time.Millisecond)
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/exhausted", nil))
+	var deadlineErr *DeadlineError
+	assert.ErrorAs(t, <-errs, &deadlineErr)
+
+	resp, err := client.Get(downstream.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, <-budgets)
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// bufferClasses are the upper capacity bounds of the size classes of the buffers pooled by [NewBufferPool]. Buffers
+// larger than the last bound share the last class.
+var bufferClasses = [...]int{4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+
+// defaultBufferPool is the pool shared by the middleware not configured with WithBufferPool.
+var defaultBufferPool = NewBufferPool()
+
+// BufferPool is a pool of the buffers holding the handler responses until they are committed. Implementations must
+// be safe for concurrent use. The buffers returned by Get may hold data and are reset before use.
+type BufferPool interface {
+	// Get returns a buffer from the pool, or a new one if the pool is empty.
+	Get() *bytes.Buffer
+	// Put returns buf to the pool once the response is written.
+	Put(buf *bytes.Buffer)
+}
+
+// SizedBufferPool is a [BufferPool] able to hand out a buffer fitting a response of an expected size. The middleware
+// uses it to pick the buffer of a route from the recent sizes of its responses.
+type SizedBufferPool interface {
+	BufferPool
+	// GetSized returns a buffer from the pool fitting a response of size bytes, or a new one with enough capacity
+	// if the pool has none.
+	GetSized(size int) *bytes.Buffer
+}
+
+// BufferClassStats reports how often a size class of the pool returned by [NewBufferPool] had a pooled buffer for
+// the responses expected to fall in the class, see [Timeout.BufferStats].
+type BufferClassStats struct {
+	// Size is the upper capacity bound of the class, or zero for the class of the larger buffers.
+	Size int
+	// Hits is the number of buffers handed out from the class for the responses expected to fall in it.
+	Hits uint64
+	// Misses is the number of responses expected to fall in the class for which it had no pooled buffer, and
+	// which got a buffer from another class or a new one instead.
+	Misses uint64
+}
+
+// bucketPool is a BufferPool keeping one sync.Pool per size class, see NewBufferPool.
+type bucketPool struct {
+	buckets [len(bufferClasses) + 1]sync.Pool
+	hits    [len(bufferClasses) + 1]atomic.Uint64
+	misses  [len(bufferClasses) + 1]atomic.Uint64
+}
+
+// NewBufferPool returns a [BufferPool] sorting the buffers into size classes, from 4KB to 4MB, and handing out the
+// smallest pooled buffers first. Under mixed workloads, this prevents a few large buffers, grown by handlers writing
+// huge responses, from being handed out to the handlers writing small ones, which would keep them alive and inflate
+// the memory usage. See [WithMaxPooledBufferSize] to drop the large buffers altogether. The returned pool is a
+// [SizedBufferPool]: the routes with a history of large responses get a buffer of their class right away, sparing
+// the copies of growing a small one.
+func NewBufferPool() BufferPool {
+	return &bucketPool{}
+}
+
+func (p *bucketPool) Get() *bytes.Buffer {
+	for i := range p.buckets {
+		if buf, ok := p.buckets[i].Get().(*bytes.Buffer); ok {
+			return buf
+		}
+	}
+	return new(bytes.Buffer)
+}
+
+func (p *bucketPool) Put(buf *bytes.Buffer) {
+	p.buckets[bufferClass(buf.Cap())].Put(buf)
+}
+
+// GetSized returns a buffer of the class of size, or else of a larger class, or else of a smaller class, so that a
+// large response is not handed a small buffer while a fitting one is pooled.
+func (p *bucketPool) GetSized(size int) *bytes.Buffer {
+	class := bufferClass(size)
+	if buf, ok := p.buckets[class].Get().(*bytes.Buffer); ok {
+		p.hits[class].Add(1)
+		return buf
+	}
+	p.misses[class].Add(1)
+	for i := class + 1; i < len(p.buckets); i++ {
+		if buf, ok := p.buckets[i].Get().(*bytes.Buffer); ok {
+			return buf
+		}
+	}
+	for i := class - 1; i >= 0; i-- {
+		if buf, ok := p.buckets[i].Get().(*bytes.Buffer); ok {
+			buf.Grow(size)
+			return buf
+		}
+	}
+	if class < len(bufferClasses) {
+		size = bufferClasses[class]
+	}
+	return bytes.NewBuffer(make([]byte, 0, size))
+}
+
+// stats returns the hit and miss counts of the size classes.
+func (p *bucketPool) stats() []BufferClassStats {
+	stats := make([]BufferClassStats, len(p.buckets))
+	for i := range stats {
+		if i < len(bufferClasses) {
+			stats[i].Size = bufferClasses[i]
+		}
+		stats[i].Hits = p.hits[i].Load()
+		stats[i].Misses = p.misses[i].Load()
+	}
+	return stats
+}
+
+// bufferClass returns the index of the size class of a buffer of capacity n.
+func bufferClass(n int) int {
+	for i, bound := range bufferClasses {
+		if n <= bound {
+			return i
+		}
+	}
+	return len(bufferClasses)
+}
+
+// getBuffer returns an empty buffer from the pool of t.
+func (t *Timeout) getBuffer() *bytes.Buffer {
+	buf := t.cfg.pool.Get()
+	buf.Reset()
+	return buf
+}
+
+// getSizedBuffer returns an empty buffer from the pool of t, fitting the recent responses of the route counted by
+// rc if the pool is a SizedBufferPool, and records the size of the response once written.
+func (t *Timeout) getSizedBuffer(rc *routeCounters) *bytes.Buffer {
+	sp, ok := t.cfg.pool.(SizedBufferPool)
+	if !ok {
+		return t.getBuffer()
+	}
+	size := int(rc.sizeHint.Load())
+	if size == 0 {
+		// The route has no history yet.
+		return t.getBuffer()
+	}
+	buf := sp.GetSized(size)
+	buf.Reset()
+	return buf
+}
+
+// BufferStats returns the hit and miss counts of the size classes of the buffer pool of t, from the smallest to the
+// largest class, or nil if the pool set with [WithBufferPool] is not one returned by [NewBufferPool]. Note that the
+// middleware not configured with [WithBufferPool] share the same pool, and thus the same counts. A low hit rate for
+// a class means that the buffers of the class are often handed out from another class, or allocated.
+func (t *Timeout) BufferStats() []BufferClassStats {
+	if p, ok := t.cfg.pool.(*bucketPool); ok {
+		return p.stats()
+	}
+	return nil
+}
+
+// observeSize records the size n of a response of the route, so that the next responses get a buffer fitting it. The
+// hint follows a larger response right away, and decays slowly otherwise, so that an occasional small response does
+// not cause the next large ones to grow their buffer.
+func (rc *routeCounters) observeSize(n int) {
+	for {
+		hint := rc.sizeHint.Load()
+		next := max(int64(n), hint-hint/8)
+		if next == hint || rc.sizeHint.CompareAndSwap(hint, next) {
+			return
+		}
+	}
+}
+
+// putBuffer returns buf to the pool of t, unless it is larger than the limit set with WithMaxPooledBufferSize.
+func (t *Timeout) putBuffer(buf *bytes.Buffer) {
+	if n := t.cfg.maxPooled; n > 0 && buf.Cap() > n {
+		return
+	}
+	t.cfg.pool.Put(buf)
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingPool struct {
+	mu   sync.Mutex
+	gets int
+	puts []int
+}
+
+func (p *countingPool) Get() *bytes.Buffer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gets++
+	return bytes.NewBufferString("stale")
+}
+
+func (p *countingPool) Put(buf *bytes.Buffer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.puts = append(p.puts, buf.Cap())
+}
+
+func TestBufferClass(t *testing.T) {
+	cases := []struct {
+		n    int
+		want int
+	}{
+		{n: 0, want: 0},
+		{n: 4 << 10, want: 0},
+		{n: 4<<10 + 1, want: 1},
+		{n: 1 << 20, want: 4},
+		{n: 4 << 20, want: 5},
+		{n: 64 << 20, want: 6},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, bufferClass(tc.n), tc.n)
+	}
+}
+
+func TestNewBufferPool(t *testing.T) {
+	p := NewBufferPool()
+	buf := p.Get()
+	require.NotNil(t, buf)
+	buf.WriteString("foo")
+	p.Put(buf)
+	p.Put(bytes.NewBuffer(make([]byte, 0, 8<<20)))
+	// Pooled buffers may be released at any time, so only the validity of the buffers is checked.
+	for range 3 {
+		assert.NotNil(t, p.Get())
+	}
+}
+
+func TestMiddleware_WithBufferPool(t *testing.T) {
+	pool := new(countingPool)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithBufferPool(pool))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	// The buffer is reset before use.
+	assert.NotContains(t, w.Body.String(), "stale")
+	assert.Equal(t, 1, pool.gets)
+	assert.Len(t, pool.puts, 1)
+}
+
+func TestMiddleware_WithMaxPooledBufferSize(t *testing.T) {
+	pool := new(countingPool)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithBufferPool(pool), WithMaxPooledBufferSize(64<<10))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/small", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, "small")
+	})
+	f.MustAdd(fox.MethodGet, "/large", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, strings.Repeat("x", 1<<20))
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/small", nil))
+	assert.Len(t, pool.puts, 1)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/large", nil))
+	assert.Equal(t, 1<<20, w.Body.Len())
+	assert.Len(t, pool.puts, 1)
+	assert.Equal(t, 2, pool.gets)
+}
+
+func TestBucketPool_GetSized(t *testing.T) {
+	p := NewBufferPool().(*bucketPool)
+	p.Put(bytes.NewBuffer(make([]byte, 0, 4<<10)))
+	// Pooled buffers may be released at any time, so only the capacity of the buffers and the number of lookups are
+	// checked.
+	for _, size := range []int{100 << 10, 1 << 10, 8 << 20} {
+		buf := p.GetSized(size)
+		assert.GreaterOrEqual(t, buf.Cap(), size)
+	}
+	var lookups uint64
+	for _, s := range p.stats() {
+		lookups += s.Hits + s.Misses
+	}
+	assert.Equal(t, uint64(3), lookups)
+	assert.Equal(t, 256<<10, p.stats()[3].Size)
+	assert.Zero(t, p.stats()[len(bufferClasses)].Size)
+}
+
+func TestRouteCounters_ObserveSize(t *testing.T) {
+	var rc routeCounters
+	rc.observeSize(80 << 10)
+	assert.Equal(t, int64(80<<10), rc.sizeHint.Load())
+	// A smaller response lowers the hint slowly.
+	rc.observeSize(1 << 10)
+	assert.Equal(t, int64(70<<10), rc.sizeHint.Load())
+	rc.observeSize(1 << 20)
+	assert.Equal(t, int64(1<<20), rc.sizeHint.Load())
+}
+
+func TestTimeout_BufferStats(t *testing.T) {
+	tm := New(time.Second, WithBufferPool(NewBufferPool()))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/large", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, strings.Repeat("x", 100<<10))
+	})
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/large", nil))
+		assert.Equal(t, 100<<10, w.Body.Len())
+	}
+	// The first request has no history, the second one looks up the class of its predecessor.
+	stats := tm.BufferStats()
+	require.Len(t, stats, len(bufferClasses)+1)
+	assert.Equal(t, uint64(1), stats[3].Hits+stats[3].Misses)
+
+	assert.Nil(t, New(time.Second, WithBufferPool(new(countingPool))).BufferStats())
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"github.com/fox-toolkit/fox"
+)
+
+// StatusClientClosedRequest is the non-standard status code, popularized by NGINX, recorded for the requests canceled
+// by the client before the handler completed, see [DefaultCanceledResponse].
+const StatusClientClosedRequest = 499
+
+// DefaultCanceledResponse sets the status of the response to [StatusClientClosedRequest], without a body, since the
+// client that went away will not read it. The status is only seen by the middleware running before this one, e.g.
+// to tell the canceled requests apart from the timed out ones in the access logs.
+func DefaultCanceledResponse(c *fox.Context) {
+	c.Writer().WriteHeader(StatusClientClosedRequest)
+}
+
+// WithCanceledResponse sets the response sent when the request is canceled before the handler completes, typically
+// because the client went away, in place of the timeout response, which is reserved to the handlers exceeding their
+// deadline. The cause of the cancellation is reported by [context.Cause] of the request context. The request is
+// reported with [OutcomeClientGone], and to the hook set with [WithOnCanceled] rather than [WithOnTimeout]. If not
+// set, the middleware uses [DefaultCanceledResponse]. A nil h restores the default.
+func WithCanceledResponse(h fox.HandlerFunc) Option {
+	return optionFunc(func(c *config) {
+		c.canceledResp = h
+	})
+}
+
+// WithOnCanceled sets a hook called when the request is canceled before the handler completes, typically because the
+// client went away, once the canceled response is written, see [WithCanceledResponse]. It is called synchronously on
+// the request path, before the [EventCanceled] event is sent to the [EventSink], so it should be fast. The handler
+// may still be running, and c must not be retained after the hook returns.
+func WithOnCanceled(fn func(c *fox.Context, info TimeoutInfo)) Option {
+	return optionFunc(func(c *config) {
+		c.onCanceled = fn
+	})
+}
+
+// writeCanceled writes the canceled response of the request.
+func (t *Timeout) writeCanceled(c *fox.Context) {
+	if h := t.cfg.canceledResp; h != nil {
+		h(c)
+		return
+	}
+	DefaultCanceledResponse(c)
+}
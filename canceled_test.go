@@ -0,0 +1,74 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithCanceledResponse(t *testing.T) {
+	cause := errors.New("client gone")
+	cases := []struct {
+		name string
+		opts []Option
+		code int
+	}{
+		{name: "default response", code: StatusClientClosedRequest},
+		{
+			name: "custom response",
+			opts: []Option{WithCanceledResponse(func(c *fox.Context) {
+				assert.Equal(t, cause, context.Cause(c.Request().Context()))
+				c.Writer().WriteHeader(http.StatusBadRequest)
+			})},
+			code: http.StatusBadRequest,
+		},
+		{name: "write gated", opts: []Option{WithEnforcement(WriteGated)}, code: StatusClientClosedRequest},
+		{name: "compat mode", opts: []Option{CompatMode()}, code: http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				timeouts, canceled int
+				events             []Event
+			)
+			opts := append([]Option{
+				WithOnTimeout(func(c *fox.Context, info TimeoutInfo) { timeouts++ }),
+				WithOnCanceled(func(c *fox.Context, info TimeoutInfo) { canceled++ }),
+				WithEventSink(EventSinkFunc(func(e Event) { events = append(events, e) })),
+			}, tc.opts...)
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, opts...)))
+			require.NoError(t, err)
+			started := make(chan struct{})
+			f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+				close(started)
+				<-c.Request().Context().Done()
+			})
+
+			ctx, cancel := context.WithCancelCause(context.Background())
+			go func() {
+				<-started
+				cancel(cause)
+			}()
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/slow", nil))
+			assert.Equal(t, tc.code, w.Code)
+			assert.Empty(t, w.Body.String())
+			assert.Zero(t, timeouts)
+			assert.Equal(t, 1, canceled)
+			require.Len(t, events, 1)
+			assert.Equal(t, EventCanceled, events[0].Kind)
+		})
+	}
+}
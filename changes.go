@@ -0,0 +1,135 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// maxChanges is the number of changes kept by a changeLog, the oldest ones being dropped first.
+const maxChanges = 100
+
+// Change is a change of the configuration of the middleware at runtime, as returned by [Timeout.Changes], for change
+// attribution during incident reviews.
+type Change struct {
+	// Time is when the change was made.
+	Time time.Time `json:"time"`
+	// Setting is the setting changed: "timeout" (see [Timeout.SetTimeout]), "response" (see [Timeout.SetResponse]),
+	// "rules" (see [Timeout.Apply]) or "policy" (see [Policy.Set] and [Policy.Load]).
+	Setting string `json:"setting"`
+	// Old and New are the values of the setting before and after the change: a duration, the name of a handler
+	// function, or the rules.
+	Old string `json:"old"`
+	New string `json:"new"`
+	// Source is the function that made the change, with its file and line, e.g. "main.(*admin).setTimeout
+	// (admin.go:42)".
+	Source string `json:"source"`
+}
+
+// changeLog records the last changes of the configuration.
+type changeLog struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+// record records a change of setting made from source at now.
+func (l *changeLog) record(now time.Time, setting, oldValue, newValue, source string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.changes) == maxChanges {
+		l.changes = slices.Delete(l.changes, 0, 1)
+	}
+	l.changes = append(l.changes, Change{Time: now, Setting: setting, Old: oldValue, New: newValue, Source: source})
+}
+
+// list returns a copy of the changes, oldest first.
+func (l *changeLog) list() []Change {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.changes)
+}
+
+// changeSource returns the caller of the exported function calling changeSource.
+func changeSource() string {
+	pc, file, line, ok := runtime.Caller(2)
+	if !ok {
+		return "unknown"
+	}
+	name := "unknown"
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		name = fn.Name()
+	}
+	return fmt.Sprintf("%s (%s:%d)", name, path.Base(file), line)
+}
+
+// handlerName returns the name of the function h.
+func handlerName(h fox.HandlerFunc) string {
+	if h == nil {
+		return "nil"
+	}
+	if fn := runtime.FuncForPC(reflect.ValueOf(h).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return "unknown"
+}
+
+// formatRules returns the patterns and methods of rules, since their route options cannot be represented.
+func formatRules(rules []Rule) string {
+	parts := make([]string, 0, len(rules))
+	for _, r := range rules {
+		if len(r.Methods) > 0 {
+			parts = append(parts, strings.Join(r.Methods, ",")+" "+r.Pattern)
+			continue
+		}
+		parts = append(parts, r.Pattern)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// formatPolicy returns the JSON encoding of rules, as loaded by Policy.Load.
+func formatPolicy(rules []PolicyRule) string {
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// Changes returns the last changes of the configuration of t made at runtime, with the ones of the policy set with
+// [WithPolicy], oldest first. Only the last 100 changes of t and of the policy are kept.
+func (t *Timeout) Changes() []Change {
+	changes := t.changes.list()
+	if p := t.cfg.policy; p != nil {
+		changes = append(changes, p.changes.list()...)
+		slices.SortStableFunc(changes, func(a, b Change) int {
+			return a.Time.Compare(b.Time)
+		})
+	}
+	return changes
+}
+
+// ChangesHandler returns a [fox.HandlerFunc] rendering the changes returned by [Timeout.Changes], e.g. to serve a
+// /debug/timeouts/changes endpoint next to [Timeout.StatsHandler]. The endpoint discloses the configuration of the
+// application, so it should not be exposed publicly. The encoding and the redacted fields are set with
+// [WithMarshaler] and [WithFieldRedaction].
+func (t *Timeout) ChangesHandler() fox.HandlerFunc {
+	return func(c *fox.Context) {
+		changes := t.Changes()
+		if changes == nil {
+			changes = []Change{}
+		}
+		t.render(c, changes)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout_Changes(t *testing.T) {
+	p, err := NewPolicy([]PolicyRule{{Pattern: "/foo", Handler: durationPtr(time.Second)}})
+	require.NoError(t, err)
+	tm := New(time.Second, WithPolicy(p))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+	assert.Empty(t, tm.Changes())
+
+	tm.SetTimeout(2 * time.Second)
+	tm.SetResponse(JSONResponse)
+	tm.SetResponse(nil)
+	require.NoError(t, p.Set([]PolicyRule{{Pattern: "/foo", Handler: durationPtr(3 * time.Second)}}))
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"pattern": "/bar", "handler": "4s"}]`), 0o644))
+	require.NoError(t, p.Load(path))
+	require.NoError(t, tm.Apply(f, []Rule{{Pattern: "/foo", Methods: []string{http.MethodGet}, Options: []fox.RouteOption{OverrideHandler(time.Second)}}}))
+	// The rejected changes are not recorded.
+	assert.Error(t, p.Set([]PolicyRule{{Pattern: ""}}))
+
+	changes := tm.Changes()
+	require.Len(t, changes, 6)
+	want := []struct{ setting, old, new string }{
+		{"timeout", "1s", "2s"},
+		{"response", handlerName(DefaultResponse), handlerName(JSONResponse)},
+		{"response", handlerName(JSONResponse), handlerName(DefaultResponse)},
+		{"policy", `[{"pattern":"/foo","handler":"1s"}]`, `[{"pattern":"/foo","handler":"3s"}]`},
+		{"policy", `[{"pattern":"/foo","handler":"3s"}]`, `[{"pattern":"/bar","handler":"4s"}]`},
+		{"rules", "", "GET /foo"},
+	}
+	for i, w := range want {
+		assert.Equal(t, w.setting, changes[i].Setting)
+		assert.Equal(t, w.old, changes[i].Old)
+		assert.Equal(t, w.new, changes[i].New)
+		assert.Contains(t, changes[i].Source, "TestTimeout_Changes (changes_test.go:")
+		assert.False(t, changes[i].Time.IsZero())
+	}
+
+	w := httptest.NewRecorder()
+	tm.ChangesHandler()(fox.NewTestContextOnly(w, httptest.NewRequest(http.MethodGet, "/debug/timeouts/changes", nil)))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var got []Change
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Len(t, got, 6)
+}
+
+func TestTimeout_ChangesLimit(t *testing.T) {
+	tm := New(time.Second)
+	w := httptest.NewRecorder()
+	tm.ChangesHandler()(fox.NewTestContextOnly(w, httptest.NewRequest(http.MethodGet, "/debug/timeouts/changes", nil)))
+	assert.Equal(t, "[]", strings.TrimSpace(w.Body.String()))
+
+	for i := range maxChanges + 10 {
+		tm.SetTimeout(time.Duration(i+1) * time.Millisecond)
+	}
+	changes := tm.Changes()
+	require.Len(t, changes, maxChanges)
+	assert.Equal(t, "10ms", changes[0].Old)
+	assert.Equal(t, "110ms", changes[maxChanges-1].New)
+}
@@ -0,0 +1,45 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// chaosMargin is how long after the deadline a handler forced to time out completes, so that the timeout always
+// wins, including when the handler context is not canceled at the deadline.
+const chaosMargin = 10 * time.Millisecond
+
+type chaos struct {
+	probability float64
+	extraDelay  time.Duration
+}
+
+// pick reports whether the request should be disturbed.
+func (ch *chaos) pick() bool {
+	return rand.Float64() < ch.probability
+}
+
+// wrap returns a handler that delays the completion of next, until after the deadline if no extra delay is set.
+func (ch *chaos) wrap(next fox.HandlerFunc, clock Clock) fox.HandlerFunc {
+	return func(c *fox.Context) {
+		next(c)
+
+		d := ch.extraDelay
+		if d <= 0 {
+			deadline, _ := Deadline(c)
+			d = deadline.Sub(clock.Now()) + chaosMargin
+		}
+		timer := clock.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+		case <-c.Request().Context().Done():
+		}
+	}
+}
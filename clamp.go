@@ -0,0 +1,50 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"time"
+)
+
+// Clamp reports whether the resolved timeout of a request was clamped to the bounds set with [WithMinTimeout] and
+// [WithMaxTimeout].
+type Clamp uint8
+
+const (
+	// ClampNone means that the resolved timeout is within the bounds, and applied as is.
+	ClampNone Clamp = iota
+	// ClampMin means that the resolved timeout was raised to the minimum set with [WithMinTimeout].
+	ClampMin
+	// ClampMax means that the resolved timeout was lowered to the maximum set with [WithMaxTimeout].
+	ClampMax
+)
+
+// String returns the name of the clamp.
+func (c Clamp) String() string {
+	switch c {
+	case ClampNone:
+		return "none"
+	case ClampMin:
+		return "min"
+	case ClampMax:
+		return "max"
+	default:
+		return "unknown"
+	}
+}
+
+// clamp bounds the positive timeout dt to the minimum and maximum timeouts, if set.
+func (t *Timeout) clamp(dt time.Duration) (time.Duration, Clamp) {
+	if dt <= 0 {
+		return dt, ClampNone
+	}
+	if m := t.cfg.minTimeout; m > 0 && dt < m {
+		return m, ClampMin
+	}
+	if m := t.cfg.maxTimeout; m > 0 && dt > m {
+		return m, ClampMax
+	}
+	return dt, ClampNone
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithMinMaxTimeout(t *testing.T) {
+	var (
+		mu           sync.Mutex
+		observations []Observation
+	)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second,
+		WithMinTimeout(100*time.Millisecond),
+		WithMaxTimeout(time.Minute),
+		WithTimeoutResolver(func(c *fox.Context) time.Duration {
+			if c.Request().Header.Get("X-Long") != "" {
+				return 10 * time.Hour
+			}
+			return -1
+		}),
+		WithMetrics(MetricsRecorderFunc(func(o Observation) {
+			mu.Lock()
+			defer mu.Unlock()
+			observations = append(observations, o)
+		})),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/short", func(c *fox.Context) {
+		time.Sleep(10 * time.Millisecond)
+		c.Writer().WriteHeader(http.StatusOK)
+	}, OverrideHandler(time.Microsecond))
+	f.MustAdd(fox.MethodGet, "/default", func(c *fox.Context) {})
+	f.MustAdd(fox.MethodGet, "/unbounded", func(c *fox.Context) {}, OverrideHandler(NoTimeout))
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/short", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/default", nil)
+	req.Header.Set("X-Long", "1")
+	f.ServeHTTP(httptest.NewRecorder(), req)
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/default", nil))
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unbounded", nil))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, observations, 3)
+	assert.Equal(t, 100*time.Millisecond, observations[0].Timeout)
+	assert.Equal(t, ClampMin, observations[0].Clamp)
+	assert.Equal(t, time.Minute, observations[1].Timeout)
+	assert.Equal(t, ClampMax, observations[1].Clamp)
+	assert.Equal(t, time.Second, observations[2].Timeout)
+	assert.Equal(t, ClampNone, observations[2].Clamp)
+}
+
+func TestClamp_String(t *testing.T) {
+	assert.Equal(t, "none", ClampNone.String())
+	assert.Equal(t, "min", ClampMin.String())
+	assert.Equal(t, "max", ClampMax.String())
+	assert.Equal(t, "unknown", Clamp(42).String())
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// clientIP returns the IP address of the client of the request served by c, as resolved by the resolver set with
+// WithClientIPResolver, or else by the resolver of the router.
+func (t *Timeout) clientIP(c *fox.Context) (*net.IPAddr, error) {
+	if r := t.cfg.ipResolver; r != nil {
+		return r.ClientIP(c)
+	}
+	return c.ClientIP()
+}
+
+// trustedCaller reports whether the request served by c comes from a trusted caller, as told by the predicate set
+// with WithTrustedBypass or the networks set with WithTrustedNetworks.
+func (t *Timeout) trustedCaller(c *fox.Context) bool {
+	if t.cfg.trusted != nil && t.cfg.trusted(c.Request()) {
+		return true
+	}
+	if len(t.cfg.trustedNets) == 0 {
+		return false
+	}
+	ip, err := t.clientIP(c)
+	if err != nil {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(ip.IP)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range t.cfg.trustedNets {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
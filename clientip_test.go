@@ -0,0 +1,79 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/fox-toolkit/fox/clientip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithTrustedNetworks(t *testing.T) {
+	resolver, err := clientip.NewSingleIPHeader("X-Real-IP")
+	require.NoError(t, err)
+	events := make(chan Event, 1)
+	f, err := fox.NewRouter(
+		fox.WithClientIPResolver(resolver),
+		fox.WithMiddleware(Middleware(10*time.Millisecond,
+			WithTrustedNetworks(netip.MustParsePrefix("10.0.0.0/8")),
+			WithEventSink(EventSinkFunc(func(ev Event) { events <- ev })),
+		)),
+	)
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/backfill", func(c *fox.Context) {
+		time.Sleep(30 * time.Millisecond)
+		_ = c.String(http.StatusOK, "done")
+	}, OverrideTrusted(NoTimeout))
+
+	cases := []struct {
+		name     string
+		ip       string
+		wantCode int
+	}{
+		{name: "trusted", ip: "10.1.2.3", wantCode: http.StatusOK},
+		{name: "untrusted", ip: "203.0.113.7", wantCode: http.StatusServiceUnavailable},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/backfill", nil)
+			req.Header.Set("X-Real-IP", tc.ip)
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+			assert.Equal(t, tc.wantCode, w.Code)
+		})
+	}
+	// The event reports the client IP resolved by the router, rather than the address of the peer.
+	ev := <-events
+	assert.Equal(t, EventTimeout, ev.Kind)
+	assert.Equal(t, "203.0.113.7", ev.ClientIP)
+}
+
+func TestMiddleware_WithClientIPResolver(t *testing.T) {
+	events := make(chan Event, 1)
+	resolver := fox.ClientIPResolverFunc(func(c fox.RequestContext) (*net.IPAddr, error) {
+		return &net.IPAddr{IP: net.ParseIP("192.0.2.1")}, nil
+	})
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond,
+		WithClientIPResolver(resolver),
+		WithEventSink(EventSinkFunc(func(ev Event) { events <- ev })),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "192.0.2.1", (<-events).ClientIP)
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time of the middleware, see [WithClock]. Implementations must be safe for concurrent use.
+// If a Clock also implements
+//
+//	WithDeadlineCause(parent context.Context, deadline time.Time, cause error) (context.Context, context.CancelFunc)
+//
+// it is used to derive the handler contexts, so that they are canceled with [ErrTimeout] as their cause, as
+// [context.WithDeadlineCause] does with the wall clock. Otherwise, their cause is [context.DeadlineExceeded].
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer creates a new Timer that sends the current time on its channel after at least d.
+	NewTimer(d time.Duration) Timer
+	// WithDeadline returns a copy of parent that is canceled with context.DeadlineExceeded once the clock reaches
+	// deadline, as context.WithDeadline does with the wall clock.
+	WithDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc)
+}
+
+// Timer is a timer created by a [Clock], with the semantics of [time.Timer].
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing. It reports whether the call stops the timer.
+	Stop() bool
+	// Reset changes the timer to expire after d. It reports whether the timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// realClock is the Clock reading the wall clock, used by default.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{t: time.NewTimer(d)}
+}
+
+func (realClock) WithDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	return context.WithDeadline(parent, deadline)
+}
+
+func (realClock) WithDeadlineCause(parent context.Context, deadline time.Time, cause error) (context.Context, context.CancelFunc) {
+	return context.WithDeadlineCause(parent, deadline, cause)
+}
+
+// withDeadlineCause is like clock.WithDeadline, but the returned context is canceled with cause once the deadline is
+// reached, if the clock supports it.
+func withDeadlineCause(clock Clock, parent context.Context, deadline time.Time, cause error) (context.Context, context.CancelFunc) {
+	if cc, ok := clock.(interface {
+		WithDeadlineCause(parent context.Context, deadline time.Time, cause error) (context.Context, context.CancelFunc)
+	}); ok {
+		return cc.WithDeadlineCause(parent, deadline, cause)
+	}
+	return clock.WithDeadline(parent, deadline)
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (rt realTimer) C() <-chan time.Time {
+	return rt.t.C
+}
+
+func (rt realTimer) Stop() bool {
+	return rt.t.Stop()
+}
+
+func (rt realTimer) Reset(d time.Duration) bool {
+	return rt.t.Reset(d)
+}
+
+// now returns the current time of the clock of t.
+func (t *Timeout) now() time.Time {
+	return t.cfg.clock.Now()
+}
+
+// since returns the time elapsed since tm according to the clock of t.
+func (t *Timeout) since(tm time.Time) time.Duration {
+	return t.now().Sub(tm)
+}
+
+// until returns the duration until tm according to the clock of t.
+func (t *Timeout) until(tm time.Time) time.Duration {
+	return tm.Sub(t.now())
+}
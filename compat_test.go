@@ -0,0 +1,124 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// compatResult is what a client and a handler observe from a request, for comparing the middleware with
+// http.TimeoutHandler.
+type compatResult struct {
+	header http.Header
+	body   string
+	code   int
+	err    error
+}
+
+func TestCompatMode(t *testing.T) {
+	const dt = 20 * time.Millisecond
+
+	cases := []struct {
+		name    string
+		handler func(w http.ResponseWriter, r *http.Request) error
+		cancel  bool
+	}{
+		{
+			name: "complete",
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("X-Before", "1")
+				w.WriteHeader(http.StatusCreated)
+				// Unlike with a plain writer, headers set after WriteHeader are sent.
+				w.Header().Set("X-After", "1")
+				_, err := io.WriteString(w, "created")
+				return err
+			},
+		},
+		{
+			name: "implicit status",
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				_, err := io.WriteString(w, "<p>hello</p>")
+				return err
+			},
+		},
+		{
+			name: "no response",
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				return nil
+			},
+		},
+		{
+			name: "flush",
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				return http.NewResponseController(w).Flush()
+			},
+		},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("X-Discarded", "1")
+				<-r.Context().Done()
+				time.Sleep(dt)
+				_, err := io.WriteString(w, "late")
+				return err
+			},
+		},
+		{
+			name:   "client gone",
+			cancel: true,
+			handler: func(w http.ResponseWriter, r *http.Request) error {
+				<-r.Context().Done()
+				time.Sleep(dt)
+				_, err := io.WriteString(w, "late")
+				return err
+			},
+		},
+	}
+
+	serve := func(h http.Handler, handlerErr <-chan error, cancel bool) compatResult {
+		ctx, cancelFn := context.WithCancel(context.Background())
+		defer cancelFn()
+		if cancel {
+			time.AfterFunc(dt/4, cancelFn)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/foo", nil))
+		return compatResult{header: w.Header(), body: w.Body.String(), code: w.Code, err: <-handlerErr}
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stdErr := make(chan error, 1)
+			std := http.TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				stdErr <- tc.handler(w, r)
+			}), dt, "")
+
+			foxErr := make(chan error, 1)
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(dt, CompatMode())))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+				foxErr <- tc.handler(c.Writer(), c.Request())
+			})
+
+			want := serve(std, stdErr, tc.cancel)
+			got := serve(f, foxErr, tc.cancel)
+			assert.Equal(t, want.code, got.code)
+			assert.Equal(t, want.header, got.header)
+			assert.Equal(t, want.body, got.body)
+			// Some errors are wrapped on the fly, so only their message is comparable.
+			assert.Equal(t, fmt.Sprint(want.err), fmt.Sprint(got.err))
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// Scope returns a RouteOption serving the routes it is given to under their own [Timeout], created with dt and
+// opts, in place of the middleware installed for the router, so that a group of routes can have its own timeout,
+// response, telemetry and so on, while sharing the router. The same RouteOption can be given to several routes to
+// form a scope sharing a single instance. The instance of the scope is configured with opts only: it does not
+// inherit the options of the router middleware.
+//
+// Since fox has no group-level middleware, the scope is applied by the middleware installed with [Middleware] or
+// [New] for the router, which delegates the routes of the scope to its instance: the scope has no effect on the
+// routes that are not served behind such a middleware. The innermost instance wins: the timeout of the scope
+// replaces the timeout of the router middleware, whether it is shorter or longer, but the chain budget set with
+// [Outer], if any, still applies, since deadlines nest. The counters of the routes of the scope are merged into the
+// counters of the router middleware, see [Timeout.Stats].
+func Scope(dt time.Duration, opts ...Option) fox.RouteOption {
+	return fox.WithAnnotation(cKey{}, create(dt, opts...))
+}
+
+// scoped returns the instance of the scope of the route serving c, or nil if the route is not in a scope, or is
+// served by t itself.
+func (t *Timeout) scoped(c *fox.Context) *Timeout {
+	if t.outer {
+		return nil
+	}
+	r := t.route(c.Route())
+	if r == nil {
+		return nil
+	}
+	if s, ok := r.Annotation(cKey{}).(*Timeout); ok && s != t {
+		return s
+	}
+	return nil
+}
+
+// delegate serves c under the instance s of its scope, whose counters are merged into the counters of t. The handler
+// of s wrapping next is built on the first request of the scope, and kept in handlers for the next ones.
+func (t *Timeout) delegate(s *Timeout, c *fox.Context, handlers *sync.Map, next fox.HandlerFunc) {
+	h, ok := handlers.Load(s)
+	if !ok {
+		if _, ok := t.scopes.Load(s); !ok {
+			t.scopes.LoadOrStore(s, struct{}{})
+		}
+		h, _ = handlers.LoadOrStore(s, s.run(next))
+	}
+	h.(fox.HandlerFunc)(c)
+}
+
+// merge returns the sum of the counters rc and other of the same route.
+func (rc RouteCounters) merge(other RouteCounters) RouteCounters {
+	return RouteCounters{
+		Requests:     rc.Requests + other.Requests,
+		Completed:    rc.Completed + other.Completed,
+		TimedOut:     rc.TimedOut + other.TimedOut,
+		ClientGone:   rc.ClientGone + other.ClientGone,
+		Panicked:     rc.Panicked + other.Panicked,
+		OverBudget:   rc.OverBudget + other.OverBudget,
+		Shed:         rc.Shed + other.Shed,
+		MaxLatency:   max(rc.MaxLatency, other.MaxLatency),
+		InFlight:     rc.InFlight + other.InFlight,
+		PeakInFlight: max(rc.PeakInFlight, other.PeakInFlight),
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScope(t *testing.T) {
+	sleep := func(d time.Duration) fox.HandlerFunc {
+		return func(c *fox.Context) {
+			select {
+			case <-time.After(d):
+				_ = c.String(http.StatusOK, "done")
+			case <-c.Request().Context().Done():
+			}
+		}
+	}
+	teapot := WithResponse(func(c *fox.Context) {
+		c.Writer().WriteHeader(http.StatusTeapot)
+	})
+
+	cases := []struct {
+		name  string
+		mws   []fox.MiddlewareFunc
+		scope fox.RouteOption
+		// d is how long the handler sleeps.
+		d    time.Duration
+		code int
+	}{
+		{
+			name:  "longer scope wins over the router middleware",
+			mws:   []fox.MiddlewareFunc{Middleware(10 * time.Millisecond)},
+			scope: Scope(time.Second),
+			d:     30 * time.Millisecond,
+			code:  http.StatusOK,
+		},
+		{
+			name:  "shorter scope wins over the router middleware",
+			mws:   []fox.MiddlewareFunc{Middleware(time.Second)},
+			scope: Scope(10*time.Millisecond, teapot),
+			d:     time.Second,
+			code:  http.StatusTeapot,
+		},
+		{
+			name:  "scope within the chain budget",
+			mws:   []fox.MiddlewareFunc{Outer(time.Second), Middleware(10 * time.Millisecond)},
+			scope: Scope(time.Second),
+			d:     30 * time.Millisecond,
+			code:  http.StatusOK,
+		},
+		{
+			name:  "chain budget bounds the scope",
+			mws:   []fox.MiddlewareFunc{Outer(10 * time.Millisecond), Middleware(time.Second)},
+			scope: Scope(time.Second, teapot),
+			d:     time.Second,
+			code:  http.StatusServiceUnavailable,
+		},
+		{
+			name:  "scope behind the chain budget only",
+			mws:   []fox.MiddlewareFunc{Outer(time.Second)},
+			scope: Scope(10*time.Millisecond, teapot),
+			d:     30 * time.Millisecond,
+			code:  http.StatusOK,
+		},
+		{
+			name:  "scope without router middleware",
+			scope: Scope(10*time.Millisecond, teapot),
+			d:     30 * time.Millisecond,
+			code:  http.StatusOK,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(tc.mws...))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/foo", sleep(tc.d), tc.scope)
+
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+			assert.Equal(t, tc.code, w.Code)
+		})
+	}
+}
+
+func TestScope_Stats(t *testing.T) {
+	tm := New(time.Second)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	scope := Scope(100 * time.Millisecond)
+	f.MustAdd(fox.MethodGet, "/foo", success201response, scope)
+	f.MustAdd(fox.MethodGet, "/bar", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	}, scope)
+	f.MustAdd(fox.MethodGet, "/baz", success201response)
+
+	for _, path := range []string{"/foo", "/bar", "/baz"} {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	stats := tm.Stats()
+	require.Len(t, stats, 3)
+	assert.Equal(t, RouteCounters{Requests: 1, Completed: 1}, withoutLatency(stats["/foo"]))
+	assert.Equal(t, RouteCounters{Requests: 1, TimedOut: 1}, withoutLatency(stats["/bar"]))
+	assert.Equal(t, RouteCounters{Requests: 1, Completed: 1}, withoutLatency(stats["/baz"]))
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var gzipPool = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+type compression struct {
+	minSize   int
+	minBudget time.Duration
+}
+
+// compress compresses body into dst and updates the response headers h accordingly, if the client accepts gzip,
+// the response is eligible and at least the minimum budget remains. It reports whether the body was compressed.
+func (cp *compression) compress(dst *bytes.Buffer, req *http.Request, h http.Header, code int, body []byte, remaining time.Duration) bool {
+	if len(body) < cp.minSize || !bodyAllowed(req, code) || code == http.StatusPartialContent {
+		return false
+	}
+	// A Content-Encoding header means that the handler, or a compression middleware running before, already
+	// took care of the encoding.
+	if h.Get("Content-Encoding") != "" || !acceptsGzip(req.Header) || gzipped(h, body) {
+		return false
+	}
+	if remaining < cp.minBudget {
+		return false
+	}
+
+	zw := gzipPool.Get().(*gzip.Writer)
+	defer gzipPool.Put(zw)
+	zw.Reset(dst)
+	if _, err := zw.Write(body); err != nil {
+		return false
+	}
+	if err := zw.Close(); err != nil {
+		return false
+	}
+
+	// The content type must be sniffed from the uncompressed body, as the server would have done.
+	if _, ok := h["Content-Type"]; !ok {
+		h.Set("Content-Type", http.DetectContentType(body))
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+	h.Del("Content-Length")
+	// The representation sent differs from the one the handler tagged, so a strong validator no longer holds.
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	return true
+}
+
+// gzipped reports whether the handler negotiated a gzip encoded body without declaring it with a Content-Encoding
+// header, e.g. when serving a precompressed file, so that compressing it again would garble the response.
+func gzipped(h http.Header, body []byte) bool {
+	mt, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	switch strings.ToLower(strings.TrimSpace(mt)) {
+	case "application/gzip", "application/x-gzip":
+		return true
+	}
+	return bytes.HasPrefix(body, gzipMagic)
+}
+
+// gzipMagic is the header of the gzip members, see RFC 1952.
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// bodyAllowed reports whether a response to req with the given status code may have a body.
+func bodyAllowed(req *http.Request, code int) bool {
+	if req.Method == http.MethodHead {
+		return false
+	}
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of the request allows a gzip encoded response.
+func acceptsGzip(h http.Header) bool {
+	for _, v := range h.Values("Accept-Encoding") {
+		for part := range strings.SplitSeq(v, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+				continue
+			}
+			name, value, ok := strings.Cut(strings.TrimSpace(params), "=")
+			if !ok || strings.TrimSpace(name) != "q" {
+				return true
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			return err == nil && q > 0
+		}
+	}
+	return false
+}
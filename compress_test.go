@@ -0,0 +1,96 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithCompression(t *testing.T) {
+	payload := strings.Repeat("foo bar ", 128)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithCompression(64, 10*time.Millisecond))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/large", func(c *fox.Context) {
+		c.SetHeader("Content-Length", strconv.Itoa(len(payload)))
+		c.SetHeader("ETag", `"v1"`)
+		_, _ = io.WriteString(c.Writer(), payload)
+	})
+	f.MustAdd(fox.MethodGet, "/small", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, "foo")
+	})
+	f.MustAdd(fox.MethodGet, "/encoded", func(c *fox.Context) {
+		c.SetHeader("Content-Encoding", "br")
+		_ = c.String(http.StatusOK, payload)
+	})
+	f.MustAdd(fox.MethodGet, "/precompressed", func(c *fox.Context) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = io.WriteString(zw, payload)
+		_ = zw.Close()
+		_ = c.Blob(http.StatusOK, "application/octet-stream", buf.Bytes())
+	})
+	f.MustAdd(fox.MethodGet, "/archive", func(c *fox.Context) {
+		_ = c.Blob(http.StatusOK, "application/gzip", []byte(payload))
+	})
+	f.MustAdd(fox.MethodGet, "/budget", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, payload)
+	}, OverrideHandler(5*time.Millisecond))
+
+	t.Run("compressed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/large", nil)
+		req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Empty(t, w.Header().Get("Content-Length"))
+		assert.Equal(t, `W/"v1"`, w.Header().Get("ETag"))
+		zr, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(body))
+	})
+
+	cases := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+	}{
+		{name: "not accepted", path: "/large", acceptEncoding: "gzip;q=0, br"},
+		{name: "too small", path: "/small", acceptEncoding: "gzip"},
+		{name: "already encoded", path: "/encoded", acceptEncoding: "gzip"},
+		{name: "precompressed body", path: "/precompressed", acceptEncoding: "gzip"},
+		{name: "gzip content type", path: "/archive", acceptEncoding: "gzip"},
+		{name: "not enough budget", path: "/budget", acceptEncoding: "gzip"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NotEqual(t, "gzip", w.Header().Get("Content-Encoding"))
+		})
+	}
+}
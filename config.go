@@ -0,0 +1,567 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// ErrInvalidConfig is returned when a [Config] is invalid.
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Duration is a [time.Duration] that is encoded as a string such as "1.5s" or "300ms" in configuration files. It is
+// decoded with [ParseDuration], so integer milliseconds (e.g. "1500") and ISO-8601 durations (e.g. "PT1.5S") are
+// accepted as well.
+type Duration time.Duration
+
+// MarshalText implements [encoding.TextMarshaler].
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (d *Duration) UnmarshalText(text []byte) error {
+	dt, err := ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(dt)
+	return nil
+}
+
+// Config is a plain representation of the middleware configuration, suitable to be decoded from a configuration
+// file (e.g. JSON or YAML). See [FromConfig].
+type Config struct {
+	// BodyCancellation controls the request body cancellation. See [WithBodyCancellation]. If nil, the default
+	// applies.
+	BodyCancellation *bool `json:"bodyCancellation,omitempty" yaml:"bodyCancellation,omitempty"`
+	// HostTimeouts sets the timeout by host. See [WithHostTimeouts].
+	HostTimeouts map[string]Duration `json:"hostTimeouts,omitempty" yaml:"hostTimeouts,omitempty"`
+	// PatternTimeouts sets the timeout by route pattern. See [WithPatternTimeouts].
+	PatternTimeouts map[string]Duration `json:"patternTimeouts,omitempty" yaml:"patternTimeouts,omitempty"`
+	// Policy sets the timeouts of the routes matching the patterns of the rules. See [WithPolicy].
+	Policy []PolicyRule `json:"policy,omitempty" yaml:"policy,omitempty"`
+	// Preset is the name of a preset applied before any other option: "api" for [PresetAPI], "web" for
+	// [PresetWeb], "streaming" for [PresetStreaming] or "compat" for [CompatMode].
+	Preset string `json:"preset,omitempty" yaml:"preset,omitempty"`
+	// ErrorFormat is the name of the format of the timeout response: "plain", "problem", "jsonapi" or "graphql". See
+	// [WithErrorFormat].
+	ErrorFormat string `json:"errorFormat,omitempty" yaml:"errorFormat,omitempty"`
+	// ElapsedHeader is the name of the header reporting the handler duration. See [WithElapsedHeader].
+	ElapsedHeader string `json:"elapsedHeader,omitempty" yaml:"elapsedHeader,omitempty"`
+	// BudgetTrailer is the name of the trailer reporting the remaining budget. See [WithBudgetTrailer].
+	BudgetTrailer string `json:"budgetTrailer,omitempty" yaml:"budgetTrailer,omitempty"`
+	// MaxHeaderBytes limits the size of the handler responses header block. See [WithMaxHeaderBytes].
+	MaxHeaderBytes int `json:"maxHeaderBytes,omitempty" yaml:"maxHeaderBytes,omitempty"`
+	// Redaction sets the headers, query parameters and fields redacted from diagnostic outputs. See [WithRedaction].
+	Redaction RedactionConfig `json:"redaction" yaml:"redaction"`
+	// UnboundedRoute sets the thresholds of the unbounded route detection. See [WithUnboundedRouteDetection].
+	UnboundedRoute UnboundedRouteConfig `json:"unboundedRoute" yaml:"unboundedRoute"`
+	// Timeout is the global handler timeout. Zero means no timeout.
+	Timeout Duration `json:"timeout" yaml:"timeout"`
+	// Precision sets the deadline precision. See [WithPrecision].
+	Precision Duration `json:"precision,omitempty" yaml:"precision,omitempty"`
+	// FallbackBudget sets the fallback handler budget. See [WithFallbackBudget].
+	FallbackBudget Duration `json:"fallbackBudget,omitempty" yaml:"fallbackBudget,omitempty"`
+	// ResponseBudget sets the timeout response handler budget. See [WithResponseBudget].
+	ResponseBudget Duration `json:"responseBudget,omitempty" yaml:"responseBudget,omitempty"`
+	// SafeResponseDeadline sets the write deadline of the safe timeout response handler. See
+	// [WithSafeResponseDeadline].
+	SafeResponseDeadline Duration `json:"safeResponseDeadline,omitempty" yaml:"safeResponseDeadline,omitempty"`
+	// ContinueTimeout sets the time allowed to the client to start sending the body after a 100 Continue response.
+	// See [WithContinueTimeout].
+	ContinueTimeout Duration `json:"continueTimeout,omitempty" yaml:"continueTimeout,omitempty"`
+	// RuntimeStats enables the runtime statistics snapshot. See [WithRuntimeStats].
+	RuntimeStats bool `json:"runtimeStats,omitempty" yaml:"runtimeStats,omitempty"`
+	// TraceRegions enables the runtime/trace instrumentation. See [WithTraceRegions].
+	TraceRegions bool `json:"traceRegions,omitempty" yaml:"traceRegions,omitempty"`
+	// LogOutcome records the outcome of the requests for the logging middleware. See [WithLogOutcome].
+	LogOutcome bool `json:"logOutcome,omitempty" yaml:"logOutcome,omitempty"`
+	// RetryAfter enables the strict RFC compliance of the timeout responses, with the given Retry-After delay, if
+	// not zero. See [WithRFCCompliance].
+	RetryAfter Duration `json:"retryAfter,omitempty" yaml:"retryAfter,omitempty"`
+	// Compression enables the compression of the buffered responses if not nil. See [WithCompression].
+	Compression *CompressionConfig `json:"compression,omitempty" yaml:"compression,omitempty"`
+	// Chaos enables the chaos testing mode. See [WithChaos].
+	Chaos ChaosConfig `json:"chaos" yaml:"chaos"`
+	// DebuggerDetection stretches the timeouts while a debugger is attached. See [WithDebuggerDetection].
+	DebuggerDetection bool `json:"debuggerDetection,omitempty" yaml:"debuggerDetection,omitempty"`
+	// Reentrant allows the middleware to nest. See [Reentrant].
+	Reentrant bool `json:"reentrant,omitempty" yaml:"reentrant,omitempty"`
+	// AutoRequestTimeout responds with 408 Request Timeout when the request body does not arrive in time. See
+	// [WithAutoRequestTimeout].
+	AutoRequestTimeout bool `json:"autoRequestTimeout,omitempty" yaml:"autoRequestTimeout,omitempty"`
+	// VersionLabel sets the application version reported with the telemetry. See [WithVersionLabel].
+	VersionLabel string `json:"versionLabel,omitempty" yaml:"versionLabel,omitempty"`
+	// StatusCode sets the status code of the built-in timeout responses. See [WithStatusCode].
+	StatusCode int `json:"statusCode,omitempty" yaml:"statusCode,omitempty"`
+	// HeaderTimeout derives the timeout from a request header if not nil. See [WithHeaderTimeout].
+	HeaderTimeout *HeaderTimeoutConfig `json:"headerTimeout,omitempty" yaml:"headerTimeout,omitempty"`
+	// GracePeriod sets how long to wait for a handler past its deadline. See [WithGracePeriod].
+	GracePeriod Duration `json:"gracePeriod,omitempty" yaml:"gracePeriod,omitempty"`
+	// FirstByteTimeout sets how long the handler has to start its response. See [WithFirstByteTimeout].
+	FirstByteTimeout Duration `json:"firstByteTimeout,omitempty" yaml:"firstByteTimeout,omitempty"`
+	// MinTimeout sets the lower bound of the resolved handler timeouts. See [WithMinTimeout].
+	MinTimeout Duration `json:"minTimeout,omitempty" yaml:"minTimeout,omitempty"`
+	// MaxTimeout sets the upper bound of the resolved handler timeouts. See [WithMaxTimeout].
+	MaxTimeout Duration `json:"maxTimeout,omitempty" yaml:"maxTimeout,omitempty"`
+	// ReportRetention enables the recording of the report history. See [WithReportRetention].
+	ReportRetention Duration `json:"reportRetention,omitempty" yaml:"reportRetention,omitempty"`
+	// ReadDeadline sets the default read deadline of the connection. See [WithReadDeadline].
+	ReadDeadline Duration `json:"readDeadline,omitempty" yaml:"readDeadline,omitempty"`
+	// WriteDeadline sets the default write deadline of the connection. See [WithWriteDeadline].
+	WriteDeadline Duration `json:"writeDeadline,omitempty" yaml:"writeDeadline,omitempty"`
+	// IdleWriteTimeout sets the default sliding write deadline of the connection. See [WithIdleWriteTimeout].
+	IdleWriteTimeout Duration `json:"idleWriteTimeout,omitempty" yaml:"idleWriteTimeout,omitempty"`
+	// BodyTimeout bounds the time taken to read the request body. See [WithBodyTimeout].
+	BodyTimeout Duration `json:"bodyTimeout,omitempty" yaml:"bodyTimeout,omitempty"`
+	// BodyDrain discards the rest of the request body after a timeout if not nil. See [WithBodyDrain].
+	BodyDrain *BodyDrainConfig `json:"bodyDrain,omitempty" yaml:"bodyDrain,omitempty"`
+	// MaxPooledBufferSize drops the response buffers larger than the given size. See [WithMaxPooledBufferSize].
+	MaxPooledBufferSize int `json:"maxPooledBufferSize,omitempty" yaml:"maxPooledBufferSize,omitempty"`
+	// InFlightByRoute tracks the requests being served for each route. See [WithInFlightByRoute].
+	InFlightByRoute bool `json:"inFlightByRoute,omitempty" yaml:"inFlightByRoute,omitempty"`
+	// ResponseBuffer limits the size of the buffered responses if not nil. See [WithMaxResponseBuffer].
+	ResponseBuffer *ResponseBufferConfig `json:"responseBuffer,omitempty" yaml:"responseBuffer,omitempty"`
+	// Adaptive derives the timeout of each route from its recent latency if not nil. See [WithAdaptive].
+	Adaptive *AdaptiveConfig `json:"adaptive,omitempty" yaml:"adaptive,omitempty"`
+	// StreamingBypass sets the idle write timeout of the content types served without handler timeout. See
+	// [WithStreamingBypass].
+	StreamingBypass map[string]Duration `json:"streamingBypass,omitempty" yaml:"streamingBypass,omitempty"`
+	// FlushPartial sends the partial response of the handlers that timed out if not nil. See [WithFlushPartial].
+	FlushPartial *FlushPartialConfig `json:"flushPartial,omitempty" yaml:"flushPartial,omitempty"`
+	// MaxRequestAge rejects the requests older than the given maximum if not nil. See [WithMaxRequestAge].
+	MaxRequestAge *RequestAgeConfig `json:"maxRequestAge,omitempty" yaml:"maxRequestAge,omitempty"`
+	// ShedStatusCode sets the status code of the responses for the requests rejected by the admission control. See
+	// [WithShedStatusCode].
+	ShedStatusCode int `json:"shedStatusCode,omitempty" yaml:"shedStatusCode,omitempty"`
+	// ShedRetryAfter sets the Retry-After delay of the responses for the requests rejected by the admission control.
+	// See [WithShedRetryAfter].
+	ShedRetryAfter Duration `json:"shedRetryAfter,omitempty" yaml:"shedRetryAfter,omitempty"`
+	// MaxConcurrent limits the number of requests served concurrently if not nil. See [WithMaxConcurrent].
+	MaxConcurrent *MaxConcurrentConfig `json:"maxConcurrent,omitempty" yaml:"maxConcurrent,omitempty"`
+	// AutoRelax relaxes the timeout of the routes timing out too often if not nil. See [WithAutoRelax].
+	AutoRelax *AutoRelaxConfig `json:"autoRelax,omitempty" yaml:"autoRelax,omitempty"`
+	// CircuitBreaker rejects the requests of the routes timing out repeatedly if not nil. See [WithCircuitBreaker].
+	CircuitBreaker *CircuitBreakerConfig `json:"circuitBreaker,omitempty" yaml:"circuitBreaker,omitempty"`
+	// MaxExtension allows handlers to extend their deadline by up to the given duration. See [WithMaxExtension].
+	MaxExtension Duration `json:"maxExtension,omitempty" yaml:"maxExtension,omitempty"`
+	// Enforcement is the name of the enforcement mechanism: "buffered" for [Buffered], "conn-deadline" for
+	// [ConnDeadline], "write-gated" for [WriteGated] or "soft" for [Soft]. See [WithEnforcement].
+	Enforcement string `json:"enforcement,omitempty" yaml:"enforcement,omitempty"`
+}
+
+// RedactionConfig is the redaction part of a [Config].
+type RedactionConfig struct {
+	Headers []string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Query   []string `json:"query,omitempty" yaml:"query,omitempty"`
+	// Fields are the fields redacted from the introspection outputs. See [WithFieldRedaction].
+	Fields []string `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// UnboundedRouteConfig is the unbounded route detection part of a [Config].
+type UnboundedRouteConfig struct {
+	BodySize int64    `json:"bodySize,omitempty" yaml:"bodySize,omitempty"`
+	Duration Duration `json:"duration,omitempty" yaml:"duration,omitempty"`
+}
+
+// CompressionConfig is the compression part of a [Config].
+type CompressionConfig struct {
+	MinSize   int      `json:"minSize,omitempty" yaml:"minSize,omitempty"`
+	MinBudget Duration `json:"minBudget,omitempty" yaml:"minBudget,omitempty"`
+}
+
+// HeaderTimeoutConfig is the header timeout part of a [Config].
+type HeaderTimeoutConfig struct {
+	Name string   `json:"name" yaml:"name"`
+	Min  Duration `json:"min,omitempty" yaml:"min,omitempty"`
+	Max  Duration `json:"max" yaml:"max"`
+}
+
+// FlushPartialConfig is the partial response part of a [Config].
+type FlushPartialConfig struct {
+	Trailer string `json:"trailer,omitempty" yaml:"trailer,omitempty"`
+}
+
+// RequestAgeConfig is the request age admission part of a [Config].
+type RequestAgeConfig struct {
+	Header string   `json:"header" yaml:"header"`
+	Max    Duration `json:"max" yaml:"max"`
+}
+
+// BodyDrainConfig is the request body drain part of a [Config].
+type BodyDrainConfig struct {
+	MaxBytes int64    `json:"maxBytes" yaml:"maxBytes"`
+	Timeout  Duration `json:"timeout" yaml:"timeout"`
+}
+
+// MaxConcurrentConfig is the concurrency limit part of a [Config].
+type MaxConcurrentConfig struct {
+	Limit        int      `json:"limit" yaml:"limit"`
+	QueueTimeout Duration `json:"queueTimeout,omitempty" yaml:"queueTimeout,omitempty"`
+}
+
+// AutoRelaxConfig is the auto-relax policy part of a [Config].
+type AutoRelaxConfig struct {
+	Ratio       float64  `json:"ratio" yaml:"ratio"`
+	Window      Duration `json:"window" yaml:"window"`
+	MinRequests int      `json:"minRequests,omitempty" yaml:"minRequests,omitempty"`
+}
+
+// CircuitBreakerConfig is the circuit breaker part of a [Config].
+type CircuitBreakerConfig struct {
+	Threshold int      `json:"threshold" yaml:"threshold"`
+	Window    Duration `json:"window" yaml:"window"`
+	Cooldown  Duration `json:"cooldown" yaml:"cooldown"`
+}
+
+// AdaptiveConfig is the adaptive timeout part of a [Config].
+type AdaptiveConfig struct {
+	Percentile float64  `json:"percentile" yaml:"percentile"`
+	Multiplier float64  `json:"multiplier" yaml:"multiplier"`
+	Floor      Duration `json:"floor,omitempty" yaml:"floor,omitempty"`
+	Ceiling    Duration `json:"ceiling,omitempty" yaml:"ceiling,omitempty"`
+}
+
+// ResponseBufferConfig is the response buffering limit part of a [Config]. Overflow is the name of the
+// [OverflowStrategy]: "reject", "spill" or "stream".
+type ResponseBufferConfig struct {
+	Overflow string `json:"overflow" yaml:"overflow"`
+	MaxSize  int    `json:"maxSize" yaml:"maxSize"`
+	// SpillDir sets the directory of the spilled responses. See [WithSpillDir].
+	SpillDir string `json:"spillDir,omitempty" yaml:"spillDir,omitempty"`
+	// SpillQuota limits the disk space of the spilled responses. See [WithSpillQuota].
+	SpillQuota int64 `json:"spillQuota,omitempty" yaml:"spillQuota,omitempty"`
+}
+
+// ChaosConfig is the chaos testing part of a [Config].
+type ChaosConfig struct {
+	Probability float64  `json:"probability,omitempty" yaml:"probability,omitempty"`
+	ExtraDelay  Duration `json:"extraDelay,omitempty" yaml:"extraDelay,omitempty"`
+}
+
+// FromConfig returns a [fox.MiddlewareFunc] configured from cfg, as [Middleware] would with the equivalent
+// options. Options that cannot be represented in a configuration file, such as [WithResponse] or [WithEventSink],
+// can be passed as opts, and are applied after the configuration.
+func FromConfig(cfg Config, opts ...Option) (fox.MiddlewareFunc, error) {
+	cfgOpts, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	return Middleware(time.Duration(cfg.Timeout), append(cfgOpts, opts...)...), nil
+}
+
+func (cfg Config) options() ([]Option, error) {
+	var opts []Option
+	switch cfg.Preset {
+	case "":
+	case "api":
+		opts = append(opts, PresetAPI())
+	case "web":
+		opts = append(opts, PresetWeb())
+	case "streaming":
+		opts = append(opts, PresetStreaming())
+	case "compat":
+		opts = append(opts, CompatMode())
+	default:
+		return nil, fmt.Errorf("%w: unknown preset %q", ErrInvalidConfig, cfg.Preset)
+	}
+
+	if cfg.ErrorFormat != "" {
+		format := ErrorFormat(0)
+		for f := FormatPlain; f <= FormatGraphQL; f++ {
+			if f.String() == cfg.ErrorFormat {
+				format = f
+			}
+		}
+		if format == 0 {
+			return nil, fmt.Errorf("%w: unknown error format %q", ErrInvalidConfig, cfg.ErrorFormat)
+		}
+		opts = append(opts, WithErrorFormat(format))
+	}
+
+	switch cfg.Enforcement {
+	case "":
+	case Buffered.String():
+		opts = append(opts, WithEnforcement(Buffered))
+	case ConnDeadline.String():
+		opts = append(opts, WithEnforcement(ConnDeadline))
+	case WriteGated.String():
+		opts = append(opts, WithEnforcement(WriteGated))
+	case Soft.String():
+		opts = append(opts, WithEnforcement(Soft))
+	default:
+		return nil, fmt.Errorf("%w: unknown enforcement %q", ErrInvalidConfig, cfg.Enforcement)
+	}
+
+	if cfg.Precision < 0 {
+		return nil, fmt.Errorf("%w: negative precision", ErrInvalidConfig)
+	}
+	if cfg.FallbackBudget < 0 {
+		return nil, fmt.Errorf("%w: negative fallback budget", ErrInvalidConfig)
+	}
+	if cfg.ResponseBudget < 0 {
+		return nil, fmt.Errorf("%w: negative response budget", ErrInvalidConfig)
+	}
+	if cfg.SafeResponseDeadline < 0 {
+		return nil, fmt.Errorf("%w: negative safe response deadline", ErrInvalidConfig)
+	}
+	if cfg.ContinueTimeout < 0 {
+		return nil, fmt.Errorf("%w: negative continue timeout", ErrInvalidConfig)
+	}
+	if cfg.MaxHeaderBytes < 0 {
+		return nil, fmt.Errorf("%w: negative max header bytes", ErrInvalidConfig)
+	}
+	if rb := cfg.ResponseBuffer; rb != nil {
+		var strategy OverflowStrategy
+		switch rb.Overflow {
+		case OverflowReject.String():
+			strategy = OverflowReject
+		case OverflowSpill.String():
+			strategy = OverflowSpill
+		case OverflowStream.String():
+			strategy = OverflowStream
+		default:
+			return nil, fmt.Errorf("%w: unknown overflow strategy %q", ErrInvalidConfig, rb.Overflow)
+		}
+		if rb.MaxSize <= 0 {
+			return nil, fmt.Errorf("%w: non-positive response buffer size", ErrInvalidConfig)
+		}
+		if rb.SpillQuota < 0 {
+			return nil, fmt.Errorf("%w: negative spill quota", ErrInvalidConfig)
+		}
+		opts = append(opts, WithMaxResponseBuffer(rb.MaxSize, strategy))
+		if rb.SpillDir != "" {
+			opts = append(opts, WithSpillDir(rb.SpillDir))
+		}
+		if rb.SpillQuota > 0 {
+			opts = append(opts, WithSpillQuota(rb.SpillQuota))
+		}
+	}
+	if cfg.MaxPooledBufferSize < 0 {
+		return nil, fmt.Errorf("%w: negative max pooled buffer size", ErrInvalidConfig)
+	}
+	if cfg.RetryAfter < 0 {
+		return nil, fmt.Errorf("%w: negative retry after", ErrInvalidConfig)
+	}
+	if ht := cfg.HeaderTimeout; ht != nil && (ht.Name == "" || ht.Min < 0 || ht.Max < ht.Min) {
+		return nil, fmt.Errorf("%w: invalid header timeout", ErrInvalidConfig)
+	}
+	if cfg.StatusCode != 0 && (cfg.StatusCode < 400 || cfg.StatusCode > 599) {
+		return nil, fmt.Errorf("%w: status code %d out of range", ErrInvalidConfig, cfg.StatusCode)
+	}
+	if cfg.ShedStatusCode != 0 && (cfg.ShedStatusCode < 400 || cfg.ShedStatusCode > 599) {
+		return nil, fmt.Errorf("%w: shed status code %d out of range", ErrInvalidConfig, cfg.ShedStatusCode)
+	}
+	if ra := cfg.MaxRequestAge; ra != nil && (ra.Header == "" || ra.Max <= 0) {
+		return nil, fmt.Errorf("%w: invalid max request age", ErrInvalidConfig)
+	}
+	if bd := cfg.BodyDrain; bd != nil && (bd.MaxBytes <= 0 || bd.Timeout <= 0) {
+		return nil, fmt.Errorf("%w: invalid body drain", ErrInvalidConfig)
+	}
+	if mc := cfg.MaxConcurrent; mc != nil && (mc.Limit <= 0 || mc.QueueTimeout < 0) {
+		return nil, fmt.Errorf("%w: invalid max concurrent", ErrInvalidConfig)
+	}
+	if ar := cfg.AutoRelax; ar != nil && (!(ar.Ratio > 0 && ar.Ratio < 1) || ar.Window <= 0 || ar.MinRequests < 0) {
+		return nil, fmt.Errorf("%w: invalid auto relax", ErrInvalidConfig)
+	}
+	if cb := cfg.CircuitBreaker; cb != nil && (cb.Threshold <= 0 || cb.Window <= 0 || cb.Cooldown <= 0) {
+		return nil, fmt.Errorf("%w: invalid circuit breaker", ErrInvalidConfig)
+	}
+	if cfg.MaxExtension < 0 {
+		return nil, fmt.Errorf("%w: negative max extension", ErrInvalidConfig)
+	}
+	if cfg.ShedRetryAfter < 0 {
+		return nil, fmt.Errorf("%w: negative shed retry after", ErrInvalidConfig)
+	}
+	if cfg.GracePeriod < 0 {
+		return nil, fmt.Errorf("%w: negative grace period", ErrInvalidConfig)
+	}
+	if cfg.FirstByteTimeout < 0 {
+		return nil, fmt.Errorf("%w: negative first byte timeout", ErrInvalidConfig)
+	}
+	if cfg.MinTimeout < 0 || cfg.MaxTimeout < 0 {
+		return nil, fmt.Errorf("%w: negative timeout bound", ErrInvalidConfig)
+	}
+	if cfg.MinTimeout > 0 && cfg.MaxTimeout > 0 && cfg.MinTimeout > cfg.MaxTimeout {
+		return nil, fmt.Errorf("%w: min timeout greater than max timeout", ErrInvalidConfig)
+	}
+	if cfg.ReportRetention < 0 {
+		return nil, fmt.Errorf("%w: negative report retention", ErrInvalidConfig)
+	}
+	if cfg.ReadDeadline < 0 || cfg.WriteDeadline < 0 || cfg.IdleWriteTimeout < 0 || cfg.BodyTimeout < 0 {
+		return nil, fmt.Errorf("%w: negative connection deadline", ErrInvalidConfig)
+	}
+	if a := cfg.Adaptive; a != nil && (a.Percentile <= 0 || a.Percentile > 1 || a.Multiplier <= 0 || a.Floor < 0) {
+		return nil, fmt.Errorf("%w: invalid adaptive timeout", ErrInvalidConfig)
+	}
+	if cfg.Chaos.Probability < 0 || cfg.Chaos.Probability > 1 {
+		return nil, fmt.Errorf("%w: chaos probability out of range", ErrInvalidConfig)
+	}
+
+	opts = append(opts, WithPrecision(time.Duration(cfg.Precision)), WithFallbackBudget(time.Duration(cfg.FallbackBudget)))
+	if cfg.ResponseBudget > 0 {
+		opts = append(opts, WithResponseBudget(time.Duration(cfg.ResponseBudget)))
+	}
+	if cfg.SafeResponseDeadline > 0 {
+		opts = append(opts, WithSafeResponseDeadline(time.Duration(cfg.SafeResponseDeadline)))
+	}
+	if cfg.ContinueTimeout > 0 {
+		opts = append(opts, WithContinueTimeout(time.Duration(cfg.ContinueTimeout)))
+	}
+	if len(cfg.HostTimeouts) > 0 {
+		hosts := make(map[string]time.Duration, len(cfg.HostTimeouts))
+		for host, dt := range cfg.HostTimeouts {
+			hosts[host] = time.Duration(dt)
+		}
+		opts = append(opts, WithHostTimeouts(hosts))
+	}
+	if len(cfg.PatternTimeouts) > 0 {
+		patterns := make(map[string]time.Duration, len(cfg.PatternTimeouts))
+		for pattern, dt := range cfg.PatternTimeouts {
+			patterns[pattern] = time.Duration(dt)
+		}
+		opts = append(opts, WithPatternTimeouts(patterns))
+	}
+	if cfg.BodyCancellation != nil {
+		opts = append(opts, WithBodyCancellation(*cfg.BodyCancellation))
+	}
+	if cfg.ElapsedHeader != "" {
+		opts = append(opts, WithElapsedHeader(cfg.ElapsedHeader))
+	}
+	if cfg.BudgetTrailer != "" {
+		opts = append(opts, WithBudgetTrailer(cfg.BudgetTrailer))
+	}
+	if cfg.MaxHeaderBytes > 0 {
+		opts = append(opts, WithMaxHeaderBytes(cfg.MaxHeaderBytes))
+	}
+	if len(cfg.Redaction.Headers) > 0 || len(cfg.Redaction.Query) > 0 {
+		opts = append(opts, WithRedaction(cfg.Redaction.Headers, cfg.Redaction.Query))
+	}
+	if len(cfg.Redaction.Fields) > 0 {
+		opts = append(opts, WithFieldRedaction(cfg.Redaction.Fields...))
+	}
+	if cfg.UnboundedRoute.BodySize > 0 || cfg.UnboundedRoute.Duration > 0 {
+		opts = append(opts, WithUnboundedRouteDetection(cfg.UnboundedRoute.BodySize, time.Duration(cfg.UnboundedRoute.Duration)))
+	}
+	if cfg.RuntimeStats {
+		opts = append(opts, WithRuntimeStats())
+	}
+	if cfg.TraceRegions {
+		opts = append(opts, WithTraceRegions())
+	}
+	if cfg.RetryAfter != 0 {
+		opts = append(opts, WithRFCCompliance(time.Duration(cfg.RetryAfter)))
+	}
+	if cfg.Compression != nil {
+		opts = append(opts, WithCompression(cfg.Compression.MinSize, time.Duration(cfg.Compression.MinBudget)))
+	}
+	if cfg.Chaos.Probability > 0 {
+		opts = append(opts, WithChaos(cfg.Chaos.Probability, time.Duration(cfg.Chaos.ExtraDelay)))
+	}
+	if cfg.LogOutcome {
+		opts = append(opts, WithLogOutcome())
+	}
+	if cfg.DebuggerDetection {
+		opts = append(opts, WithDebuggerDetection())
+	}
+	if cfg.Reentrant {
+		opts = append(opts, Reentrant())
+	}
+	if ht := cfg.HeaderTimeout; ht != nil {
+		opts = append(opts, WithHeaderTimeout(ht.Name, time.Duration(ht.Min), time.Duration(ht.Max)))
+	}
+	if cfg.StatusCode != 0 {
+		opts = append(opts, WithStatusCode(cfg.StatusCode))
+	}
+	if fp := cfg.FlushPartial; fp != nil {
+		opts = append(opts, WithFlushPartial(fp.Trailer))
+	}
+	if ra := cfg.MaxRequestAge; ra != nil {
+		opts = append(opts, WithMaxRequestAge(ra.Header, time.Duration(ra.Max)))
+	}
+	if bd := cfg.BodyDrain; bd != nil {
+		opts = append(opts, WithBodyDrain(bd.MaxBytes, time.Duration(bd.Timeout)))
+	}
+	if mc := cfg.MaxConcurrent; mc != nil {
+		opts = append(opts, WithMaxConcurrent(mc.Limit, time.Duration(mc.QueueTimeout)))
+	}
+	if len(cfg.Policy) > 0 {
+		policy, err := NewPolicy(cfg.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+		opts = append(opts, WithPolicy(policy))
+	}
+	if ar := cfg.AutoRelax; ar != nil {
+		opts = append(opts, WithAutoRelax(ar.Ratio, time.Duration(ar.Window), ar.MinRequests))
+	}
+	if cb := cfg.CircuitBreaker; cb != nil {
+		opts = append(opts, WithCircuitBreaker(cb.Threshold, time.Duration(cb.Window), time.Duration(cb.Cooldown)))
+	}
+	if cfg.MaxExtension > 0 {
+		opts = append(opts, WithMaxExtension(time.Duration(cfg.MaxExtension)))
+	}
+	if cfg.ShedStatusCode != 0 {
+		opts = append(opts, WithShedStatusCode(cfg.ShedStatusCode))
+	}
+	if cfg.ShedRetryAfter > 0 {
+		opts = append(opts, WithShedRetryAfter(time.Duration(cfg.ShedRetryAfter)))
+	}
+	if cfg.VersionLabel != "" {
+		opts = append(opts, WithVersionLabel(cfg.VersionLabel))
+	}
+	if cfg.AutoRequestTimeout {
+		opts = append(opts, WithAutoRequestTimeout())
+	}
+	if cfg.GracePeriod > 0 {
+		opts = append(opts, WithGracePeriod(time.Duration(cfg.GracePeriod)))
+	}
+	if cfg.FirstByteTimeout > 0 {
+		opts = append(opts, WithFirstByteTimeout(time.Duration(cfg.FirstByteTimeout)))
+	}
+	if cfg.MinTimeout > 0 {
+		opts = append(opts, WithMinTimeout(time.Duration(cfg.MinTimeout)))
+	}
+	if cfg.MaxTimeout > 0 {
+		opts = append(opts, WithMaxTimeout(time.Duration(cfg.MaxTimeout)))
+	}
+	if cfg.ReportRetention > 0 {
+		opts = append(opts, WithReportRetention(time.Duration(cfg.ReportRetention)))
+	}
+	if cfg.ReadDeadline > 0 {
+		opts = append(opts, WithReadDeadline(time.Duration(cfg.ReadDeadline)))
+	}
+	if cfg.WriteDeadline > 0 {
+		opts = append(opts, WithWriteDeadline(time.Duration(cfg.WriteDeadline)))
+	}
+	if len(cfg.StreamingBypass) > 0 {
+		types := make(map[string]time.Duration, len(cfg.StreamingBypass))
+		for ct, idle := range cfg.StreamingBypass {
+			types[ct] = time.Duration(idle)
+		}
+		opts = append(opts, WithStreamingBypass(types))
+	}
+	if a := cfg.Adaptive; a != nil {
+		opts = append(opts, WithAdaptive(a.Percentile, a.Multiplier, time.Duration(a.Floor), time.Duration(a.Ceiling)))
+	}
+	if cfg.InFlightByRoute {
+		opts = append(opts, WithInFlightByRoute())
+	}
+	if cfg.MaxPooledBufferSize > 0 {
+		opts = append(opts, WithMaxPooledBufferSize(cfg.MaxPooledBufferSize))
+	}
+	if cfg.BodyTimeout > 0 {
+		opts = append(opts, WithBodyTimeout(time.Duration(cfg.BodyTimeout)))
+	}
+	if cfg.IdleWriteTimeout > 0 {
+		opts = append(opts, WithIdleWriteTimeout(time.Duration(cfg.IdleWriteTimeout)))
+	}
+	return opts, nil
+}
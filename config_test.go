@@ -0,0 +1,146 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromConfig(t *testing.T) {
+	raw := `{
+		"timeout": "50µs",
+		"preset": "api",
+		"precision": "1ms",
+		"elapsedHeader": "X-Handler-Duration",
+		"hostTimeouts": {"*.internal.example.com": "1s"},
+		"bodyCancellation": false
+	}`
+
+	var cfg Config
+	require.NoError(t, json.Unmarshal([]byte(raw), &cfg))
+	assert.Equal(t, Duration(50*time.Microsecond), cfg.Timeout)
+	assert.Equal(t, Duration(time.Second), cfg.HostTimeouts["*.internal.example.com"])
+	require.NotNil(t, cfg.BodyCancellation)
+
+	mw, err := FromConfig(cfg)
+	require.NoError(t, err)
+
+	f, err := fox.NewRouter(fox.WithMiddleware(mw))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	t.Run("timeout with preset response", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, fox.MIMEApplicationJSONCharsetUTF8, w.Header().Get("Content-Type"))
+	})
+
+	t.Run("host timeout", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		req.Host = "db.internal.example.com"
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NotEmpty(t, w.Header().Get("X-Handler-Duration"))
+	})
+}
+
+func TestFromConfig_Invalid(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "unknown preset", cfg: Config{Preset: "grpc"}},
+		{name: "unknown error format", cfg: Config{ErrorFormat: "xml"}},
+		{name: "negative precision", cfg: Config{Precision: Duration(-time.Second)}},
+		{name: "negative fallback budget", cfg: Config{FallbackBudget: Duration(-time.Second)}},
+		{name: "negative response budget", cfg: Config{ResponseBudget: Duration(-time.Second)}},
+		{name: "negative safe response deadline", cfg: Config{SafeResponseDeadline: Duration(-time.Second)}},
+		{name: "negative continue timeout", cfg: Config{ContinueTimeout: Duration(-time.Second)}},
+		{name: "negative max header bytes", cfg: Config{MaxHeaderBytes: -1}},
+		{name: "negative retry after", cfg: Config{RetryAfter: Duration(-time.Second)}},
+		{name: "negative report retention", cfg: Config{ReportRetention: Duration(-time.Hour)}},
+		{name: "negative write deadline", cfg: Config{WriteDeadline: Duration(-time.Second)}},
+		{name: "unknown enforcement", cfg: Config{Enforcement: "goroutine"}},
+		{name: "invalid adaptive percentile", cfg: Config{Adaptive: &AdaptiveConfig{Percentile: 99, Multiplier: 2}}},
+		{name: "unknown overflow strategy", cfg: Config{ResponseBuffer: &ResponseBufferConfig{MaxSize: 1, Overflow: "drop"}}},
+		{name: "zero response buffer size", cfg: Config{ResponseBuffer: &ResponseBufferConfig{Overflow: "spill"}}},
+		{name: "negative spill quota", cfg: Config{ResponseBuffer: &ResponseBufferConfig{MaxSize: 1, Overflow: "spill", SpillQuota: -1}}},
+		{name: "negative grace period", cfg: Config{GracePeriod: Duration(-time.Second)}},
+		{name: "negative first byte timeout", cfg: Config{FirstByteTimeout: Duration(-time.Second)}},
+		{name: "negative min timeout", cfg: Config{MinTimeout: Duration(-time.Second)}},
+		{name: "min timeout greater than max timeout", cfg: Config{MinTimeout: Duration(time.Minute), MaxTimeout: Duration(time.Second)}},
+		{name: "status code out of range", cfg: Config{StatusCode: http.StatusOK}},
+		{name: "shed status code out of range", cfg: Config{ShedStatusCode: http.StatusOK}},
+		{name: "zero body drain", cfg: Config{BodyDrain: &BodyDrainConfig{Timeout: Duration(time.Second)}}},
+		{name: "zero max concurrent", cfg: Config{MaxConcurrent: &MaxConcurrentConfig{QueueTimeout: Duration(time.Second)}}},
+		{name: "policy rule without pattern", cfg: Config{Policy: []PolicyRule{{StatusCode: http.StatusGatewayTimeout}}}},
+		{name: "zero circuit breaker threshold", cfg: Config{CircuitBreaker: &CircuitBreakerConfig{Window: Duration(time.Minute), Cooldown: Duration(time.Second)}}},
+		{name: "invalid auto relax ratio", cfg: Config{AutoRelax: &AutoRelaxConfig{Ratio: 1, Window: Duration(time.Minute)}}},
+		{name: "max request age without header", cfg: Config{MaxRequestAge: &RequestAgeConfig{Max: Duration(time.Second)}}},
+		{name: "negative max extension", cfg: Config{MaxExtension: Duration(-time.Second)}},
+		{name: "negative shed retry after", cfg: Config{ShedRetryAfter: Duration(-time.Second)}},
+		{name: "header timeout without name", cfg: Config{HeaderTimeout: &HeaderTimeoutConfig{Max: Duration(time.Second)}}},
+		{name: "header timeout max below min", cfg: Config{HeaderTimeout: &HeaderTimeoutConfig{Name: "X-Request-Timeout", Min: Duration(time.Second)}}},
+		{name: "chaos probability out of range", cfg: Config{Chaos: ChaosConfig{Probability: 1.5}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := FromConfig(tc.cfg)
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+		})
+	}
+}
+
+func TestDuration_Text(t *testing.T) {
+	b, err := json.Marshal(Duration(1500 * time.Millisecond))
+	require.NoError(t, err)
+	assert.JSONEq(t, `"1.5s"`, string(b))
+
+	var d Duration
+	assert.Error(t, json.Unmarshal([]byte(`"foo"`), &d))
+}
+
+func FuzzFromConfig(f *testing.F) {
+	for _, raw := range []string{
+		`{"timeout": "1s"}`,
+		`{"timeout": "PT1S", "minTimeout": "100", "maxTimeout": "1m", "preset": "web"}`,
+		`{"timeout": "50ms", "headerTimeout": {"name": "Grpc-Timeout", "max": "1s"}, "firstByteTimeout": "10ms"}`,
+		`{"timeout": "-1s"}`,
+		`{"maxConcurrent": {"limit": 1, "queueTimeout": "1ms"}, "bodyDrain": {"maxBytes": 1, "timeout": "1ms"}}`,
+	} {
+		f.Add(raw, "100m")
+	}
+	f.Fuzz(func(t *testing.T, raw, header string) {
+		var cfg Config
+		if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+			return
+		}
+		// The middleware must be usable, and never panic, for any configuration it accepts.
+		mw, err := FromConfig(cfg)
+		if err != nil {
+			return
+		}
+		r, err := fox.NewRouter(fox.WithMiddleware(mw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {})
+		req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		req.Header.Set("Grpc-Timeout", header)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	})
+}
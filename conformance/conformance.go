@@ -0,0 +1,192 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+// Package conformance provides a test suite that checks the exact response bytes produced by the timeout
+// middleware on success, timeout, panic and client abort, for each mode. It can be run against middleware
+// configured with custom options (e.g. a custom response writer or buffer pool) to verify they behave like the
+// reference implementation.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+//go:embed testdata
+var testdata embed.FS
+
+// Mode identifies the set of expected responses to compare against.
+type Mode string
+
+const (
+	// Buffered is the default mode of the middleware, where the handler response is buffered and replaced by
+	// the timeout response once the deadline is exceeded.
+	Buffered Mode = "buffered"
+	// PassThrough is the mode of a middleware that does not enforce any timeout (e.g. created with NoTimeout).
+	PassThrough Mode = "passthrough"
+	// RFC is the mode of a middleware configured with timeout.WithRFCCompliance and a Retry-After delay of one
+	// second.
+	RFC Mode = "rfc"
+	// Streaming is the mode of a middleware configured with timeout.WithStreaming, where the handler response is
+	// written through to the client.
+	Streaming Mode = "streaming"
+	// Soft is the mode of a middleware configured with timeout.WithEnforcement(timeout.Soft), where the deadline is
+	// only observed: the handler is never canceled and its response is written through.
+	Soft Mode = "soft"
+)
+
+// Factory returns the middleware under test, configured to time out handlers after dt.
+type Factory func(dt time.Duration) fox.MiddlewareFunc
+
+// Scenario is a request handled by the middleware under test whose response is compared with a golden file.
+type Scenario struct {
+	// Handler is the handler registered behind the middleware under test.
+	Handler fox.HandlerFunc
+	// Name is the name of the scenario, which is also the name of the golden file.
+	Name string
+	// Method is the request method, or GET if empty.
+	Method string
+	// Timeout is the timeout passed to the Factory.
+	Timeout time.Duration
+	// Abort is the delay after which the client aborts the request, or zero to never abort.
+	Abort time.Duration
+}
+
+// Scenarios returns the scenarios exercised by [Run].
+func Scenarios() []Scenario {
+	return []Scenario{
+		{
+			Name:    "success",
+			Timeout: time.Second,
+			Handler: func(c *fox.Context) {
+				c.SetHeader("X-Conformance", "success")
+				_ = c.String(http.StatusCreated, "created\n")
+			},
+		},
+		{
+			Name:    "timeout",
+			Timeout: time.Millisecond,
+			Handler: func(c *fox.Context) {
+				select {
+				case <-c.Request().Context().Done():
+				case <-time.After(50 * time.Millisecond):
+				}
+				c.SetHeader("X-Conformance", "timeout")
+				_ = c.String(http.StatusOK, "late\n")
+			},
+		},
+		{
+			Name:    "timeout-head",
+			Method:  http.MethodHead,
+			Timeout: time.Millisecond,
+			Handler: func(c *fox.Context) {
+				select {
+				case <-c.Request().Context().Done():
+				case <-time.After(50 * time.Millisecond):
+				}
+				c.SetHeader("X-Conformance", "timeout")
+				_ = c.String(http.StatusOK, "late\n")
+			},
+		},
+		{
+			Name:    "panic",
+			Timeout: time.Second,
+			Handler: func(c *fox.Context) {
+				c.SetHeader("X-Conformance", "panic")
+				panic("conformance")
+			},
+		},
+		{
+			Name:    "client-abort",
+			Timeout: time.Second,
+			Abort:   time.Millisecond,
+			Handler: func(c *fox.Context) {
+				select {
+				case <-c.Request().Context().Done():
+				case <-time.After(time.Second):
+					_ = c.String(http.StatusOK, "not aborted\n")
+				}
+			},
+		},
+	}
+}
+
+// Run runs every scenario returned by [Scenarios] against the middleware created by factory, and compares the
+// response bytes with the golden files of the given mode.
+func Run(t *testing.T, mode Mode, factory Factory) {
+	t.Helper()
+	for _, sc := range Scenarios() {
+		t.Run(sc.Name, func(t *testing.T) {
+			want, err := Golden(mode, sc.Name)
+			if err != nil {
+				t.Fatalf("unable to read golden file: %s", err)
+			}
+			got, err := Record(factory, sc)
+			if err != nil {
+				t.Fatalf("unable to record response: %s", err)
+			}
+			if !bytes.Equal(want, got) {
+				t.Errorf("unexpected response bytes for %s mode\nwant:\n%q\ngot:\n%q", mode, want, got)
+			}
+		})
+	}
+}
+
+// Golden returns the expected response bytes of a scenario for the given mode.
+func Golden(mode Mode, name string) ([]byte, error) {
+	return testdata.ReadFile(path.Join("testdata", string(mode), name+".golden"))
+}
+
+// Record serves the scenario behind the middleware created by factory, and returns the response in its HTTP/1.1
+// wire representation. Panics escaping the middleware are recovered by a recovery middleware responding with a
+// 500 Internal Server Error.
+func Record(factory Factory, sc Scenario) ([]byte, error) {
+	f, err := fox.NewRouter(
+		fox.WithMiddleware(
+			fox.RecoveryWithFunc(slog.DiscardHandler, func(c *fox.Context, _ any) {
+				if !c.Writer().Written() {
+					http.Error(c.Writer(), http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}),
+			factory(sc.Timeout),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+	method := sc.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	if _, err = f.Add([]string{method}, "/conformance", sc.Handler); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if sc.Abort > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		timer := time.AfterFunc(sc.Abort, cancel)
+		defer timer.Stop()
+	}
+
+	req := httptest.NewRequestWithContext(ctx, method, "/conformance", nil)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	buf := new(bytes.Buffer)
+	if err = w.Result().Write(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package conformance_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/fox-toolkit/timeout"
+	"github.com/fox-toolkit/timeout/conformance"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+var factories = map[conformance.Mode]conformance.Factory{
+	conformance.Buffered: func(dt time.Duration) fox.MiddlewareFunc {
+		return timeout.Middleware(dt)
+	},
+	conformance.PassThrough: func(time.Duration) fox.MiddlewareFunc {
+		return timeout.Middleware(timeout.NoTimeout)
+	},
+	conformance.Streaming: func(dt time.Duration) fox.MiddlewareFunc {
+		return timeout.Middleware(dt, timeout.WithStreaming())
+	},
+	conformance.Soft: func(dt time.Duration) fox.MiddlewareFunc {
+		return timeout.Middleware(dt, timeout.WithEnforcement(timeout.Soft))
+	},
+	conformance.RFC: func(dt time.Duration) fox.MiddlewareFunc {
+		return timeout.Middleware(dt, timeout.WithRFCCompliance(time.Second))
+	},
+}
+
+func TestConformance(t *testing.T) {
+	for mode, factory := range factories {
+		if *update {
+			for _, sc := range conformance.Scenarios() {
+				b, err := conformance.Record(factory, sc)
+				require.NoError(t, err)
+				dir := filepath.Join("testdata", string(mode))
+				require.NoError(t, os.MkdirAll(dir, 0o755))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, sc.Name+".golden"), b, 0o644))
+			}
+		}
+		t.Run(string(mode), func(t *testing.T) {
+			conformance.Run(t, mode, factory)
+		})
+	}
+}
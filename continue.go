@@ -0,0 +1,60 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// continueBody wraps the body of a request expecting a 100 Continue interim response, so that the client must start
+// sending the body within timeout of the interim response, see WithContinueTimeout. The interim response is sent by
+// the server on the first read of the body, unless the handler sent it before with WriteHeader. The body is read by
+// the handler only.
+type continueBody struct {
+	io.ReadCloser
+	w fox.ResponseWriter
+	// restore is the read deadline of the connection restored once the body started to arrive, if any.
+	restore time.Time
+	timeout time.Duration
+	once    sync.Once
+	started bool
+}
+
+// expectsContinue reports whether the client waits for a 100 Continue interim response before sending the body.
+func expectsContinue(r *http.Request) bool {
+	return hasBody(r) && r.ProtoAtLeast(1, 1) && strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// arm sets the read deadline of the connection to timeout from now, or to the restored deadline if it is earlier, as
+// the interim response is sent. Only the first call has an effect.
+func (b *continueBody) arm() {
+	b.once.Do(func() {
+		deadline := time.Now().Add(b.timeout)
+		if !b.restore.IsZero() && b.restore.Before(deadline) {
+			deadline = b.restore
+		}
+		// Errors are ignored for the same reason as in setDeadline.
+		_ = b.w.SetReadDeadline(deadline)
+	})
+}
+
+func (b *continueBody) Read(p []byte) (int, error) {
+	if b.started {
+		return b.ReadCloser.Read(p)
+	}
+	b.arm()
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.started = true
+		_ = b.w.SetReadDeadline(b.restore)
+	}
+	return n, err
+}
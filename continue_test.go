@@ -0,0 +1,102 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithContinueTimeout(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithContinueTimeout(30*time.Millisecond))))
+	require.NoError(t, err)
+	readErrs := make(chan error, 1)
+	echo := func(c *fox.Context) {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			readErrs <- err
+			return
+		}
+		_ = c.String(http.StatusOK, string(body))
+	}
+	f.MustAdd(fox.MethodPost, "/echo", echo)
+	f.MustAdd(fox.MethodPost, "/explicit", func(c *fox.Context) {
+		c.SetHeader("X-Interim", "1")
+		c.Writer().WriteHeader(http.StatusContinue)
+		echo(c)
+	})
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	// send writes the headers of a request expecting a 100 Continue response, reads the interim response, and sends
+	// the body unless it is empty.
+	send := func(t *testing.T, path, body string) (*http.Response, string) {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		_, err = io.WriteString(conn, "POST "+path+" HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+		require.NoError(t, err)
+		br := bufio.NewReader(conn)
+		status, err := br.ReadString('\n')
+		require.NoError(t, err)
+		interim := strings.TrimSpace(status)
+		for {
+			// Skip the headers of the interim response.
+			line, err := br.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\r\n" {
+				break
+			}
+		}
+		if body != "" {
+			_, err = io.WriteString(conn, body)
+			require.NoError(t, err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		return resp, interim
+	}
+
+	t.Run("body sent", func(t *testing.T) {
+		resp, interim := send(t, "/echo", "hello")
+		assert.Equal(t, "HTTP/1.1 100 Continue", interim)
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello", string(got))
+	})
+
+	t.Run("body not sent", func(t *testing.T) {
+		begin := time.Now()
+		resp, interim := send(t, "/echo", "")
+		assert.Equal(t, "HTTP/1.1 100 Continue", interim)
+		// The server cancels the request once the read deadline expires, so the handler response is discarded.
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+		assert.Less(t, time.Since(begin), 500*time.Millisecond)
+		assert.ErrorIs(t, <-readErrs, os.ErrDeadlineExceeded)
+	})
+
+	t.Run("explicit interim response", func(t *testing.T) {
+		resp, interim := send(t, "/explicit", "world")
+		assert.Equal(t, "HTTP/1.1 100 Continue", interim)
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		// The interim response is not mistaken for the final one.
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "1", resp.Header.Get("X-Interim"))
+		assert.Equal(t, "world", string(got))
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// debuggerFactor is the factor applied to the timeouts while a debugger is attached.
+	debuggerFactor = 100
+	// debuggerCheckInterval is how often the presence of a debugger is checked.
+	debuggerCheckInterval = time.Second
+)
+
+// debuggerDetector caches whether a debugger is attached to the process, since it may be attached or detached at
+// any time but checking it is too expensive to be done on every request.
+type debuggerDetector struct {
+	attached  func() bool
+	checkedAt atomic.Int64
+	debugging atomic.Bool
+}
+
+func newDebuggerDetector() *debuggerDetector {
+	return &debuggerDetector{
+		attached: func() bool {
+			return os.Getenv("TIMEOUT_DEBUGGER") != "" || tracerAttached()
+		},
+	}
+}
+
+func (d *debuggerDetector) active() bool {
+	now := time.Now().UnixNano()
+	last := d.checkedAt.Load()
+	if now-last >= int64(debuggerCheckInterval) && d.checkedAt.CompareAndSwap(last, now) {
+		d.debugging.Store(d.attached())
+	}
+	return d.debugging.Load()
+}
+
+// stretch returns dt multiplied by debuggerFactor if a debugger is attached.
+func (d *debuggerDetector) stretch(dt time.Duration) time.Duration {
+	if dt > 0 && d.active() {
+		return min(dt, math.MaxInt64/debuggerFactor) * debuggerFactor
+	}
+	return dt
+}
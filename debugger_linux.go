@@ -0,0 +1,27 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+//go:build linux
+
+package timeout
+
+import (
+	"bytes"
+	"os"
+)
+
+// tracerAttached reports whether a tracer, such as a debugger, is attached to the process.
+func tracerAttached() bool {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for line := range bytes.SplitSeq(status, []byte("\n")) {
+		if pid, ok := bytes.CutPrefix(line, []byte("TracerPid:")); ok {
+			pid = bytes.TrimSpace(pid)
+			return len(pid) > 0 && !bytes.Equal(pid, []byte("0"))
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DurationParser parses a duration from its textual representation, see [ParseDuration] and [WithDurationParser].
+type DurationParser func(s string) (time.Duration, error)
+
+// ParseDuration parses a duration in one of the formats commonly exchanged between systems:
+//   - a Go duration, e.g. "1500ms", "2s" or "1.5m", as parsed by [time.ParseDuration].
+//   - an integer number of milliseconds, e.g. "1500".
+//   - an ISO-8601 duration made of weeks, days, hours, minutes and seconds, e.g. "PT1.5S", "PT1M30S" or "P1DT2H". The
+//     years and months are rejected, since their length varies. The last component may have a fraction.
+//
+// It is the parser of the [Duration] of a [Config], and can be set with [WithDurationParser] to parse the header
+// timeouts in the same formats.
+func ParseDuration(s string) (time.Duration, error) {
+	v := strings.TrimSpace(s)
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if ms > math.MaxInt64/int64(time.Millisecond) || ms < math.MinInt64/int64(time.Millisecond) {
+			return 0, fmt.Errorf("timeout: duration %q out of range", s)
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	neg := strings.HasPrefix(v, "-")
+	iso := strings.TrimPrefix(v, "-")
+	if strings.HasPrefix(iso, "P") || strings.HasPrefix(iso, "p") {
+		dt, ok := parseISODuration(iso[1:])
+		if !ok {
+			return 0, fmt.Errorf("timeout: invalid ISO-8601 duration %q", s)
+		}
+		if neg {
+			dt = -dt
+		}
+		return dt, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// isoUnits maps the units of the date and time parts of an ISO-8601 duration to their duration.
+var isoUnits = [2]map[byte]time.Duration{
+	{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour},
+	{'H': time.Hour, 'M': time.Minute, 'S': time.Second},
+}
+
+// parseISODuration parses the ISO-8601 duration v, stripped of its "P" designator.
+func parseISODuration(v string) (time.Duration, bool) {
+	v = strings.ToUpper(v)
+	var (
+		total    float64
+		part     int
+		n        int
+		fraction bool
+	)
+	for v != "" {
+		if v[0] == 'T' {
+			if part == 1 || len(v) == 1 {
+				return 0, false
+			}
+			part = 1
+			v = v[1:]
+			continue
+		}
+		if fraction {
+			// Only the last component may have a fraction.
+			return 0, false
+		}
+		i := strings.IndexFunc(v, func(r rune) bool { return (r < '0' || r > '9') && r != '.' && r != ',' })
+		if i <= 0 {
+			return 0, false
+		}
+		num := strings.Replace(v[:i], ",", ".", 1)
+		value, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return 0, false
+		}
+		unit, ok := isoUnits[part][v[i]]
+		if !ok {
+			return 0, false
+		}
+		fraction = strings.Contains(num, ".")
+		total += value * float64(unit)
+		n++
+		v = v[i+1:]
+	}
+	// The conversion of a float64 not lower than 2^63 to a time.Duration overflows.
+	if n == 0 || total >= math.MaxInt64 {
+		return 0, false
+	}
+	return time.Duration(total), true
+}
+
+// WithDurationParser sets the parser of the timeouts set by the client in the header configured with
+// [WithHeaderTimeout], e.g. [ParseDuration], so that the formats accepted match those of the configuration files and
+// of the other systems exchanging deadlines. The values the parser rejects, as well as the zero or negative ones, are
+// ignored. A nil parser restores the default parsing, see [WithHeaderTimeout].
+func WithDurationParser(p DurationParser) Option {
+	return optionFunc(func(c *config) {
+		c.parseDuration = p
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		in   string
+		want time.Duration
+	}{
+		{in: "1500ms", want: 1500 * time.Millisecond},
+		{in: "2s", want: 2 * time.Second},
+		{in: "1.5m", want: 90 * time.Second},
+		{in: "1500", want: 1500 * time.Millisecond},
+		{in: " 250 ", want: 250 * time.Millisecond},
+		{in: "0", want: 0},
+		{in: "PT1.5S", want: 1500 * time.Millisecond},
+		{in: "PT1M30S", want: 90 * time.Second},
+		{in: "P1DT2H", want: 26 * time.Hour},
+		{in: "P1W", want: 7 * 24 * time.Hour},
+		{in: "pt0,5s", want: 500 * time.Millisecond},
+		{in: "-PT2S", want: -2 * time.Second},
+	}
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			dt, err := ParseDuration(tc.in)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, dt)
+		})
+	}
+
+	for _, in := range []string{"", "foo", "1.5", "P", "PT", "P1Y", "P1M", "PT1H1D", "PT1.5M30S", "P1DT", "PTS", "9999999999999999ms", "99999999999999"} {
+		t.Run(in, func(t *testing.T) {
+			_, err := ParseDuration(in)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDuration_UnmarshalFormats(t *testing.T) {
+	var cfg struct {
+		A, B, C Duration
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"A": "2s", "B": "1500", "C": "PT1M"}`), &cfg))
+	assert.Equal(t, Duration(2*time.Second), cfg.A)
+	assert.Equal(t, Duration(1500*time.Millisecond), cfg.B)
+	assert.Equal(t, Duration(time.Minute), cfg.C)
+}
+
+func TestMiddleware_WithDurationParser(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(2*time.Second,
+		WithHeaderTimeout("X-Budget", 0, time.Minute),
+		WithDurationParser(ParseDuration),
+		WithMetrics(MetricsRecorderFunc(func(o Observation) {
+			assert.Equal(t, 1500*time.Millisecond, o.Timeout)
+		})),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	for _, v := range []string{"1500", "PT1.5S", "1.5s"} {
+		req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		req.Header.Set("X-Budget", v)
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+}
+
+func FuzzParseDuration(f *testing.F) {
+	for _, v := range []string{"1500ms", "2s", "1.5m", "1500", "PT1.5S", "P1DT2H", "P1W", "-PT2S", "P9999999999W", "PT0,5S", "PT1.S"} {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, v string) {
+		dt, err := ParseDuration(v)
+		if err == nil && (v != "" && (v[0] == 'P' || v[0] == 'p')) && dt < 0 {
+			t.Fatalf("ParseDuration(%q) = %s, want a non negative duration", v, dt)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// Enforcement is the mechanism used by the middleware to enforce the handler timeout.
+type Enforcement uint8
+
+const (
+	// Buffered runs the handler in its own goroutine with a buffered writer and a context expiring at the deadline,
+	// and replaces its response by the timeout response if the deadline is exceeded. This is the default
+	// enforcement.
+	Buffered Enforcement = iota + 1
+	// ConnDeadline enforces the timeout only by setting the write deadline of the underlying connection to the
+	// handler deadline. The handler runs on the request goroutine, its response is written through and its context
+	// is left untouched, so no timeout response is ever sent: a handler exceeding its deadline results in a broken
+	// response, e.g. a connection closed or reset by the server. This is the cheapest enforcement, intended for
+	// internal, high-throughput services whose clients handle such failures.
+	ConnDeadline
+	// WriteGated runs the handler on the request goroutine with a context expiring at the deadline, behind a writer
+	// buffering its response, whose buffer is only taken from the pool on the first write, and failing every write
+	// once the deadline is exceeded. The response of a handler returning past its deadline is replaced by the
+	// timeout response. This saves the goroutine and the copy of the [fox.Context] of [Buffered] for each request,
+	// which suits fast handlers that rarely come close to their deadline, but the timeout response is only sent
+	// once the handler returns: a handler ignoring its context delays it for as long as it runs.
+	WriteGated
+	// Soft only observes the handler deadline: the handler runs on the request goroutine, its response is written
+	// through and its context is left untouched, and no deadline is set on the connection, so the handler is never
+	// canceled. A handler exceeding its deadline is reported as timed out by the telemetry of the middleware, such as
+	// the events, the hooks set with [WithOnTimeout] or the metrics. This is intended to roll out the timeouts, or
+	// tune them, by observing which routes would time out before enforcing them.
+	Soft
+)
+
+// String returns the name of the enforcement.
+func (e Enforcement) String() string {
+	switch e {
+	case Buffered:
+		return "buffered"
+	case ConnDeadline:
+		return "conn-deadline"
+	case WriteGated:
+		return "write-gated"
+	case Soft:
+		return "soft"
+	default:
+		return "unknown"
+	}
+}
+
+// serveConn calls next with the write deadline of the connection set to the handler deadline, unless an earlier
+// write deadline is set for the route, see ConnDeadline.
+func (t *Timeout) serveConn(c *fox.Context, next fox.HandlerFunc, start time.Time, dt time.Duration) result {
+	deadline := t.deadline(start, dt)
+	// The write deadlines derived by the writers must not postpone the handler deadline.
+	switch w := c.Writer().(type) {
+	case *slidingWriter:
+		w.limit = deadline
+	case *sizedWriter:
+		w.limit = deadline
+	}
+	wdt, ok := t.connDeadline(c, wKey{}, t.cfg.writeDeadline)
+	if t.outer || !ok || start.Add(wdt).After(deadline) {
+		// Errors are ignored for the same reason as in setDeadline.
+		_ = c.Writer().SetWriteDeadline(deadline)
+	}
+	next(c)
+	return result{timedOut: time.Now().After(deadline)}
+}
+
+// serveSoft calls next without enforcing its deadline, and reports whether it exceeded it, see Soft.
+func (t *Timeout) serveSoft(c *fox.Context, next fox.HandlerFunc, start time.Time, dt time.Duration) result {
+	deadline := t.deadline(start, dt)
+	next(c)
+	var res result
+	if now := t.now(); now.After(deadline) {
+		res.timedOut = true
+		res.overshoot = now.Sub(deadline)
+	}
+	return res
+}
@@ -0,0 +1,241 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"runtime"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// EventKind identifies what an [Event] is about.
+type EventKind uint8
+
+const (
+	// EventTimeout is emitted when a handler exceeds its deadline.
+	EventTimeout EventKind = iota + 1
+	// EventUnboundedRoute is emitted, once per route, when a route served without timeout receives a large request
+	// body or runs for a long time. See [WithUnboundedRouteDetection].
+	EventUnboundedRoute
+	// EventHeaderTooLarge is emitted when a handler response is rejected because its header block exceeds the
+	// limit set with [WithMaxHeaderBytes].
+	EventHeaderTooLarge
+	// EventCanceled is emitted when the request is canceled, typically because the client went away, before the
+	// handler completed. See [WithCanceledResponse].
+	EventCanceled
+	// EventRelaxed is emitted when the timeout of a route is no longer enforced because too many of its requests timed
+	// out. See [WithAutoRelax].
+	EventRelaxed
+	// EventBreakerOpen is emitted when the circuit breaker of a route opens because too many of its requests timed
+	// out, or because the probe of the half-open breaker timed out. See [WithCircuitBreaker].
+	EventBreakerOpen
+)
+
+// String returns the name of the event kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventTimeout:
+		return "timeout"
+	case EventUnboundedRoute:
+		return "unbounded_route"
+	case EventHeaderTooLarge:
+		return "header_too_large"
+	case EventCanceled:
+		return "canceled"
+	case EventRelaxed:
+		return "relaxed"
+	case EventBreakerOpen:
+		return "breaker_open"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes something notable that happened while serving a request. An Event is a plain value that does
+// not reference the request or the [fox.Context], so it can safely be retained or processed asynchronously.
+type Event struct {
+	// Time is when the event occurred.
+	Time time.Time
+	// Runtime is a snapshot of the Go runtime taken when the event occurred. It is only set when the
+	// middleware is configured with [WithRuntimeStats].
+	Runtime *RuntimeStats
+	// Header is a copy of the request headers, redacted according to [WithRedaction].
+	Header http.Header
+	// Query is a copy of the request query parameters, redacted according to [WithRedaction].
+	Query url.Values
+	// Version is the application version set with [WithVersionLabel], if any.
+	Version string
+	// Method is the request method.
+	Method string
+	// Path is the request path.
+	Path string
+	// Pattern is the matched route pattern, if any, rewritten by the [WithRouteNormalizer] function.
+	Pattern string
+	// ContentLength is the declared length of the request body, or -1 if unknown.
+	ContentLength int64
+	// ClientIP is the IP address of the client, as resolved by the resolver set with [WithClientIPResolver] or else
+	// by the [fox.ClientIPResolver] of the router, or empty if it cannot be resolved, e.g. if no resolver is
+	// configured.
+	ClientIP string
+	// Timeout is the effective handler timeout.
+	Timeout time.Duration
+	// Clamp reports whether Timeout was clamped to the bounds set with [WithMinTimeout] and [WithMaxTimeout].
+	Clamp Clamp
+	// Elapsed is the time spent in the handler, under the timeout, when the event occurred.
+	Elapsed time.Duration
+	// Total is the time elapsed since the request entered the middleware chain when the event occurred. It is only
+	// known when the request is served behind [Outer], and zero otherwise. The difference between Total and Elapsed
+	// is the time spent in the middleware running before the handler.
+	Total time.Duration
+	// Overshoot is, for an [EventTimeout], how long after the deadline the timeout response was actually written.
+	// It is typically a few microseconds; a large overshoot (e.g. recorded in a histogram) indicates that the
+	// middleware itself is starved by scheduler delays or lock contention.
+	Overshoot time.Duration
+	// Internal reports, for an [EventTimeout], whether the deadline that expired is one derived by the handler, as
+	// reported with [ReportError], rather than the deadline enforced by the middleware.
+	Internal bool
+	// ID is, for an [EventTimeout], the identifier of the event, also sent to the client in the header configured
+	// with [WithEventID]. It is empty if no identifier generator is configured.
+	ID string
+	// Fields holds the business dimensions of the request returned by the function set with [WithEnricher], if
+	// any.
+	Fields map[string]any
+	// Stack is, for an [EventTimeout], the stack trace of the handler goroutine, still running after the timeout,
+	// taken when the timeout response was written. It is only set when the middleware is configured with
+	// [WithStackDump].
+	Stack []byte
+	// Kind is the kind of event.
+	Kind EventKind
+}
+
+// TimeoutInfo describes a handler that exceeded its deadline, as passed to the [WithOnTimeout] hook.
+type TimeoutInfo struct {
+	// Pattern is the matched route pattern, if any, rewritten by the [WithRouteNormalizer] function.
+	Pattern string
+	// Timeout is the effective handler timeout.
+	Timeout time.Duration
+	// Elapsed is the time spent in the handler when it was abandoned.
+	Elapsed time.Duration
+	// Buffered reports whether the handler had started writing its response, which is discarded unless Partial is
+	// set, when it was abandoned.
+	Buffered bool
+	// Partial reports whether the partial response of the handler was sent instead of the timeout response, see
+	// [WithFlushPartial] and [JSONArray].
+	Partial bool
+	// ID is the identifier of the timeout event, see [WithEventID]. It is empty if no identifier generator is
+	// configured.
+	ID string
+	// Fields holds the business dimensions of the request returned by the function set with [WithEnricher], if
+	// any.
+	Fields map[string]any
+	// Stack is the stack trace of the handler goroutine, still running after the timeout, taken when the timeout
+	// response was written. It is only set when the middleware is configured with [WithStackDump].
+	Stack []byte
+	// Overshoot is how long after the deadline the timeout response was written. It exceeds the grace period set
+	// with [WithGracePeriod] when the handler was given one.
+	Overshoot time.Duration
+	// Clamp reports whether Timeout was clamped to the bounds set with [WithMinTimeout] and [WithMaxTimeout].
+	Clamp Clamp
+}
+
+type eventIDGenerator struct {
+	generate func() string
+	header   string
+}
+
+// NewEventID returns a random 128-bit identifier, hex encoded. It is the default generator of [WithEventID].
+func NewEventID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// EventSink receives the events emitted by the middleware. Emit is called synchronously on the request path,
+// so implementations should be fast and must be safe for concurrent use. Heavier sinks can be wrapped with
+// [NewAsyncEventSink].
+type EventSink interface {
+	Emit(ev Event)
+}
+
+// The EventSinkFunc type is an adapter to allow the use of ordinary functions as [EventSink].
+type EventSinkFunc func(ev Event)
+
+// Emit calls f(ev).
+func (f EventSinkFunc) Emit(ev Event) {
+	f(ev)
+}
+
+// RuntimeStats is a snapshot of a few Go runtime statistics. It helps to tell apart a handler waiting on a slow
+// downstream dependency from a process spending its time in garbage collection.
+type RuntimeStats struct {
+	// Goroutines is the number of goroutines that currently exist.
+	Goroutines int
+	// HeapInUse is the number of bytes in in-use heap spans.
+	HeapInUse uint64
+	// NumGC is the number of completed GC cycles.
+	NumGC uint32
+	// LastGCPause is the stop-the-world pause time of the most recent GC cycle.
+	LastGCPause time.Duration
+	// GCPauseTotal is the cumulative stop-the-world pause time since the program started.
+	GCPauseTotal time.Duration
+	// GCCPUFraction is the fraction of the available CPU time used by the GC since the program started.
+	GCCPUFraction float64
+}
+
+func readRuntimeStats() *RuntimeStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	stats := &RuntimeStats{
+		Goroutines:    runtime.NumGoroutine(),
+		HeapInUse:     ms.HeapInuse,
+		NumGC:         ms.NumGC,
+		GCPauseTotal:  time.Duration(ms.PauseTotalNs),
+		GCCPUFraction: ms.GCCPUFraction,
+	}
+	if ms.NumGC > 0 {
+		stats.LastGCPause = time.Duration(ms.PauseNs[(ms.NumGC+255)%256])
+	}
+	return stats
+}
+
+// emit completes the event with the request metadata and sends it to the configured sink, if any.
+func (t *Timeout) emit(c *fox.Context, ev Event) {
+	sink := t.cfg.sink
+	if r := t.route(c.Route()); r != nil {
+		if s, ok := r.Annotation(eKey{}).(EventSink); ok {
+			sink = s
+		}
+	}
+	if sink == nil {
+		return
+	}
+
+	ev.Time = t.now()
+	ev.Version = t.cfg.version
+	ev.Method = c.Method()
+	ev.Path = c.Path()
+	ev.Pattern = t.routeKey(c)
+	ev.ContentLength = c.Request().ContentLength
+	if ip, err := t.clientIP(c); err == nil {
+		ev.ClientIP = ip.String()
+	}
+	ev.Header = t.cfg.redactor.header(c.Request().Header)
+	ev.Query = t.cfg.redactor.values(c.QueryParams())
+	if entry, ok := EntryTime(c); ok {
+		ev.Total = ev.Time.Sub(entry)
+	}
+	if t.cfg.runtimeStats {
+		ev.Runtime = readRuntimeStats()
+	}
+	if ev.Fields == nil && t.cfg.enricher != nil {
+		ev.Fields = t.cfg.enricher(c)
+	}
+	sink.Emit(ev)
+}
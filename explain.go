@@ -0,0 +1,267 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// Source identifies where a handler timeout comes from.
+type Source uint8
+
+const (
+	// SourceRoute is the timeout set for the route with [OverrideHandler], or for the route and the request method
+	// with [OverrideMethod].
+	SourceRoute Source = iota + 1
+	// SourcePattern is the timeout set for the route pattern, or one of its parents, with [WithPatternTimeouts].
+	SourcePattern
+	// SourceHost is the timeout set for the request host with [WithHostTimeouts].
+	SourceHost
+	// SourceGlobal is the timeout the middleware is created with.
+	SourceGlobal
+	// SourceHeader is the timeout set by the client in the request header configured with [WithHeaderTimeout].
+	SourceHeader
+	// SourceAdaptive is the timeout derived from the recent latency of the route with [WithAdaptive].
+	SourceAdaptive
+	// SourceMethod is the timeout set for the request method with [WithMethodTimeout].
+	SourceMethod
+	// SourceFlag is the timeout set for the route by the feature flag provider configured with [WithFlagProvider].
+	SourceFlag
+	// SourceTrusted is the timeout set for the route with [OverrideTrusted], for the requests from the trusted callers
+	// configured with [WithTrustedBypass].
+	SourceTrusted
+	// SourceResolver is the timeout returned for the request by the function set with [WithTimeoutResolver].
+	SourceResolver
+	// SourceHandlerScope is the timeout set for the scope of the handler with [WithHandlerScopeTimeout].
+	SourceHandlerScope
+	// SourcePolicy is the timeout set for the route by the rules of the policy applied with [WithPolicy].
+	SourcePolicy
+	// SourceProtocol is the timeout set for the protocol of the request with [WithProtocolTimeout].
+	SourceProtocol
+)
+
+// String returns the name of the source.
+func (s Source) String() string {
+	switch s {
+	case SourceRoute:
+		return "route"
+	case SourcePattern:
+		return "pattern"
+	case SourceHost:
+		return "host"
+	case SourceGlobal:
+		return "global"
+	case SourceHeader:
+		return "header"
+	case SourceAdaptive:
+		return "adaptive"
+	case SourceMethod:
+		return "method"
+	case SourceFlag:
+		return "flag"
+	case SourceTrusted:
+		return "trusted"
+	case SourceResolver:
+		return "resolver"
+	case SourceHandlerScope:
+		return "handler-scope"
+	case SourcePolicy:
+		return "policy"
+	case SourceProtocol:
+		return "protocol"
+	default:
+		return "unknown"
+	}
+}
+
+// precedence lists the timeout sources from the highest to the lowest precedence. The first source that has a
+// timeout for the request wins.
+var precedence = [...]Source{
+	SourceTrusted,
+	SourceResolver,
+	SourceFlag,
+	SourceRoute,
+	SourcePolicy,
+	SourcePattern,
+	SourceAdaptive,
+	SourceHandlerScope,
+	SourceMethod,
+	SourceHost,
+	SourceProtocol,
+	SourceGlobal,
+}
+
+// Step is a step of the timeout resolution, as returned by [ResolveExplain].
+type Step struct {
+	// Timeout is the timeout found in the source, if any.
+	Timeout time.Duration
+	// Source is the source consulted.
+	Source Source
+	// Found reports whether the source has a timeout for the request.
+	Found bool
+	// Applied reports whether the timeout of this source is the effective timeout, i.e. whether it is the first
+	// source that has a timeout for the request, or the request header if it shortens that timeout.
+	Applied bool
+}
+
+// ResolveExplain returns the steps taken by the middleware to resolve the timeout of the handler serving c, one per
+// source consulted, from the highest to the lowest precedence: the trusted callers ([WithTrustedBypass], only if
+// configured), the resolver ([WithTimeoutResolver], only if configured), the feature flags ([WithFlagProvider], only
+// if configured), the route ([OverrideMethod], then [OverrideHandler]), the policy ([WithPolicy], only if
+// configured), the route pattern ([WithPatternTimeouts]), the adapted timeout ([WithAdaptive], only if configured),
+// the handler scope ([WithHandlerScopeTimeout], only if configured), the request method ([WithMethodTimeout], only if
+// configured), the host ([WithHostTimeouts]), the request protocol ([WithProtocolTimeout], only if configured) and
+// finally the global timeout. The request header ([WithHeaderTimeout], only if configured) comes last, since it can
+// only shorten the timeout of the other sources. This helps to answer questions such as "why did this request get
+// 2s?". It returns nil if the request is not served under a timeout, including when the effective timeout is
+// [NoTimeout]. When middleware are nested, the steps of the innermost one are returned. The timeouts of the steps
+// are not clamped to the bounds set with [WithMinTimeout] and [WithMaxTimeout].
+func ResolveExplain(c *fox.Context) []Step {
+	s, ok := c.Request().Context().Value(scopeKey{}).(*scope)
+	if !ok {
+		return nil
+	}
+	return s.t.explain(c)
+}
+
+// scopeTimeout is the timeout of the handlers of a scope, see WithHandlerScopeTimeout.
+type scopeTimeout struct {
+	scope fox.HandlerScope
+	dt    time.Duration
+}
+
+func (t *Timeout) explain(c *fox.Context) []Step {
+	steps := make([]Step, 0, len(precedence)+1)
+	applied := -1
+	for _, src := range precedence {
+		if (src == SourceTrusted && t.cfg.trusted == nil && t.cfg.trustedNets == nil) ||
+			(src == SourceResolver && t.cfg.resolver == nil) ||
+			(src == SourceFlag && t.cfg.flags == nil) ||
+			(src == SourcePolicy && t.cfg.policy == nil) ||
+			(src == SourceAdaptive && t.cfg.adaptive == nil) ||
+			(src == SourceHandlerScope && t.cfg.scopeTimeouts == nil) ||
+			(src == SourceMethod && t.cfg.methods == nil) ||
+			(src == SourceProtocol && t.cfg.protocols == nil) {
+			continue
+		}
+		dt, ok := t.lookupTimeout(c, src)
+		steps = append(steps, Step{Source: src, Timeout: dt, Found: ok, Applied: ok && applied < 0})
+		if ok && applied < 0 {
+			applied = len(steps) - 1
+		}
+	}
+	if t.cfg.headerTimeout != nil {
+		dt, ok := t.lookupTimeout(c, SourceHeader)
+		shorter := ok && applied >= 0 && shortens(dt, steps[applied].Timeout)
+		if shorter {
+			steps[applied].Applied = false
+		}
+		steps = append(steps, Step{Source: SourceHeader, Timeout: dt, Found: ok, Applied: shorter})
+	}
+	return steps
+}
+
+// resolveTimeout returns the timeout of the handler serving c, clamped to the minimum and maximum timeouts. The
+// timeout set by the client with the header configured with WithHeaderTimeout can only shorten the one of the other
+// sources, so that a client cannot extend the timeout of a route.
+func (t *Timeout) resolveTimeout(c *fox.Context) (time.Duration, Clamp) {
+	resolved := t.timeout()
+	for _, src := range precedence {
+		if dt, ok := t.lookupTimeout(c, src); ok {
+			resolved = dt
+			break
+		}
+	}
+	if dt, ok := t.lookupTimeout(c, SourceHeader); ok && shortens(dt, resolved) {
+		resolved = dt
+	}
+	return t.clamp(resolved)
+}
+
+// shortens reports whether the timeout dt is shorter than resolved, NoTimeout being the longest, so that the client
+// still does not wait past its own deadline on the routes without timeout.
+func shortens(dt, resolved time.Duration) bool {
+	return resolved <= 0 || dt < resolved
+}
+
+// lookupTimeout returns the timeout of the request in the given source, if any.
+func (t *Timeout) lookupTimeout(c *fox.Context, src Source) (time.Duration, bool) {
+	switch src {
+	case SourceTrusted:
+		if !t.outer && (t.cfg.trusted != nil || t.cfg.trustedNets != nil) {
+			if dt, ok := unwrapRouteTimeout(t.route(c.Route()), tKey{}); ok && t.trustedCaller(c) {
+				return dt, true
+			}
+		}
+	case SourceHeader:
+		if t.cfg.headerTimeout != nil {
+			return t.cfg.headerTimeout.lookup(c.Request().Header, t.cfg.parseDuration)
+		}
+	case SourceResolver:
+		if t.cfg.resolver != nil {
+			if dt := t.cfg.resolver(c); dt >= 0 {
+				return dt, true
+			}
+		}
+	case SourceAdaptive:
+		if !t.outer && t.cfg.adaptive != nil {
+			return t.cfg.adaptive.lookup(t.routeKey(c))
+		}
+	case SourceHost:
+		if t.cfg.hosts != nil {
+			return t.cfg.hosts.lookup(c.Host())
+		}
+	case SourceProtocol:
+		if t.cfg.protocols != nil {
+			dt, ok := t.cfg.protocols[c.Request().Proto]
+			return dt, ok
+		}
+	default:
+		return t.lookupRouteTimeout(t.route(c.Route()), c.Pattern(), c.Method(), c.Scope(), src)
+	}
+	return 0, false
+}
+
+// lookupRouteTimeout returns the timeout of the requests with the given method served by the route r in the given
+// source, if any, for the sources that do not depend on the request itself.
+func (t *Timeout) lookupRouteTimeout(r *fox.Route, pattern, method string, scope fox.HandlerScope, src Source) (time.Duration, bool) {
+	switch src {
+	case SourceFlag:
+		if !t.outer && t.cfg.flags != nil {
+			return t.cfg.flags.lookup(t.patternKey(pattern), t.now())
+		}
+	case SourcePolicy:
+		if !t.outer {
+			return t.cfg.policy.handler(pattern, method)
+		}
+	case SourceRoute:
+		if !t.outer {
+			if dt, ok := unwrapRouteTimeout(r, methodKey{method: method}); ok {
+				return dt, true
+			}
+			return unwrapRouteTimeout(r, hKey{})
+		}
+	case SourcePattern:
+		if !t.outer && t.cfg.patterns != nil {
+			return t.cfg.patterns.lookup(pattern)
+		}
+	case SourceHandlerScope:
+		for i := len(t.cfg.scopeTimeouts) - 1; i >= 0; i-- {
+			if st := t.cfg.scopeTimeouts[i]; st.scope&scope != 0 {
+				return st.dt, true
+			}
+		}
+	case SourceMethod:
+		if t.cfg.methods != nil {
+			dt, ok := t.cfg.methods[method]
+			return dt, ok
+		}
+	case SourceGlobal:
+		return t.timeout(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,198 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveExplain(t *testing.T) {
+	explained := make(chan []Step, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithHostTimeouts(map[string]time.Duration{
+		"api.example.com": 2 * time.Second,
+	}))))
+	require.NoError(t, err)
+	handler := func(c *fox.Context) {
+		explained <- ResolveExplain(c)
+	}
+	f.MustAdd(fox.MethodGet, "/foo", handler)
+	f.MustAdd(fox.MethodGet, "/bar", handler, OverrideHandler(3*time.Second))
+	f.MustAdd(fox.MethodGet, "/none", handler, OverrideHandler(NoTimeout))
+
+	cases := []struct {
+		name  string
+		path  string
+		host  string
+		steps []Step
+	}{
+		{
+			name: "global",
+			path: "/foo",
+			host: "example.com",
+			steps: []Step{
+				{Source: SourceRoute},
+				{Source: SourcePattern},
+				{Source: SourceHost},
+				{Source: SourceGlobal, Timeout: time.Second, Found: true, Applied: true},
+			},
+		},
+		{
+			name: "host",
+			path: "/foo",
+			host: "api.example.com",
+			steps: []Step{
+				{Source: SourceRoute},
+				{Source: SourcePattern},
+				{Source: SourceHost, Timeout: 2 * time.Second, Found: true, Applied: true},
+				{Source: SourceGlobal, Timeout: time.Second, Found: true},
+			},
+		},
+		{
+			name: "route",
+			path: "/bar",
+			host: "api.example.com",
+			steps: []Step{
+				{Source: SourceRoute, Timeout: 3 * time.Second, Found: true, Applied: true},
+				{Source: SourcePattern},
+				{Source: SourceHost, Timeout: 2 * time.Second, Found: true},
+				{Source: SourceGlobal, Timeout: time.Second, Found: true},
+			},
+		},
+		{
+			name: "no timeout",
+			path: "/none",
+			host: "example.com",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Host = tc.host
+			f.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.steps, <-explained)
+		})
+	}
+}
+
+func TestResolveExplain_Header(t *testing.T) {
+	explained := make(chan []Step, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithHeaderTimeout("X-Request-Timeout", 10*time.Millisecond, 5*time.Second))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		explained <- ResolveExplain(c)
+	}, OverrideHandler(3*time.Second))
+
+	cases := []struct {
+		name   string
+		header string
+		steps  []Step
+	}{
+		{
+			name: "no header",
+			steps: []Step{
+				{Source: SourceRoute, Timeout: 3 * time.Second, Found: true, Applied: true},
+				{Source: SourcePattern},
+				{Source: SourceHost},
+				{Source: SourceGlobal, Timeout: time.Second, Found: true},
+				{Source: SourceHeader},
+			},
+		},
+		{
+			name:   "header",
+			header: "200ms",
+			steps: []Step{
+				{Source: SourceRoute, Timeout: 3 * time.Second, Found: true},
+				{Source: SourcePattern},
+				{Source: SourceHost},
+				{Source: SourceGlobal, Timeout: time.Second, Found: true},
+				{Source: SourceHeader, Timeout: 200 * time.Millisecond, Found: true, Applied: true},
+			},
+		},
+		{
+			name:   "longer header",
+			header: "1h",
+			steps: []Step{
+				{Source: SourceRoute, Timeout: 3 * time.Second, Found: true, Applied: true},
+				{Source: SourcePattern},
+				{Source: SourceHost},
+				{Source: SourceGlobal, Timeout: time.Second, Found: true},
+				{Source: SourceHeader, Timeout: 5 * time.Second, Found: true},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			if tc.header != "" {
+				req.Header.Set("X-Request-Timeout", tc.header)
+			}
+			f.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.steps, <-explained)
+		})
+	}
+}
+
+func TestResolveExplain_Protocol(t *testing.T) {
+	explained := make(chan []Step, 1)
+	tm := New(time.Second, WithProtocolTimeout("HTTP/1.0", 200*time.Millisecond))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		explained <- ResolveExplain(c)
+	})
+
+	cases := []struct {
+		name  string
+		proto string
+		steps []Step
+	}{
+		{
+			name:  "protocol",
+			proto: "HTTP/1.0",
+			steps: []Step{
+				{Source: SourceRoute},
+				{Source: SourcePattern},
+				{Source: SourceHost},
+				{Source: SourceProtocol, Timeout: 200 * time.Millisecond, Found: true, Applied: true},
+				{Source: SourceGlobal, Timeout: time.Second, Found: true},
+			},
+		},
+		{
+			name:  "other protocol",
+			proto: "HTTP/1.1",
+			steps: []Step{
+				{Source: SourceRoute},
+				{Source: SourcePattern},
+				{Source: SourceHost},
+				{Source: SourceProtocol},
+				{Source: SourceGlobal, Timeout: time.Second, Found: true, Applied: true},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			req.Proto = tc.proto
+			req.ProtoMajor, req.ProtoMinor, _ = http.ParseHTTPVersion(tc.proto)
+			f.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.steps, <-explained)
+		})
+	}
+
+	audits := tm.Audit(f)
+	require.Len(t, audits, 1)
+	assert.Equal(t, []Source{SourceProtocol}, audits[0].Dynamic)
+	assert.Equal(t, "protocol", SourceProtocol.String())
+}
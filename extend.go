@@ -0,0 +1,190 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+var (
+	// ErrNotExtendable is returned by [Extend] if the deadline of the handler cannot be extended, because the request
+	// is not served under a timeout, extensions are not enabled with [WithMaxExtension], or the timeout is enforced
+	// with [ConnDeadline]. It is returned by [Heartbeat] if the route has no idle timeout.
+	ErrNotExtendable = errors.New("timeout: deadline cannot be extended")
+	// ErrExtensionLimit is returned by [Extend] if the extension would exceed the maximum set with
+	// [WithMaxExtension].
+	ErrExtensionLimit = errors.New("timeout: deadline extension limit exceeded")
+)
+
+// Extend pushes the deadline of the handler serving c out by d, for handlers discovering mid-request that they
+// legitimately need more time, e.g. after validating an expensive export request. The deadline of the request
+// context, [Deadline], [Remaining] and [Checkpoint] all follow the extended deadline. Extensions add up, and are
+// bounded in total by the maximum set with [WithMaxExtension]: an extension exceeding it is refused with
+// [ErrExtensionLimit], leaving the deadline unchanged. Extend returns a [*DeadlineError] if the deadline has
+// already passed, and [ErrNotExtendable] if the deadline cannot be extended. A zero or negative d is a no-op. Note
+// that the connection deadlines, such as the one set with [OverrideWrite], are not extended.
+func Extend(c *fox.Context, d time.Duration) error {
+	s, ok := c.Request().Context().Value(scopeKey{}).(*scope)
+	if !ok || s.ext == nil {
+		return ErrNotExtendable
+	}
+	if d <= 0 {
+		return nil
+	}
+	return s.ext.extend(s, d)
+}
+
+// Heartbeat signals that the handler serving c is making progress, resetting its deadline to the idle timeout set
+// with [OverrideHeartbeat] from now. The deadline of the request context, [Deadline], [Remaining] and [Checkpoint]
+// all follow the reset deadline, which never goes past the deadline of the timeout of the route. Heartbeat returns a
+// [*DeadlineError] if the deadline has already passed, and [ErrNotExtendable] if the route has no idle timeout or the
+// timeout is enforced with [ConnDeadline] or [WriteGated].
+func Heartbeat(c *fox.Context) error {
+	s, ok := c.Request().Context().Value(scopeKey{}).(*scope)
+	if !ok || s.ext == nil || s.idle <= 0 {
+		return ErrNotExtendable
+	}
+	return s.ext.reset(s, s.idle, s.bound)
+}
+
+// end returns the deadline of the handler, including the extensions granted with Extend.
+func (s *scope) end() time.Time {
+	return s.deadline.Add(time.Duration(s.extended.Load()))
+}
+
+// deadlineContext is a context expiring at a deadline that can be pushed out, see Extend. Unlike a context derived
+// with context.WithCancelCause, it reports context.DeadlineExceeded once expired, including to the contexts derived
+// from it by the handler.
+type deadlineContext struct {
+	parent context.Context
+	// values is a child of parent, canceled with the cause of the cancellation just before done is closed. The
+	// values are looked up in values, so that context.Cause, which finds the closest cancelable context by value,
+	// reports the cause.
+	values      context.Context
+	cancelCause context.CancelCauseFunc
+	done        chan struct{}
+	stop        func() bool
+	timer       Timer
+	// limit is the maximum total extension of the deadline.
+	limit    time.Duration
+	clock    Clock
+	mu       sync.Mutex
+	deadline time.Time
+	err      error
+}
+
+// withExtendableDeadline is like context.WithDeadlineCause, but the deadline of the returned context can be pushed
+// out by up to limit in total. The deadline is measured with clock.
+func withExtendableDeadline(parent context.Context, clock Clock, deadline time.Time, limit time.Duration, cause error) (*deadlineContext, context.CancelFunc) {
+	dc := &deadlineContext{
+		parent:   parent,
+		done:     make(chan struct{}),
+		limit:    limit,
+		deadline: deadline,
+	}
+	dc.values, dc.cancelCause = context.WithCancelCause(parent)
+	dc.clock = clock
+	dc.timer = clock.NewTimer(deadline.Sub(clock.Now()))
+	go func() {
+		select {
+		case <-dc.timer.C():
+			dc.cancel(context.DeadlineExceeded, cause)
+		case <-dc.done:
+		}
+	}()
+	dc.stop = context.AfterFunc(parent, func() {
+		dc.cancel(parent.Err(), context.Cause(parent))
+	})
+	return dc, func() {
+		dc.stop()
+		dc.cancel(context.Canceled, context.Canceled)
+	}
+}
+
+func (dc *deadlineContext) Deadline() (time.Time, bool) {
+	dc.mu.Lock()
+	deadline := dc.deadline
+	dc.mu.Unlock()
+	if pd, ok := dc.parent.Deadline(); ok && pd.Before(deadline) {
+		return pd, true
+	}
+	return deadline, true
+}
+
+func (dc *deadlineContext) Done() <-chan struct{} {
+	return dc.done
+}
+
+func (dc *deadlineContext) Err() error {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.err
+}
+
+func (dc *deadlineContext) Value(key any) any {
+	return dc.values.Value(key)
+}
+
+func (dc *deadlineContext) cancel(err, cause error) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.err != nil {
+		return
+	}
+	dc.cancelCause(cause)
+	dc.err = err
+	dc.timer.Stop()
+	close(dc.done)
+}
+
+// extend pushes the deadline out by d, and records the extension in s.
+func (dc *deadlineContext) extend(s *scope, d time.Duration) error {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	extended := time.Duration(s.extended.Load())
+	if extended+d > dc.limit {
+		return ErrExtensionLimit
+	}
+	// The timer may have fired without canceling the context yet, in which case it is too late.
+	if dc.err != nil || !dc.timer.Stop() {
+		return &DeadlineError{Deadline: dc.deadline}
+	}
+	dc.deadline = dc.deadline.Add(d)
+	s.extended.Store(int64(extended + d))
+	dc.timer.Reset(dc.deadline.Sub(dc.clock.Now()))
+	return nil
+}
+
+// reset pushes the deadline out to d from now, but not past bound nor the limit of the extensions, and records the
+// extension in s. A deadline already further out is left unchanged.
+func (dc *deadlineContext) reset(s *scope, d time.Duration, bound time.Time) error {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.err != nil {
+		return &DeadlineError{Deadline: dc.deadline}
+	}
+	next := dc.clock.Now().Add(d)
+	if next.After(bound) {
+		next = bound
+	}
+	extended := time.Duration(s.extended.Load())
+	ext := min(next.Sub(dc.deadline), dc.limit-extended)
+	if ext <= 0 {
+		return nil
+	}
+	// The timer may have fired without canceling the context yet, in which case it is too late.
+	if !dc.timer.Stop() {
+		return &DeadlineError{Deadline: dc.deadline}
+	}
+	dc.deadline = dc.deadline.Add(ext)
+	s.extended.Store(int64(extended + ext))
+	dc.timer.Reset(dc.deadline.Sub(dc.clock.Now()))
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtend(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(30*time.Millisecond, WithMaxExtension(time.Second))))
+	require.NoError(t, err)
+	errs := make(chan error, 4)
+	f.MustAdd(fox.MethodGet, "/export", func(c *fox.Context) {
+		before, _ := Deadline(c)
+		require.NoError(t, Extend(c, 300*time.Millisecond))
+		after, _ := Deadline(c)
+		assert.Equal(t, 300*time.Millisecond, after.Sub(before))
+		ctxDeadline, _ := c.Request().Context().Deadline()
+		assert.Equal(t, after, ctxDeadline)
+		assert.ErrorIs(t, Extend(c, time.Second), ErrExtensionLimit)
+		time.Sleep(60 * time.Millisecond)
+		assert.NoError(t, Checkpoint(c))
+		_ = c.String(http.StatusOK, "ok")
+	})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		// Contexts derived by the handler report the deadline as exceeded, not canceled.
+		ctx, cancel := context.WithTimeout(c.Request().Context(), time.Hour)
+		defer cancel()
+		<-ctx.Done()
+		errs <- ctx.Err()
+		errs <- c.Request().Context().Err()
+		var dErr *DeadlineError
+		assert.ErrorAs(t, Extend(c, time.Millisecond), &dErr)
+		errs <- nil
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.ErrorIs(t, <-errs, context.DeadlineExceeded)
+	assert.ErrorIs(t, <-errs, context.DeadlineExceeded)
+	assert.NoError(t, <-errs)
+}
+
+func TestExtend_NotExtendable(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		assert.ErrorIs(t, Extend(c, time.Second), ErrNotExtendable)
+	})
+	f.MustAdd(fox.MethodGet, "/bar", func(c *fox.Context) {
+		assert.ErrorIs(t, Extend(c, time.Second), ErrNotExtendable)
+	}, OverrideHandler(NoTimeout))
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bar", nil))
+}
+
+func TestHeartbeat(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(200 * time.Millisecond)))
+	require.NoError(t, err)
+	errs := make(chan error, 1)
+	f.MustAdd(fox.MethodGet, "/poll", func(c *fox.Context) {
+		// The handler outlives its idle timeout as long as it ticks.
+		for range 4 {
+			time.Sleep(15 * time.Millisecond)
+			require.NoError(t, Heartbeat(c))
+		}
+		_ = c.String(http.StatusOK, "ok")
+	}, OverrideHeartbeat(30*time.Millisecond))
+	f.MustAdd(fox.MethodGet, "/stalled", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+		var dErr *DeadlineError
+		assert.ErrorAs(t, Heartbeat(c), &dErr)
+		errs <- nil
+	}, OverrideHeartbeat(30*time.Millisecond))
+	var begin time.Time
+	f.MustAdd(fox.MethodGet, "/bounded", func(c *fox.Context) {
+		require.NoError(t, Heartbeat(c))
+		time.Sleep(30 * time.Millisecond)
+		require.NoError(t, Heartbeat(c))
+		// The deadline is never reset past the timeout of the route.
+		deadline, _ := Deadline(c)
+		assert.Less(t, deadline.Sub(begin), 60*time.Millisecond)
+		_ = c.String(http.StatusOK, "ok")
+	}, OverrideHandler(50*time.Millisecond), OverrideHeartbeat(40*time.Millisecond))
+	f.MustAdd(fox.MethodGet, "/none", func(c *fox.Context) {
+		assert.ErrorIs(t, Heartbeat(c), ErrNotExtendable)
+	})
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/poll", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+
+	start = time.Now()
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stalled", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+	assert.NoError(t, <-errs)
+
+	begin = time.Now()
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bounded", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/none", nil))
+}
+
+func TestDeadlineContext_ParentCanceled(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	dc, cancel := withExtendableDeadline(parent, realClock{}, time.Now().Add(time.Hour), time.Second, ErrTimeout)
+	defer cancel()
+	cancelParent()
+	<-dc.Done()
+	assert.ErrorIs(t, dc.Err(), context.Canceled)
+}
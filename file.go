@@ -0,0 +1,191 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// passThroughSize is the size from which ServeContent writes the content directly to the client instead of
+// buffering it.
+const passThroughSize = 1 << 20
+
+// fileChunk is the maximum size of the chunks a file is sent in by ServeContent, the write deadline of the
+// connection being advanced before each chunk.
+const fileChunk = 4 << 20
+
+// errWritten is returned by passThrough when the handler already started its response.
+var errWritten = errors.New("timeout: response already started")
+
+// ServeFile replies to the request with the contents of the named file, like [http.ServeFile], but within the
+// budget of the handler: the file is opened and stat'ed under the deadline, and if the deadline is exceeded first,
+// ServeFile returns without writing anything, so that the timeout response is sent. The contents are then served
+// with [ServeContent]. Unlike [http.ServeFile], name is not checked against the request path, so it must not be
+// derived from untrusted input without sanitization. Directories are served by [http.ServeFile] as is.
+func ServeFile(c *fox.Context, name string) {
+	f, fi, err := openFile(c.Request().Context(), name)
+	if err != nil {
+		if c.Request().Context().Err() == nil {
+			code := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				code = http.StatusNotFound
+			case errors.Is(err, fs.ErrPermission):
+				code = http.StatusForbidden
+			}
+			http.Error(c.Writer(), http.StatusText(code), code)
+		}
+		return
+	}
+	defer f.Close()
+	if fi.IsDir() {
+		http.ServeFile(c.Writer(), c.Request(), name)
+		return
+	}
+	serveContent(c, fi.Name(), fi.ModTime(), fi.Size(), f)
+}
+
+// ServeContent replies to the request with the given content, like [http.ServeContent]. Small contents are
+// buffered like any other response. Contents of 1MB or more are written directly to the client instead, as
+// buffering them is a waste of memory and delays the response, while the handler timeout would cut off the
+// transfer of any large file over a slow network: the handler takes over the response, which is then no longer
+// bound by the handler deadline, but by an idle write deadline of the handler timeout, so that only a client that
+// stalls for longer than the timeout is cut off. The contents are sent in chunks with [io.ReaderFrom], which lets
+// the server use sendfile for files. If the handler already wrote to its response, or outside of the middleware,
+// the content is served by [http.ServeContent] as is.
+func ServeContent(c *fox.Context, name string, modtime time.Time, content io.ReadSeeker) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		http.Error(c.Writer(), http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	serveContent(c, name, modtime, size, content)
+}
+
+func serveContent(c *fox.Context, name string, modtime time.Time, size int64, content io.ReadSeeker) {
+	tw, ok := c.Writer().(*timeoutWriter)
+	if !ok || size < passThroughSize {
+		http.ServeContent(c.Writer(), c.Request(), name, modtime, content)
+		return
+	}
+	w, err := tw.passThrough()
+	if err != nil {
+		if errors.Is(err, errWritten) {
+			http.ServeContent(c.Writer(), c.Request(), name, modtime, content)
+		}
+		return
+	}
+	http.ServeContent(w, c.Request(), name, modtime, content)
+}
+
+// openFile opens and stats the named file, unless ctx is done first.
+func openFile(ctx context.Context, name string) (*os.File, fs.FileInfo, error) {
+	type opened struct {
+		f   *os.File
+		fi  fs.FileInfo
+		err error
+	}
+	ch := make(chan opened, 1)
+	go func() {
+		f, err := os.Open(name)
+		if err != nil {
+			ch <- opened{err: err}
+			return
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			ch <- opened{err: err}
+			return
+		}
+		ch <- opened{f: f, fi: fi}
+	}()
+
+	select {
+	case o := <-ch:
+		return o.f, o.fi, o.err
+	case <-ctx.Done():
+		// The file system is slow to respond, the file is closed once opened.
+		go func() {
+			if o := <-ch; o.f != nil {
+				_ = o.f.Close()
+			}
+		}()
+		return nil, nil, handlerErr(ctx.Err())
+	}
+}
+
+// passThrough hands the response over to the handler, which writes it directly to the client from now on, with an
+// idle write deadline of the handler timeout. It fails with errWritten if the handler already started its response,
+// or with the error of the arbiter if the handler timed out.
+func (tw *timeoutWriter) passThrough() (fox.ResponseWriter, error) {
+	if err := tw.arb.Acquire(); err != nil {
+		return nil, tw.lateWrite(err)
+	}
+	defer tw.arb.Release()
+	if err := tw.expired(); err != nil {
+		return nil, tw.lateWrite(err)
+	}
+	if tw.written || tw.sent {
+		return nil, errWritten
+	}
+	tw.arb.commitLocked()
+	tw.through = true
+	maps.Copy(tw.w.Header(), tw.headers)
+	return &fileWriter{slidingWriter{ResponseWriter: tw.w, idle: tw.dt}}, nil
+}
+
+// fileWriter is a slidingWriter sending the content read from files in chunks with io.ReaderFrom, so that the
+// server can use sendfile.
+type fileWriter struct {
+	slidingWriter
+}
+
+func (w *fileWriter) ReadFrom(src io.Reader) (int64, error) {
+	// The server only uses sendfile for a file, possibly limited, so a limited reader is unwrapped first.
+	remaining := int64(-1)
+	r := src
+	if lr, ok := src.(*io.LimitedReader); ok {
+		r, remaining = lr.R, lr.N
+	}
+	if _, ok := r.(*os.File); !ok {
+		// Other sources may be slow to read, so the deadline is advanced before each write.
+		return w.slidingWriter.ReadFrom(src)
+	}
+	src = r
+	var n int64
+	for remaining != 0 {
+		chunk := int64(fileChunk)
+		if remaining > 0 {
+			chunk = min(chunk, remaining)
+		}
+		w.advance()
+		nn, err := w.ResponseWriter.ReadFrom(&io.LimitedReader{R: src, N: chunk})
+		n += nn
+		if remaining > 0 {
+			remaining -= nn
+		}
+		if err != nil {
+			return n, err
+		}
+		if nn < chunk {
+			// The end of the source is reached.
+			return n, nil
+		}
+	}
+	return n, nil
+}
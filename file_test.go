@@ -0,0 +1,117 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowReader is an io.ReadSeeker whose reads are delayed.
+type slowReader struct {
+	*bytes.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.Reader.Read(p)
+}
+
+func TestServeFile(t *testing.T) {
+	dir := t.TempDir()
+	small := []byte("hello")
+	large := bytes.Repeat([]byte("0123456789abcdef"), passThroughSize/16*2)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "small.txt"), small, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "large.bin"), large, 0o600))
+
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50 * time.Millisecond)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/files/{name}", func(c *fox.Context) {
+		ServeFile(c, filepath.Join(dir, c.Param("name")))
+	})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		ServeContent(c, "large.bin", time.Now(), &slowReader{Reader: bytes.NewReader(large), delay: time.Millisecond})
+	})
+	f.MustAdd(fox.MethodGet, "/slow-small", func(c *fox.Context) {
+		ServeContent(c, "small.txt", time.Now(), &slowReader{Reader: bytes.NewReader(small), delay: 100 * time.Millisecond})
+	})
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	get := func(path string, header http.Header) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp, body
+	}
+
+	t.Run("small file", func(t *testing.T) {
+		resp, body := get("/files/small.txt", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, small, body)
+	})
+
+	t.Run("large file", func(t *testing.T) {
+		resp, body := get("/files/large.bin", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, len(large), len(body))
+		assert.True(t, bytes.Equal(large, body))
+	})
+
+	t.Run("range", func(t *testing.T) {
+		resp, body := get("/files/large.bin", http.Header{"Range": {"bytes=16-31"}})
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, large[16:32], body)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		resp, _ := get("/files/missing.txt", nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("large content past the deadline", func(t *testing.T) {
+		resp, body := get("/slow", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, bytes.Equal(large, body))
+	})
+
+	t.Run("small content past the deadline", func(t *testing.T) {
+		resp, _ := get("/slow-small", nil)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+}
+
+func TestServeContent_Written(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), passThroughSize)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/", func(c *fox.Context) {
+		c.Writer().WriteHeader(http.StatusOK)
+		ServeContent(c, "large.bin", time.Now(), bytes.NewReader(large))
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, len(large), w.Body.Len())
+}
@@ -0,0 +1,28 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+)
+
+// filterContext is a context whose values are restricted to the keys accepted by keep. Its deadline and
+// cancellation are those of the parent context.
+type filterContext struct {
+	context.Context
+	keep func(key any) bool
+}
+
+func (c filterContext) Value(key any) any {
+	switch key.(type) {
+	case entryKey, activeKey, scopeKey, outcomeKey:
+		// The values of the middleware are always kept, as they are part of its contract with the handler.
+	default:
+		if !c.keep(key) {
+			return nil
+		}
+	}
+	return c.Context.Value(key)
+}
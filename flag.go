@@ -0,0 +1,38 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultFlagTTL is how long the timeouts returned by the flag provider are cached by default.
+const defaultFlagTTL = 10 * time.Second
+
+type flagResult struct {
+	expires time.Time
+	dt      time.Duration
+	ok      bool
+}
+
+// flagTimeouts resolves a timeout from a feature flag system, see WithFlagProvider. The result of the provider is
+// cached by route for ttl, so that the flags are not evaluated on every request.
+type flagTimeouts struct {
+	provider func(route, flag string) (time.Duration, bool)
+	flag     string
+	ttl      time.Duration
+	cache    sync.Map
+}
+
+func (ft *flagTimeouts) lookup(route string, now time.Time) (time.Duration, bool) {
+	if res, ok := ft.cache.Load(route); ok && now.Before(res.(*flagResult).expires) {
+		return res.(*flagResult).dt, res.(*flagResult).ok
+	}
+	// Concurrent requests may evaluate the flag at expiry, which is harmless.
+	dt, ok := ft.provider(route, ft.flag)
+	ft.cache.Store(route, &flagResult{expires: now.Add(ft.ttl), dt: dt, ok: ok})
+	return dt, ok
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// FlushHeaders sends the status and headers written so far by the handler serving c, while its body keeps being
+// buffered under the deadline, so that e.g. a 200 status and caching headers are committed early. The status
+// defaults to 200 if the handler did not set one. Once the headers are sent, the timeout response can no longer
+// replace the response: if the handler times out, only the body is replaced by a truncation-safe fallback when
+// possible, that is a [JSONArray] closed with the truncation sentinel, or the partial body followed by the trailer
+// set with [WithFlushPartial]. Otherwise, the response is aborted and the connection closed, so that the client
+// cannot mistake the truncated body for a complete one. The headers set after FlushHeaders are ignored, and so are
+// the options editing the response once the handler is done, such as [WithPreCommit], [WithCompression],
+// [WithElapsedHeader] and [WithBudgetTrailer]. FlushHeaders returns [http.ErrHandlerTimeout] if the handler already
+// timed out. If the response is not buffered by the middleware, it flushes the response writer instead.
+func FlushHeaders(c *fox.Context) error {
+	tw, ok := c.Writer().(*timeoutWriter)
+	if !ok {
+		return c.Writer().FlushError()
+	}
+	if err := tw.arb.Acquire(); err != nil {
+		return tw.lateWrite(err)
+	}
+	defer tw.arb.Release()
+	if err := tw.expired(); err != nil {
+		return tw.lateWrite(err)
+	}
+	if !tw.written {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	if tw.sent {
+		return nil
+	}
+	if !tw.stream {
+		tw.early = true
+		// The trailer marking a truncated body must be declared along with the headers.
+		s, ok := c.Request().Context().Value(scopeKey{}).(*scope)
+		if ok && s.t.cfg.partial != nil && s.t.cfg.partial.trailer != "" && tw.req.ProtoAtLeast(1, 1) &&
+			tw.headers.Get("Content-Length") == "" {
+			tw.trailer = s.t.cfg.partial.trailer
+			tw.headers.Add("Trailer", tw.trailer)
+		}
+	}
+	tw.sendHeaderLocked()
+	return tw.w.FlushError()
+}
+
+// truncateEarly ends the body of a response whose headers were sent with FlushHeaders, once its handler timed out.
+// It sends the partial body followed by the truncation trailer if one was declared, and otherwise reports that the
+// response must be aborted. The caller must hold the arbiter of tw.
+func (tw *timeoutWriter) truncateEarly(w fox.ResponseWriter) bool {
+	if tw.trailer == "" || tw.n == 0 {
+		return false
+	}
+	tw.writeBodyLocked(w)
+	w.Header().Set(tw.trailer, "truncated")
+	return true
+}
+
+// writeBodyLocked writes the buffered or spilled body to w. The caller must hold the arbiter of tw.
+func (tw *timeoutWriter) writeBodyLocked(w fox.ResponseWriter) {
+	if tw.spill != nil {
+		_ = tw.spill.writeTo(w)
+		return
+	}
+	_, _ = w.Write(tw.buf.Bytes())
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushHeaders(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50 * time.Millisecond)))
+	require.NoError(t, err)
+	headers := make(chan http.Header, 1)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		c.SetHeader("Cache-Control", "max-age=60")
+		require.NoError(t, FlushHeaders(c))
+		c.SetHeader("X-Late", "ignored")
+		// The headers are received by the client before the body is written.
+		<-headers
+		_ = c.String(http.StatusCreated, "done")
+	})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		require.NoError(t, FlushHeaders(c))
+		_, _ = io.WriteString(c.Writer(), "partial")
+		<-c.Request().Context().Done()
+	})
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/foo")
+	require.NoError(t, err)
+	headers <- resp.Header
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "max-age=60", resp.Header.Get("Cache-Control"))
+	assert.Empty(t, resp.Header.Get("X-Late"))
+	assert.Equal(t, "done", string(body))
+
+	// The response is aborted, so that the client does not mistake the partial body for a complete one.
+	resp, err = http.Get(srv.URL + "/slow")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestFlushHeaders_Truncated(t *testing.T) {
+	infos := make(chan TimeoutInfo, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond,
+		WithFlushPartial("X-Truncated"),
+		WithOnTimeout(func(c *fox.Context, info TimeoutInfo) { infos <- info }),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/partial", func(c *fox.Context) {
+		require.NoError(t, FlushHeaders(c))
+		_, _ = io.WriteString(c.Writer(), "partial")
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/array", func(c *fox.Context) {
+		c.SetHeader("Content-Type", "application/json")
+		require.NoError(t, FlushHeaders(c))
+		ja := NewJSONArray(c)
+		_ = ja.Encode(1)
+		<-c.Request().Context().Done()
+	})
+	late := make(chan error, 1)
+	f.MustAdd(fox.MethodGet, "/late", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+		time.Sleep(5 * time.Millisecond)
+		late <- FlushHeaders(c)
+	})
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/partial")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "partial", string(body))
+	assert.Equal(t, "truncated", resp.Trailer.Get("X-Truncated"))
+	assert.True(t, (<-infos).Partial)
+
+	resp, err = http.Get(srv.URL + "/array")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `[1,{"truncated":true}]`, string(body))
+	assert.True(t, (<-infos).Partial)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/late", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.False(t, (<-infos).Partial)
+	assert.ErrorIs(t, <-late, http.ErrHandlerTimeout)
+}
+
+func TestFlushHeaders_Unbuffered(t *testing.T) {
+	f, err := fox.NewRouter()
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		c.SetHeader("Cache-Control", "max-age=60")
+		require.NoError(t, FlushHeaders(c))
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+}
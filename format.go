@@ -0,0 +1,131 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// ErrorFormat is the format of the timeout response, so that each kind of endpoint reports timeouts with the error
+// semantics its clients expect, see [WithErrorFormat] and [OverrideErrorFormat].
+type ErrorFormat uint8
+
+const (
+	// FormatPlain reports timeouts with [DefaultResponse].
+	FormatPlain ErrorFormat = iota + 1
+	// FormatProblem reports timeouts with [NegotiatedResponse].
+	FormatProblem
+	// FormatJSONAPI reports timeouts with [JSONAPIResponse].
+	FormatJSONAPI
+	// FormatGraphQL reports timeouts with [GraphQLResponse].
+	FormatGraphQL
+)
+
+const mimeJSONAPI = "application/vnd.api+json"
+
+// String returns the name of the error format.
+func (f ErrorFormat) String() string {
+	switch f {
+	case FormatPlain:
+		return "plain"
+	case FormatProblem:
+		return "problem"
+	case FormatJSONAPI:
+		return "jsonapi"
+	case FormatGraphQL:
+		return "graphql"
+	default:
+		return "unknown"
+	}
+}
+
+// response returns the handler writing the timeout response in the format, or nil if the format is unknown.
+func (f ErrorFormat) response() fox.HandlerFunc {
+	switch f {
+	case FormatPlain:
+		return DefaultResponse
+	case FormatProblem:
+		return NegotiatedResponse
+	case FormatJSONAPI:
+		return JSONAPIResponse
+	case FormatGraphQL:
+		return GraphQLResponse
+	default:
+		return nil
+	}
+}
+
+// WithErrorFormat sets the format of the timeout response, as [WithResponse] does with the handler of the format.
+// The [OverrideErrorFormat] route option takes precedence. An unknown format is ignored.
+func WithErrorFormat(f ErrorFormat) Option {
+	return WithResponse(f.response())
+}
+
+// OverrideErrorFormat returns a RouteOption that sets the format of the timeout response of a specific route, as
+// [OverrideResponse] does with the handler of the format, so that e.g. a GraphQL endpoint reports timeouts in its
+// errors array while the REST endpoints sharing the middleware keep the HTTP error semantics. An unknown format is
+// ignored.
+func OverrideErrorFormat(f ErrorFormat) fox.RouteOption {
+	return OverrideResponse(f.response())
+}
+
+// jsonAPIError is an error object, as defined by the JSON:API specification.
+type jsonAPIError struct {
+	Status string `json:"status"`
+	Code   string `json:"code"`
+	Title  string `json:"title"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// JSONAPIResponse sends a 503 Service Unavailable response, or the status code set with [WithStatusCode], with a
+// JSON:API document holding a single error object ("application/vnd.api+json"). The code of the error object is
+// "handler_timeout", or "request_shed" for a request rejected by the admission control.
+func JSONAPIResponse(c *fox.Context) {
+	code, dt := timeoutDetails(c)
+	e := jsonAPIError{Status: strconv.Itoa(code), Code: "handler_timeout", Title: http.StatusText(code)}
+	if errors.Is(responseErr(c), ErrShed) {
+		e.Code = "request_shed"
+	} else if dt > 0 {
+		e.Detail = "The handler did not complete within " + dt.String() + "."
+	}
+	b, _ := json.Marshal(struct {
+		Errors []jsonAPIError `json:"errors"`
+	}{Errors: []jsonAPIError{e}})
+	_ = c.Blob(code, mimeJSONAPI, b)
+}
+
+// graphQLError is an error, as defined by the GraphQL specification.
+type graphQLError struct {
+	Message    string         `json:"message"`
+	Extensions map[string]any `json:"extensions"`
+}
+
+// GraphQLResponse sends a 200 OK response with a GraphQL response holding no data and a single error, as the GraphQL
+// over HTTP specification mandates for the "application/json" media type, so that GraphQL clients surface the
+// timeout as any other error. The extensions of the error carry the code "TIMEOUT", or "SHED" for a request rejected
+// by the admission control, and the status code the response would otherwise have, as set with [WithStatusCode].
+func GraphQLResponse(c *fox.Context) {
+	code, dt := timeoutDetails(c)
+	e := graphQLError{
+		Message:    http.StatusText(code),
+		Extensions: map[string]any{"code": "TIMEOUT", "status": code},
+	}
+	if errors.Is(responseErr(c), ErrShed) {
+		e.Extensions["code"] = "SHED"
+	} else if dt > 0 {
+		e.Message = "The handler did not complete within " + dt.String() + "."
+		e.Extensions["timeout"] = dt.String()
+	}
+	b, _ := json.Marshal(struct {
+		Data   any            `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}{Errors: []graphQLError{e}})
+	_ = c.Blob(http.StatusOK, fox.MIMEApplicationJSONCharsetUTF8, b)
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithErrorFormat(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, WithErrorFormat(FormatJSONAPI))))
+	require.NoError(t, err)
+	slow := func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	}
+	f.MustAdd(fox.MethodGet, "/rest", slow)
+	f.MustAdd(fox.MethodPost, "/graphql", slow, OverrideErrorFormat(FormatGraphQL))
+	f.MustAdd(fox.MethodGet, "/plain", slow, OverrideErrorFormat(FormatPlain))
+	f.MustAdd(fox.MethodGet, "/unknown", slow, OverrideErrorFormat(ErrorFormat(0)))
+
+	cases := []struct {
+		name     string
+		method   string
+		path     string
+		wantCode int
+		wantType string
+		wantBody string
+		plain    bool
+	}{
+		{
+			name:     "json:api",
+			method:   http.MethodGet,
+			path:     "/rest",
+			wantCode: http.StatusServiceUnavailable,
+			wantType: "application/vnd.api+json",
+			wantBody: `{"errors":[{"status":"503","code":"handler_timeout","title":"Service Unavailable","detail":"The handler did not complete within 10ms."}]}`,
+		},
+		{
+			name:     "graphql",
+			method:   http.MethodPost,
+			path:     "/graphql",
+			wantCode: http.StatusOK,
+			wantType: fox.MIMEApplicationJSONCharsetUTF8,
+			wantBody: `{"data":null,"errors":[{"message":"The handler did not complete within 10ms.","extensions":{"code":"TIMEOUT","status":503,"timeout":"10ms"}}]}`,
+		},
+		{
+			name:     "plain",
+			method:   http.MethodGet,
+			path:     "/plain",
+			wantCode: http.StatusServiceUnavailable,
+			plain:    true,
+		},
+		{
+			name:     "unknown format",
+			method:   http.MethodGet,
+			path:     "/unknown",
+			wantCode: http.StatusServiceUnavailable,
+			wantType: "application/vnd.api+json",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+			assert.Equal(t, tc.wantCode, w.Code)
+			if tc.plain {
+				assert.Equal(t, "Service Unavailable\n", w.Body.String())
+				return
+			}
+			assert.Equal(t, tc.wantType, w.Header().Get("Content-Type"))
+			if tc.wantBody != "" {
+				assert.JSONEq(t, tc.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGraphQLResponse_Shed(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second,
+		WithErrorFormat(FormatGraphQL),
+		WithMaxRequestAge("X-Request-Start", time.Second),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodPost, "/graphql", func(c *fox.Context) {})
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	req.Header.Set("X-Request-Start", "t=1000000000")
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"data":null,"errors":[{"message":"Too Many Requests","extensions":{"code":"SHED","status":429}}]}`, w.Body.String())
+}
@@ -0,0 +1,270 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// serveGated calls next on the request goroutine behind a gatedWriter, and replaces its response by the timeout
+// response if the handler context is done by the time it returns, see WriteGated.
+func (t *Timeout) serveGated(c *fox.Context, next fox.HandlerFunc, start time.Time, dt time.Duration, respond fox.HandlerFunc) result {
+	deadline := t.deadline(start, dt)
+	req := c.Request()
+	ctx, cancel := withDeadlineCause(t.cfg.clock, req.Context(), deadline, ErrTimeout)
+	defer cancel()
+	hctx := context.WithValue(ctx, scopeKey{}, &scope{t: t, deadline: deadline, pattern: c.Pattern(), timeout: dt})
+	if !t.cfg.reentrant {
+		hctx = context.WithValue(hctx, activeKey{}, t)
+	}
+
+	w := c.Writer()
+	gw := &gatedWriter{w: w, t: t, ctx: ctx, code: http.StatusOK, start: start, dt: dt, pattern: c.Pattern()}
+	defer gw.release()
+	c.SetRequest(req.WithContext(hctx))
+	c.SetWriter(gw)
+	func() {
+		defer func() {
+			c.SetWriter(w)
+			c.SetRequest(req)
+		}()
+		next(c)
+	}()
+
+	if err := ctx.Err(); err != nil && !gw.through {
+		// The gate closed before the handler returned, so its response is discarded.
+		err = handlerErr(err)
+		res := result{timedOut: true, canceled: err != http.ErrHandlerTimeout, buffered: gw.written}
+		res.id = t.eventID(w, false)
+		if res.canceled {
+			t.writeCanceled(c)
+		} else {
+			t.writeTimeout(c, respond, start, deadline, dt, context.Cause(ctx))
+		}
+		res.overshoot = max(t.since(deadline), 0)
+		return res
+	}
+	// Errors are ignored, as the client went away and there is nothing left to do.
+	_ = gw.commit()
+	return result{}
+}
+
+// gatedWriter is the fox.ResponseWriter of the WriteGated enforcement. The response is buffered until the handler
+// returns or flushes it, the buffer being taken from the pool on the first write only, and every write fails once
+// the handler context is done: the gate is the context itself, so that no goroutine nor lock is needed. Like any
+// http.ResponseWriter, it must not be used concurrently.
+type gatedWriter struct {
+	w       fox.ResponseWriter
+	t       *Timeout
+	ctx     context.Context
+	headers http.Header
+	buf     *bytes.Buffer
+	code    int
+	n       int
+	written bool
+	// through reports whether the response is written through to the client, once flushed by the handler.
+	through bool
+	// start is the start of the handler of the route of the given pattern, and dt its timeout, see TimeoutWriteError.
+	start   time.Time
+	dt      time.Duration
+	pattern string
+}
+
+func (gw *gatedWriter) capabilities() WriterCapabilities {
+	return WriterCapabilities{
+		Flush:         true,
+		Push:          true,
+		ReadFrom:      true,
+		ReadDeadline:  true,
+		WriteDeadline: true,
+		FullDuplex:    true,
+	}
+}
+
+// gate returns a non-nil error once the handler context is done, a *TimeoutWriteError if the handler timed out.
+func (gw *gatedWriter) gate() error {
+	if err := gw.ctx.Err(); err != nil {
+		if err = handlerErr(err); err == http.ErrHandlerTimeout {
+			return gw.t.writeError(gw.pattern, gw.start, gw.dt, relevantCaller())
+		}
+		return err
+	}
+	return nil
+}
+
+// Header returns the headers of the response, a copy of the headers of the underlying writer made on the first call
+// while the response is buffered, so that the headers set by the handler are discarded with its response.
+func (gw *gatedWriter) Header() http.Header {
+	if gw.through {
+		return gw.w.Header()
+	}
+	if gw.headers == nil {
+		gw.headers = gw.w.Header().Clone()
+		if gw.headers == nil {
+			gw.headers = make(http.Header)
+		}
+	}
+	return gw.headers
+}
+
+func (gw *gatedWriter) WriteHeader(code int) {
+	checkWriteHeaderCode(code)
+	if gw.written || gw.gate() != nil {
+		return
+	}
+	gw.written = true
+	gw.code = code
+}
+
+func (gw *gatedWriter) Write(p []byte) (int, error) {
+	if err := gw.gate(); err != nil {
+		return 0, err
+	}
+	if !gw.written {
+		gw.WriteHeader(http.StatusOK)
+	}
+	var (
+		n   int
+		err error
+	)
+	if gw.through {
+		n, err = gw.w.Write(p)
+	} else {
+		n, err = gw.buffer().Write(p)
+	}
+	gw.n += n
+	return n, err
+}
+
+func (gw *gatedWriter) WriteString(s string) (int, error) {
+	if err := gw.gate(); err != nil {
+		return 0, err
+	}
+	if !gw.written {
+		gw.WriteHeader(http.StatusOK)
+	}
+	var (
+		n   int
+		err error
+	)
+	if gw.through {
+		n, err = io.WriteString(gw.w, s)
+	} else {
+		n, err = gw.buffer().WriteString(s)
+	}
+	gw.n += n
+	return n, err
+}
+
+func (gw *gatedWriter) ReadFrom(src io.Reader) (n int64, err error) {
+	bufPtr := copyBufPool.Get().(*[]byte)
+	buf := *bufPtr
+
+	n, err = io.CopyBuffer(onlyWrite{gw}, src, buf)
+	copyBufPool.Put(bufPtr)
+	return
+}
+
+// buffer returns the buffer of the response, taken from the pool on the first call.
+func (gw *gatedWriter) buffer() *bytes.Buffer {
+	if gw.buf == nil {
+		gw.buf = gw.t.getBuffer()
+	}
+	return gw.buf
+}
+
+// commit sends the buffered response to the client, once, and writes the rest of the response through.
+func (gw *gatedWriter) commit() error {
+	if gw.through {
+		return nil
+	}
+	gw.through = true
+	if gw.headers != nil {
+		dst := gw.w.Header()
+		clear(dst)
+		maps.Copy(dst, gw.headers)
+	}
+	if !gw.written {
+		// Like net/http, a handler writing nothing gets an empty 200 OK response.
+		return nil
+	}
+	gw.w.WriteHeader(gw.code)
+	if gw.buf == nil {
+		return nil
+	}
+	_, err := gw.w.Write(gw.buf.Bytes())
+	return err
+}
+
+// release returns the buffer of the response to the pool.
+func (gw *gatedWriter) release() {
+	if gw.buf != nil {
+		gw.t.putBuffer(gw.buf)
+		gw.buf = nil
+	}
+}
+
+func (gw *gatedWriter) FlushError() error {
+	if err := gw.gate(); err != nil {
+		return err
+	}
+	if !gw.written {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if err := gw.commit(); err != nil {
+		return err
+	}
+	return gw.w.FlushError()
+}
+
+func (gw *gatedWriter) Status() int {
+	return gw.code
+}
+
+func (gw *gatedWriter) Written() bool {
+	return gw.written
+}
+
+func (gw *gatedWriter) Size() int {
+	return gw.n
+}
+
+func (gw *gatedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, fox.ErrNotSupported()
+}
+
+func (gw *gatedWriter) Push(target string, opts *http.PushOptions) error {
+	return gw.w.Push(target, opts)
+}
+
+func (gw *gatedWriter) SetReadDeadline(deadline time.Time) error {
+	if err := gw.gate(); err != nil {
+		return err
+	}
+	return gw.w.SetReadDeadline(deadline)
+}
+
+func (gw *gatedWriter) SetWriteDeadline(deadline time.Time) error {
+	if err := gw.gate(); err != nil {
+		return err
+	}
+	return gw.w.SetWriteDeadline(deadline)
+}
+
+func (gw *gatedWriter) EnableFullDuplex() error {
+	if err := gw.gate(); err != nil {
+		return err
+	}
+	return gw.w.EnableFullDuplex()
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithEnforcementWriteGated(t *testing.T) {
+	tm := New(20*time.Millisecond, WithEnforcement(WriteGated))
+	f, err := fox.NewRouter(fox.WithMiddleware(func(next fox.HandlerFunc) fox.HandlerFunc {
+		return func(c *fox.Context) {
+			c.Writer().Header().Set("X-Upstream", "1")
+			next(c)
+		}
+	}, tm.Middleware()))
+	require.NoError(t, err)
+
+	writeErr := make(chan error, 1)
+	f.MustAdd(fox.MethodGet, "/fast", func(c *fox.Context) {
+		_, ok := Deadline(c)
+		assert.True(t, ok)
+		c.Writer().Header().Set("X-Handler", "1")
+		_ = c.String(http.StatusCreated, "created")
+	})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		c.Writer().Header().Set("X-Handler", "1")
+		_, _ = c.Writer().Write([]byte("partial"))
+		<-c.Request().Context().Done()
+		_, err := c.Writer().Write([]byte("late"))
+		writeErr <- err
+	})
+	f.MustAdd(fox.MethodGet, "/flush", func(c *fox.Context) {
+		_, _ = c.Writer().Write([]byte("streamed"))
+		assert.NoError(t, c.Writer().FlushError())
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/empty", func(c *fox.Context) {})
+
+	t.Run("in time", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "created", w.Body.String())
+		assert.Equal(t, "1", w.Header().Get("X-Upstream"))
+		assert.Equal(t, "1", w.Header().Get("X-Handler"))
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.NotContains(t, w.Body.String(), "partial")
+		assert.Equal(t, "1", w.Header().Get("X-Upstream"))
+		assert.Empty(t, w.Header().Get("X-Handler"))
+		assert.ErrorIs(t, <-writeErr, http.ErrHandlerTimeout)
+	})
+
+	t.Run("flushed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/flush", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "streamed", w.Body.String())
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/empty", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Body.String())
+	})
+
+	stats := tm.Stats()
+	assert.Equal(t, uint64(1), stats["/slow"].TimedOut)
+	assert.Equal(t, uint64(1), stats["/fast"].Completed)
+}
+
+// BenchmarkEnforcement compares the cost of the enforcements for a fast handler, against the handler alone.
+func BenchmarkEnforcement(b *testing.B) {
+	cases := []struct {
+		name string
+		mws  []fox.MiddlewareFunc
+	}{
+		{name: "none"},
+		{name: "buffered", mws: []fox.MiddlewareFunc{Middleware(time.Second, WithEnforcement(Buffered))}},
+		{name: "write-gated", mws: []fox.MiddlewareFunc{Middleware(time.Second, WithEnforcement(WriteGated))}},
+		{name: "conn-deadline", mws: []fox.MiddlewareFunc{Middleware(time.Second, WithEnforcement(ConnDeadline))}},
+	}
+
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			f, err := fox.NewRouter(fox.WithMiddleware(tc.mws...))
+			require.NoError(b, err)
+			f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+				_ = c.String(http.StatusOK, "ok")
+			})
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			b.ReportAllocs()
+			for b.Loop() {
+				f.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headerTimeout resolves a timeout from a request header set by the client, clamped between min and max.
+type headerTimeout struct {
+	name string
+	min  time.Duration
+	max  time.Duration
+}
+
+// lookup returns the timeout of the header, parsed with parse if not nil, see WithDurationParser.
+func (ht *headerTimeout) lookup(h http.Header, parse DurationParser) (time.Duration, bool) {
+	v := h.Get(ht.name)
+	if v == "" {
+		return 0, false
+	}
+	var (
+		dt time.Duration
+		ok bool
+	)
+	if parse != nil {
+		var err error
+		dt, err = parse(v)
+		ok = err == nil && dt > 0
+	} else {
+		dt, ok = parseTimeoutHeader(v, http.CanonicalHeaderKey(ht.name) == grpcTimeoutHeader)
+	}
+	if !ok {
+		return 0, false
+	}
+	// The client must not be able to disable the timeout, e.g. if max is zero.
+	dt = min(max(dt, ht.min), ht.max)
+	return dt, dt > 0
+}
+
+// grpcTimeoutHeader is the canonical name of the header carrying the timeout of the gRPC calls.
+const grpcTimeoutHeader = "Grpc-Timeout"
+
+// grpcUnits maps the units of the grpc-timeout header to their duration.
+var grpcUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// parseTimeoutHeader parses a positive timeout as a Go duration (e.g. "1.5s") or as a number of seconds (e.g. "2.5"),
+// or in the grpc-timeout format (e.g. "100m" for 100 milliseconds) if grpc is set. The units of the two formats
+// overlap, "5m" being 5 minutes as a Go duration, so the grpc-timeout format is only accepted from the grpc-timeout
+// header, where it takes precedence.
+func parseTimeoutHeader(v string, grpc bool) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if n := len(v); grpc && n >= 2 && n <= 9 {
+		if unit, ok := grpcUnits[v[n-1]]; ok {
+			if value, err := strconv.ParseUint(v[:n-1], 10, 64); err == nil {
+				// The value has at most 8 digits, which still overflows in hours.
+				if value > uint64(math.MaxInt64/unit) {
+					return 0, false
+				}
+				return time.Duration(value) * unit, value > 0
+			}
+		}
+	}
+	if dt, err := time.ParseDuration(v); err == nil {
+		return dt, dt > 0
+	}
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || !(secs > 0) || secs > float64(math.MaxInt64/time.Second) {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
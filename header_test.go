@@ -0,0 +1,45 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func FuzzParseTimeoutHeader(f *testing.F) {
+	for _, v := range []string{"100m", "2S", "1H", "99999999H", "1.5s", "2.5", "0", "-1s", "NaN", "Inf", "1e300", "00000000n"} {
+		f.Add(v, true)
+		f.Add(v, false)
+	}
+	f.Fuzz(func(t *testing.T, v string, grpc bool) {
+		dt, ok := parseTimeoutHeader(v, grpc)
+		if ok && dt <= 0 {
+			t.Fatalf("parseTimeoutHeader(%q, %t) = %s, want a positive timeout", v, grpc, dt)
+		}
+	})
+}
+
+func FuzzHeaderTimeout(f *testing.F) {
+	for _, v := range []string{"100m", "1500", "PT1.5S", "P1W", "-PT1S", "1h", "0"} {
+		f.Add(v, false, int64(0), int64(time.Second))
+		f.Add(v, true, int64(time.Millisecond), int64(0))
+	}
+	f.Fuzz(func(t *testing.T, v string, iso bool, minDt, maxDt int64) {
+		cfg := defaultConfig()
+		WithHeaderTimeout("Grpc-Timeout", time.Duration(minDt), time.Duration(maxDt)).apply(cfg)
+		if iso {
+			WithDurationParser(ParseDuration).apply(cfg)
+		}
+		dt, ok := cfg.headerTimeout.lookup(http.Header{"Grpc-Timeout": {v}}, cfg.parseDuration)
+		if !ok {
+			return
+		}
+		if dt <= 0 || dt < cfg.headerTimeout.min || dt > cfg.headerTimeout.max {
+			t.Fatalf("header timeout %q = %s, want within (0, %s] and not below %s", v, dt, cfg.headerTimeout.max, cfg.headerTimeout.min)
+		}
+	})
+}
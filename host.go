@@ -0,0 +1,59 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"cmp"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+type hostWildcard struct {
+	suffix string
+	dt     time.Duration
+}
+
+// hostTimeouts resolves a timeout from the request host. Exact hosts take precedence over wildcards, and longer
+// wildcards take precedence over shorter ones.
+type hostTimeouts struct {
+	exact     map[string]time.Duration
+	wildcards []hostWildcard
+}
+
+func newHostTimeouts(hosts map[string]time.Duration) *hostTimeouts {
+	ht := &hostTimeouts{
+		exact: make(map[string]time.Duration, len(hosts)),
+	}
+	for host, dt := range hosts {
+		host = strings.ToLower(host)
+		if suffix, ok := strings.CutPrefix(host, "*"); ok {
+			ht.wildcards = append(ht.wildcards, hostWildcard{suffix: suffix, dt: dt})
+			continue
+		}
+		ht.exact[host] = dt
+	}
+	slices.SortFunc(ht.wildcards, func(a, b hostWildcard) int {
+		return cmp.Compare(len(b.suffix), len(a.suffix))
+	})
+	return ht
+}
+
+func (ht *hostTimeouts) lookup(host string) (time.Duration, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if dt, ok := ht.exact[host]; ok {
+		return dt, true
+	}
+	for _, w := range ht.wildcards {
+		if len(host) > len(w.suffix) && strings.HasSuffix(host, w.suffix) {
+			return w.dt, true
+		}
+	}
+	return 0, false
+}
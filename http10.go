@@ -0,0 +1,92 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// respondHTTP10 calls respond with a writer buffering the response, which is then sent with a Content-Length and the
+// "Connection: close" header. HTTP/1.0 clients do not understand the chunked framing, and some proxies and legacy
+// health checkers mishandle a response delimited by the closing of the connection.
+func (t *Timeout) respondHTTP10(c *fox.Context, respond fox.HandlerFunc) {
+	buf := t.getBuffer()
+	defer t.putBuffer(buf)
+	w := &http10Writer{ResponseWriter: c.Writer(), buf: buf}
+	cp := c.CloneWith(w, c.Request())
+	respond(cp)
+	cp.Close()
+	w.flush()
+}
+
+// http10Writer is a fox.ResponseWriter buffering the timeout response for an HTTP/1.0 client, see respondHTTP10.
+type http10Writer struct {
+	fox.ResponseWriter
+	buf  *bytes.Buffer
+	code int
+}
+
+func (w *http10Writer) Status() int {
+	return w.code
+}
+
+func (w *http10Writer) Written() bool {
+	return w.code != 0
+}
+
+func (w *http10Writer) Size() int {
+	return w.buf.Len()
+}
+
+func (w *http10Writer) WriteHeader(code int) {
+	checkWriteHeaderCode(code)
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *http10Writer) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.buf.Write(p)
+}
+
+func (w *http10Writer) WriteString(s string) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.buf.WriteString(s)
+}
+
+func (w *http10Writer) ReadFrom(src io.Reader) (int64, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.buf.ReadFrom(src)
+}
+
+func (w *http10Writer) FlushError() error {
+	return fox.ErrNotSupported()
+}
+
+// flush sends the buffered response.
+func (w *http10Writer) flush() {
+	h := w.ResponseWriter.Header()
+	h.Del("Transfer-Encoding")
+	h.Del("Trailer")
+	h.Set("Connection", "close")
+	h.Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_HTTP10TimeoutResponse(t *testing.T) {
+	cases := []struct {
+		name   string
+		opts   []Option
+		method string
+	}{
+		{name: "default response", method: http.MethodGet},
+		{name: "json response", opts: []Option{PresetAPI()}, method: http.MethodGet},
+		{name: "rfc compliance", opts: []Option{WithRFCCompliance(time.Second)}, method: http.MethodGet},
+		{name: "head request", method: http.MethodHead},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Millisecond, tc.opts...)))
+			require.NoError(t, err)
+			f.MustAdd([]string{http.MethodGet, http.MethodHead}, "/foo", func(c *fox.Context) {
+				c.Writer().Header().Set("Transfer-Encoding", "chunked")
+				<-c.Request().Context().Done()
+			})
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = fmt.Fprintf(conn, "%s /foo HTTP/1.0\r\n\r\n", tc.method)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(tc.method, "/foo", nil)
+			require.NoError(t, err)
+			resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+			assert.Empty(t, resp.TransferEncoding)
+			assert.Equal(t, "close", resp.Header.Get("Connection"))
+			contentLength, err := strconv.Atoi(resp.Header.Get("Content-Length"))
+			require.NoError(t, err)
+			assert.Positive(t, contentLength)
+			if tc.method != http.MethodHead {
+				assert.Len(t, body, contentLength)
+			}
+		})
+	}
+}
+
+func TestTimeout_ShedHTTP10(t *testing.T) {
+	tm := New(time.Second)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.0", 1, 0
+	tm.shed(fox.NewTestContextOnly(w, req), ShedAge)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "close", w.Header().Get("Connection"))
+	assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"sync"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// Invalidate discards the state accumulated by t for the given route patterns, or for every route if none is given:
+// the counters reported by [Timeout.Snapshot], the history reported by [Timeout.Report], the latency observed by
+// [WithAdaptive], the timeouts counted by [WithAutoRelax], which re-arms the relaxed routes, the timeouts counted by
+// [WithCircuitBreaker], which closes the breakers, and the record of the [EventUnboundedRoute] events already emitted.
+// It is intended to be called when routes are updated or deleted at runtime, e.g. to start counting afresh once the
+// timeout of a route changes. The patterns are normalized with the function set with [WithRouteNormalizer], if any.
+//
+// The timeout, [Strictness], [AbandonPolicy] and other route options are read from the route serving each request,
+// so they take effect as soon as a route is updated and never need to be invalidated. Likewise, the timeouts set with
+// [WithPatternTimeouts] only depend on the pattern, and remain valid.
+func (t *Timeout) Invalidate(patterns ...string) {
+	if len(patterns) == 0 {
+		t.counters.Clear()
+		t.history.Clear()
+		t.unbounded.Clear()
+		if t.cfg.adaptive != nil {
+			t.cfg.adaptive.routes.Clear()
+		}
+		if t.cfg.relax != nil {
+			t.cfg.relax.routes.Clear()
+		}
+		if t.cfg.breaker != nil {
+			t.cfg.breaker.routes.Clear()
+		}
+		return
+	}
+	keys := make(map[string]struct{}, len(patterns))
+	for _, pattern := range patterns {
+		key := t.patternKey(pattern)
+		keys[key] = struct{}{}
+		t.counters.Delete(key)
+		t.history.Delete(key)
+		if t.cfg.adaptive != nil {
+			t.cfg.adaptive.routes.Delete(key)
+		}
+		if t.cfg.relax != nil {
+			t.cfg.relax.routes.Delete(key)
+		}
+		if t.cfg.breaker != nil {
+			t.cfg.breaker.routes.Delete(key)
+		}
+	}
+	t.unbounded.Range(func(key, _ any) bool {
+		if _, ok := keys[t.patternKey(key.(*fox.Route).Pattern())]; ok {
+			t.unbounded.Delete(key)
+		}
+		return true
+	})
+}
+
+// Prune discards the state accumulated by t for the routes no longer registered in f, as [Timeout.Invalidate] does,
+// and keeps the state of the others. Since the state is recorded by route pattern, the counters and history of an
+// updated route carry over to the new route, while the [EventUnboundedRoute] event may be emitted again for it. It
+// is intended to be called after the routes of f are deleted at runtime, to release the memory held for them.
+func (t *Timeout) Prune(f *fox.Router) {
+	routes := make(map[*fox.Route]struct{})
+	keys := make(map[string]struct{})
+	for route := range f.Iter().All() {
+		routes[route] = struct{}{}
+		keys[t.patternKey(route.Pattern())] = struct{}{}
+	}
+	retain(&t.counters, keys)
+	retain(&t.history, keys)
+	if t.cfg.adaptive != nil {
+		retain(&t.cfg.adaptive.routes, keys)
+	}
+	if t.cfg.relax != nil {
+		retain(&t.cfg.relax.routes, keys)
+	}
+	if t.cfg.breaker != nil {
+		retain(&t.cfg.breaker.routes, keys)
+	}
+	t.unbounded.Range(func(key, _ any) bool {
+		if _, ok := routes[key.(*fox.Route)]; !ok {
+			t.unbounded.Delete(key)
+		}
+		return true
+	})
+}
+
+// retain deletes the entries of m whose pattern key is not in keys.
+func retain(m *sync.Map, keys map[string]struct{}) {
+	m.Range(func(key, _ any) bool {
+		if _, ok := keys[key.(string)]; !ok {
+			m.Delete(key)
+		}
+		return true
+	})
+}
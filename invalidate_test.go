@@ -0,0 +1,117 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout_RouteUpdate(t *testing.T) {
+	tm := New(time.Second)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	slow := func(c *fox.Context) {
+		time.Sleep(30 * time.Millisecond)
+		c.Writer().WriteHeader(http.StatusOK)
+	}
+	f.MustAdd(fox.MethodGet, "/foo", slow)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The updated timeout applies to the next request, and the counters carry over to the new route.
+	_, err = f.Update(fox.MethodGet, "/foo", slow, OverrideHandler(time.Millisecond))
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, RouteCounters{Requests: 2, Completed: 1, TimedOut: 1}, withoutLatency(tm.Snapshot().Routes["/foo"]))
+}
+
+func TestTimeout_Invalidate(t *testing.T) {
+	var events []Event
+	tm := New(NoTimeout,
+		WithUnboundedRouteDetection(0, time.Nanosecond),
+		WithEventSink(EventSinkFunc(func(e Event) { events = append(events, e) })),
+		WithHostTimeouts(map[string]time.Duration{"timed.example.com": time.Second}),
+		WithReportRetention(time.Hour),
+		WithRouteNormalizer(func(pattern string) string { return "norm:" + pattern }),
+	)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+	f.MustAdd(fox.MethodGet, "/bar", success201response)
+
+	serve := func(host, path string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		f.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for _, path := range []string{"/foo", "/bar"} {
+		serve("timed.example.com", path)
+		serve("example.com", path)
+	}
+	require.Len(t, events, 2)
+	require.Len(t, tm.Snapshot().Routes, 2)
+	require.Len(t, tm.Report(time.Hour).Routes, 2)
+
+	tm.Invalidate("/foo")
+	assert.NotContains(t, tm.Snapshot().Routes, "norm:/foo")
+	assert.Contains(t, tm.Snapshot().Routes, "norm:/bar")
+	assert.Len(t, tm.Report(time.Hour).Routes, 1)
+	serve("example.com", "/foo")
+	serve("example.com", "/bar")
+	// The unbounded route event is emitted again only for the invalidated route.
+	require.Len(t, events, 3)
+	assert.Equal(t, "norm:/foo", events[2].Pattern)
+
+	tm.Invalidate()
+	assert.Empty(t, tm.Snapshot().Routes)
+	assert.Empty(t, tm.Report(time.Hour).Routes)
+	serve("example.com", "/bar")
+	assert.Len(t, events, 4)
+}
+
+func TestTimeout_Prune(t *testing.T) {
+	var events []Event
+	tm := New(NoTimeout,
+		WithUnboundedRouteDetection(0, time.Nanosecond),
+		WithEventSink(EventSinkFunc(func(e Event) { events = append(events, e) })),
+		WithHostTimeouts(map[string]time.Duration{"timed.example.com": time.Second}),
+	)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+	f.MustAdd(fox.MethodGet, "/bar", success201response)
+
+	serve := func(host, path string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		f.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for _, path := range []string{"/foo", "/bar"} {
+		serve("timed.example.com", path)
+		serve("example.com", path)
+	}
+	require.Len(t, events, 2)
+
+	_, err = f.Delete(fox.MethodGet, "/foo")
+	require.NoError(t, err)
+	tm.Prune(f)
+	routes := tm.Snapshot().Routes
+	require.Len(t, routes, 1)
+	assert.Equal(t, RouteCounters{Requests: 1, Completed: 1}, withoutLatency(routes["/bar"]))
+	// The unbounded route event of the remaining route is not emitted again.
+	serve("example.com", "/bar")
+	assert.Len(t, events, 2)
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// ErrArrayClosed is returned when writing to a [JSONArray] that is already closed.
+var ErrArrayClosed = errors.New("timeout: json array already closed")
+
+// truncatedSentinel is the last element of a JSON array ended by the middleware, see JSONArray.
+const truncatedSentinel = `{"truncated":true}`
+
+// JSONArray writes a JSON array to the response, one element at a time, so that a client consuming a large list
+// receives a valid document even if the handler times out. If the handler serving the array times out after it
+// wrote at least one element, the middleware sends the elements written so far instead of the timeout response,
+// closes the array, and appends the sentinel object {"truncated":true} as its last element. This works in
+// buffered and streaming mode (see [WithStreaming]); in streaming mode, the elements are written through to the
+// client as they are encoded. A handler that times out before writing any element gets the timeout response.
+//
+// Each element is written in a single write, so that the array is never cut in the middle of an element. A
+// JSONArray is not safe for concurrent use. Outside of the middleware, the array is written as is.
+type JSONArray struct {
+	w fox.ResponseWriter
+	// tw is the writer of the middleware, if any. The fields below are only accessed with its arbiter held.
+	tw     *timeoutWriter
+	n      int
+	closed bool
+}
+
+// NewJSONArray returns a JSONArray writing to the response of c. The Content-Type header is set to
+// "application/json" with the first element, unless already set.
+func NewJSONArray(c *fox.Context) *JSONArray {
+	ja := &JSONArray{w: c.Writer()}
+	ja.tw, _ = c.Writer().(*timeoutWriter)
+	return ja
+}
+
+// Encode writes the JSON encoding of v as the next element of the array. If the handler timed out, it returns
+// [http.ErrHandlerTimeout] and the element is not written.
+func (ja *JSONArray) Encode(v any) error {
+	elem, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ja.write(elem, false)
+}
+
+// Close ends the array. It writes an empty array if no element was written. Close must be called once the handler
+// is done with the array, otherwise the response is not valid JSON.
+func (ja *JSONArray) Close() error {
+	return ja.write(nil, true)
+}
+
+func (ja *JSONArray) write(elem []byte, end bool) error {
+	if ja.tw == nil {
+		return ja.writeTo(ja.w.Write, elem, end)
+	}
+	tw := ja.tw
+	if err := tw.arb.Acquire(); err != nil {
+		return tw.lateWrite(err)
+	}
+	defer tw.arb.Release()
+	// Unlike the buffered writes, the array is not written past the deadline, so that it is sure to be truncated.
+	if err := tw.expired(); err != nil {
+		return tw.lateWrite(err)
+	}
+	tw.array = ja
+	return ja.writeTo(tw.writeLocked, elem, end)
+}
+
+// writeTo writes elem, or the end of the array, with write.
+func (ja *JSONArray) writeTo(write func(p []byte) (int, error), elem []byte, end bool) error {
+	if ja.closed {
+		return ErrArrayClosed
+	}
+	if ja.n == 0 && ja.w.Header().Get("Content-Type") == "" {
+		ja.w.Header().Set("Content-Type", "application/json")
+	}
+	p := ja.next(elem, end)
+	if _, err := write(p); err != nil {
+		return err
+	}
+	if end {
+		ja.closed = true
+	} else {
+		ja.n++
+	}
+	return nil
+}
+
+// next returns the bytes to write for elem, or for the end of the array.
+func (ja *JSONArray) next(elem []byte, end bool) []byte {
+	p := make([]byte, 0, len(elem)+2)
+	switch {
+	case end && ja.n == 0:
+		return append(p, "[]"...)
+	case end:
+		return append(p, ']')
+	case ja.n == 0:
+		p = append(p, '[')
+	default:
+		p = append(p, ',')
+	}
+	return append(p, elem...)
+}
+
+// open reports whether the array has elements written and is not closed yet. The caller must hold the arbiter.
+func (ja *JSONArray) open() bool {
+	return ja.n > 0 && !ja.closed
+}
+
+// truncate ends the array of a handler that timed out with the truncation sentinel. Unless the response is already
+// under way in streaming mode, the elements buffered so far are sent first. The caller must hold the arbiter of
+// tw, which must be abandoned.
+func (ja *JSONArray) truncate(w fox.ResponseWriter, tw *timeoutWriter, req *http.Request) {
+	if !tw.sent {
+		(&partialFlush{}).flush(w, tw, req)
+	} else if tw.early && !tw.stream {
+		tw.writeBodyLocked(w)
+	}
+	_, _ = w.Write(ja.next([]byte(truncatedSentinel), false))
+	_, _ = w.Write(ja.next(nil, true))
+	if tw.stream {
+		_ = w.FlushError()
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONArray(t *testing.T) {
+	list := func(n int, wait bool) fox.HandlerFunc {
+		return func(c *fox.Context) {
+			ja := NewJSONArray(c)
+			for i := range n {
+				if err := ja.Encode(map[string]int{"id": i}); err != nil {
+					return
+				}
+			}
+			if wait {
+				<-c.Request().Context().Done()
+				assert.ErrorIs(t, ja.Encode(n), http.ErrHandlerTimeout)
+				return
+			}
+			assert.NoError(t, ja.Close())
+			assert.ErrorIs(t, ja.Close(), ErrArrayClosed)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		opts     []Option
+		handler  fox.HandlerFunc
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "completed",
+			handler:  list(2, false),
+			wantCode: http.StatusOK,
+			wantBody: `[{"id":0},{"id":1}]`,
+		},
+		{
+			name:     "empty",
+			handler:  list(0, false),
+			wantCode: http.StatusOK,
+			wantBody: `[]`,
+		},
+		{
+			name:     "truncated",
+			handler:  list(2, true),
+			wantCode: http.StatusOK,
+			wantBody: `[{"id":0},{"id":1},{"truncated":true}]`,
+		},
+		{
+			name:     "truncated streaming",
+			opts:     []Option{WithStreaming()},
+			handler:  list(2, true),
+			wantCode: http.StatusOK,
+			wantBody: `[{"id":0},{"id":1},{"truncated":true}]`,
+		},
+		{
+			name:     "timed out before the first element",
+			handler:  list(0, true),
+			wantCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond, tc.opts...)))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/items", tc.handler)
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/items")
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, tc.wantCode, resp.StatusCode)
+			if tc.wantBody != "" {
+				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+				assert.Equal(t, tc.wantBody, string(body))
+				assert.True(t, json.Valid(body))
+			}
+		})
+	}
+}
+
+func TestJSONArray_WithoutMiddleware(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := fox.NewTestContextOnly(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	ja := NewJSONArray(c)
+	require.NoError(t, ja.Encode("a"))
+	require.NoError(t, ja.Encode("b"))
+	require.NoError(t, ja.Close())
+	assert.Equal(t, `["a","b"]`, w.Body.String())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"cmp"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// LateWrite is a call site writing to the response after the handler timed out, as returned by
+// [Timeout.LateWrites]. Such a write is discarded, and typically reveals code ignoring the cancellation of the
+// request context.
+type LateWrite struct {
+	// Function is the fully qualified name of the function making the write.
+	Function string
+	// File and Line locate the write.
+	File string
+	Line int
+	// Requests is the number of requests whose handler wrote from this call site after timing out. Only the first
+	// late write of each request is attributed, so that a handler writing in a loop is counted once.
+	Requests uint64
+}
+
+type callSite struct {
+	file string
+	line int
+}
+
+type lateSite struct {
+	function string
+	requests atomic.Uint64
+}
+
+// TimeoutWriteError is the error returned by the writes to the response once the handler timed out, such as Write,
+// WriteString or FlushError, so that the error logging of the handler can tell what happened without plumbing the
+// route and its timeout down to the code making the write. It matches [http.ErrHandlerTimeout] with [errors.Is].
+type TimeoutWriteError struct {
+	// Pattern is the pattern of the route, and Timeout its handler timeout.
+	Pattern string
+	Timeout time.Duration
+	// Elapsed is the time elapsed from the start of the handler to the write.
+	Elapsed time.Duration
+	// Function is the fully qualified name of the function making the write, and File and Line locate the write, as
+	// in [LateWrite].
+	Function string
+	File     string
+	Line     int
+}
+
+// Error returns the error message.
+func (e *TimeoutWriteError) Error() string {
+	msg := http.ErrHandlerTimeout.Error() + ": write after " + e.Elapsed.String() + ", past the " + e.Timeout.String() +
+		" timeout of route " + e.Pattern
+	if e.Function != "" {
+		msg += ", from " + e.Function + " (" + e.File + ":" + strconv.Itoa(e.Line) + ")"
+	}
+	return msg
+}
+
+// Unwrap returns http.ErrHandlerTimeout.
+func (e *TimeoutWriteError) Unwrap() error {
+	return http.ErrHandlerTimeout
+}
+
+// writeError returns the error of a write made from frame after the handler of the route, started at start with the
+// timeout dt, timed out. It is http.ErrHandlerTimeout itself in compatibility mode, see CompatMode.
+func (t *Timeout) writeError(pattern string, start time.Time, dt time.Duration, frame runtime.Frame) error {
+	if t.cfg.bareWriteErr {
+		return http.ErrHandlerTimeout
+	}
+	return &TimeoutWriteError{
+		Pattern:  pattern,
+		Timeout:  dt,
+		Elapsed:  t.since(start),
+		Function: frame.Function,
+		File:     frame.File,
+		Line:     frame.Line,
+	}
+}
+
+// lateWrite attributes a write rejected with err to its call site, once per request, if the handler timed out. It
+// returns a [*TimeoutWriteError] if the handler timed out, or err unchanged otherwise.
+func (tw *timeoutWriter) lateWrite(err error) error {
+	if err != http.ErrHandlerTimeout {
+		return err
+	}
+	frame := relevantCaller()
+	if tw.sites != nil && tw.late.CompareAndSwap(false, true) {
+		if tw.labels != nil {
+			setOrphanedLabel(tw.labels)
+		}
+		key := callSite{file: frame.File, line: frame.Line}
+		site, ok := tw.sites.Load(key)
+		if !ok {
+			site, _ = tw.sites.LoadOrStore(key, &lateSite{function: frame.Function})
+		}
+		site.(*lateSite).requests.Add(1)
+	}
+	return tw.t.writeError(tw.pattern, tw.start, tw.dt, frame)
+}
+
+// LateWrites returns the call sites that wrote to the response after their handler timed out, most frequent first,
+// so that the code ignoring the cancellation of the request can be found and fixed.
+func (t *Timeout) LateWrites() []LateWrite {
+	var writes []LateWrite
+	t.lateSites.Range(func(key, value any) bool {
+		cs, site := key.(callSite), value.(*lateSite)
+		writes = append(writes, LateWrite{
+			Function: site.function,
+			File:     cs.file,
+			Line:     cs.line,
+			Requests: site.requests.Load(),
+		})
+		return true
+	})
+	slices.SortFunc(writes, func(a, b LateWrite) int {
+		return cmp.Or(
+			cmp.Compare(b.Requests, a.Requests),
+			cmp.Compare(a.File, b.File),
+			cmp.Compare(a.Line, b.Line),
+		)
+	})
+	return writes
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/fox-toolkit/timeout"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout_LateWrites(t *testing.T) {
+	tm := timeout.New(10 * time.Millisecond)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	done := make(chan struct{})
+	release := make(chan struct{})
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		defer func() { done <- struct{}{} }()
+		<-c.Request().Context().Done()
+		<-release
+		// Only the first late write of the request is attributed.
+		_ = c.String(http.StatusOK, "late")
+		_, _ = io.WriteString(c.Writer(), "later")
+	})
+	f.MustAdd(fox.MethodGet, "/bar", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, "ok")
+	})
+
+	for range 2 {
+		release = make(chan struct{})
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		close(release)
+		<-done
+	}
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bar", nil))
+
+	writes := tm.LateWrites()
+	require.Len(t, writes, 1)
+	assert.Equal(t, "latewrite_test.go", filepath.Base(writes[0].File))
+	assert.Contains(t, writes[0].Function, "TestTimeout_LateWrites")
+	assert.NotZero(t, writes[0].Line)
+	assert.Equal(t, uint64(2), writes[0].Requests)
+	assert.Equal(t, writes, tm.Snapshot().LateWrites)
+}
+
+func TestTimeoutWriteError(t *testing.T) {
+	errs := make(chan error, 1)
+	handler := func(c *fox.Context) {
+		<-c.Request().Context().Done()
+		time.Sleep(5 * time.Millisecond)
+		_, err := io.WriteString(c.Writer(), "late")
+		errs <- err
+	}
+	for _, enforcement := range []timeout.Enforcement{timeout.Buffered, timeout.WriteGated} {
+		t.Run(enforcement.String(), func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(timeout.Middleware(10*time.Millisecond, timeout.WithEnforcement(enforcement))))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/slow/{id}", handler)
+
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow/1", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+			err = <-errs
+			assert.ErrorIs(t, err, http.ErrHandlerTimeout)
+			var we *timeout.TimeoutWriteError
+			require.ErrorAs(t, err, &we)
+			assert.Equal(t, "/slow/{id}", we.Pattern)
+			assert.Equal(t, 10*time.Millisecond, we.Timeout)
+			assert.GreaterOrEqual(t, we.Elapsed, 10*time.Millisecond)
+			assert.Equal(t, "latewrite_test.go", filepath.Base(we.File))
+			assert.Contains(t, we.Function, "TestTimeoutWriteError")
+			assert.Contains(t, err.Error(), "past the 10ms timeout of route /slow/{id}")
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// Marshaler encodes the introspection outputs of the middleware, such as the one of [Timeout.StatsHandler]. See
+// [WithMarshaler].
+type Marshaler interface {
+	// Marshal returns the encoding of v.
+	Marshal(v any) ([]byte, error)
+	// ContentType returns the media type of the encoding, set as the Content-Type of the introspection responses.
+	ContentType() string
+}
+
+// NewMarshaler returns a [Marshaler] encoding with marshal, whose output has the given media type, e.g. to plug a
+// YAML or msgpack encoder without adding the dependency to this package:
+//
+//	timeout.NewMarshaler("application/yaml", yaml.Marshal)
+func NewMarshaler(contentType string, marshal func(v any) ([]byte, error)) Marshaler {
+	return &funcMarshaler{marshal: marshal, contentType: contentType}
+}
+
+// JSONMarshaler returns the [Marshaler] encoding in JSON with [json.Marshal], which is the default.
+func JSONMarshaler() Marshaler {
+	return NewMarshaler("application/json", json.Marshal)
+}
+
+type funcMarshaler struct {
+	marshal     func(v any) ([]byte, error)
+	contentType string
+}
+
+func (m *funcMarshaler) Marshal(v any) ([]byte, error) {
+	return m.marshal(v)
+}
+
+func (m *funcMarshaler) ContentType() string {
+	return m.contentType
+}
+
+// WithMarshaler sets the [Marshaler] encoding the introspection outputs served by the handlers of the middleware,
+// such as [Timeout.StatsHandler]. A nil marshaler restores the default, [JSONMarshaler].
+func WithMarshaler(m Marshaler) Option {
+	return optionFunc(func(c *config) {
+		c.marshaler = m
+	})
+}
+
+// WithFieldRedaction adds fields whose values must never appear in the introspection outputs served by the
+// handlers of the middleware, such as [Timeout.StatsHandler], e.g. because some deployments must not expose the
+// client IPs or the header values. A field is named by its JSON name, and is redacted at any depth of the output:
+// its value is replaced by [Redacted], whatever the [Marshaler]. Field names are case-sensitive.
+func WithFieldRedaction(fields ...string) Option {
+	return optionFunc(func(c *config) {
+		c.redactor.addFields(fields)
+	})
+}
+
+// render writes v as an introspection response, encoded with the Marshaler set with WithMarshaler once the fields
+// set with WithFieldRedaction are redacted.
+func (t *Timeout) render(c *fox.Context, v any) {
+	m := t.cfg.marshaler
+	if m == nil {
+		m = JSONMarshaler()
+	}
+	data, err := t.cfg.redactor.marshal(v, m)
+	if err != nil {
+		http.Error(c.Writer(), http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	c.Writer().Header().Set("Content-Type", m.ContentType())
+	c.Writer().Header().Set("Cache-Control", "no-store")
+	_, _ = c.Writer().Write(data)
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsHandler_Marshaler(t *testing.T) {
+	// A toy line-oriented encoding standing in for YAML or msgpack.
+	text := NewMarshaler("text/plain", func(v any) ([]byte, error) {
+		var sb strings.Builder
+		for pattern, rc := range v.(map[string]any) {
+			_, _ = fmt.Fprintf(&sb, "%s %v\n", pattern, rc.(map[string]any)["requests"])
+		}
+		return []byte(sb.String()), nil
+	})
+
+	cases := []struct {
+		name   string
+		opts   []Option
+		ctype  string
+		assert func(t *testing.T, body []byte)
+	}{
+		{
+			name:  "default json",
+			ctype: "application/json",
+			assert: func(t *testing.T, body []byte) {
+				var got map[string]RouteCounters
+				require.NoError(t, json.Unmarshal(body, &got))
+				assert.Equal(t, uint64(1), got["/foo"].Requests)
+			},
+		},
+		{
+			name:  "redacted fields",
+			opts:  []Option{WithFieldRedaction("maxLatency", "requests")},
+			ctype: "application/json",
+			assert: func(t *testing.T, body []byte) {
+				var got map[string]map[string]any
+				require.NoError(t, json.Unmarshal(body, &got))
+				assert.Equal(t, Redacted, got["/foo"]["requests"])
+				assert.Equal(t, Redacted, got["/foo"]["maxLatency"])
+				assert.Equal(t, float64(1), got["/foo"]["completed"])
+			},
+		},
+		{
+			name:  "custom marshaler",
+			opts:  []Option{WithMarshaler(text), WithFieldRedaction("requests")},
+			ctype: "text/plain",
+			assert: func(t *testing.T, body []byte) {
+				assert.Equal(t, "/foo "+Redacted+"\n", string(body))
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tm := New(time.Second, tc.opts...)
+			f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/foo", success201response)
+			f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+
+			w := httptest.NewRecorder()
+			tm.StatsHandler()(fox.NewTestContextOnly(w, httptest.NewRequest(http.MethodGet, "/debug/timeouts", nil)))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.ctype, w.Header().Get("Content-Type"))
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			tc.assert(t, w.Body.Bytes())
+		})
+	}
+}
+
+func TestStatsHandler_MarshalError(t *testing.T) {
+	tm := New(time.Second, WithMarshaler(NewMarshaler("application/x-broken", func(any) ([]byte, error) {
+		return nil, errors.New("broken")
+	})))
+	w := httptest.NewRecorder()
+	tm.StatsHandler()(fox.NewTestContextOnly(w, httptest.NewRequest(http.MethodGet, "/debug/timeouts", nil)))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
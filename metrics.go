@@ -0,0 +1,54 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"time"
+)
+
+// Observation describes a request served under a timeout, as passed to a [MetricsRecorder].
+type Observation struct {
+	// Pattern is the matched route pattern, if any, rewritten by the [WithRouteNormalizer] function.
+	Pattern string
+	// Method is the request method.
+	Method string
+	// Version is the application version set with [WithVersionLabel], if any.
+	Version string
+	// Timeout is the effective handler timeout.
+	Timeout time.Duration
+	// Elapsed is the time spent in the handler, under the timeout. For a request that timed out, it is the time
+	// elapsed until the timeout response was written.
+	Elapsed time.Duration
+	// Budget is the observe-only latency budget of the route set with [ObserveBudget], or zero if none.
+	Budget time.Duration
+	// Overshoot is, for a request that timed out, how long after the deadline the timeout response was actually
+	// written, or zero otherwise. A large overshoot indicates that the middleware itself is starved by scheduler
+	// delays or lock contention, see [Event].
+	Overshoot time.Duration
+	// Outcome is how the request ended. Requests whose handler panicked are not observed.
+	Outcome Outcome
+	// Clamp reports whether Timeout was clamped to the bounds set with [WithMinTimeout] and [WithMaxTimeout].
+	Clamp Clamp
+	// TraceID is the hex-encoded identifier of the OpenTelemetry trace of a request that timed out, if its context
+	// carries a valid span context, or empty otherwise. It is meant to be attached as an exemplar to the metrics, so
+	// that operators can jump from a latency spike straight to an affected trace.
+	TraceID string
+}
+
+// MetricsRecorder records an [Observation] for every request served under a timeout. Observe is called
+// synchronously on the request path, so implementations should be fast and must be safe for concurrent use. The
+// github.com/fox-toolkit/timeout/promtimeout module provides an implementation exporting Prometheus metrics, so that
+// the middleware itself does not depend on the Prometheus client.
+type MetricsRecorder interface {
+	Observe(o Observation)
+}
+
+// The MetricsRecorderFunc type is an adapter to allow the use of ordinary functions as [MetricsRecorder].
+type MetricsRecorderFunc func(o Observation)
+
+// Observe calls f(o).
+func (f MetricsRecorderFunc) Observe(o Observation) {
+	f(o)
+}
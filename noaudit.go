@@ -0,0 +1,11 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+//go:build !timeoutaudit
+
+package timeout
+
+import "time"
+
+func auditMonotonic(string, time.Time) {}
@@ -5,13 +5,139 @@
 package timeout
 
 import (
+	"context"
+	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/netip"
+	"runtime/debug"
+	"slices"
+	"time"
 
 	"github.com/fox-toolkit/fox"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const defaultFallbackBudget = 100 * time.Millisecond
+
+const defaultResponseBudget = 250 * time.Millisecond
+
+const defaultSafeWriteDeadline = 100 * time.Millisecond
+
+type unboundedThresholds struct {
+	bodySize int64
+	duration time.Duration
+}
+
+type warnThreshold struct {
+	fn        func(c *fox.Context, elapsed time.Duration)
+	threshold time.Duration
+}
+
+type replayLimits struct {
+	memLimit int64
+	maxSize  int64
+}
+
+type writeExtension struct {
+	estimate func(r *http.Request) time.Duration
+	limit    time.Duration
+}
+
 type config struct {
-	resp fox.HandlerFunc
+	resp           fox.HandlerFunc
+	sink           EventSink
+	redactor       *redactor
+	marshaler      Marshaler
+	normalize      func(pattern string) string
+	skipper        func(c *fox.Context) bool
+	onTimeout      func(c *fox.Context, info TimeoutInfo)
+	onCanceled     func(c *fox.Context, info TimeoutInfo)
+	canceledResp   fox.HandlerFunc
+	relax          *autoRelax
+	onLate         func(c *fox.Context, overrun time.Duration)
+	preCommit      func(c *fox.Context) error
+	flags          *flagTimeouts
+	resolver       func(c *fox.Context) time.Duration
+	metrics        MetricsRecorder
+	tracer         trace.Tracer
+	eventID        *eventIDGenerator
+	retention      time.Duration
+	logger         *slog.Logger
+	warn           *warnThreshold
+	gracePeriod    time.Duration
+	firstByte      time.Duration
+	profileDt      time.Duration
+	minTimeout     time.Duration
+	maxTimeout     time.Duration
+	parseDuration  DurationParser
+	contextFilter  func(key any) bool
+	headerTimeout  *headerTimeout
+	trusted        func(r *http.Request) bool
+	trustedNets    []netip.Prefix
+	ipResolver     fox.ClientIPResolver
+	retryAfter     func(c *fox.Context) time.Duration
+	statusCode     int
+	version        string
+	requestTimeout bool
+	readDeadline   time.Duration
+	writeDeadline  time.Duration
+	enforcement    Enforcement
+	idleWrite      time.Duration
+	bodyTimeout    time.Duration
+	pool           BufferPool
+	maxPooled      int
+	routeInFlight  bool
+	responseLimit  *responseLimit
+	spillDir       string
+	spillQuota     int64
+	adaptive       *adaptive
+	enricher       func(c *fox.Context) map[string]any
+	bypass         map[string]time.Duration
+	shedResp       fox.HandlerFunc
+	shedStatus     int
+	shedRetry      time.Duration
+	maxExtension   time.Duration
+	requestAge     *requestAge
+	admission      *admission
+	shutdown       *shutdown
+	methods        map[string]time.Duration
+	protocols      map[string]time.Duration
+	handlerScope   fox.HandlerScope
+	scopeTimeouts  []scopeTimeout
+	partial        *partialFlush
+	clock          Clock
+	hosts          *hostTimeouts
+	patterns       *patternTimeouts
+	breaker        *circuitBreaker
+	policy         *Policy
+	recorder       *FlightRecorder
+	unbounded      *unboundedThresholds
+	replay         *replayLimits
+	chaos          *chaos
+	compression    *compression
+	writeExtension *writeExtension
+	rfc            *rfcCompliance
+	debugger       *debuggerDetector
+	elapsedHeader  string
+	budgetTrailer  string
+	precision      time.Duration
+	maxHeaderBytes int
+	fallbackBudget time.Duration
+	respBudget     time.Duration
+	safeDeadline   time.Duration
+	continueTO     time.Duration
+	runtimeStats   bool
+	traceRegions   bool
+	profileLabels  bool
+	stackDump      bool
+	keepBody       bool
+	bareWriteErr   bool
+	bodyDrain      *bodyDrain
+	logOutcome     bool
+	streaming      bool
+	reentrant      bool
 }
 
 type Option interface {
@@ -26,7 +152,14 @@ func (f optionFunc) apply(c *config) {
 
 func defaultConfig() *config {
 	return &config{
-		resp: DefaultResponse,
+		resp:           DefaultResponse,
+		redactor:       newRedactor(),
+		fallbackBudget: defaultFallbackBudget,
+		respBudget:     defaultResponseBudget,
+		safeDeadline:   defaultSafeWriteDeadline,
+		pool:           defaultBufferPool,
+		shedRetry:      defaultShedRetryAfter,
+		clock:          realClock{},
 	}
 }
 
@@ -41,7 +174,1157 @@ func WithResponse(h fox.HandlerFunc) Option {
 	})
 }
 
-// DefaultResponse sends a default 503 Service Unavailable response.
+// WithErrorResponse is like [WithResponse], but h also receives the reason why the timeout response is sent: err
+// matches [context.DeadlineExceeded] if the handler exceeded its deadline, being [ErrTimeout] for the deadline
+// enforced by the middleware, and otherwise the error that vetoed the response of the handler (see [WithPreCommit]).
+// For a request rejected by the admission control, err is [ErrShed]. The requests canceled before the handler
+// completes, e.g. because the client went away, get the response set with [WithCanceledResponse] instead.
+func WithErrorResponse(h func(c *fox.Context, err error)) Option {
+	return optionFunc(func(c *config) {
+		if h != nil {
+			c.resp = func(c *fox.Context) {
+				h(c, responseErr(c))
+			}
+		}
+	})
+}
+
+// WithResponseFunc is like [WithResponse], but h also receives the details of the timeout. Only the Pattern,
+// Timeout, Elapsed and Overshoot fields of info are set, the outcome of the handler being unknown until the response
+// is written. Overshoot tells how far past the deadline the handler is, so that h can pick its message accordingly,
+// e.g. asking the client to retry right after the deadline, and reporting the request as abandoned once the grace
+// period set with [WithGracePeriod] is over. For a request rejected by the admission control, info is zero.
+func WithResponseFunc(h func(c *fox.Context, info TimeoutInfo)) Option {
+	return optionFunc(func(c *config) {
+		if h != nil {
+			c.resp = func(c *fox.Context) {
+				h(c, responseTimeoutInfo(c))
+			}
+		}
+	})
+}
+
+// WithNegotiatedResponse sets [NegotiatedResponse] as the timeout response, so that clients get the timeout
+// response in the format they accept.
+func WithNegotiatedResponse() Option {
+	return optionFunc(func(c *config) {
+		c.resp = NegotiatedResponse
+	})
+}
+
+// WithSafeResponse is like [WithResponse], but codifies the safe way of writing a custom timeout response: h runs
+// with a short write deadline, 100ms by default (see [WithSafeResponseDeadline]), so a slow client cannot hold the
+// middleware indefinitely, and a panic in h is recovered and logged, in which case [DefaultResponse] is sent
+// if h did not write anything yet. A [http.ErrAbortHandler] panic is propagated as is.
+func WithSafeResponse(h fox.HandlerFunc) Option {
+	return optionFunc(func(c *config) {
+		if h != nil {
+			c.resp = safeResponse(h, c)
+		}
+	})
+}
+
+// WithSafeResponseDeadline sets the write deadline of the timeout response handler set with [WithSafeResponse],
+// from the start of the response, and of the end of a streamed response past the handler deadline, see
+// [WithStreaming]. If not set, the response is given 100ms. A value <= 0 is ignored.
+func WithSafeResponseDeadline(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if d > 0 {
+			c.safeDeadline = d
+		}
+	})
+}
+
+func safeResponse(h fox.HandlerFunc, cfg *config) fox.HandlerFunc {
+	return func(c *fox.Context) {
+		// Errors are intentionally ignored: the underlying connection may not support deadlines.
+		_ = c.Writer().SetWriteDeadline(time.Now().Add(cfg.safeDeadline))
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				log.Printf("timeout: panic writing the timeout response for %s: %v\n%s", c.Path(), p, debug.Stack())
+				if !c.Writer().Written() {
+					DefaultResponse(c)
+				}
+			}
+		}()
+		h(c)
+	}
+}
+
+// WithStreaming enables the streaming mode, where the handler response is written through to the client as it is
+// produced instead of being buffered, and [fox.ResponseWriter.FlushError] is supported. This makes the middleware
+// usable for Server-Sent Events or long chunked downloads. The deadline is still enforced: once it is exceeded,
+// writes by the handler return [http.ErrHandlerTimeout], and the timeout response is sent if the handler did not
+// write anything yet. Otherwise, since the status and headers are already sent, the response is ended as is and
+// the client observes a truncated stream. The write deadline of the connection is capped to the handler deadline
+// before each write, so that a client not reading the response cannot block the handler, and the middleware with it,
+// past the deadline; the writes ending the response past the deadline get the deadline set with
+// [WithSafeResponseDeadline]. Options that act on the buffered response, such as [WithElapsedHeader],
+// [WithCompression] or [WithMaxHeaderBytes], have no effect in streaming mode. Individual
+// routes can enable or disable the streaming mode with the [OverrideStreaming] option.
+func WithStreaming() Option {
+	return optionFunc(func(c *config) {
+		c.streaming = true
+	})
+}
+
+// WithDebuggerDetection multiplies every timeout enforced by the middleware by 100 while a debugger is attached to
+// the process, so that stepping through a handler locally does not end up with a timeout response at every
+// breakpoint. A debugger is detected when the process is traced (on Linux only, e.g. with Delve), or when the
+// TIMEOUT_DEBUGGER environment variable is set to a non-empty value. The detection is refreshed every second, so
+// attaching or detaching a debugger is taken into account. This option is intended for development builds only.
+func WithDebuggerDetection() Option {
+	return optionFunc(func(c *config) {
+		c.debugger = newDebuggerDetector()
+	})
+}
+
+// WithRFCCompliance makes the timeout responses strictly compliant with the HTTP semantics, since several proxies
+// mishandle bare responses. Whichever handler writes the timeout response (see [WithResponse] and
+// [OverrideFallbackRoute]):
+//   - A 503 Service Unavailable or 429 Too Many Requests response always includes a Retry-After header, set to
+//     retryAfter rounded up to the second (at least one second) unless already set.
+//   - The body of the response to a HEAD request is omitted.
+//   - A response with a body always has a Content-Type header, sniffed from the body if not set.
+//   - Hop-by-hop headers, such as Keep-Alive or Upgrade, are removed, except for "Connection: close".
+func WithRFCCompliance(retryAfter time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.rfc = &rfcCompliance{retryAfter: formatRetryAfter(int64((retryAfter + time.Second - 1) / time.Second))}
+	})
+}
+
+// WithOnTimeout sets a hook called when a handler exceeds its deadline, once the timeout response is written. It
+// is called synchronously on the request path, before the event is sent to the [EventSink], so it should be fast.
+// The handler may still be running, and c must not be retained after the hook returns.
+func WithOnTimeout(fn func(c *fox.Context, info TimeoutInfo)) Option {
+	return optionFunc(func(c *config) {
+		c.onTimeout = fn
+	})
+}
+
+// WithOnLateCompletion sets a hook called when a handler that timed out eventually returns, with overrun the time it
+// ran past its deadline, so that leaked work, runaway queries and handlers that never observe the cancellation of
+// the request context can be detected. It is called on the goroutine of the handler, after the timeout response is
+// written, and c must not be retained after the hook returns. Handlers returning before the timeout response is
+// written are not reported. See also [Timeout.Orphaned].
+func WithOnLateCompletion(fn func(c *fox.Context, overrun time.Duration)) Option {
+	return optionFunc(func(c *config) {
+		c.onLate = fn
+	})
+}
+
+// WithPreCommit sets a hook called once a handler completes in time, before its buffered response is written to
+// the client, which can veto the response by returning a non-nil error, e.g. if a transaction the response depends
+// on failed to commit after the handler returned. The response of the handler is then discarded and the timeout
+// response is sent instead, with the error passed to the handler set with [WithErrorResponse], and the request is
+// reported as timed out, like with [ReportError]. The handler can no longer write to its response when the hook is
+// called. The hook has no effect in streaming mode (see [WithStreaming]), where the response is already under way.
+func WithPreCommit(fn func(c *fox.Context) error) Option {
+	return optionFunc(func(c *config) {
+		c.preCommit = fn
+	})
+}
+
+// WithSkipper sets a predicate that exempts requests from the timeout, such as health checks, internal probes or
+// specific clients, without registering route options. When fn returns true, the middleware behaves as a
+// passthrough for the request: the handler runs without timeout, and the read and write deadlines set with
+// [OverrideRead] and [OverrideWrite] are not applied. The function is called concurrently and must be safe for
+// concurrent use.
+func WithSkipper(fn func(c *fox.Context) bool) Option {
+	return optionFunc(func(c *config) {
+		c.skipper = fn
+	})
+}
+
+// WithPrecision rounds the effective handler deadline up to the next multiple of d. Aligning deadlines on a
+// common granularity (e.g. 10ms) lets the runtime coalesce timers firing at the same instant and keeps
+// the observed durations in stable buckets. Because the deadline is always rounded up, a request is never
+// cut off before its configured timeout, but it may run up to d longer. This matters for timeouts smaller
+// than d: with a 50µs timeout and a 10ms precision, the effective timeout is anywhere between 50µs and
+// 10ms. If d <= 0, deadlines are not rounded (default).
+func WithPrecision(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.precision = max(d, 0)
+	})
+}
+
+// WithBodyCancellation controls whether the request body is canceled when the handler times out (enabled by
+// default). Many handlers block in [http.Request.Body] reads and never observe the context cancellation. When
+// enabled, pending and subsequent reads from the body return an error as soon as the deadline fires, so orphaned
+// handlers are unblocked immediately. To unblock a pending read, the read deadline of the underlying connection is
+// expired, which usually prevents the connection from being reused. Disable it for handlers that intentionally
+// keep draining the body after a timeout.
+func WithBodyCancellation(enable bool) Option {
+	return optionFunc(func(c *config) {
+		c.keepBody = !enable
+	})
+}
+
+// WithBodyDrain discards the rest of the request body of a handler that timed out, up to limit bytes read within
+// timeout, so that the connection can be reused by the clients behind keep-alive pools instead of being closed. The
+// body is only drained if the handler is not blocked reading it, and if it ends within the limits, otherwise the read
+// deadline of the connection is expired as usual, see [WithBodyCancellation]. Since most clients send the whole body
+// before reading the response, the body is drained before the timeout response is sent, which it delays by up to
+// timeout. A limit or timeout <= 0 disables the drain.
+func WithBodyDrain(limit int64, timeout time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if limit <= 0 || timeout <= 0 {
+			c.bodyDrain = nil
+			return
+		}
+		c.bodyDrain = &bodyDrain{limit: limit, timeout: timeout}
+	})
+}
+
+// WithHostTimeouts sets the timeout for requests based on their host, for routers serving multiple virtual hosts
+// with different latency expectations. A host may be a wildcard such as "*.internal.example.com", which matches any
+// subdomain of "internal.example.com" but not "internal.example.com" itself. Exact hosts take precedence over
+// wildcards, and the most specific wildcard wins. Hosts are case-insensitive and the port is ignored. The host
+// timeout takes precedence over the global timeout, but not over the [OverrideHandler] route option.
+func WithHostTimeouts(hosts map[string]time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.hosts = newHostTimeouts(hosts)
+	})
+}
+
+// WithPatternTimeouts sets the timeout for routes based on their pattern, so that a whole resource hierarchy can be
+// given a timeout without annotating each route. A pattern ending with "/*", such as "/users/{id}/*", applies to
+// every route below it (e.g. "/users/{id}/orders/{oid}"), but not to "/users/{id}" itself. Other patterns apply to
+// the matching route only. Parameter names are ignored, so "/users/{uid}/*" applies to "/users/{id}/orders" as well.
+// Exact patterns take precedence over parent patterns, and the most specific parent pattern wins. The pattern
+// timeout takes precedence over the host and global timeouts, but not over the [OverrideHandler] route option,
+// and is ignored by [Outer].
+func WithPatternTimeouts(patterns map[string]time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.patterns = newPatternTimeouts(patterns)
+	})
+}
+
+// WithPolicy applies the rules of p, setting the handler timeouts, the connection deadlines and the status code of the
+// built-in timeout responses of the routes matching their pattern. The rules can be replaced at runtime with
+// [Policy.Set] or [Policy.Load], e.g. to tune the timeouts from the configuration management without annotating the
+// routes. See [Policy] for the precedence of the rules. A policy is ignored by [Outer], and a nil p disables it.
+func WithPolicy(p *Policy) Option {
+	return optionFunc(func(c *config) {
+		c.policy = p
+	})
+}
+
+// WithRouteTimeout sets the timeout of the routes matching pattern to d, following the same matching rules as
+// [WithPatternTimeouts], so that e.g. "/api/reports/*" can be given 30s and "/healthz" [NoTimeout] in one place,
+// without annotating each route. The option can be repeated to configure several patterns, and adds to the patterns
+// set with a previous [WithPatternTimeouts] option, which replaces them if applied afterwards. A later timeout for
+// the same pattern replaces the previous one. The most specific matching pattern wins, and the [OverrideHandler]
+// route option takes precedence.
+func WithRouteTimeout(pattern string, d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if c.patterns == nil {
+			c.patterns = newPatternTimeouts(nil)
+		}
+		c.patterns.set(pattern, d)
+	})
+}
+
+// WithMethodTimeout sets the timeout for the requests with the given method, e.g. so that POST and PUT requests get a
+// longer budget than GET requests across all routes. The option can be repeated to configure several methods. The
+// method timeout takes precedence over the host and global timeouts, but not over the route options, such as
+// [OverrideMethod] and [OverrideHandler], the pattern timeouts set with [WithPatternTimeouts] and the adapted
+// timeouts, and is consulted by [Outer] too.
+func WithMethodTimeout(method string, d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if c.methods == nil {
+			c.methods = make(map[string]time.Duration)
+		}
+		c.methods[method] = d
+	})
+}
+
+// WithProtocolTimeout sets the timeout for the requests with the given protocol, as in [http.Request.Proto] (e.g.
+// "HTTP/1.0" or "HTTP/2.0"), so that e.g. legacy HTTP/1.0 clients get a shorter budget. The option can be repeated to
+// configure several protocols. The protocol timeout only takes precedence over the global timeout, and is consulted
+// by [Outer] too.
+func WithProtocolTimeout(proto string, d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if c.protocols == nil {
+			c.protocols = make(map[string]time.Duration)
+		}
+		c.protocols[proto] = d
+	})
+}
+
+// WithHandlerScope restricts the middleware to the handlers of the given [fox.HandlerScope], such as
+// fox.RouteHandler, the handlers of the other scopes being called as they are. The middleware registered with
+// [fox.WithMiddleware] applies to the handlers of every scope, including the NotFound, MethodNotAllowed, redirect and
+// OPTIONS handlers of the router, which are then bounded by a deadline as well, see [WithHandlerScopeTimeout]. A zero
+// scope, which is the default, applies the middleware to the handlers of every scope it is registered for. Not to be
+// confused with [Scope], which groups routes under their own instance.
+func WithHandlerScope(scope fox.HandlerScope) Option {
+	return optionFunc(func(c *config) {
+		c.handlerScope = scope
+	})
+}
+
+// WithHandlerScopeTimeout sets the timeout for the handlers of the given [fox.HandlerScope], e.g.
+// fox.NoRouteHandler|fox.NoMethodHandler for custom NotFound and MethodNotAllowed handlers looking up suggestions
+// in a database. These handlers are not bound to a route, so the route options do not apply to them. The option can
+// be repeated, a later scope timeout taking precedence over an earlier one for the scopes they share. The scope
+// timeout takes precedence over the method, host and global timeouts, but not over the route options, such as
+// [OverrideHandler], the pattern timeouts set with [WithPatternTimeouts] and the adapted timeouts. Passing a value
+// <= 0 (or NoTimeout) disables the timeout for the handlers of the scope.
+func WithHandlerScopeTimeout(scope fox.HandlerScope, dt time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.scopeTimeouts = append(c.scopeTimeouts, scopeTimeout{scope: scope, dt: dt})
+	})
+}
+
+// WithFlagProvider sets the timeout of the routes from a feature flag system, so that they can be changed at runtime
+// (e.g. during an incident) without a deploy, and without the middleware depending on a specific SDK. The provider
+// is called with the route pattern, rewritten by the [WithRouteNormalizer] function, and the given flag name, and
+// returns the timeout of the route, or false to leave it to the other sources. Its result is cached by route for
+// ttl, or 10 seconds if ttl <= 0, so the provider is only called on the request path once in a while, and must be
+// safe for concurrent use. The flag timeout takes precedence over every source but the resolver (see
+// [WithTimeoutResolver]), including the [OverrideHandler] route option, and can only be shortened by the request
+// header (see [WithHeaderTimeout]).
+func WithFlagProvider(flag string, ttl time.Duration, provider func(route, flag string) (time.Duration, bool)) Option {
+	return optionFunc(func(c *config) {
+		if provider == nil {
+			c.flags = nil
+			return
+		}
+		if ttl <= 0 {
+			ttl = defaultFlagTTL
+		}
+		c.flags = &flagTimeouts{provider: provider, flag: flag, ttl: ttl}
+	})
+}
+
+// WithTimeoutResolver sets a function resolving the handler timeout of each request before its deadline is set, so
+// that the timeout can depend on the request itself, such as the tier of the tenant, the API key, the client IP or
+// a priority header, e.g. to honor per-customer SLAs. The resolver returns the timeout of the request, [NoTimeout] to
+// exempt it from the timeout, or a negative duration to leave it to the other sources. The resolved timeout takes
+// precedence over every source but the trusted callers (see [WithTrustedBypass]), including the [OverrideHandler]
+// route option, and can only be shortened by the request header (see [WithHeaderTimeout]). The resolver is called on
+// the request path, and must be fast and safe for concurrent use. A nil resolver disables it.
+func WithTimeoutResolver(resolver func(c *fox.Context) time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.resolver = resolver
+	})
+}
+
+// WithFallbackBudget sets the time limit of the fallback handler dispatched on timeout for routes configured with
+// [OverrideFallbackRoute]. If not set, the fallback handler is given 100ms. A value <= 0 is ignored.
+func WithFallbackBudget(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if d > 0 {
+			c.fallbackBudget = d
+		}
+	})
+}
+
+// WithResponseBudget sets the time limit of the handler writing the timeout response, such as the one set with
+// [WithResponse] or [OverrideResponse], so that a slow response handler, e.g. one rendering a template or calling a
+// translation service, cannot itself hang the request. The response handler runs on its own goroutine with a
+// buffered response and a context expiring after d, and [DefaultResponse] is sent instead if it does not return in
+// time. For the routes configured with [OverrideFallbackRoute], the budget set with [WithFallbackBudget] is added.
+// If not set, the response handler is given 250ms. A value <= 0 (or NoTimeout) runs the response handler on the
+// request goroutine, without time limit.
+func WithResponseBudget(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.respBudget = max(d, 0)
+	})
+}
+
+// WithBodyReplay records the request body as it is read by the handler of routes configured with
+// [OverrideFallbackRoute], so that the fallback handler dispatched on timeout can read the body again from the
+// start, instead of the unread remainder. The body is kept in memory up to memLimit bytes and spilled to a temporary
+// file beyond, up to maxSize bytes in total; the fallback handler of a request whose body exceeds maxSize gets the
+// remainder of the body as if replay was disabled. Once the fallback handler reads the body, reads by the timed out
+// handler fail. If the request already provides a [http.Request.GetBody] function, it is used instead and the body
+// is not recorded. A maxSize <= 0 disables the replay (default).
+func WithBodyReplay(memLimit, maxSize int64) Option {
+	return optionFunc(func(c *config) {
+		if maxSize <= 0 {
+			c.replay = nil
+			return
+		}
+		c.replay = &replayLimits{memLimit: max(memLimit, 0), maxSize: maxSize}
+	})
+}
+
+// WithElapsedHeader adds a response header with the given name (e.g. "X-Handler-Duration") to the responses of
+// handlers that complete within their deadline, reporting the time spent in the handler in milliseconds
+// (e.g. "12.345ms"). This gives clients cheap latency observability without full tracing. Since the header is
+// computed when the buffered response is committed, it is not added to responses of routes without a timeout.
+func WithElapsedHeader(name string) Option {
+	return optionFunc(func(c *config) {
+		c.elapsedHeader = name
+	})
+}
+
+// WithUnboundedRouteDetection enables the detection of risky routes running without timeout. The first time a
+// request is served without timeout (e.g. with [OverrideHandler] set to [NoTimeout]) while declaring a request body
+// larger than bodySize bytes, or lasting longer than duration, an [EventUnboundedRoute] is emitted for its route,
+// nudging teams toward adding a budget to it. Only one event is emitted per route. A threshold <= 0 is disabled.
+// This option has no effect without an [EventSink].
+func WithUnboundedRouteDetection(bodySize int64, duration time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if bodySize <= 0 && duration <= 0 {
+			c.unbounded = nil
+			return
+		}
+		c.unbounded = &unboundedThresholds{bodySize: bodySize, duration: duration}
+	})
+}
+
+// Reentrant allows the middleware to nest when a handler running under it redispatches the request through the
+// same middleware, for example with [fox.Router.ServeHTTP], or with [fox.Route.HandleMiddleware] when the
+// middleware is registered for the route. By default, a redispatched request reuses the
+// deadline and the buffered writer already in place instead of adding a second buffered layer with a fresh
+// deadline. Nesting is rarely desirable, but may be useful when the redispatched route must get its own budget.
+func Reentrant() Option {
+	return optionFunc(func(c *config) {
+		c.reentrant = true
+	})
+}
+
+// WithBudgetTrailer appends a trailer with the given name (e.g. "X-Budget-Remaining") to the responses of handlers
+// that complete within their deadline, reporting the budget left when the response was written, in milliseconds
+// (e.g. "12.345ms"). Load testing tools and clients can use it to adaptively pace subsequent requests. In streaming
+// mode, see [WithStreaming], the trailer is declared along with the headers and reports the budget left when the
+// handler returned. The trailer is omitted for HTTP/1.0 requests, and when the handler sets the Content-Length
+// header, since trailers require a chunked response.
+func WithBudgetTrailer(name string) Option {
+	return optionFunc(func(c *config) {
+		c.budgetTrailer = name
+	})
+}
+
+// WithFlightRecorder records the start, completion and timeout of the requests served under a timeout in the given
+// [FlightRecorder], so that the requests in flight can be reconstructed after a crash of the process.
+func WithFlightRecorder(r *FlightRecorder) Option {
+	return optionFunc(func(c *config) {
+		c.recorder = r
+	})
+}
+
+// WithCompression compresses the buffered response body with gzip before writing it to the client, when the client
+// accepts it. Since the whole body is already in memory, compressing it at once is cheap and improves egress
+// efficiency. The body is only compressed if it is at least minSize bytes, and if at least minBudget is left before
+// the handler deadline, so that compressing never pushes a response past its deadline. Responses that already have
+// a Content-Encoding header, set by the handler or by a compression middleware running before, are left as is, as
+// are the bodies already gzip encoded by the handler, partial responses and responses without a body. The strong
+// ETag of a compressed response is weakened, since the bytes sent differ from the ones it was computed over.
+// Compression is disabled by default.
+func WithCompression(minSize int, minBudget time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.compression = &compression{minSize: max(minSize, 0), minBudget: max(minBudget, 0)}
+	})
+}
+
+// WithMaxHeaderBytes limits the size of the header block of the handler responses to n bytes, protecting the
+// client and the proxies in between from pathological handlers generating enormous headers. Since the response
+// is buffered, the limit is checked before anything is written: a response whose header block exceeds n is
+// replaced by a 500 Internal Server Error response, and an [EventHeaderTooLarge] is emitted. The size of a header
+// block is the sum of the size of each header line, including the name, the value and the separators. The limit
+// does not apply to the timeout response. If n <= 0, the size is not limited (default).
+func WithMaxHeaderBytes(n int) Option {
+	return optionFunc(func(c *config) {
+		c.maxHeaderBytes = max(n, 0)
+	})
+}
+
+// WithChaos enables a chaos testing mode, intended for staging environments, where each request served under a
+// timeout is disturbed with the given probability (between 0 and 1): once the handler returns, its completion is
+// delayed by extraDelay, which may push it past its deadline. If extraDelay <= 0, the completion is delayed until
+// after the deadline, forcing an artificial timeout. This lets teams verify that their clients and dashboards
+// handle timeout responses correctly before a real incident. The handler still runs to completion, only the
+// response is affected. Chaos is disabled by default, or if probability <= 0.
+func WithChaos(probability float64, extraDelay time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if probability <= 0 {
+			c.chaos = nil
+			return
+		}
+		c.chaos = &chaos{probability: min(probability, 1), extraDelay: extraDelay}
+	})
+}
+
+// WithWriteDeadlineExtension is an experimental option that extends the write deadline set with the [OverrideWrite]
+// route option for distant or slow clients, reducing spurious write deadline failures for mobile users, while the
+// handler timeout is kept unchanged. The estimate function is called once per request to estimate how much extra
+// time the client needs (e.g. from its round-trip time as measured by the TLS terminating proxy and forwarded in a
+// header), and the extension is capped to limit. Neither the standard library nor fox expose connection timings,
+// so the estimation is left to the caller. The function is called concurrently and must be safe for concurrent use.
+// This option is likely to change.
+func WithWriteDeadlineExtension(estimate func(r *http.Request) time.Duration, limit time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if estimate == nil || limit <= 0 {
+			c.writeExtension = nil
+			return
+		}
+		c.writeExtension = &writeExtension{estimate: estimate, limit: limit}
+	})
+}
+
+// WithEventSink registers an [EventSink] that receives the events emitted by the middleware, such as
+// [EventTimeout]. By default, no event is emitted.
+func WithEventSink(sink EventSink) Option {
+	return optionFunc(func(c *config) {
+		c.sink = sink
+	})
+}
+
+// WithMetrics sets the [MetricsRecorder] that receives an [Observation] for every request served under a timeout,
+// whether it completed or timed out, so operators can see which routes are close to their limit before clients
+// start receiving timeout responses. Requests served without timeout are not observed.
+func WithMetrics(m MetricsRecorder) Option {
+	return optionFunc(func(c *config) {
+		c.metrics = m
+	})
+}
+
+// WithTracing creates, for every request served under a timeout, an OpenTelemetry span with the given provider,
+// parent of the spans of the handler. The span records the configured timeout and the elapsed time as
+// [AttrTimeoutConfigured] and [AttrTimeoutElapsed], and the outcome of the request as [AttrTimeoutOutcome]. When
+// the handler exceeds its deadline, a "timeout" event is added and the span status is set to error with
+// [http.ErrHandlerTimeout]. When the handler panics, a "panic" event is added before the panic is propagated.
+func WithTracing(tp trace.TracerProvider) Option {
+	return optionFunc(func(c *config) {
+		c.tracer = tp.Tracer(tracerName)
+	})
+}
+
+// WithEventID assigns a unique identifier, generated by gen, to every timeout. The identifier is set in the given
+// response header of the timeout response, and reported in [Event.ID] and [TimeoutInfo.ID], so the server-side
+// diagnostics of a timeout response seen by a client can be found. If gen is nil, [NewEventID] is used. If header is
+// empty, the identifier is not sent to the client. In streaming mode, the identifier is not sent either if the
+// response is already under way when the deadline expires. The function is called concurrently and must be safe for
+// concurrent use.
+func WithEventID(header string, gen func() string) Option {
+	return optionFunc(func(c *config) {
+		if gen == nil {
+			gen = NewEventID
+		}
+		c.eventID = &eventIDGenerator{generate: gen, header: header}
+	})
+}
+
+// WithReportRetention enables the recording of the history summarized by [Timeout.Report], over the given
+// retention, e.g. 7 * 24 * time.Hour for weekly SLA reviews. The history is kept by route pattern in 60 time slots,
+// so the memory used does not depend on the request rate. A zero or negative retention disables the recording,
+// which is the default.
+func WithReportRetention(retention time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.retention = max(retention, 0)
+	})
+}
+
+// WithLogger sets the logger used to log a warning with the method, path, route pattern, timeout and elapsed time
+// of every request whose handler exceeds its deadline, along with the event identifier if configured with
+// [WithEventID]. Requests canceled by the client are logged with the "canceled" attribute set.
+func WithLogger(logger *slog.Logger) Option {
+	return optionFunc(func(c *config) {
+		c.logger = logger
+	})
+}
+
+// WithWarnThreshold sets a callback called when a handler completes within its deadline, but after running for at
+// least d, so that routes slowly degrading can be detected before they start to time out. The threshold is
+// typically set to a fraction of the timeout, e.g. 80% of it. The callback is called synchronously on the request
+// path once the response is written, with the time spent in the handler. A zero or negative d disables the
+// callback.
+func WithWarnThreshold(d time.Duration, fn func(c *fox.Context, elapsed time.Duration)) Option {
+	return optionFunc(func(c *config) {
+		if d <= 0 || fn == nil {
+			c.warn = nil
+			return
+		}
+		c.warn = &warnThreshold{threshold: d, fn: fn}
+	})
+}
+
+// WithGracePeriod sets how long the middleware waits, once the deadline of a handler expires, for the handler to
+// complete before abandoning it. If the handler completes within the grace period, its response is sent instead of
+// the timeout response, so work finishing a few milliseconds late is not wasted. The handler context is still
+// canceled at the deadline. The grace period does not apply to the requests canceled by the client, nor in streaming
+// mode (see [WithStreaming]). A zero or negative d disables the grace period, which is the default.
+func WithGracePeriod(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.gracePeriod = max(d, 0)
+	})
+}
+
+// WithFirstByteTimeout fails the request with the timeout response if the handler has not started its response
+// within d, i.e. written its status, headers or any of its body, even if its deadline is further away. This
+// catches the handlers stuck before producing anything, e.g. waiting on a lock or a dead upstream, much sooner than
+// the handler timeout, which can then be set generously for the handlers streaming or computing a large response.
+// Once the handler started its response, only the handler timeout applies. The handler context is canceled at the
+// first-byte deadline, with a [DeadlineError] as its cause. A d that is zero, negative, or not lower than the
+// handler timeout disables the first-byte timeout, which is the default.
+func WithFirstByteTimeout(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.firstByte = max(d, 0)
+	})
+}
+
+// WithMinTimeout raises the handler timeouts shorter than d to d, once resolved from all sources (the global
+// timeout, the route options, the resolver, the client header, ...), so that a misconfigured route or a client
+// cannot set a timeout too short to serve any request. The requests served without timeout are not affected. The
+// clamp is reported in [Observation.Clamp], [TimeoutInfo.Clamp] and [Event.Clamp], and as the [AttrTimeoutClamped]
+// span attribute. A zero or negative d disables the minimum, which is the default.
+func WithMinTimeout(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.minTimeout = max(d, 0)
+	})
+}
+
+// WithMaxTimeout lowers the handler timeouts longer than d to d, once resolved from all sources, so that a
+// misconfigured route cannot hold resources for hours. The requests served without timeout, e.g. with
+// [OverrideHandler] set to [NoTimeout], are not affected. The clamp is reported as for [WithMinTimeout], which takes
+// precedence if the minimum is greater than d. A zero or negative d disables the maximum, which is the default.
+func WithMaxTimeout(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.maxTimeout = max(d, 0)
+	})
+}
+
+// WithTrustedBypass sets the predicate telling the requests from trusted callers, such as internal services
+// authenticated by their mTLS identity, coming from an internal network or carrying a signed header. On the routes
+// designated with [OverrideTrusted], the trusted requests get the timeout of the route option instead, which takes
+// precedence over any other timeout, so that e.g. an admin backfill can hit the same endpoints as the users without
+// their timeout. The predicate is only called for the designated routes, and must be safe for concurrent use. See
+// [WithTrustedNetworks] to trust the callers by client IP. A nil trusted disables the exemption by predicate.
+func WithTrustedBypass(trusted func(r *http.Request) bool) Option {
+	return optionFunc(func(c *config) {
+		c.trusted = trusted
+	})
+}
+
+// WithTrustedNetworks designates the requests whose client IP belongs to one of the given networks as coming from
+// trusted callers, as [WithTrustedBypass] does with a predicate, e.g. for internal services calling from a private
+// network. The client IP is resolved with the resolver set with [WithClientIPResolver], or else with the
+// [fox.ClientIPResolver] of the router, so that the requests forwarded by a proxy are told apart by the address of
+// their client rather than the one of the proxy. A request is trusted if either the predicate or the networks say
+// so. No networks disables the exemption by network.
+func WithTrustedNetworks(prefixes ...netip.Prefix) Option {
+	return optionFunc(func(c *config) {
+		c.trustedNets = slices.Clone(prefixes)
+	})
+}
+
+// WithClientIPResolver sets the resolver of the client IP reported in [Event.ClientIP] and in the logs, and checked
+// against the networks set with [WithTrustedNetworks], in place of the [fox.ClientIPResolver] configured for the
+// router, e.g. when the middleware serves routes behind a different proxy than the rest of the router. A nil resolver
+// restores the resolver of the router.
+func WithClientIPResolver(resolver fox.ClientIPResolver) Option {
+	return optionFunc(func(c *config) {
+		c.ipResolver = resolver
+	})
+}
+
+// WithContextFilter restricts the values of the request context propagated to the handler context to the keys for
+// which keep returns true, e.g. to prevent internal credentials from flowing into code paths that may outlive the
+// request. The deadline and cancellation of the request context still propagate, and so do the values set by this
+// middleware, such as the ones read by [Deadline] or [EntryTime]. The keys of values set by the standard library or
+// other middleware are typically unexported, so keep usually compares the key with a set of known keys. The function
+// is called on every value lookup of the handler, and must be fast and safe for concurrent use.
+func WithContextFilter(keep func(key any) bool) Option {
+	return optionFunc(func(c *config) {
+		c.contextFilter = keep
+	})
+}
+
+// WithHeaderTimeout derives the handler timeout from the given request header, set by the client or a gateway that
+// already knows its own deadline, so that no work is done past the point where the client gives up. The header value
+// is a timeout as a Go duration (e.g. "1.5s") or a number of seconds (e.g. "2.5"), and is clamped between minDt and
+// maxDt. If the header is grpc-timeout, the grpc-timeout format (e.g. "100m" for 100 milliseconds, or "2S" for 2
+// seconds) is accepted too, and takes precedence, so that "5m" is 5 milliseconds there, but 5 minutes in any other
+// header. The header timeout is applied once the timeout of the request is resolved from the other sources, and only
+// if it is shorter, so that a client can shorten the timeout of a route, e.g. one set with [OverrideHandler], but never
+// extend it. It also applies to the routes without timeout. Invalid, zero or negative values are ignored. The formats
+// accepted can be changed with [WithDurationParser].
+func WithHeaderTimeout(name string, minDt, maxDt time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if name == "" {
+			c.headerTimeout = nil
+			return
+		}
+		c.headerTimeout = &headerTimeout{name: name, min: max(minDt, 0), max: max(maxDt, minDt, 0)}
+	})
+}
+
+// WithStatusCode sets the status code of the built-in timeout responses, [DefaultResponse], [JSONResponse] and
+// [HTMLResponse], e.g. http.StatusGatewayTimeout. The default is http.StatusServiceUnavailable. It has no effect on
+// the custom responses set with [WithResponse] or [WithSafeResponse]. Codes outside of the 4xx and 5xx ranges are
+// ignored.
+func WithStatusCode(code int) Option {
+	return optionFunc(func(c *config) {
+		if code >= 400 && code <= 599 {
+			c.statusCode = code
+		}
+	})
+}
+
+// WithRetryAfter sets the Retry-After header of the timeout responses to d, rounded up to the second, so that clients
+// know when to retry. It applies to any timeout response, including custom ones, unless they override the header. A
+// zero or negative d disables the header. See [WithRetryAfterFunc] to compute the delay per request.
+func WithRetryAfter(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if d <= 0 {
+			c.retryAfter = nil
+			return
+		}
+		c.retryAfter = func(*fox.Context) time.Duration { return d }
+	})
+}
+
+// WithRetryAfterFunc is like [WithRetryAfter], but the delay is computed by fn for each timeout response, e.g. based
+// on the current load or the route. No header is set when fn returns zero or a negative delay.
+func WithRetryAfterFunc(fn func(c *fox.Context) time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.retryAfter = fn
+	})
+}
+
+// WithAutoRequestTimeout makes the middleware respond with 408 Request Timeout, instead of the response of the
+// handler, when the read deadline set with [OverrideRead] expires before any byte of the request body arrives, since
+// many handlers do not handle the resulting i/o timeout error gracefully. The connection is closed after the
+// response. It only applies to the requests served under a timeout, whose response is buffered, and not if the
+// handler already started to stream its response (see [WithStreaming]).
+func WithAutoRequestTimeout() Option {
+	return optionFunc(func(c *config) {
+		c.requestTimeout = true
+	})
+}
+
+// WithContinueTimeout bounds how long the middleware waits for the client to start sending the body of a request
+// expecting a 100 Continue interim response ("Expect: 100-continue"), once the interim response is sent: the read
+// deadline of the connection is set to d from then until the first byte of the body arrives, after which the read
+// deadline set with [OverrideRead] or [WithReadDeadline], if any, is restored. The interim response is sent by the
+// server on the first read of the body, or explicitly by the handler with WriteHeader(http.StatusContinue), which
+// the middleware sends through to the client instead of buffering it. Reading the body then fails with an error
+// matching [os.ErrDeadlineExceeded], see also [WithAutoRequestTimeout]. It only applies to the requests served under
+// a timeout, whose response is buffered. A value <= 0 disables the bound, which is the default.
+func WithContinueTimeout(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.continueTO = max(d, 0)
+	})
+}
+
+// WithVersionLabel sets the application version, e.g. a release tag or commit hash, reported with the telemetry
+// of the middleware: [Event.Version], [Observation.Version] and the logs of [WithLogger]. This allows comparing
+// deployments directly from the telemetry of the middleware, e.g. to find out whether a release made a route slower.
+func WithVersionLabel(v string) Option {
+	return optionFunc(func(c *config) {
+		c.version = v
+	})
+}
+
+// WithReadDeadline sets the read deadline of the underlying connection to d for all the routes served by the
+// middleware, as [OverrideRead] does for a single route. The [OverrideRead] route option takes precedence over this
+// default. A zero or negative d leaves the read deadline untouched.
+func WithReadDeadline(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.readDeadline = max(d, 0)
+	})
+}
+
+// WithWriteDeadline sets the write deadline of the underlying connection to d for all the routes served by the
+// middleware, as [OverrideWrite] does for a single route. The [OverrideWrite] route option takes precedence over
+// this default. A zero or negative d leaves the write deadline untouched.
+func WithWriteDeadline(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.writeDeadline = max(d, 0)
+	})
+}
+
+// WithStreamingBypass serves the requests whose content type is a key of types, such as the streaming calls of RPC
+// protocols, without handler timeout: their response is written through to the client, and bounded only by a sliding
+// write deadline, advanced to the idle duration from now each time the handler writes, as with [OverrideWriteIdle].
+// This lets long-lived streams run for as long as they make progress, while the other requests keep the strict
+// handler timeout. A zero idle duration disables the write deadline for the content type. The content types are
+// matched against the media type of the request, ignoring its parameters. The option may be applied multiple times,
+// adding to the content types already registered. See [WithConnectStreaming] for the Connect and gRPC protocols.
+func WithStreamingBypass(types map[string]time.Duration) Option {
+	return optionFunc(func(c *config) {
+		for ct, idle := range types {
+			if c.bypass == nil {
+				c.bypass = make(map[string]time.Duration)
+			}
+			c.bypass[normalizeMediaType(ct)] = max(idle, 0)
+		}
+	})
+}
+
+// WithConnectStreaming registers the [ConnectStreamingTypes] with [WithStreamingBypass] and the given idle duration,
+// so that the streaming calls of the Connect, gRPC and gRPC-Web protocols are passed through, while the unary calls
+// of the Connect protocol keep the strict handler timeout. Since the unary calls of the gRPC and gRPC-Web protocols
+// cannot be told apart from their streaming calls, they are passed through too. The deadline they carry in the
+// grpc-timeout header is then left to the RPC framework.
+func WithConnectStreaming(idle time.Duration) Option {
+	types := make(map[string]time.Duration, len(ConnectStreamingTypes))
+	for _, ct := range ConnectStreamingTypes {
+		types[ct] = idle
+	}
+	return WithStreamingBypass(types)
+}
+
+// WithEnricher sets a function returning business dimensions of a request, such as the user ID, feature flags or
+// experiment arm, attached to the telemetry of the request: [Event.Fields], [TimeoutInfo.Fields] and the "fields"
+// group of the logs of [WithLogger]. This allows slicing the timeouts by business dimensions. The function is only
+// called when the request is reported, at most once per request, and is called concurrently, so it must be safe for
+// concurrent use. The returned map must not be modified afterward.
+func WithEnricher(fn func(c *fox.Context) map[string]any) Option {
+	return optionFunc(func(c *config) {
+		c.enricher = fn
+	})
+}
+
+// WithAdaptive derives the timeout of each route from its recent latency: the timeout is the given percentile of the
+// time spent in the handler (e.g. 0.99 for the p99), times multiplier, bounded by floor and ceiling. The latency is
+// tracked per route pattern, rewritten by the [WithRouteNormalizer] function, with a histogram in which the older
+// requests weigh exponentially less than the recent ones. Until a route served enough requests, the host or global
+// timeout applies. The timeouts set with [OverrideHandler] or [WithPatternTimeouts] take precedence over the adapted
+// timeout, which [WithHeaderTimeout] can only shorten, see [ResolveExplain]. Since the requests that timed out are
+// observed too, the timeout of a route getting slower grows up to the ceiling. It does not apply to [Outer]. A
+// percentile outside of (0, 1] or a non-positive multiplier disables the adaptive mode, and a zero or negative
+// ceiling leaves the timeout unbounded.
+func WithAdaptive(percentile, multiplier float64, floor, ceiling time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if percentile <= 0 || percentile > 1 || multiplier <= 0 {
+			c.adaptive = nil
+			return
+		}
+		floor = max(floor, 0)
+		if ceiling <= 0 {
+			ceiling = math.MaxInt64
+		}
+		c.adaptive = &adaptive{
+			percentile: percentile,
+			multiplier: multiplier,
+			floor:      floor,
+			ceiling:    max(ceiling, floor),
+		}
+	})
+}
+
+// WithMaxResponseBuffer limits the size of the buffered handler responses to n bytes, so that a single huge response
+// cannot exhaust the memory of the process. The strategy controls what happens once a handler writes past the limit:
+// [OverflowReject] returns an error to the handler, [OverflowSpill] moves the response to a temporary file and
+// [OverflowStream] sends the response through to the client. It has no effect on the streamed responses (see
+// [WithStreaming]). A zero or negative n disables the limit, which is the default.
+func WithMaxResponseBuffer(n int, strategy OverflowStrategy) Option {
+	return optionFunc(func(c *config) {
+		if n <= 0 {
+			c.responseLimit = nil
+			return
+		}
+		c.responseLimit = &responseLimit{size: n, strategy: strategy}
+	})
+}
+
+// WithSpillDir sets the directory of the temporary files holding the responses spilled with [OverflowSpill], e.g. a
+// tmpfs mount, instead of the default directory for temporary files (see [os.TempDir]).
+func WithSpillDir(dir string) Option {
+	return optionFunc(func(c *config) {
+		c.spillDir = dir
+	})
+}
+
+// WithSpillQuota limits the disk space used altogether by the responses of the middleware spilled with
+// [OverflowSpill] to n bytes, so that slow clients or huge responses cannot fill the disk. Once the quota is
+// reached, the responses that would exceed it are sent through to the client instead, as with [OverflowStream],
+// which releases their disk space. See [OverrideSpillQuota] for a quota per route. A zero or negative n disables
+// the quota, which is the default.
+func WithSpillQuota(n int64) Option {
+	return optionFunc(func(c *config) {
+		c.spillQuota = max(n, 0)
+	})
+}
+
+// WithInFlightByRoute tracks the number of requests being served, and its high-water mark, for each route, as
+// reported in [RouteCounters.InFlight] and [RouteCounters.PeakInFlight]. The total for the middleware is always
+// tracked, see [Timeout.InFlight].
+func WithInFlightByRoute() Option {
+	return optionFunc(func(c *config) {
+		c.routeInFlight = true
+	})
+}
+
+// WithBufferPool sets the pool of the buffers holding the handler responses, e.g. to share a pool with other
+// components or to instrument it. By default, the middleware share a pool created with [NewBufferPool]. A nil pool
+// restores the default.
+func WithBufferPool(p BufferPool) Option {
+	return optionFunc(func(c *config) {
+		if p == nil {
+			p = defaultBufferPool
+		}
+		c.pool = p
+	})
+}
+
+// WithMaxPooledBufferSize drops the response buffers whose capacity exceeds n bytes instead of returning them to the
+// pool, so that a handler writing a huge response does not leave an oversized buffer in the pool forever. A zero or
+// negative n disables the limit, which is the default.
+func WithMaxPooledBufferSize(n int) Option {
+	return optionFunc(func(c *config) {
+		c.maxPooled = max(n, 0)
+	})
+}
+
+// WithBodyTimeout bounds the time taken to read the entire request body to d for all the routes served by the
+// middleware, as [OverrideBody] does for a single route. The duration is measured from the first read of the body,
+// independently of the handler timeout, and once it is exceeded, reads return a [*BodyTimeoutError]. A read blocked
+// on a slow client is unblocked by setting the read deadline of the underlying connection, which replaces the one set
+// with [WithReadDeadline] or [OverrideRead]. A zero or negative d disables the body timeout.
+func WithBodyTimeout(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.bodyTimeout = max(d, 0)
+	})
+}
+
+// WithIdleWriteTimeout sets a sliding write deadline for the underlying connection for all the routes served by the
+// middleware, as [OverrideWriteIdle] does for a single route: the deadline is advanced to d from now each time the
+// handler writes, so that only clients that stall for longer than d are cut off. It takes precedence over the absolute
+// write deadline set with [WithWriteDeadline] or [OverrideWrite]. The [OverrideWriteIdle] route option takes
+// precedence over this default. A zero or negative d disables the sliding write deadline.
+func WithIdleWriteTimeout(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.idleWrite = max(d, 0)
+	})
+}
+
+// WithEnforcement sets the mechanism used to enforce the handler timeout, [Buffered] by default. With
+// [ConnDeadline], the timeout response, the hooks of the handler context such as [Checkpoint] or [ResolveExplain],
+// and the options acting on the buffered response, such as [WithStreaming] or [WithCompression], do not apply, but
+// the telemetry of the middleware still reports the requests that exceeded their deadline as timed out. With
+// [WriteGated], the hooks of the handler context still apply, except [Extend], but the options acting on the buffered
+// response, such as [WithStreaming], [WithMaxResponseBuffer] or [WithCompression], do not. With [Soft], nothing is
+// enforced, as with [ConnDeadline] but without the write deadline, and the requests exceeding their deadline are only
+// reported as timed out by the telemetry.
+func WithEnforcement(mode Enforcement) Option {
+	return optionFunc(func(c *config) {
+		c.enforcement = mode
+	})
+}
+
+// WithShedResponse sets the handler writing the response of the requests rejected by the admission control before
+// their handler runs, e.g. because the server is overloaded. By default, the timeout response handler is used, with
+// the status code set with [WithShedStatusCode], so that the built-in responses tell clients to back off rather than
+// report a slow handler. A nil handler restores the default.
+func WithShedResponse(h fox.HandlerFunc) Option {
+	return optionFunc(func(c *config) {
+		c.shedResp = h
+	})
+}
+
+// WithShedStatusCode sets the status code of the built-in responses for the requests rejected by the admission
+// control, 429 Too Many Requests by default. A code that is not a 4xx or 5xx status code is ignored.
+func WithShedStatusCode(code int) Option {
+	return optionFunc(func(c *config) {
+		if code >= 400 && code <= 599 {
+			c.shedStatus = code
+		}
+	})
+}
+
+// WithShedRetryAfter sets the Retry-After header of the responses for the requests rejected by the admission control
+// to d, rounded up to the second, one second by default. A zero or negative d disables the header.
+func WithShedRetryAfter(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.shedRetry = d
+	})
+}
+
+// WithMaxExtension allows handlers to push their deadline out with [Extend], by up to d in total per request. By
+// default, or if d <= 0, the deadline cannot be extended. Enabling extensions makes the middleware use a slightly
+// more expensive context for the handler.
+func WithMaxExtension(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.maxExtension = max(d, 0)
+	})
+}
+
+// WithMaxRequestAge rejects the requests that are already older than max when they reach the middleware, as told
+// by the request start timestamp an upstream proxy sets in the header name (e.g. "X-Request-Start"), since their
+// client has most likely given up by then. This saves the work of their handler during queue backlogs. The requests
+// are rejected with the shed response, see [WithShedResponse], and counted in [RouteCounters.Shed]. The timestamp is
+// either a number of seconds since the epoch with a fractional part, optionally prefixed with "t=" (e.g.
+// "t=1700000000.123"), or an integer number of seconds, milliseconds, microseconds or nanoseconds since the epoch.
+// Requests without or with an invalid timestamp are served as usual. Note that the clocks of the proxy and the server
+// must be synchronized. A max <= 0 disables the check.
+func WithMaxRequestAge(name string, max time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if name == "" || max <= 0 {
+			c.requestAge = nil
+			return
+		}
+		c.requestAge = &requestAge{name: name, max: max}
+	})
+}
+
+// WithAdmissionQueue limits the number of requests served concurrently under a timeout to limit, queuing up to size
+// requests beyond it until a slot is released, according to policy (see [QueueFIFO] and [QueueCoDel]). Requests
+// arriving while the queue is full, waiting for too long, or whose client goes away while queued, are rejected with
+// the shed response, see [WithShedResponse], and counted in [RouteCounters.Shed] and, by reason, in
+// [Timeout.ShedCounts]. The requests served without a timeout (e.g. upgraded or streaming ones, or routes with
+// [NoTimeout]) are not subject to the admission queue. A limit <= 0 disables the queue, and a size <= 0 rejects the
+// requests beyond the limit right away. It replaces the limit set with [WithMaxConcurrent].
+func WithAdmissionQueue(limit, size int, policy QueuePolicy) Option {
+	return optionFunc(func(c *config) {
+		if limit <= 0 {
+			c.admission = nil
+			return
+		}
+		if policy != QueueCoDel {
+			policy = QueueFIFO
+		}
+		c.admission = &admission{limit: limit, size: max(size, 0), policy: policy}
+	})
+}
+
+// WithMaxConcurrent limits the number of requests served concurrently under a timeout by the middleware to n. The
+// requests beyond it wait in order of arrival for up to queueTimeout for a slot to be released, and are then
+// rejected with the shed response (see [WithShedResponse]), with a 429 status code by default (see
+// [WithShedStatusCode]), and counted in [RouteCounters.Shed] and in [ShedCounters.QueueTimeout], or in
+// [ShedCounters.QueueFull] if queueTimeout <= 0, which rejects them right away. The time spent waiting does not
+// count against the handler timeout, which starts once the request is admitted. See [OverrideMaxConcurrent] to limit a
+// single route, and [WithAdmissionQueue] for a bounded queue with an adaptive policy, which this option replaces. A
+// n <= 0 disables the limit.
+func WithMaxConcurrent(n int, queueTimeout time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.admission = newMaxConcurrent(n, queueTimeout)
+	})
+}
+
+// WithShutdownContext helps the server drain on graceful shutdown, as signaled by ctx being done (e.g. the context
+// passed to [http.Server.Shutdown], or a context canceled on SIGTERM). From then on, the handlers still running are
+// timed out at the drain deadline, drain from the shutdown, if their own deadline is later, and their timeout
+// response closes the connection. The requests arriving meanwhile whose timeout would run past the drain deadline
+// are rejected right away with a 503 status code and a "Connection: close" header, so that the client retries on
+// another instance, and counted in [RouteCounters.Shed] and in [ShedCounters.Draining]. The requests served without a
+// timeout are not affected. A nil ctx disables the drain.
+func WithShutdownContext(ctx context.Context, drain time.Duration) Option {
+	return optionFunc(func(c *config) {
+		if ctx == nil {
+			c.shutdown = nil
+			return
+		}
+		c.shutdown = &shutdown{ctx: ctx, drain: max(drain, 0)}
+	})
+}
+
+// WithFlushPartial sends the status, headers and partial body buffered by a handler that exceeded its deadline,
+// instead of replacing them with the timeout response, so that e.g. a proxy-style handler delivers the part of the
+// upstream payload already transferred rather than discarding it. The Content-Length header set by the handler is
+// dropped, since the body is truncated. If trailer is not empty (e.g. "X-Truncated"), a trailer with this name and
+// the value "truncated" is appended to the response, so that clients can tell it apart from a complete one; it is
+// omitted for HTTP/1.0 requests, which do not support trailers. Handlers that did not write any body yet, and
+// requests canceled by the client, get the timeout response as usual. This option has no effect in streaming mode,
+// where the response is already under way.
+func WithFlushPartial(trailer string) Option {
+	return optionFunc(func(c *config) {
+		c.partial = &partialFlush{trailer: trailer}
+	})
+}
+
+// WithClock sets the source of time of the middleware, so that the timeout behavior of an application can be tested
+// deterministically with a fake clock instead of real sleeps, see timeouttest.NewClock. The clock drives the handler
+// deadline and the request context, the grace period, the elapsed times reported to the telemetry, and the helpers
+// such as [Checkpoint], [Remaining] and [Extend]. It does not apply to the read and write deadlines of the
+// connection, which the server enforces with the wall clock, nor to the [ConnDeadline] enforcement. A nil clock
+// restores the wall clock.
+func WithClock(clock Clock) Option {
+	return optionFunc(func(c *config) {
+		if clock == nil {
+			clock = realClock{}
+		}
+		c.clock = clock
+	})
+}
+
+// WithRedaction adds request headers and query parameters whose values must never appear in the diagnostic
+// outputs of the middleware, such as the [Event] passed to an [EventSink]. Redacted values are replaced by
+// [Redacted]. The "Authorization", "Proxy-Authorization" and "Cookie" headers as well as the "access_token" query
+// parameter are always redacted. Header names are case-insensitive, query parameter names are case-sensitive.
+func WithRedaction(headers []string, queryParams []string) Option {
+	return optionFunc(func(c *config) {
+		c.redactor.add(headers, queryParams)
+	})
+}
+
+// WithRouteNormalizer registers a function that rewrites the route pattern before it is used as a label or a key
+// in the telemetry produced by the middleware, such as [Event.Pattern]. This allows collapsing high-cardinality
+// patterns (e.g. versions or tenants embedded in the path) to protect metrics backends from cardinality explosion.
+// The function is called concurrently and must be safe for concurrent use.
+func WithRouteNormalizer(fn func(pattern string) string) Option {
+	return optionFunc(func(c *config) {
+		c.normalize = fn
+	})
+}
+
+// WithRuntimeStats attaches a snapshot of the Go runtime statistics (goroutine count, heap in use, GC pauses) to
+// every emitted [Event]. This helps to distinguish a slow downstream dependency from a process under GC pressure
+// without a separate metrics query. Note that taking the snapshot briefly stops the world, so it is best suited
+// for rare events such as timeouts. This option has no effect without an [EventSink].
+func WithRuntimeStats() Option {
+	return optionFunc(func(c *config) {
+		c.runtimeStats = true
+	})
+}
+
+// WithTraceRegions wraps the handler execution in a [runtime/trace] task and region named after the route
+// pattern, so an execution trace captured during an incident (e.g. with "go tool trace") shows exactly which
+// routes were executing and for how long. Timeouts are also logged on the task. The overhead is negligible when
+// no trace is being recorded.
+func WithTraceRegions() Option {
+	return optionFunc(func(c *config) {
+		c.traceRegions = true
+	})
+}
+
+// WithProfileLabels sets profiler labels on the goroutines running the handlers, so that a goroutine dump taken
+// during an incident (e.g. from net/http/pprof with debug=1) separates live work from abandoned work: the label
+// "timeout.pattern" holds the route pattern, "timeout.method" the request method, "timeout.timeout" the timeout
+// applied to the handler, and "timeout.deadline" its deadline, so that the goroutines still running past their
+// deadline stand out. The labels are also carried by the samples of the CPU profile, so that the time spent in the
+// handlers can be attributed to their routes (e.g. with "go tool pprof -tagfocus"). Since the labels of a goroutine
+// can only be changed by the goroutine itself, a handler that timed out is only labeled "timeout.orphaned=true" once
+// it writes to its response. The handlers still running after timing out are also counted by the [OrphanProfile]
+// profile. Setting the labels costs a few allocations per request.
+func WithProfileLabels() Option {
+	return optionFunc(func(c *config) {
+		c.profileLabels = true
+	})
+}
+
+// WithStackDump captures the stack trace of the handler goroutines still running after timing out, so that a
+// timeout can be diagnosed from where the handler was blocked (e.g. on a database call, a lock or an external HTTP
+// request) without reproducing it with a profiler attached. The stack trace is taken once the timeout response is
+// written, and reported in [TimeoutInfo.Stack] and [Event.Stack]. It is not taken for the requests canceled by the
+// client, nor for the handlers that returned meanwhile. Taking it requires dumping the stack traces of all
+// goroutines, which stops the world for a time proportional to their number, so it is best suited to services
+// where timeouts are rare. The routes with the [Strict] level capture it even without this option.
+func WithStackDump() Option {
+	return optionFunc(func(c *config) {
+		c.stackDump = true
+	})
+}
+
+// WithLogOutcome records the [Outcome] of each request served under a timeout in the request context, where it can
+// be read with [OutcomeFromContext] by the middleware running before, such as [fox.Logger] configured with
+// [LogHandler]. To that end, the request carrying the outcome is left in the [fox.Context] once served.
+func WithLogOutcome() Option {
+	return optionFunc(func(c *config) {
+		c.logOutcome = true
+	})
+}
+
+// DefaultResponse sends a default 503 Service Unavailable response, or the status code set with [WithStatusCode].
 func DefaultResponse(c *fox.Context) {
-	http.Error(c.Writer(), http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	code := responseStatus(c)
+	http.Error(c.Writer(), http.StatusText(code), code)
 }
@@ -0,0 +1,38 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import "sync/atomic"
+
+const (
+	handlerRunning uint32 = iota
+	handlerDone
+	handlerOrphaned
+)
+
+// orphan tracks whether a handler that timed out is still running once the timeout response is written, see
+// WithOnLateCompletion. The handler goroutine and the middleware race to settle it, so that exactly one of them
+// accounts for the orphaned handler.
+type orphan struct {
+	state atomic.Uint32
+}
+
+// finish is called by the handler goroutine once the handler returned, and reports whether it was orphaned.
+func (o *orphan) finish() bool {
+	return !o.state.CompareAndSwap(handlerRunning, handlerDone)
+}
+
+// abandon is called by the middleware once the timeout response is written, and reports whether the handler is
+// still running.
+func (o *orphan) abandon() bool {
+	return o.state.CompareAndSwap(handlerRunning, handlerOrphaned)
+}
+
+// Orphaned returns the number of handlers still running after timing out, and the highest number of such handlers
+// since the middleware was created. A steadily growing number reveals leaked work, such as runaway queries or
+// handlers that never observe the cancellation of the request context.
+func (t *Timeout) Orphaned() (current, peak int64) {
+	return t.orphaned.cur.Load(), t.orphaned.peak.Load()
+}
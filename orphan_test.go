@@ -0,0 +1,70 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithOnLateCompletion(t *testing.T) {
+	type late struct {
+		pattern string
+		overrun time.Duration
+	}
+	lates := make(chan late, 1)
+	tm := New(10*time.Millisecond, WithOnLateCompletion(func(c *fox.Context, overrun time.Duration) {
+		lates <- late{pattern: c.Pattern(), overrun: overrun}
+	}))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	release := make(chan struct{})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+		<-release
+		time.Sleep(20 * time.Millisecond)
+	})
+	f.MustAdd(fox.MethodGet, "/fast", func(c *fox.Context) {
+		c.Writer().WriteHeader(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	current, peak := tm.Orphaned()
+	assert.Equal(t, int64(1), current)
+	assert.Equal(t, int64(1), peak)
+
+	close(release)
+	l := <-lates
+	assert.Equal(t, "/slow", l.pattern)
+	assert.GreaterOrEqual(t, l.overrun, 20*time.Millisecond)
+	s := tm.Snapshot()
+	assert.Equal(t, int64(0), s.Orphaned)
+	assert.Equal(t, int64(1), s.PeakOrphaned)
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	select {
+	case l := <-lates:
+		t.Fatalf("unexpected late completion of %s", l.pattern)
+	default:
+	}
+}
+
+func TestOrphan(t *testing.T) {
+	var o orphan
+	assert.True(t, o.abandon())
+	assert.True(t, o.finish())
+
+	o = orphan{}
+	assert.False(t, o.finish())
+	assert.False(t, o.abandon())
+}
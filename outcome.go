@@ -0,0 +1,89 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogOutcomeKey is the key used by [LogHandler] for the outcome of the request. The associated [slog.Value] is a
+// string.
+const LogOutcomeKey = "timeout"
+
+type outcomeKey struct{}
+
+// Outcome is how a request served under a timeout ended.
+type Outcome uint8
+
+const (
+	// OutcomeCompleted means that the handler completed within its deadline.
+	OutcomeCompleted Outcome = iota + 1
+	// OutcomeTimedOut means that the handler exceeded its deadline.
+	OutcomeTimedOut
+	// OutcomePanicked means that the handler panicked within its deadline.
+	OutcomePanicked
+	// OutcomeClientGone means that the request was canceled, typically because the client went away, before the
+	// handler completed.
+	OutcomeClientGone
+)
+
+// String returns the name of the outcome.
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeCompleted:
+		return "completed"
+	case OutcomeTimedOut:
+		return "timed_out"
+	case OutcomePanicked:
+		return "panicked"
+	case OutcomeClientGone:
+		return "client_gone"
+	default:
+		return "unknown"
+	}
+}
+
+// OutcomeFromContext returns the outcome of the request carrying ctx, as recorded by a middleware configured with
+// [WithLogOutcome]. It reports false if the outcome is not known, e.g. if the request was served without timeout.
+func OutcomeFromContext(ctx context.Context) (Outcome, bool) {
+	if o, ok := ctx.Value(outcomeKey{}).(*Outcome); ok && *o != 0 {
+		return *o, true
+	}
+	return 0, false
+}
+
+// LogHandler returns a [slog.Handler] that adds the outcome of the request, as returned by [OutcomeFromContext], to
+// the records logged with the request context, under the [LogOutcomeKey] key. It is designed to wrap the handler
+// of the [fox.Logger] middleware, which logs with the request context once the request is served, so the outcome
+// is attached to the access log record without double bookkeeping:
+//
+//	fox.WithMiddleware(
+//		fox.Logger(timeout.LogHandler(handler)),
+//		timeout.Middleware(2*time.Second, timeout.WithLogOutcome()),
+//	)
+func LogHandler(h slog.Handler) slog.Handler {
+	return &logHandler{Handler: h}
+}
+
+type logHandler struct {
+	slog.Handler
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	if o, ok := OutcomeFromContext(ctx); ok {
+		r = r.Clone()
+		r.AddAttrs(slog.String(LogOutcomeKey, o.String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogHandler(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	f, err := fox.NewRouter(fox.WithMiddleware(
+		fox.Logger(LogHandler(slog.NewJSONHandler(buf, nil))),
+		fox.RecoveryWithFunc(slog.DiscardHandler, func(c *fox.Context, err any) {}),
+		Middleware(time.Second, WithLogOutcome()),
+	))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/ok", func(c *fox.Context) {})
+	f.MustAdd(fox.MethodGet, "/slow", success201response, OverrideHandler(time.Millisecond))
+	f.MustAdd(fox.MethodGet, "/panic", panicResponse)
+	f.MustAdd(fox.MethodGet, "/gone", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/none", func(c *fox.Context) {}, OverrideHandler(NoTimeout))
+
+	cases := []struct {
+		path    string
+		outcome string
+	}{
+		{path: "/ok", outcome: "completed"},
+		{path: "/slow", outcome: "timed_out"},
+		{path: "/panic", outcome: "panicked"},
+		{path: "/gone", outcome: "client_gone"},
+		{path: "/none"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			buf.Reset()
+			ctx, cancel := context.WithCancel(context.Background())
+			if tc.path == "/gone" {
+				time.AfterFunc(time.Millisecond, cancel)
+			}
+			defer cancel()
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, tc.path, nil)
+			f.ServeHTTP(httptest.NewRecorder(), req)
+
+			var record map[string]any
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+			if tc.outcome == "" {
+				assert.NotContains(t, record, LogOutcomeKey)
+				return
+			}
+			assert.Equal(t, tc.outcome, record[LogOutcomeKey])
+		})
+	}
+}
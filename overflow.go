@@ -0,0 +1,237 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrResponseTooLarge is returned to a handler writing a buffered response larger than the limit set with
+// [WithMaxResponseBuffer] and the [OverflowReject] strategy.
+var ErrResponseTooLarge = errors.New("timeout: response exceeds the buffer limit")
+
+// OverflowStrategy controls what happens when a handler writes a buffered response larger than the limit set with
+// [WithMaxResponseBuffer].
+type OverflowStrategy uint8
+
+const (
+	// OverflowReject fails the write exceeding the limit with [ErrResponseTooLarge], leaving the handler to deal with
+	// the error. The response buffered so far is kept.
+	OverflowReject OverflowStrategy = iota + 1
+	// OverflowSpill moves the buffered response to a temporary file, which then receives the rest of the response, so
+	// that the response can still be replaced by the timeout response. The file is encrypted with a key generated
+	// for the lifetime of the process and never written to disk, so that the response never hits the disk in
+	// plaintext. On the systems that allow it, the file is unlinked as soon as it is created, so that it does not
+	// outlive the process even if it crashes. Otherwise, it is removed once the request is served. Spilled responses
+	// are not compressed (see [WithCompression]).
+	OverflowSpill
+	// OverflowStream sends the buffered response to the client and switches to the streaming mode for the rest of the
+	// response, as if [WithStreaming] was enabled for the route. If the deadline is exceeded afterward, the response
+	// is ended as is.
+	OverflowStream
+)
+
+// String returns the name of the overflow strategy.
+func (s OverflowStrategy) String() string {
+	switch s {
+	case OverflowReject:
+		return "reject"
+	case OverflowSpill:
+		return "spill"
+	case OverflowStream:
+		return "stream"
+	default:
+		return "unknown"
+	}
+}
+
+type responseLimit struct {
+	size     int
+	strategy OverflowStrategy
+}
+
+// spillCipher returns the block cipher encrypting the spilled responses, keyed with an ephemeral key generated once
+// per process, so that the files left over by a crashed process cannot be decrypted.
+var spillCipher = sync.OnceValue(func() cipher.Block {
+	key := make([]byte, 32)
+	// rand.Read never fails.
+	_, _ = rand.Read(key)
+	// aes.NewCipher only fails on invalid key sizes.
+	block, _ := aes.NewCipher(key)
+	return block
+})
+
+// spillFile is a temporary file holding a buffered response that exceeded the limit, see OverflowSpill. The content
+// is encrypted with AES-CTR under the process key and a random IV per file.
+type spillFile struct {
+	f  *os.File
+	w  io.Writer
+	iv []byte
+	// unlinked reports whether the file was unlinked right after its creation.
+	unlinked bool
+	size     int64
+}
+
+func newSpillFile(dir string, p []byte) (*spillFile, error) {
+	f, err := os.CreateTemp(dir, "fox-timeout-*")
+	if err != nil {
+		return nil, fmt.Errorf("timeout: spill response: %w", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	_, _ = rand.Read(iv)
+	sf := &spillFile{
+		f:  f,
+		w:  cipher.StreamWriter{S: cipher.NewCTR(spillCipher(), iv), W: f},
+		iv: iv,
+		// Some systems, such as Windows, do not allow an open file to be removed.
+		unlinked: os.Remove(f.Name()) == nil,
+	}
+	if _, err := sf.Write(p); err != nil {
+		sf.remove()
+		return nil, fmt.Errorf("timeout: spill response: %w", err)
+	}
+	return sf, nil
+}
+
+func (f *spillFile) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// writeTo copies the decrypted content of the file to w.
+func (f *spillFile) writeTo(w io.Writer) error {
+	if _, err := f.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	bufPtr := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufPtr)
+	r := cipher.StreamReader{S: cipher.NewCTR(spillCipher(), f.iv), R: f.f}
+	_, err := io.CopyBuffer(onlyWrite{w}, r, *bufPtr)
+	return err
+}
+
+// remove closes and removes the file.
+func (f *spillFile) remove() {
+	_ = f.f.Close()
+	if !f.unlinked {
+		_ = os.Remove(f.f.Name())
+	}
+}
+
+// spillQuota reserves the disk space of a spilled response against the quotas of the middleware and of its route,
+// see WithSpillQuota and OverrideSpillQuota. A nil spillQuota reserves without limit.
+type spillQuota struct {
+	global      *atomic.Int64
+	globalLimit int64
+	route       *atomic.Int64
+	routeLimit  int64
+	// reserved is the space reserved for the response so far.
+	reserved int64
+}
+
+// reserve reserves n more bytes, and reports whether the quotas allow it.
+func (q *spillQuota) reserve(n int64) bool {
+	if q == nil {
+		return true
+	}
+	if q.globalLimit > 0 && q.global.Add(n) > q.globalLimit {
+		q.global.Add(-n)
+		return false
+	}
+	if q.routeLimit > 0 && q.route.Add(n) > q.routeLimit {
+		q.route.Add(-n)
+		if q.globalLimit > 0 {
+			q.global.Add(-n)
+		}
+		return false
+	}
+	q.reserved += n
+	return true
+}
+
+// release releases the space reserved for the response.
+func (q *spillQuota) release() {
+	if q == nil || q.reserved == 0 {
+		return
+	}
+	if q.globalLimit > 0 {
+		q.global.Add(-q.reserved)
+	}
+	if q.routeLimit > 0 {
+		q.route.Add(-q.reserved)
+	}
+	q.reserved = 0
+}
+
+// overflowLocked applies the overflow strategy if writing n more bytes to the buffered response exceeds the limit.
+// A spilled response exceeding its quota is sent through to the client, as with OverflowStream.
+func (tw *timeoutWriter) overflowLocked(n int) error {
+	if tw.spill != nil {
+		if tw.quota.reserve(int64(n)) {
+			return nil
+		}
+		return tw.streamLocked()
+	}
+	if tw.limit == nil || tw.buf.Len()+n <= tw.limit.size {
+		return nil
+	}
+	switch tw.limit.strategy {
+	case OverflowSpill:
+		if !tw.quota.reserve(int64(tw.buf.Len() + n)) {
+			return tw.streamLocked()
+		}
+		f, err := newSpillFile(tw.spillDir, tw.buf.Bytes())
+		if err != nil {
+			tw.quota.release()
+			return err
+		}
+		tw.spill = f
+		tw.buf.Reset()
+	case OverflowStream:
+		return tw.streamLocked()
+	default:
+		return ErrResponseTooLarge
+	}
+	return nil
+}
+
+// streamLocked sends the buffered or spilled response to the client, and switches to the streaming mode for the
+// rest of the response.
+func (tw *timeoutWriter) streamLocked() error {
+	if err := tw.expired(); err != nil {
+		return err
+	}
+	tw.stream = true
+	tw.sendHeaderLocked()
+	if tw.spill != nil {
+		err := tw.spill.writeTo(tw.w)
+		tw.spill.remove()
+		tw.spill = nil
+		tw.quota.release()
+		return err
+	}
+	if _, err := tw.w.Write(tw.buf.Bytes()); err != nil {
+		return err
+	}
+	tw.buf.Reset()
+	return nil
+}
+
+// bufferLocked returns the writer receiving the buffered response.
+func (tw *timeoutWriter) bufferLocked() io.Writer {
+	if tw.spill != nil {
+		return tw.spill
+	}
+	return tw.buf
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithMaxResponseBuffer(t *testing.T) {
+	chunk := bytes.Repeat([]byte("x"), 10)
+
+	t.Run("reject", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithMaxResponseBuffer(16, OverflowReject))))
+		require.NoError(t, err)
+		errs := make(chan error, 1)
+		f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+			_, _ = c.Writer().Write(chunk)
+			_, err := c.Writer().Write(chunk)
+			errs <- err
+		})
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.ErrorIs(t, <-errs, ErrResponseTooLarge)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, chunk, w.Body.Bytes())
+	})
+
+	t.Run("spill", func(t *testing.T) {
+		dir := t.TempDir()
+		t.Setenv("TMPDIR", dir)
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(100*time.Millisecond, WithMaxResponseBuffer(16, OverflowSpill))))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+			for range 3 {
+				_, _ = c.Writer().Write(chunk)
+			}
+			_, _ = c.Writer().WriteString("end")
+		})
+		f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+			for range 3 {
+				_, _ = c.Writer().Write(chunk)
+			}
+			<-c.Request().Context().Done()
+		})
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, append(bytes.Repeat(chunk, 3), "end"...), w.Body.Bytes())
+		assert.Equal(t, strconv.Itoa(33), w.Header().Get("Content-Length"))
+
+		w = httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Millisecond, WithMaxResponseBuffer(16, OverflowStream))))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+			c.SetHeader("X-Foo", "bar")
+			c.Writer().WriteHeader(http.StatusAccepted)
+			for range 2 {
+				_, _ = c.Writer().Write(chunk)
+			}
+			<-c.Request().Context().Done()
+			_, _ = c.Writer().Write(chunk)
+		})
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		// The response is under way when the deadline expires, so it is ended as is.
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "bar", w.Header().Get("X-Foo"))
+		assert.Equal(t, bytes.Repeat(chunk, 2), w.Body.Bytes())
+	})
+}
+
+func TestMiddleware_WithSpillQuota(t *testing.T) {
+	chunk := bytes.Repeat([]byte("a"), 10)
+	tm := New(100*time.Millisecond, WithMaxResponseBuffer(16, OverflowSpill), WithSpillDir(t.TempDir()), WithSpillQuota(25))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	spilled := make(chan int64, 2)
+	handler := func(c *fox.Context) {
+		for range 2 {
+			_, _ = c.Writer().Write(chunk)
+		}
+		spilled <- tm.spilled.Load()
+		_, _ = c.Writer().Write(chunk)
+		spilled <- tm.spilled.Load()
+		_, _ = c.Writer().WriteString("end")
+	}
+	f.MustAdd(fox.MethodGet, "/global", handler)
+	f.MustAdd(fox.MethodGet, "/route", handler, OverrideSpillQuota(15))
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/global", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, append(bytes.Repeat(chunk, 3), "end"...), w.Body.Bytes())
+	assert.Empty(t, w.Header().Get("Content-Length"))
+	assert.Equal(t, int64(20), <-spilled)
+	assert.Zero(t, <-spilled)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/route", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, append(bytes.Repeat(chunk, 3), "end"...), w.Body.Bytes())
+	assert.Zero(t, <-spilled)
+	assert.Zero(t, <-spilled)
+	assert.Zero(t, tm.routeCounters("/route").spilled.Load())
+
+	t.Run("missing directory", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(
+			100*time.Millisecond,
+			WithMaxResponseBuffer(16, OverflowSpill),
+			WithSpillDir(filepath.Join(t.TempDir(), "missing")),
+		)))
+		require.NoError(t, err)
+		errs := make(chan error, 1)
+		f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+			_, _ = c.Writer().Write(chunk)
+			_, err := c.Writer().Write(chunk)
+			errs <- err
+		})
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.ErrorIs(t, <-errs, os.ErrNotExist)
+	})
+}
+
+func TestSpillFile(t *testing.T) {
+	plain := bytes.Repeat([]byte("secret"), 100)
+	f, err := newSpillFile(t.TempDir(), plain[:300])
+	require.NoError(t, err)
+	_, err = f.Write(plain[300:])
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(plain)), f.size)
+
+	raw := make([]byte, len(plain))
+	_, err = f.f.ReadAt(raw, 0)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "secret")
+	if runtime.GOOS != "windows" {
+		assert.True(t, f.unlinked)
+		_, err = os.Stat(f.f.Name())
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+
+	for range 2 {
+		var w bytes.Buffer
+		require.NoError(t, f.writeTo(&w))
+		assert.Equal(t, plain, w.Bytes())
+	}
+	f.remove()
+}
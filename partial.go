@@ -0,0 +1,43 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"maps"
+	"net/http"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// partialFlush sends the response buffered by a handler that timed out instead of the timeout response, see
+// WithFlushPartial.
+type partialFlush struct {
+	trailer string
+}
+
+// flush sends the status, headers and partial body buffered by tw to w. The caller must hold the arbiter of tw.
+func (pf *partialFlush) flush(w fox.ResponseWriter, tw *timeoutWriter, req *http.Request) {
+	dst := w.Header()
+	maps.Copy(dst, tw.headers)
+	// The body is truncated, so it must not be framed by the length set by the handler.
+	dst.Del("Content-Length")
+	// Trailers require a chunked response, and an HTTP/1.0 response is delimited by the closing of the connection.
+	trailer := pf.trailer != "" && req.ProtoAtLeast(1, 1)
+	if trailer {
+		dst.Add("Trailer", pf.trailer)
+	}
+	if !req.ProtoAtLeast(1, 1) {
+		dst.Set("Connection", "close")
+	}
+	w.WriteHeader(tw.code)
+	if tw.spill != nil {
+		_ = tw.spill.writeTo(w)
+	} else {
+		_, _ = w.Write(tw.buf.Bytes())
+	}
+	if trailer {
+		dst.Set(pf.trailer, "truncated")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithFlushPartial(t *testing.T) {
+	infos := make(chan TimeoutInfo, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond,
+		WithFlushPartial("X-Truncated"),
+		WithOnTimeout(func(c *fox.Context, info TimeoutInfo) { infos <- info }),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/proxy", func(c *fox.Context) {
+		c.SetHeader("Content-Type", "application/octet-stream")
+		c.SetHeader("Content-Length", "1000")
+		c.Writer().WriteHeader(http.StatusPartialContent)
+		_, _ = io.WriteString(c.Writer(), "partial")
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/empty", func(c *fox.Context) {
+		c.SetHeader("Content-Type", "application/octet-stream")
+		<-c.Request().Context().Done()
+	})
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/proxy")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "partial", string(body))
+	assert.Equal(t, "truncated", resp.Trailer.Get("X-Truncated"))
+	info := <-infos
+	assert.True(t, info.Buffered)
+	assert.True(t, info.Partial)
+
+	resp, err = http.Get(srv.URL + "/empty")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Empty(t, resp.Trailer.Get("X-Truncated"))
+	info = <-infos
+	assert.False(t, info.Partial)
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// patternMatcher matches a route pattern against exact patterns and prefixes, given as patterns ending with "/*".
+// Exact patterns take precedence over prefixes, and longer prefixes take precedence over shorter ones. Since a router
+// has a bounded number of patterns, the matches of a pattern are computed once and cached.
+type patternMatcher[V any] struct {
+	exact    map[string]V
+	prefixes []patternPrefix[V]
+	cache    sync.Map
+}
+
+type patternPrefix[V any] struct {
+	prefix string
+	v      V
+}
+
+// update sets the value of pattern to the result of fn, given the previous value of pattern, or the zero value. It
+// must not be called once the lookups started.
+func (pm *patternMatcher[V]) update(pattern string, fn func(v V) V) {
+	pattern = normalizeParams(pattern)
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	if !ok || !strings.HasSuffix(prefix, "/") {
+		pm.exact[pattern] = fn(pm.exact[pattern])
+		return
+	}
+	if i := slices.IndexFunc(pm.prefixes, func(p patternPrefix[V]) bool { return p.prefix == prefix }); i >= 0 {
+		pm.prefixes[i].v = fn(pm.prefixes[i].v)
+		return
+	}
+	var zero V
+	pm.prefixes = append(pm.prefixes, patternPrefix[V]{prefix: prefix, v: fn(zero)})
+	slices.SortStableFunc(pm.prefixes, func(a, b patternPrefix[V]) int {
+		return cmp.Compare(len(b.prefix), len(a.prefix))
+	})
+}
+
+// matches returns the values matching pattern, the most specific first.
+func (pm *patternMatcher[V]) matches(pattern string) []V {
+	if res, ok := pm.cache.Load(pattern); ok {
+		return res.([]V)
+	}
+
+	var res []V
+	normalized := normalizeParams(pattern)
+	if v, ok := pm.exact[normalized]; ok {
+		res = append(res, v)
+	}
+	for _, p := range pm.prefixes {
+		if strings.HasPrefix(normalized, p.prefix) {
+			res = append(res, p.v)
+		}
+	}
+	pm.cache.Store(pattern, res)
+	return res
+}
+
+// patternTimeouts resolves a timeout from the route pattern, see patternMatcher.
+type patternTimeouts struct {
+	patternMatcher[time.Duration]
+}
+
+func newPatternTimeouts(patterns map[string]time.Duration) *patternTimeouts {
+	pt := &patternTimeouts{patternMatcher: patternMatcher[time.Duration]{exact: make(map[string]time.Duration, len(patterns))}}
+	for pattern, dt := range patterns {
+		pt.set(pattern, dt)
+	}
+	return pt
+}
+
+// set sets the timeout of pattern, replacing any previous one. It must not be called once the lookups started.
+func (pt *patternTimeouts) set(pattern string, dt time.Duration) {
+	pt.update(pattern, func(time.Duration) time.Duration { return dt })
+}
+
+func (pt *patternTimeouts) lookup(pattern string) (time.Duration, bool) {
+	if pattern == "" {
+		return 0, false
+	}
+	if res := pt.matches(pattern); len(res) > 0 {
+		return res[0], true
+	}
+	return 0, false
+}
+
+// normalizeParams strips the name of the parameters of a pattern, so that "/users/{id}" and "/users/{uid}"
+// compare equal.
+func normalizeParams(pattern string) string {
+	if !strings.Contains(pattern, "{") {
+		return pattern
+	}
+	var sb strings.Builder
+	sb.Grow(len(pattern))
+	for {
+		start := strings.IndexByte(pattern, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(pattern[start:], '}')
+		if end < 0 {
+			break
+		}
+		sb.WriteString(pattern[:start+1])
+		pattern = pattern[start+end:]
+	}
+	sb.WriteString(pattern)
+	return sb.String()
+}
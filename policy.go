@@ -0,0 +1,265 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidPolicy is returned when the rules of a [Policy] are invalid.
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// PolicyRule sets the timeouts of the routes matching a pattern, see [Policy]. The fields left nil are not set by the
+// rule, so that the timeouts of the lower precedence sources apply.
+type PolicyRule struct {
+	// Pattern is the route pattern, matched as with [WithPatternTimeouts]: a pattern ending with "/*" applies to the
+	// routes below it, and parameter names are ignored.
+	Pattern string `json:"pattern" yaml:"pattern"`
+	// Methods are the request methods the rule applies to, all if empty.
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+	// Handler is the handler timeout, [NoTimeout] disabling it. See [OverrideHandler].
+	Handler *Duration `json:"handler,omitempty" yaml:"handler,omitempty"`
+	// Read is the read deadline of the connection. See [OverrideRead].
+	Read *Duration `json:"read,omitempty" yaml:"read,omitempty"`
+	// Write is the write deadline of the connection. See [OverrideWrite].
+	Write *Duration `json:"write,omitempty" yaml:"write,omitempty"`
+	// StatusCode is the status code of the built-in timeout responses, if not zero. See [WithStatusCode].
+	StatusCode int `json:"statusCode,omitempty" yaml:"statusCode,omitempty"`
+}
+
+// Policy is a set of [PolicyRule] that can be replaced at runtime, e.g. when the configuration management pushes a
+// new version of the file it is loaded from, so that the timeouts of the routes can be tuned per environment without
+// annotating the routes. It is applied with [WithPolicy], and is safe for concurrent use.
+//
+// The rule of a request is the one whose pattern is the most specific, exact patterns first and then the longest
+// prefixes, and, among the rules of the same pattern, the one listing the request method over the one listing none.
+// If no rule of the most specific pattern applies to the request method, the rules of the next pattern are tried.
+// As with [WithPatternTimeouts], the route options win: the handler timeout of the rule takes precedence over the
+// pattern timeouts and the lower precedence sources, but not over [OverrideHandler], [OverrideMethod] or the feature
+// flags set with [WithFlagProvider], and its read and write deadlines do not take precedence over [OverrideRead] and
+// [OverrideWrite].
+type Policy struct {
+	rules atomic.Pointer[policyRules]
+	// changes records the changes made with Set and Load once the rules are set.
+	changes changeLog
+}
+
+// NewPolicy returns a [Policy] with the given rules, or an error wrapping [ErrInvalidPolicy] if they are invalid.
+func NewPolicy(rules []PolicyRule) (*Policy, error) {
+	p := new(Policy)
+	if err := p.Set(rules); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadPolicy returns a [Policy] with the rules of the JSON file at path, holding an array of [PolicyRule]. Unknown
+// fields are rejected, so that a misspelled field does not go unnoticed. The rules can be reloaded with
+// [Policy.Load]. Rules from a YAML file can be decoded into []PolicyRule and given to [NewPolicy].
+func LoadPolicy(path string) (*Policy, error) {
+	p := new(Policy)
+	if err := p.Load(path); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Set atomically replaces the rules of p. It applies to the requests starting after the call. If the rules are
+// invalid, an error wrapping [ErrInvalidPolicy] is returned and the rules in place are kept. The change is recorded,
+// see [Timeout.Changes].
+func (p *Policy) Set(rules []PolicyRule) error {
+	return p.set(rules, changeSource())
+}
+
+// set replaces the rules of p, recording the change made from source unless the rules are set for the first time.
+func (p *Policy) set(rules []PolicyRule, source string) error {
+	pr, err := compilePolicy(rules)
+	if err != nil {
+		return err
+	}
+	if old := p.rules.Swap(pr); old != nil {
+		p.changes.record(time.Now(), "policy", formatPolicy(old.src), formatPolicy(pr.src), source)
+	}
+	return nil
+}
+
+// Load atomically replaces the rules of p with the ones of the JSON file at path, see [LoadPolicy]. If the file
+// cannot be read or holds invalid rules, an error is returned and the rules in place are kept. The change is recorded,
+// see [Timeout.Changes].
+func (p *Policy) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rules []PolicyRule
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rules); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPolicy, err)
+	}
+	return p.set(rules, changeSource())
+}
+
+// Rules returns a copy of the rules of p.
+func (p *Policy) Rules() []PolicyRule {
+	pr := p.rules.Load()
+	if pr == nil {
+		return nil
+	}
+	rules := make([]PolicyRule, len(pr.src))
+	for i, r := range pr.src {
+		rules[i] = r.clone()
+	}
+	return rules
+}
+
+// handler returns the handler timeout set by p for the requests with the given method served by the route of the
+// given pattern, if any. It is safe to call on a nil Policy.
+func (p *Policy) handler(pattern, method string) (time.Duration, bool) {
+	if r := p.lookup(pattern, method); r != nil && r.Handler != nil {
+		return time.Duration(*r.Handler), true
+	}
+	return 0, false
+}
+
+// deadline returns the connection deadline set by p under k, rKey or wKey, for the requests with the given method
+// served by the route of the given pattern, if any. It is safe to call on a nil Policy.
+func (p *Policy) deadline(pattern, method string, k any) (time.Duration, bool) {
+	r := p.lookup(pattern, method)
+	if r == nil {
+		return 0, false
+	}
+	var d *Duration
+	switch k.(type) {
+	case rKey:
+		d = r.Read
+	case wKey:
+		d = r.Write
+	}
+	if d == nil {
+		return 0, false
+	}
+	return time.Duration(*d), true
+}
+
+// status returns the status code of the built-in timeout responses set by p for the requests with the given method
+// served by the route of the given pattern, or zero. It is safe to call on a nil Policy.
+func (p *Policy) status(pattern, method string) int {
+	if r := p.lookup(pattern, method); r != nil {
+		return r.StatusCode
+	}
+	return 0
+}
+
+// lookup returns the rule of the requests with the given method served by the route of the given pattern, or nil.
+func (p *Policy) lookup(pattern, method string) *PolicyRule {
+	if p == nil || pattern == "" {
+		return nil
+	}
+	pr := p.rules.Load()
+	if pr == nil {
+		return nil
+	}
+	return pr.lookup(pattern, method)
+}
+
+// policyRules are the compiled rules of a Policy, grouped by pattern and matched as with patternTimeouts.
+type policyRules struct {
+	src      []PolicyRule
+	patterns patternMatcher[[]*PolicyRule]
+}
+
+func compilePolicy(rules []PolicyRule) (*policyRules, error) {
+	pr := &policyRules{
+		src:      make([]PolicyRule, 0, len(rules)),
+		patterns: patternMatcher[[]*PolicyRule]{exact: make(map[string][]*PolicyRule)},
+	}
+	seen := make(map[string]struct{})
+	for i, r := range rules {
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("%w: rule %d: empty pattern", ErrInvalidPolicy, i)
+		}
+		for _, d := range [...]*Duration{r.Handler, r.Read, r.Write} {
+			if d != nil && *d < 0 {
+				return nil, fmt.Errorf("%w: rule %d: negative timeout", ErrInvalidPolicy, i)
+			}
+		}
+		if r.StatusCode != 0 && (r.StatusCode < 400 || r.StatusCode > 599) {
+			return nil, fmt.Errorf("%w: rule %d: status code %d out of range", ErrInvalidPolicy, i, r.StatusCode)
+		}
+
+		pattern := normalizeParams(r.Pattern)
+		r = r.clone()
+		for j, m := range r.Methods {
+			if m == "" {
+				return nil, fmt.Errorf("%w: rule %d: empty method", ErrInvalidPolicy, i)
+			}
+			r.Methods[j] = strings.ToUpper(m)
+		}
+		methods := r.Methods
+		if len(methods) == 0 {
+			// The key of the rule applying to all the methods.
+			methods = []string{""}
+		}
+		for _, m := range methods {
+			key := pattern + " " + m
+			if _, ok := seen[key]; ok {
+				return nil, fmt.Errorf("%w: rule %d: duplicate rule for %q", ErrInvalidPolicy, i, r.Pattern)
+			}
+			seen[key] = struct{}{}
+		}
+		pr.src = append(pr.src, r)
+	}
+
+	for i := range pr.src {
+		r := &pr.src[i]
+		pr.patterns.update(r.Pattern, func(group []*PolicyRule) []*PolicyRule {
+			return append(group, r)
+		})
+	}
+	return pr, nil
+}
+
+// lookup returns the rule of the requests with the given method served by the route of the given pattern, or nil.
+// The groups of rules are tried from the most specific pattern, so that a group without a rule for the method falls
+// back to the next one.
+func (pr *policyRules) lookup(pattern, method string) *PolicyRule {
+	for _, group := range pr.patterns.matches(pattern) {
+		var fallback *PolicyRule
+		for _, r := range group {
+			if len(r.Methods) == 0 {
+				fallback = r
+				continue
+			}
+			if slices.Contains(r.Methods, method) {
+				return r
+			}
+		}
+		if fallback != nil {
+			return fallback
+		}
+	}
+	return nil
+}
+
+// clone returns a deep copy of r, so that the rules of a Policy are not changed behind its back.
+func (r PolicyRule) clone() PolicyRule {
+	r.Methods = slices.Clone(r.Methods)
+	for _, d := range [...]**Duration{&r.Handler, &r.Read, &r.Write} {
+		if *d != nil {
+			v := **d
+			*d = &v
+		}
+	}
+	return r
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func durationPtr(d time.Duration) *Duration {
+	v := Duration(d)
+	return &v
+}
+
+func TestPolicy_Lookup(t *testing.T) {
+	p, err := NewPolicy([]PolicyRule{
+		{Pattern: "/api/*", Handler: durationPtr(time.Second)},
+		{Pattern: "/api/reports/*", Handler: durationPtr(30 * time.Second), StatusCode: http.StatusGatewayTimeout},
+		{Pattern: "/api/users/{id}", Methods: []string{"post", http.MethodPut}, Handler: durationPtr(5 * time.Second)},
+		{Pattern: "/api/users/{id}", Read: durationPtr(2 * time.Second), Write: durationPtr(3 * time.Second)},
+		{Pattern: "/api/orders/{id}", Methods: []string{http.MethodDelete}, Handler: durationPtr(2 * time.Second)},
+		{Pattern: "/healthz", Handler: durationPtr(NoTimeout)},
+	})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name    string
+		pattern string
+		method  string
+		handler time.Duration
+		found   bool
+		read    time.Duration
+		status  int
+	}{
+		{name: "prefix", pattern: "/api/orders", method: http.MethodGet, handler: time.Second, found: true},
+		{name: "longest prefix", pattern: "/api/reports/daily", method: http.MethodGet, handler: 30 * time.Second, found: true, status: http.StatusGatewayTimeout},
+		{name: "method rule", pattern: "/api/users/{uid}", method: http.MethodPost, handler: 5 * time.Second, found: true},
+		{name: "method agnostic rule", pattern: "/api/users/{id}", method: http.MethodGet, read: 2 * time.Second},
+		{name: "prefix fallback", pattern: "/api/orders/{id}", method: http.MethodGet, handler: time.Second, found: true},
+		{name: "no timeout", pattern: "/healthz", method: http.MethodGet, handler: NoTimeout, found: true},
+		{name: "no rule", pattern: "/static/app.js", method: http.MethodGet},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dt, ok := p.handler(tc.pattern, tc.method)
+			assert.Equal(t, tc.found, ok)
+			assert.Equal(t, tc.handler, dt)
+			read, _ := p.deadline(tc.pattern, tc.method, rKey{})
+			assert.Equal(t, tc.read, read)
+			assert.Equal(t, tc.status, p.status(tc.pattern, tc.method))
+		})
+	}
+
+	var nilPolicy *Policy
+	_, ok := nilPolicy.handler("/api/orders", http.MethodGet)
+	assert.False(t, ok)
+}
+
+func TestPolicy_Invalid(t *testing.T) {
+	cases := []struct {
+		name  string
+		rules []PolicyRule
+	}{
+		{name: "empty pattern", rules: []PolicyRule{{Handler: durationPtr(time.Second)}}},
+		{name: "negative timeout", rules: []PolicyRule{{Pattern: "/foo", Write: durationPtr(-time.Second)}}},
+		{name: "status code out of range", rules: []PolicyRule{{Pattern: "/foo", StatusCode: http.StatusOK}}},
+		{name: "empty method", rules: []PolicyRule{{Pattern: "/foo", Methods: []string{""}}}},
+		{name: "duplicate rule", rules: []PolicyRule{
+			{Pattern: "/foo/{id}", Methods: []string{http.MethodGet}},
+			{Pattern: "/foo/{name}", Methods: []string{"get"}},
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewPolicy(tc.rules)
+			assert.ErrorIs(t, err, ErrInvalidPolicy)
+		})
+	}
+}
+
+func TestMiddleware_WithPolicy(t *testing.T) {
+	p, err := NewPolicy([]PolicyRule{
+		{Pattern: "/slow", Handler: durationPtr(time.Microsecond), StatusCode: http.StatusGatewayTimeout},
+		{Pattern: "/slower", Handler: durationPtr(time.Microsecond), StatusCode: http.StatusGatewayTimeout},
+	})
+	require.NoError(t, err)
+	tm := New(time.Second, WithPolicy(p))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/slower", func(c *fox.Context) {
+		c.String(http.StatusOK, "ok")
+	}, OverrideHandler(time.Minute))
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+	// The route options win over the policy.
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slower", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	audits := tm.Audit(f)
+	require.Len(t, audits, 2)
+	assert.Equal(t, "/slow", audits[0].Pattern)
+	assert.Equal(t, SourcePolicy, audits[0].Source)
+	assert.Equal(t, "policy", SourcePolicy.String())
+	assert.Equal(t, time.Microsecond, audits[0].Handler)
+	assert.Equal(t, "/slower", audits[1].Pattern)
+	assert.Equal(t, SourceRoute, audits[1].Source)
+	assert.Equal(t, time.Minute, audits[1].Handler)
+
+	// The rules are swapped at runtime, so that the route falls back to the global timeout.
+	require.NoError(t, p.Set(nil))
+	audits = tm.Audit(f)
+	require.Len(t, audits, 2)
+	assert.Equal(t, SourceGlobal, audits[0].Source)
+	assert.Equal(t, time.Second, audits[0].Handler)
+
+	// Invalid rules are rejected, and the rules in place are kept.
+	assert.ErrorIs(t, p.Set([]PolicyRule{{Pattern: ""}}), ErrInvalidPolicy)
+	assert.Empty(t, p.Rules())
+}
+
+func TestLoadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"pattern": "/foo", "methods": ["GET"], "handler": "1500", "write": "PT2S"}]`), 0o644))
+
+	p, err := LoadPolicy(path)
+	require.NoError(t, err)
+	rules := p.Rules()
+	require.Len(t, rules, 1)
+	assert.Equal(t, Duration(1500*time.Millisecond), *rules[0].Handler)
+	assert.Equal(t, Duration(2*time.Second), *rules[0].Write)
+
+	// The rules returned are copies.
+	*rules[0].Handler = 0
+	dt, _ := p.handler("/foo", http.MethodGet)
+	assert.Equal(t, 1500*time.Millisecond, dt)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"pattern": "/foo", "timeout": "1s"}]`), 0o644))
+	assert.ErrorIs(t, p.Load(path), ErrInvalidPolicy)
+	require.NoError(t, os.WriteFile(path, []byte(`[{"pattern": "/bar", "handler": "3s"}]`), 0o644))
+	require.NoError(t, p.Load(path))
+	dt, ok := p.handler("/bar", http.MethodGet)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, dt)
+
+	_, err = LoadPolicy(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	var cfg Config
+	require.NoError(t, json.Unmarshal([]byte(`{"policy": [{"pattern": "/foo", "handler": "1s"}]}`), &cfg))
+	_, err = FromConfig(cfg)
+	require.NoError(t, err)
+}
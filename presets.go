@@ -0,0 +1,163 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// defaultStreamingIdleWrite is the sliding write deadline of [PresetStreaming].
+const defaultStreamingIdleWrite = 30 * time.Second
+
+// PresetAPI returns an [Option] bundling a sensible configuration for JSON APIs: the response is buffered with the
+// [Buffered] enforcement, and timeouts are reported with [JSONResponse] and a 503 Service Unavailable status code.
+// Options applied after the preset take precedence.
+func PresetAPI() Option {
+	return optionFunc(func(c *config) {
+		c.resp = JSONResponse
+		c.statusCode = http.StatusServiceUnavailable
+		c.enforcement = Buffered
+		c.streaming = false
+	})
+}
+
+// PresetWeb returns an [Option] bundling a sensible configuration for web pages: the response is buffered with the
+// [Buffered] enforcement, and compressed as with [WithCompression] when it is at least 1KB and 10ms are left before
+// the deadline, and timeouts are reported with [HTMLResponse] and a 503 Service Unavailable status code. Options
+// applied after the preset take precedence.
+func PresetWeb() Option {
+	return optionFunc(func(c *config) {
+		c.resp = HTMLResponse
+		c.statusCode = http.StatusServiceUnavailable
+		c.enforcement = Buffered
+		c.streaming = false
+		c.compression = &compression{minSize: 1024, minBudget: 10 * time.Millisecond}
+	})
+}
+
+// PresetStreaming returns an [Option] bundling a sensible configuration for Server-Sent Events and long chunked
+// responses: the response is written through with [WithStreaming], the clients that stall for more than 30 seconds
+// are cut off as with [WithIdleWriteTimeout], and timeouts occurring before the first write are reported with
+// [DefaultResponse] and a 503 Service Unavailable status code. Since a stream is usually long-lived, the handler
+// timeout given to the middleware should be sized for the whole stream, or disabled on the streaming routes with
+// [OverrideHandler]. Options applied after the preset take precedence.
+func PresetStreaming() Option {
+	return optionFunc(func(c *config) {
+		c.resp = DefaultResponse
+		c.statusCode = http.StatusServiceUnavailable
+		c.enforcement = Buffered
+		c.streaming = true
+		c.idleWrite = defaultStreamingIdleWrite
+		c.compression = nil
+	})
+}
+
+// timeoutHandlerBody is the body of the timeout response of [http.TimeoutHandler] when it is given no message.
+const timeoutHandlerBody = "<html><head><title>Timeout</title></head><body><h1>Timeout</h1></body></html>"
+
+// CompatMode returns an [Option] matching the semantics of [http.TimeoutHandler], to ease the migration of
+// handlers wrapped with it: timeouts and canceled requests are both reported with [CompatResponse], and the request
+// body is not canceled when the handler times out. As with [http.TimeoutHandler], the handler response is buffered,
+// the headers it sets are sent as they are when it returns, including the ones set after calling WriteHeader, and its
+// writes return [http.ErrHandlerTimeout] itself, rather than a [*TimeoutWriteError], once it timed out, or the cause
+// of the cancellation of the request if the client went away. Options applied after the preset take precedence, so
+// features with no equivalent in [http.TimeoutHandler] can still be enabled.
+func CompatMode() Option {
+	return optionFunc(func(c *config) {
+		c.resp = CompatResponse
+		c.canceledResp = CompatResponse
+		c.statusCode = 0
+		c.keepBody = true
+		c.bareWriteErr = true
+	})
+}
+
+// CompatResponse sends the timeout response of [http.TimeoutHandler]: a 503 Service Unavailable response with a
+// minimal HTML page, or without a body if the client went away.
+func CompatResponse(c *fox.Context) {
+	w := c.Writer()
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if c.Request().Context().Err() == nil {
+		_, _ = io.WriteString(w, timeoutHandlerBody)
+	}
+}
+
+// JSONResponse sends a 503 Service Unavailable response with a JSON body, or the status code set with
+// [WithStatusCode].
+func JSONResponse(c *fox.Context) {
+	code := responseStatus(c)
+	_ = c.Blob(
+		code,
+		fox.MIMEApplicationJSONCharsetUTF8,
+		[]byte(`{"error":"`+http.StatusText(code)+`"}`),
+	)
+}
+
+// HTMLResponse sends a 503 Service Unavailable response with a minimal HTML page, or the status code set with
+// [WithStatusCode].
+func HTMLResponse(c *fox.Context) {
+	code := responseStatus(c)
+	text := http.StatusText(code)
+	_ = c.Blob(
+		code,
+		fox.MIMETextHTMLCharsetUTF8,
+		[]byte("<!DOCTYPE html><html><head><title>"+text+"</title></head><body><h1>"+text+"</h1></body></html>\n"),
+	)
+}
+
+// responseInfo holds the details of a timeout, for the built-in responses.
+type responseInfo struct {
+	status    int
+	timeout   time.Duration
+	err       error
+	pattern   string
+	elapsed   time.Duration
+	overshoot time.Duration
+}
+
+// timeoutDetails returns the status code of the built-in timeout responses, as set with [WithStatusCode], and the
+// timeout of the handler, if known.
+func timeoutDetails(c *fox.Context) (int, time.Duration) {
+	if info, ok := c.Request().Context().Value(responseKey{}).(*responseInfo); ok {
+		return info.status, info.timeout
+	}
+	return http.StatusServiceUnavailable, 0
+}
+
+// responseErr returns the reason why the timeout response is sent, see WithErrorResponse. It defaults to
+// context.DeadlineExceeded when the response is written outside of the middleware.
+func responseErr(c *fox.Context) error {
+	if info, ok := c.Request().Context().Value(responseKey{}).(*responseInfo); ok && info.err != nil {
+		return info.err
+	}
+	return context.DeadlineExceeded
+}
+
+// responseTimeoutInfo returns the details of the timeout for which the response is sent, see WithResponseFunc. It
+// returns a zero TimeoutInfo for a request rejected by the admission control, or when the response is written
+// outside of the middleware.
+func responseTimeoutInfo(c *fox.Context) TimeoutInfo {
+	info, ok := c.Request().Context().Value(responseKey{}).(*responseInfo)
+	if !ok {
+		return TimeoutInfo{}
+	}
+	return TimeoutInfo{
+		Pattern:   info.pattern,
+		Timeout:   info.timeout,
+		Elapsed:   info.elapsed,
+		Overshoot: info.overshoot,
+	}
+}
+
+// responseStatus returns the status code of the built-in timeout responses, as set with [WithStatusCode].
+func responseStatus(c *fox.Context) int {
+	code, _ := timeoutDetails(c)
+	return code
+}
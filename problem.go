@@ -0,0 +1,132 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// ProblemType is the stable URI identifying the timeout problem type in the responses sent by
+// [NegotiatedResponse].
+const ProblemType = "https://github.com/fox-toolkit/timeout#handler-timeout"
+
+const (
+	mimeProblemJSON = "application/problem+json"
+	mimeProblemXML  = "application/problem+xml"
+)
+
+// offers are the media types NegotiatedResponse can produce, in order of preference when the client accepts
+// several of them equally. Plain text comes first, so that clients without a preference get the same response as
+// with DefaultResponse.
+var offers = [...]string{
+	fox.MIMETextPlain,
+	mimeProblemJSON,
+	fox.MIMEApplicationJSON,
+	mimeProblemXML,
+	fox.MIMEApplicationXML,
+	fox.MIMETextXML,
+}
+
+// problem is a problem details object, as defined by RFC 9457 (formerly RFC 7807).
+type problem struct {
+	XMLName xml.Name `json:"-" xml:"urn:ietf:rfc:7807 problem"`
+	Type    string   `json:"type" xml:"type"`
+	Title   string   `json:"title" xml:"title"`
+	Status  int      `json:"status" xml:"status"`
+	Detail  string   `json:"detail,omitempty" xml:"detail,omitempty"`
+	Route   string   `json:"route,omitempty" xml:"route,omitempty"`
+	Timeout string   `json:"timeout,omitempty" xml:"timeout,omitempty"`
+}
+
+// NegotiatedResponse sends a timeout response whose format is negotiated with the Accept header of the request: a
+// problem details object (RFC 9457, formerly RFC 7807) encoded in JSON ("application/problem+json") or XML
+// ("application/problem+xml"), or plain text. The problem details carry the [ProblemType] type URI, the route
+// pattern and the timeout of the handler. Plain text is sent if the client has no preference. The status code is
+// 503 Service Unavailable, or the one set with [WithStatusCode].
+func NegotiatedResponse(c *fox.Context) {
+	code, dt := timeoutDetails(c)
+	p := problem{
+		Type:   ProblemType,
+		Title:  http.StatusText(code),
+		Status: code,
+		Route:  c.Pattern(),
+	}
+	if dt > 0 {
+		p.Timeout = dt.String()
+		p.Detail = "The handler did not complete within " + dt.String() + "."
+	}
+
+	c.Writer().Header().Add("Vary", "Accept")
+	switch negotiate(c.Header("Accept")) {
+	case mimeProblemJSON:
+		b, _ := json.Marshal(p)
+		_ = c.Blob(code, mimeProblemJSON, b)
+	case mimeProblemXML:
+		b, _ := xml.Marshal(p)
+		_ = c.Blob(code, mimeProblemXML+"; charset=utf-8", append([]byte(xml.Header), b...))
+	default:
+		http.Error(c.Writer(), http.StatusText(code), code)
+	}
+}
+
+// negotiate returns the media type of the response preferred by the Accept header: "text/plain",
+// "application/problem+json" or "application/problem+xml", or an empty string if none is acceptable.
+func negotiate(accept string) string {
+	if accept == "" {
+		return fox.MIMETextPlain
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	switch best {
+	case fox.MIMEApplicationJSON:
+		return mimeProblemJSON
+	case fox.MIMEApplicationXML, fox.MIMETextXML:
+		return mimeProblemXML
+	}
+	return best
+}
+
+// acceptQuality returns the quality of the media type offer according to the Accept header, as given by the most
+// specific matching media range.
+func acceptQuality(accept, offer string) float64 {
+	typ, _, _ := strings.Cut(offer, "/")
+	q, specificity := 0.0, -1
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaRange, params, _ := strings.Cut(part, ";")
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+		s := -1
+		switch mediaRange {
+		case offer:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		for param := range strings.SplitSeq(params, ";") {
+			name, value, ok := strings.Cut(param, "=")
+			if ok && strings.TrimSpace(name) == "q" {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = v
+				}
+			}
+		}
+	}
+	return q
+}
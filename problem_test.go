@@ -0,0 +1,96 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: "text/plain"},
+		{accept: "*/*", want: "text/plain"},
+		{accept: "application/json", want: "application/problem+json"},
+		{accept: "application/problem+json", want: "application/problem+json"},
+		{accept: "application/json, */*;q=0.1", want: "application/problem+json"},
+		{accept: "application/xml;q=0.9, application/json;q=0.5", want: "application/problem+xml"},
+		{accept: "text/*", want: "text/plain"},
+		{accept: "text/*, text/plain;q=0", want: "application/problem+xml"},
+		{accept: "image/png", want: ""},
+		{accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", want: "application/problem+xml"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.accept, func(t *testing.T) {
+			assert.Equal(t, tc.want, negotiate(tc.accept))
+		})
+	}
+}
+
+func TestNegotiatedResponse(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, WithNegotiatedResponse(), WithStatusCode(http.StatusGatewayTimeout))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/users/{id}", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+
+	serve := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		return w
+	}
+
+	want := problem{
+		Type:    ProblemType,
+		Title:   http.StatusText(http.StatusGatewayTimeout),
+		Status:  http.StatusGatewayTimeout,
+		Detail:  "The handler did not complete within 10ms.",
+		Route:   "/users/{id}",
+		Timeout: "10ms",
+	}
+
+	t.Run("json", func(t *testing.T) {
+		w := serve("application/json")
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+		var got problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("xml", func(t *testing.T) {
+		w := serve("application/xml")
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Equal(t, "application/problem+xml; charset=utf-8", w.Header().Get("Content-Type"))
+		var got problem
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, "urn:ietf:rfc:7807", got.XMLName.Space)
+		got.XMLName = xml.Name{}
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("text", func(t *testing.T) {
+		w := serve("")
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, http.StatusText(http.StatusGatewayTimeout)+"\n", w.Body.String())
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// OrphanProfile is the name of the [pprof.Profile] counting the handlers still running after timing out, when the
+// middleware is configured with [WithProfileLabels]. It is served by net/http/pprof along with the built-in
+// profiles, and can be looked up with [pprof.Lookup].
+const OrphanProfile = "github.com/fox-toolkit/timeout.orphaned"
+
+// The labels set on the goroutines of the handlers, see WithProfileLabels.
+const (
+	labelPattern  = "timeout.pattern"
+	labelMethod   = "timeout.method"
+	labelTimeout  = "timeout.timeout"
+	labelDeadline = "timeout.deadline"
+	labelOrphaned = "timeout.orphaned"
+)
+
+var orphanProfile = sync.OnceValue(func() *pprof.Profile {
+	if p := pprof.Lookup(OrphanProfile); p != nil {
+		return p
+	}
+	return pprof.NewProfile(OrphanProfile)
+})
+
+// handlerLabels returns a copy of ctx holding the profiler labels of the handler serving the method request of
+// pattern with the timeout dt until deadline, on top of the labels of ctx.
+func handlerLabels(ctx context.Context, pattern, method string, dt time.Duration, deadline time.Time) context.Context {
+	return pprof.WithLabels(ctx, pprof.Labels(
+		labelPattern, pattern,
+		labelMethod, method,
+		labelTimeout, dt.String(),
+		labelDeadline, deadline.Format(time.RFC3339Nano),
+	))
+}
+
+// setOrphanedLabel marks the calling goroutine, which runs a handler that timed out, as orphaned.
+func setOrphanedLabel(ctx context.Context) {
+	pprof.SetGoroutineLabels(pprof.WithLabels(ctx, pprof.Labels(labelOrphaned, "true")))
+}
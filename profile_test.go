@@ -0,0 +1,67 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func goroutineDump(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, pprof.Lookup("goroutine").WriteTo(&buf, 1))
+	return buf.String()
+}
+
+func TestMiddleware_WithProfileLabels(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, WithProfileLabels())))
+	require.NoError(t, err)
+	started := make(chan struct{})
+	written := make(chan error)
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		defer close(returned)
+		close(started)
+		<-c.Request().Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		_, err := c.Writer().Write([]byte("late"))
+		written <- err
+		<-release
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+
+	<-started
+	dump := goroutineDump(t)
+	assert.Contains(t, dump, `"timeout.pattern":"/slow"`)
+	assert.Contains(t, dump, `"timeout.method":"GET"`)
+	assert.Contains(t, dump, `"timeout.timeout":"10ms"`)
+	<-done
+	assert.ErrorIs(t, <-written, http.ErrHandlerTimeout)
+	assert.Equal(t, 1, pprof.Lookup(OrphanProfile).Count())
+	dump = goroutineDump(t)
+	assert.Contains(t, dump, `"timeout.orphaned":"true"`)
+	assert.Contains(t, dump, `"timeout.deadline":`)
+
+	close(release)
+	<-returned
+	assert.Eventually(t, func() bool {
+		return pprof.Lookup(OrphanProfile).Count() == 0
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,54 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package promtimeout
+
+import (
+	"strconv"
+
+	"github.com/fox-toolkit/timeout"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bufferCollector is a prometheus.Collector exporting the hit and miss counts of the size classes of a buffer pool.
+type bufferCollector struct {
+	gets  *prometheus.Desc
+	stats func() []timeout.BufferClassStats
+}
+
+// RegisterBufferStats registers with reg the fox_timeout_buffer_pool_gets_total counter, labeled by size class and
+// result ("hit" or "miss"), exporting the counts reported by stats, usually [timeout.Timeout.BufferStats]. The class
+// is labeled by its upper capacity bound in bytes, or "+Inf" for the class of the larger buffers. Only the
+// [WithNamespace] option applies.
+func RegisterBufferStats(reg prometheus.Registerer, stats func() []timeout.BufferClassStats, opts ...Option) error {
+	cfg := &config{}
+	for _, opt := range opts {
+		opt.apply(cfg)
+	}
+	return reg.Register(&bufferCollector{
+		gets: prometheus.NewDesc(
+			prometheus.BuildFQName(cfg.namespace, "fox_timeout", "buffer_pool_gets_total"),
+			"Number of buffers looked up in a size class of the pool, by result.",
+			[]string{"class", "result"}, nil,
+		),
+		stats: stats,
+	})
+}
+
+// Describe implements prometheus.Collector.
+func (c *bufferCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.gets
+}
+
+// Collect implements prometheus.Collector.
+func (c *bufferCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.stats() {
+		class := "+Inf"
+		if s.Size > 0 {
+			class = strconv.Itoa(s.Size)
+		}
+		ch <- prometheus.MustNewConstMetric(c.gets, prometheus.CounterValue, float64(s.Hits), class, "hit")
+		ch <- prometheus.MustNewConstMetric(c.gets, prometheus.CounterValue, float64(s.Misses), class, "miss")
+	}
+}
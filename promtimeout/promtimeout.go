@@ -0,0 +1,158 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+// Package promtimeout exports the observations of the timeout middleware as Prometheus metrics.
+package promtimeout
+
+import (
+	"github.com/fox-toolkit/timeout"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultBuckets are the default buckets of the budget utilization histogram. They are dense near 1, where a route
+// is about to exceed its deadline.
+var DefaultBuckets = []float64{0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 1}
+
+// DefaultOvershootBuckets are the default buckets of the overshoot histogram, in seconds. The overshoot is typically a
+// few microseconds, so they range from 10µs to 1s.
+var DefaultOvershootBuckets = []float64{0.00001, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+var _ timeout.MetricsRecorder = (*Collector)(nil)
+
+// Collector is a [timeout.MetricsRecorder] exporting the following metrics, labeled by route pattern, method and
+// application version (see [timeout.WithVersionLabel]):
+//   - fox_timeout_requests_total: a counter of requests served under a timeout, also labeled by outcome (see
+//     [timeout.Outcome]).
+//   - fox_timeout_budget_utilization: a histogram of the handler execution time relative to its timeout, where 1
+//     means that the whole budget was used.
+//   - fox_timeout_over_budget_total: a counter of requests whose handler ran for longer than the observe-only
+//     budget of the route (see [timeout.ObserveBudget]).
+//   - fox_timeout_overshoot_seconds: a histogram of how long after the deadline the timeout response was written,
+//     for the requests that timed out (see [timeout.Observation.Overshoot]).
+//
+// The requests that timed out carry their trace as an exemplar, see [Collector.Observe]. It is registered with the
+// middleware using [timeout.WithMetrics].
+type Collector struct {
+	requests    *prometheus.CounterVec
+	utilization *prometheus.HistogramVec
+	overBudget  *prometheus.CounterVec
+	overshoot   *prometheus.HistogramVec
+}
+
+// Option configures a [Collector].
+type Option interface {
+	apply(*config)
+}
+
+type config struct {
+	namespace        string
+	buckets          []float64
+	overshootBuckets []float64
+}
+
+type optionFunc func(*config)
+
+func (f optionFunc) apply(c *config) {
+	f(c)
+}
+
+// WithNamespace prefixes the name of the metrics with the given namespace, e.g. "myapp_fox_timeout_requests_total".
+func WithNamespace(namespace string) Option {
+	return optionFunc(func(c *config) {
+		c.namespace = namespace
+	})
+}
+
+// WithBuckets sets the buckets of the budget utilization histogram. The default is [DefaultBuckets].
+func WithBuckets(buckets []float64) Option {
+	return optionFunc(func(c *config) {
+		c.buckets = buckets
+	})
+}
+
+// WithOvershootBuckets sets the buckets of the overshoot histogram, in seconds. The default is
+// [DefaultOvershootBuckets].
+func WithOvershootBuckets(buckets []float64) Option {
+	return optionFunc(func(c *config) {
+		c.overshootBuckets = buckets
+	})
+}
+
+// New returns a [Collector] whose metrics are registered with reg. It returns an error if the metrics cannot be
+// registered, e.g. because a collector with the same namespace is already registered.
+func New(reg prometheus.Registerer, opts ...Option) (*Collector, error) {
+	cfg := &config{buckets: DefaultBuckets, overshootBuckets: DefaultOvershootBuckets}
+	for _, opt := range opts {
+		opt.apply(cfg)
+	}
+
+	c := &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Subsystem: "fox_timeout",
+			Name:      "requests_total",
+			Help:      "Number of requests served under a timeout, by outcome.",
+		}, []string{"pattern", "method", "version", "outcome"}),
+		utilization: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Subsystem: "fox_timeout",
+			Name:      "budget_utilization",
+			Help:      "Handler execution time relative to its timeout.",
+			Buckets:   cfg.buckets,
+		}, []string{"pattern", "method", "version"}),
+		overBudget: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Subsystem: "fox_timeout",
+			Name:      "over_budget_total",
+			Help:      "Number of requests whose handler exceeded the observe-only budget of the route.",
+		}, []string{"pattern", "method", "version"}),
+		overshoot: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Subsystem: "fox_timeout",
+			Name:      "overshoot_seconds",
+			Help:      "Time elapsed between the deadline and the timeout response of the requests that timed out.",
+			Buckets:   cfg.overshootBuckets,
+		}, []string{"pattern", "method", "version"}),
+	}
+	collectors := []prometheus.Collector{c.requests, c.utilization, c.overBudget, c.overshoot}
+	for i, col := range collectors {
+		if err := reg.Register(col); err != nil {
+			for _, registered := range collectors[:i] {
+				reg.Unregister(registered)
+			}
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Observe records o. The trace of a request that timed out, if any (see [timeout.Observation.TraceID]), is attached
+// as an exemplar labeled "trace_id" to the requests counter and the budget utilization histogram.
+func (c *Collector) Observe(o timeout.Observation) {
+	var exemplar prometheus.Labels
+	if o.TraceID != "" {
+		exemplar = prometheus.Labels{"trace_id": o.TraceID}
+	}
+	requests := c.requests.WithLabelValues(o.Pattern, o.Method, o.Version, o.Outcome.String())
+	if ea, ok := requests.(prometheus.ExemplarAdder); ok && exemplar != nil {
+		ea.AddWithExemplar(1, exemplar)
+	} else {
+		requests.Inc()
+	}
+	if o.Timeout > 0 {
+		utilization := c.utilization.WithLabelValues(o.Pattern, o.Method, o.Version)
+		v := float64(o.Elapsed) / float64(o.Timeout)
+		if eo, ok := utilization.(prometheus.ExemplarObserver); ok && exemplar != nil {
+			eo.ObserveWithExemplar(v, exemplar)
+		} else {
+			utilization.Observe(v)
+		}
+	}
+	if o.Budget > 0 && o.Elapsed > o.Budget {
+		c.overBudget.WithLabelValues(o.Pattern, o.Method, o.Version).Inc()
+	}
+	if o.Outcome == timeout.OutcomeTimedOut {
+		c.overshoot.WithLabelValues(o.Pattern, o.Method, o.Version).Observe(o.Overshoot.Seconds())
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package promtimeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/fox-toolkit/timeout"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c, err := New(reg, WithNamespace("test"))
+	require.NoError(t, err)
+
+	f, err := fox.NewRouter(fox.WithMiddleware(timeout.Middleware(20*time.Millisecond, timeout.WithMetrics(c), timeout.WithVersionLabel("v42"))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/fast", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, "ok")
+	})
+	f.MustAdd(fox.MethodGet, "/budget", func(c *fox.Context) {
+		time.Sleep(5 * time.Millisecond)
+	}, timeout.ObserveBudget(time.Millisecond))
+	f.MustAdd(fox.MethodGet, "/slow/{id}", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+
+	for _, path := range []string{"/fast", "/fast", "/slow/1", "/budget"} {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(c.requests.WithLabelValues("/fast", http.MethodGet, "v42", "completed")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.requests.WithLabelValues("/slow/{id}", http.MethodGet, "v42", "timed_out")))
+	assert.Equal(t, 3, testutil.CollectAndCount(c.utilization))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.overBudget.WithLabelValues("/budget", http.MethodGet, "v42")))
+	assert.Equal(t, 1, testutil.CollectAndCount(c.overBudget))
+	// Only the request that timed out is observed in the overshoot histogram.
+	assert.Equal(t, 1, testutil.CollectAndCount(c.overshoot))
+
+	_, err = New(reg, WithNamespace("test"))
+	assert.Error(t, err)
+}
+
+func TestCollector_Exemplar(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c, err := New(reg)
+	require.NoError(t, err)
+
+	c.Observe(timeout.Observation{
+		Pattern: "/slow",
+		Method:  http.MethodGet,
+		Timeout: time.Second,
+		Elapsed: time.Second,
+		Outcome: timeout.OutcomeTimedOut,
+		TraceID: "0102030000000000000000000000000",
+	})
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	exemplars := 0
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			var labels []*dto.LabelPair
+			if ctr := m.GetCounter(); ctr != nil {
+				labels = ctr.GetExemplar().GetLabel()
+			}
+			if h := m.GetHistogram(); h != nil {
+				for _, b := range h.GetBucket() {
+					if e := b.GetExemplar(); e != nil {
+						labels = e.GetLabel()
+					}
+				}
+			}
+			for _, l := range labels {
+				if l.GetName() == "trace_id" {
+					assert.Equal(t, "0102030000000000000000000000000", l.GetValue())
+					exemplars++
+				}
+			}
+		}
+	}
+	assert.Equal(t, 2, exemplars)
+}
+
+func TestRegisterBufferStats(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	stats := func() []timeout.BufferClassStats {
+		return []timeout.BufferClassStats{{Size: 4096, Hits: 3, Misses: 1}, {Hits: 0, Misses: 2}}
+	}
+	require.NoError(t, RegisterBufferStats(reg, stats, WithNamespace("test")))
+
+	expected := `
+# HELP test_fox_timeout_buffer_pool_gets_total Number of buffers looked up in a size class of the pool, by result.
+# TYPE test_fox_timeout_buffer_pool_gets_total counter
+test_fox_timeout_buffer_pool_gets_total{class="+Inf",result="hit"} 0
+test_fox_timeout_buffer_pool_gets_total{class="+Inf",result="miss"} 2
+test_fox_timeout_buffer_pool_gets_total{class="4096",result="hit"} 3
+test_fox_timeout_buffer_pool_gets_total{class="4096",result="miss"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected)))
+	assert.Error(t, RegisterBufferStats(reg, stats, WithNamespace("test")))
+}
+
+func TestCollector_Overshoot(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c, err := New(reg, WithOvershootBuckets([]float64{0.001, 0.01}))
+	require.NoError(t, err)
+
+	c.Observe(timeout.Observation{
+		Pattern:   "/slow",
+		Method:    http.MethodGet,
+		Timeout:   time.Second,
+		Elapsed:   time.Second,
+		Overshoot: 5 * time.Millisecond,
+		Outcome:   timeout.OutcomeTimedOut,
+	})
+	c.Observe(timeout.Observation{
+		Pattern: "/slow",
+		Method:  http.MethodGet,
+		Timeout: time.Second,
+		Elapsed: time.Millisecond,
+		Outcome: timeout.OutcomeCompleted,
+	})
+
+	expected := `
+# HELP fox_timeout_overshoot_seconds Time elapsed between the deadline and the timeout response of the requests that timed out.
+# TYPE fox_timeout_overshoot_seconds histogram
+fox_timeout_overshoot_seconds_bucket{method="GET",pattern="/slow",version="",le="0.001"} 0
+fox_timeout_overshoot_seconds_bucket{method="GET",pattern="/slow",version="",le="0.01"} 1
+fox_timeout_overshoot_seconds_bucket{method="GET",pattern="/slow",version="",le="+Inf"} 1
+fox_timeout_overshoot_seconds_sum{method="GET",pattern="/slow",version=""} 0.005
+fox_timeout_overshoot_seconds_count{method="GET",pattern="/slow",version=""} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "fox_timeout_overshoot_seconds"))
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// recordSize is the size in bytes of a flight record slot: a sequence number, the record time and the deadline
+// (8 bytes each), the record kind and pattern length (1 byte each), and the pattern, truncated to fit.
+const (
+	recordSize       = 128
+	recordPatternMax = recordSize - 26
+)
+
+// ErrInvalidRecorder is returned by [ReadFlightRecorder] when the file is not a flight recorder file.
+var ErrInvalidRecorder = errors.New("invalid flight recorder file")
+
+// RecordKind identifies what a [FlightRecord] is about.
+type RecordKind uint8
+
+const (
+	// RecordStart is recorded when a handler starts under a timeout.
+	RecordStart RecordKind = iota + 1
+	// RecordDone is recorded when a handler completes within its deadline.
+	RecordDone
+	// RecordTimeout is recorded when a handler exceeds its deadline.
+	RecordTimeout
+)
+
+// String returns the name of the record kind.
+func (k RecordKind) String() string {
+	switch k {
+	case RecordStart:
+		return "start"
+	case RecordDone:
+		return "done"
+	case RecordTimeout:
+		return "timeout"
+	default:
+		return "unknown"
+	}
+}
+
+// FlightRecord is an entry of a [FlightRecorder].
+type FlightRecord struct {
+	// Time is when the record was written.
+	Time time.Time
+	// Deadline is the handler deadline.
+	Deadline time.Time
+	// Pattern is the matched route pattern, if any, rewritten by the [WithRouteNormalizer] function. Patterns
+	// longer than 102 bytes are truncated.
+	Pattern string
+	// Seq is the sequence number of the record, starting at 1.
+	Seq uint64
+	// Kind is the kind of record.
+	Kind RecordKind
+}
+
+// FlightRecorder keeps the most recent request start, completion and timeout records in a fixed size ring backed
+// by a file. Each record is written to the file as it happens, so the ring survives a crash of the process (e.g. an
+// OOM kill or a SIGKILL during a timeout storm), and a post-mortem can reconstruct which requests were in flight
+// with [ReadFlightRecorder]: a request with a start record but no matching completion or timeout record was still
+// running. Records are not synced to the storage device, so they do not survive a crash of the machine.
+//
+// Recording costs a write system call per record. A FlightRecorder is safe for concurrent use and may be shared by
+// several middleware. See [WithFlightRecorder].
+type FlightRecorder struct {
+	f     *os.File
+	seq   atomic.Uint64
+	slots uint64
+}
+
+// NewFlightRecorder creates a [FlightRecorder] that keeps the last slots records in the file at path. Any existing
+// file at path is truncated, so the records of a previous process should be read with [ReadFlightRecorder] (or the
+// file moved away) before creating the recorder.
+func NewFlightRecorder(path string, slots int) (*FlightRecorder, error) {
+	if slots <= 0 {
+		return nil, fmt.Errorf("timeout: invalid flight recorder size %d", slots)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(slots) * recordSize); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &FlightRecorder{f: f, slots: uint64(slots)}, nil
+}
+
+// Close closes the underlying file. Records written after Close are dropped.
+func (r *FlightRecorder) Close() error {
+	return r.f.Close()
+}
+
+func (r *FlightRecorder) record(kind RecordKind, pattern string, deadline time.Time) {
+	var buf [recordSize]byte
+	seq := r.seq.Add(1)
+	binary.LittleEndian.PutUint64(buf[0:], seq)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(deadline.UnixNano()))
+	buf[24] = byte(kind)
+	buf[25] = byte(copy(buf[26:], pattern))
+	// Errors are intentionally ignored: the recorder is a best effort diagnostic tool and must never fail a request.
+	_, _ = r.f.WriteAt(buf[:], int64((seq-1)%r.slots)*recordSize)
+}
+
+// ReadFlightRecorder reads the records of the flight recorder file at path, ordered from the oldest to the most
+// recent. It is typically called at startup, before [NewFlightRecorder], to recover the records of a crashed process.
+func ReadFlightRecorder(path string) ([]FlightRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%recordSize != 0 {
+		return nil, ErrInvalidRecorder
+	}
+
+	records := make([]FlightRecord, 0, len(data)/recordSize)
+	for off := 0; off < len(data); off += recordSize {
+		slot := data[off : off+recordSize]
+		seq := binary.LittleEndian.Uint64(slot[0:])
+		if seq == 0 {
+			continue
+		}
+		n := int(slot[25])
+		if n > recordPatternMax {
+			return nil, ErrInvalidRecorder
+		}
+		records = append(records, FlightRecord{
+			Seq:      seq,
+			Time:     time.Unix(0, int64(binary.LittleEndian.Uint64(slot[8:]))),
+			Deadline: time.Unix(0, int64(binary.LittleEndian.Uint64(slot[16:]))),
+			Kind:     RecordKind(slot[24]),
+			Pattern:  string(slot[26 : 26+n]),
+		})
+	}
+	slices.SortFunc(records, func(a, b FlightRecord) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	return records, nil
+}
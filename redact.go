@@ -0,0 +1,119 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// Redacted is the value that replaces redacted header and query parameter values in diagnostic outputs.
+const Redacted = "[REDACTED]"
+
+var (
+	defaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+	defaultRedactedQuery   = []string{"access_token"}
+)
+
+type redactor struct {
+	headers map[string]struct{}
+	query   map[string]struct{}
+	fields  map[string]struct{}
+}
+
+func newRedactor() *redactor {
+	r := &redactor{
+		headers: make(map[string]struct{}),
+		query:   make(map[string]struct{}),
+	}
+	r.add(defaultRedactedHeaders, defaultRedactedQuery)
+	return r
+}
+
+func (r *redactor) add(headers, queryParams []string) {
+	for _, h := range headers {
+		r.headers[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	for _, q := range queryParams {
+		r.query[q] = struct{}{}
+	}
+}
+
+func (r *redactor) addFields(fields []string) {
+	if r.fields == nil {
+		r.fields = make(map[string]struct{}, len(fields))
+	}
+	for _, f := range fields {
+		r.fields[f] = struct{}{}
+	}
+}
+
+// header returns a copy of h where the values of the redacted headers are replaced by [Redacted].
+func (r *redactor) header(h http.Header) http.Header {
+	cp := h.Clone()
+	for k, v := range cp {
+		if _, ok := r.headers[k]; ok {
+			cp[k] = redactValues(v)
+		}
+	}
+	return cp
+}
+
+// values returns a copy of v where the values of the redacted query parameters are replaced by [Redacted].
+func (r *redactor) values(v url.Values) url.Values {
+	cp := make(url.Values, len(v))
+	for k, vv := range v {
+		if _, ok := r.query[k]; ok {
+			cp[k] = redactValues(vv)
+			continue
+		}
+		cp[k] = append([]string(nil), vv...)
+	}
+	return cp
+}
+
+func redactValues(v []string) []string {
+	redacted := make([]string, len(v))
+	for i := range redacted {
+		redacted[i] = Redacted
+	}
+	return redacted
+}
+
+// marshal returns the encoding of v with m, where the values of the redacted fields are replaced by [Redacted]. To
+// apply to any Marshaler, v is first converted to its generic JSON representation, unless no field is redacted.
+func (r *redactor) marshal(v any, m Marshaler) ([]byte, error) {
+	if len(r.fields) == 0 {
+		return m.Marshal(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	return m.Marshal(r.redactTree(tree))
+}
+
+func (r *redactor) redactTree(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if _, ok := r.fields[k]; ok {
+				v[k] = Redacted
+				continue
+			}
+			v[k] = r.redactTree(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = r.redactTree(e)
+		}
+	}
+	return v
+}
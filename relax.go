@@ -0,0 +1,190 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// RelaxedRoute is a route whose timeout is no longer enforced, see [WithAutoRelax].
+type RelaxedRoute struct {
+	// Since is when the timeout of the route was relaxed.
+	Since time.Time
+	// Pattern is the route pattern, rewritten by the [WithRouteNormalizer] function.
+	Pattern string
+	// Ratio is the ratio of the requests of the route that timed out during the window that relaxed it.
+	Ratio float64
+	// Requests is the number of requests served since the route was relaxed, and Exceeded the number of them that
+	// ran for longer than their timeout, and would have timed out.
+	Requests uint64
+	Exceeded uint64
+}
+
+// autoRelax stops enforcing the timeout of the routes timing out too often, see WithAutoRelax.
+type autoRelax struct {
+	routes      sync.Map
+	ratio       float64
+	window      time.Duration
+	minRequests uint64
+}
+
+// relaxRoute is the state of a route of autoRelax.
+type relaxRoute struct {
+	relaxed atomic.Bool
+	// requests and exceeded are the requests served since the route was relaxed, see RelaxedRoute.
+	requests atomic.Uint64
+	exceeded atomic.Uint64
+	mu       sync.Mutex
+	// start is the start of the current window, and total and timedOut the requests it counted.
+	start    time.Time
+	total    uint64
+	timedOut uint64
+	// since and ratio describe the relaxation of the route, see RelaxedRoute.
+	since time.Time
+	ratio float64
+}
+
+// WithAutoRelax is a safety valve against badly chosen timeouts: when more than ratio of the requests of a route
+// (e.g. 0.5) time out during a window of the given duration, and the window counts at least minRequests requests, the
+// timeout of the route is no longer enforced, instead of failing most of its traffic. The route is then served in
+// shadow mode: its handlers run to completion, and the requests that would have timed out are only counted, see
+// [Timeout.Relaxed]. An [EventRelaxed] is emitted when a route is relaxed, so that the operators can be alerted, fix
+// the timeout, and re-arm the route with [Timeout.Rearm]. The requests canceled by the client are not counted. A ratio
+// outside of (0, 1) or a window <= 0 disables the policy, which is the default.
+func WithAutoRelax(ratio float64, window time.Duration, minRequests int) Option {
+	return optionFunc(func(c *config) {
+		if !(ratio > 0 && ratio < 1) || window <= 0 {
+			c.relax = nil
+			return
+		}
+		c.relax = &autoRelax{ratio: ratio, window: window, minRequests: uint64(max(minRequests, 1))}
+	})
+}
+
+// route returns the state of the route of the given pattern key.
+func (a *autoRelax) route(key string) *relaxRoute {
+	r, ok := a.routes.Load(key)
+	if !ok {
+		r, _ = a.routes.LoadOrStore(key, new(relaxRoute))
+	}
+	return r.(*relaxRoute)
+}
+
+// relaxed returns the state of the route of the given pattern key if it is relaxed, or nil otherwise.
+func (a *autoRelax) relaxed(key string) *relaxRoute {
+	r, ok := a.routes.Load(key)
+	if !ok || !r.(*relaxRoute).relaxed.Load() {
+		return nil
+	}
+	return r.(*relaxRoute)
+}
+
+// observe records the outcome of a request of the route of the given pattern key, served under its timeout, and
+// reports whether the route got relaxed as a result. The ratio of a window is assessed once it is over.
+func (a *autoRelax) observe(key string, timedOut bool, now time.Time) bool {
+	r := a.route(key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.relaxed.Load() {
+		return false
+	}
+	if r.start.IsZero() {
+		r.start = now
+	}
+	if now.Sub(r.start) >= a.window {
+		ratio := float64(r.timedOut) / float64(max(r.total, 1))
+		relax := r.total >= a.minRequests && ratio > a.ratio
+		r.start, r.total, r.timedOut = now, 0, 0
+		if relax {
+			r.since, r.ratio = now, ratio
+			r.requests.Store(0)
+			r.exceeded.Store(0)
+			r.relaxed.Store(true)
+			return true
+		}
+	}
+	r.total++
+	if timedOut {
+		r.timedOut++
+	}
+	return false
+}
+
+// rearm enforces the timeout of the route again, starting a new window.
+func (r *relaxRoute) rearm() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.relaxed.Store(false)
+	r.start, r.total, r.timedOut = time.Time{}, 0, 0
+}
+
+// shadow serves the request of a relaxed route without enforcing its timeout dt, counting whether it exceeded dt.
+func (t *Timeout) shadow(c *fox.Context, next fox.HandlerFunc, r *relaxRoute, dt time.Duration) {
+	start := t.now()
+	defer func() {
+		r.requests.Add(1)
+		if t.since(start) > dt {
+			r.exceeded.Add(1)
+		}
+	}()
+	t.passthrough(c, next)
+}
+
+// Relaxed returns the routes whose timeout is no longer enforced, see [WithAutoRelax], sorted by pattern. It returns
+// nil if the middleware is not configured with [WithAutoRelax].
+func (t *Timeout) Relaxed() []RelaxedRoute {
+	a := t.cfg.relax
+	if a == nil {
+		return nil
+	}
+	var routes []RelaxedRoute
+	a.routes.Range(func(key, value any) bool {
+		r := value.(*relaxRoute)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.relaxed.Load() {
+			routes = append(routes, RelaxedRoute{
+				Pattern:  key.(string),
+				Since:    r.since,
+				Ratio:    r.ratio,
+				Requests: r.requests.Load(),
+				Exceeded: r.exceeded.Load(),
+			})
+		}
+		return true
+	})
+	slices.SortFunc(routes, func(a, b RelaxedRoute) int {
+		return cmp.Compare(a.Pattern, b.Pattern)
+	})
+	return routes
+}
+
+// Rearm enforces again the timeout of the given route patterns, or of every route if none is given, after they were
+// relaxed by the policy set with [WithAutoRelax], typically once their timeout is fixed. The ratio of their timeouts
+// is then assessed afresh. The patterns are normalized with the function set with [WithRouteNormalizer], if any.
+func (t *Timeout) Rearm(patterns ...string) {
+	a := t.cfg.relax
+	if a == nil {
+		return
+	}
+	if len(patterns) == 0 {
+		a.routes.Range(func(_, value any) bool {
+			value.(*relaxRoute).rearm()
+			return true
+		})
+		return
+	}
+	for _, pattern := range patterns {
+		if r, ok := a.routes.Load(t.patternKey(pattern)); ok {
+			r.(*relaxRoute).rearm()
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoRelax_Observe(t *testing.T) {
+	a := &autoRelax{ratio: 0.5, window: time.Minute, minRequests: 4}
+	now := time.Now()
+
+	// Too few requests in the window.
+	assert.False(t, a.observe("/foo", true, now))
+	assert.False(t, a.observe("/foo", true, now.Add(time.Second)))
+	assert.False(t, a.observe("/foo", true, now.Add(time.Minute)))
+	assert.Nil(t, a.relaxed("/foo"))
+
+	// Not enough timeouts in the window.
+	for i := range 4 {
+		assert.False(t, a.observe("/foo", i == 0, now.Add(time.Minute+time.Duration(i)*time.Second)))
+	}
+	assert.False(t, a.observe("/foo", true, now.Add(2*time.Minute)))
+	assert.Nil(t, a.relaxed("/foo"))
+
+	for i := range 4 {
+		assert.False(t, a.observe("/foo", i > 0, now.Add(2*time.Minute+time.Duration(i)*time.Second)))
+	}
+	assert.True(t, a.observe("/foo", false, now.Add(3*time.Minute)))
+	r := a.relaxed("/foo")
+	require.NotNil(t, r)
+	assert.InDelta(t, 0.8, r.ratio, 1e-9)
+	assert.False(t, a.observe("/foo", true, now.Add(4*time.Minute)))
+
+	r.rearm()
+	assert.Nil(t, a.relaxed("/foo"))
+}
+
+func TestMiddleware_WithAutoRelax(t *testing.T) {
+	var events []Event
+	tm := New(5*time.Millisecond,
+		WithAutoRelax(0.5, 50*time.Millisecond, 2),
+		WithEventSink(EventSinkFunc(func(e Event) { events = append(events, e) })),
+	)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		time.Sleep(20 * time.Millisecond)
+		c.Writer().WriteHeader(http.StatusOK)
+	})
+	serve := func() int {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		return w.Code
+	}
+
+	for range 3 {
+		assert.Equal(t, http.StatusServiceUnavailable, serve())
+	}
+	assert.Empty(t, tm.Relaxed())
+	time.Sleep(60 * time.Millisecond)
+	// The request assessing the window is still served under the timeout.
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	require.NotEmpty(t, events)
+	assert.Equal(t, EventRelaxed, events[len(events)-1].Kind)
+	assert.Equal(t, "/slow", events[len(events)-1].Pattern)
+
+	assert.Equal(t, http.StatusOK, serve())
+	relaxed := tm.Relaxed()
+	require.Len(t, relaxed, 1)
+	assert.Equal(t, "/slow", relaxed[0].Pattern)
+	assert.Equal(t, 1.0, relaxed[0].Ratio)
+	assert.Equal(t, uint64(1), relaxed[0].Requests)
+	assert.Equal(t, uint64(1), relaxed[0].Exceeded)
+
+	tm.Rearm("/slow")
+	assert.Empty(t, tm.Relaxed())
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+}
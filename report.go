@@ -0,0 +1,185 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// reportSlots is the number of time slots the retention of the report history is divided into.
+	reportSlots = 60
+	// latencyBuckets is the number of buckets of the latency histograms. The upper bound of bucket i is
+	// latencyBase * latencyGrowth^i, which covers latencies from 100µs to about 2 minutes with a relative error of at
+	// most 10%.
+	latencyBuckets = 150
+	latencyBase    = 100 * time.Microsecond
+	latencyGrowth  = 1.1
+)
+
+// Report is a summary of the requests served under a timeout over a period, as returned by [Timeout.Report]. It is
+// intended for periodic export, e.g. to a BI pipeline or for SLA reviews, and can be marshalled to JSON.
+type Report struct {
+	// Start is the start of the period covered by the report.
+	Start time.Time `json:"start"`
+	// End is the end of the period covered by the report.
+	End time.Time `json:"end"`
+	// Routes holds the summary of each route pattern that served at least one request during the period, sorted by
+	// pattern.
+	Routes []RouteReport `json:"routes"`
+}
+
+// RouteReport is the summary of the requests served under a timeout for a route pattern, rewritten by the
+// [WithRouteNormalizer] function. Percentiles are approximated within 10%.
+type RouteReport struct {
+	// Pattern is the route pattern.
+	Pattern string `json:"pattern"`
+	// Requests is the number of requests served.
+	Requests uint64 `json:"requests"`
+	// Timeouts is the number of requests whose handler exceeded its deadline.
+	Timeouts uint64 `json:"timeouts"`
+	// P50 is the median time spent in the handler.
+	P50 Duration `json:"p50"`
+	// P95 is the 95th percentile of the time spent in the handler.
+	P95 Duration `json:"p95"`
+	// P99 is the 99th percentile of the time spent in the handler.
+	P99 Duration `json:"p99"`
+	// BudgetUtilization is the mean of the time spent in the handler relative to its timeout, where 1 means that
+	// the whole budget was used.
+	BudgetUtilization float64 `json:"budgetUtilization"`
+}
+
+type reportSlot struct {
+	// epoch is the index of the time slot since the Unix epoch, the slot is stale if it does not match the current
+	// one.
+	epoch       int64
+	requests    uint64
+	timeouts    uint64
+	utilization float64
+	latency     [latencyBuckets]uint32
+}
+
+// routeHistory is a ring of time slots recording the requests of a route over the report retention.
+type routeHistory struct {
+	mu    sync.Mutex
+	slots [reportSlots]reportSlot
+}
+
+func (h *routeHistory) record(epoch int64, elapsed, dt time.Duration, timedOut bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &h.slots[epoch%reportSlots]
+	if s.epoch != epoch {
+		*s = reportSlot{epoch: epoch}
+	}
+	s.requests++
+	if timedOut {
+		s.timeouts++
+	}
+	s.utilization += float64(elapsed) / float64(dt)
+	s.latency[latencyBucket(elapsed)]++
+}
+
+// summarize merges the slots from the epoch first to the epoch last included.
+func (h *routeHistory) summarize(pattern string, first, last int64) (RouteReport, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rr := RouteReport{Pattern: pattern}
+	var (
+		utilization float64
+		latency     [latencyBuckets]uint64
+	)
+	for i := range h.slots {
+		s := &h.slots[i]
+		if s.requests == 0 || s.epoch < first || s.epoch > last {
+			continue
+		}
+		rr.Requests += s.requests
+		rr.Timeouts += s.timeouts
+		utilization += s.utilization
+		for j, n := range s.latency {
+			latency[j] += uint64(n)
+		}
+	}
+	if rr.Requests == 0 {
+		return rr, false
+	}
+	rr.BudgetUtilization = utilization / float64(rr.Requests)
+	rr.P50 = Duration(percentile(&latency, rr.Requests, 0.50))
+	rr.P95 = Duration(percentile(&latency, rr.Requests, 0.95))
+	rr.P99 = Duration(percentile(&latency, rr.Requests, 0.99))
+	return rr, true
+}
+
+// Report returns a summary of the requests served under a timeout over the given period before now, by route
+// pattern. The history is only recorded if the middleware is configured with [WithReportRetention], and is kept in
+// time slots of a sixtieth of the retention: the period is rounded up to a whole number of slots, and capped at the
+// retention. The report is empty if the history is not recorded.
+func (t *Timeout) Report(period time.Duration) Report {
+	now := t.now()
+	rp := Report{End: now, Start: now, Routes: []RouteReport{}}
+	slot := t.reportSlot()
+	if slot <= 0 || period <= 0 {
+		return rp
+	}
+
+	n := min(int64((period+slot-1)/slot), reportSlots)
+	last := now.UnixNano() / int64(slot)
+	first := last - n + 1
+	rp.Start = time.Unix(0, first*int64(slot))
+	t.history.Range(func(key, value any) bool {
+		if rr, ok := value.(*routeHistory).summarize(key.(string), first, last); ok {
+			rp.Routes = append(rp.Routes, rr)
+		}
+		return true
+	})
+	slices.SortFunc(rp.Routes, func(a, b RouteReport) int {
+		return cmp.Compare(a.Pattern, b.Pattern)
+	})
+	return rp
+}
+
+// reportSlot returns the duration of a time slot of the report history, or zero if it is not recorded.
+func (t *Timeout) reportSlot() time.Duration {
+	return t.cfg.retention / reportSlots
+}
+
+// recordReport records a request of the given route pattern in the report history.
+func (t *Timeout) recordReport(pattern string, end time.Time, elapsed, dt time.Duration, timedOut bool) {
+	slot := t.reportSlot()
+	if slot <= 0 {
+		return
+	}
+	h, ok := t.history.Load(pattern)
+	if !ok {
+		h, _ = t.history.LoadOrStore(pattern, new(routeHistory))
+	}
+	h.(*routeHistory).record(end.UnixNano()/int64(slot), elapsed, dt, timedOut)
+}
+
+func latencyBucket(d time.Duration) int {
+	if d <= latencyBase {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(latencyBase)) / math.Log(latencyGrowth)))
+	return min(i, latencyBuckets-1)
+}
+
+// percentile returns the upper bound of the latency bucket holding the q-quantile of the total observations.
+func percentile(latency *[latencyBuckets]uint64, total uint64, q float64) time.Duration {
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, n := range latency {
+		seen += n
+		if seen >= rank {
+			return time.Duration(float64(latencyBase) * math.Pow(latencyGrowth, float64(i)))
+		}
+	}
+	return time.Duration(float64(latencyBase) * math.Pow(latencyGrowth, latencyBuckets-1))
+}
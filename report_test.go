@@ -0,0 +1,93 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout_Report(t *testing.T) {
+	tm := New(20*time.Millisecond, WithReportRetention(time.Hour))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/fast", success201response)
+
+	for range 4 {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	}
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	rp := tm.Report(time.Hour)
+	assert.WithinDuration(t, time.Now(), rp.End, time.Second)
+	assert.False(t, rp.Start.After(rp.End.Add(-time.Hour+time.Minute)))
+	require.Len(t, rp.Routes, 2)
+
+	fast := rp.Routes[0]
+	assert.Equal(t, "/fast", fast.Pattern)
+	assert.Equal(t, uint64(4), fast.Requests)
+	assert.Zero(t, fast.Timeouts)
+	assert.GreaterOrEqual(t, time.Duration(fast.P50), 10*time.Millisecond)
+	assert.Less(t, time.Duration(fast.P99), 20*time.Millisecond)
+	assert.Greater(t, fast.BudgetUtilization, 0.5)
+
+	slow := rp.Routes[1]
+	assert.Equal(t, "/slow", slow.Pattern)
+	assert.Equal(t, uint64(1), slow.Requests)
+	assert.Equal(t, uint64(1), slow.Timeouts)
+	assert.GreaterOrEqual(t, slow.BudgetUtilization, 1.0)
+
+	b, err := json.Marshal(rp)
+	require.NoError(t, err)
+	var decoded Report
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, rp.Routes, decoded.Routes)
+}
+
+func TestTimeout_ReportDisabled(t *testing.T) {
+	tm := New(20 * time.Millisecond)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/fast", success201response)
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+	assert.Empty(t, tm.Report(time.Hour).Routes)
+}
+
+func TestRouteHistory(t *testing.T) {
+	var h routeHistory
+	for i := range 100 {
+		h.record(10, time.Duration(i+1)*time.Millisecond, 100*time.Millisecond, i >= 90)
+	}
+	h.record(1, time.Second, time.Second, true)
+
+	rr, ok := h.summarize("/foo", 5, 10)
+	require.True(t, ok)
+	assert.Equal(t, uint64(100), rr.Requests)
+	assert.Equal(t, uint64(10), rr.Timeouts)
+	assert.InEpsilon(t, 0.505, rr.BudgetUtilization, 0.001)
+	assert.InEpsilon(t, 50*time.Millisecond, time.Duration(rr.P50), 0.1)
+	assert.InEpsilon(t, 95*time.Millisecond, time.Duration(rr.P95), 0.1)
+	assert.InEpsilon(t, 99*time.Millisecond, time.Duration(rr.P99), 0.1)
+
+	// The slot is reused once its epoch is over.
+	h.record(70, time.Millisecond, time.Second, false)
+	rr, ok = h.summarize("/foo", 5, 70)
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), rr.Requests)
+
+	_, ok = h.summarize("/foo", 71, 80)
+	assert.False(t, ok)
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// responseBudget returns the time allowed to write the timeout response of the request, see WithResponseBudget. The
+// budget of the fallback handler is added for the routes configured with OverrideFallbackRoute, since it is
+// dispatched by the response.
+func (t *Timeout) responseBudget(c *fox.Context) time.Duration {
+	budget := t.cfg.respBudget
+	if budget <= 0 {
+		return 0
+	}
+	if r := t.route(c.Route()); r != nil && !t.outer && r.Annotation(fKey{}) != nil {
+		budget += t.cfg.fallbackBudget
+	}
+	if t.cfg.debugger != nil {
+		budget = t.cfg.debugger.stretch(budget)
+	}
+	return budget
+}
+
+// respondBounded calls respond on its own goroutine with a buffered writer and a context expiring after budget. The
+// response is written to the client once respond returns, or replaced by DefaultResponse if respond does not return
+// in time, so that a slow response handler cannot hang the request. A panic in respond is propagated, unless it
+// occurs after the budget is exceeded, in which case it is logged.
+func (t *Timeout) respondBounded(c *fox.Context, respond fox.HandlerFunc, budget time.Duration) {
+	start := t.now()
+	req := c.Request()
+	ctx, cancel := withDeadlineCause(t.cfg.clock, req.Context(), start.Add(budget), ErrTimeout)
+	defer cancel()
+	// The budget is enforced with a timer rather than with the context, which is also done when the client goes
+	// away, in which case the response handler is still expected to complete.
+	timer := t.cfg.clock.NewTimer(budget)
+	defer timer.Stop()
+
+	w := c.Writer()
+	buf := t.getBuffer()
+	tw := &timeoutWriter{
+		w:       w,
+		headers: w.Header().Clone(),
+		req:     req,
+		code:    http.StatusOK,
+		buf:     buf,
+		ctx:     ctx,
+		dt:      budget,
+		t:       t,
+		start:   start,
+		pattern: c.Pattern(),
+	}
+	if tw.headers == nil {
+		tw.headers = make(http.Header)
+	}
+	cp := c.CloneWith(tw, req.WithContext(ctx))
+
+	done := make(chan struct{})
+	panicChan := make(chan any, 1)
+	go func() {
+		defer func() {
+			cp.Close()
+			if p := recover(); p != nil {
+				if !tw.arb.Abandoned() {
+					panicChan <- p
+				} else if p != http.ErrAbortHandler {
+					log.Printf("timeout: panic writing the timeout response for %s after its budget: %v\n%s", req.URL.Path, p, debug.Stack())
+				}
+			}
+		}()
+		respond(cp)
+		close(done)
+	}()
+
+	// commit writes the response of the response handler, once it is done.
+	commit := func() {
+		// The response handler is done, so nothing else can settle the arbiter.
+		tw.arb.Commit()
+		defer tw.arb.Release()
+		defer t.putBuffer(buf)
+		maps.Copy(w.Header(), tw.headers)
+		if tw.written {
+			w.WriteHeader(tw.code)
+			tw.writeBodyLocked(w)
+		}
+	}
+
+	select {
+	case p := <-panicChan:
+		t.putBuffer(buf)
+		panic(p)
+	case <-done:
+		commit()
+	case <-timer.C():
+		select {
+		case <-done:
+			// The response handler returned meanwhile.
+			commit()
+			return
+		default:
+		}
+		tw.arb.Abandon(http.ErrHandlerTimeout)
+		tw.arb.Release()
+		cancel()
+		// The buffer may still be written by the response handler, so it is not returned to the pool.
+		log.Printf("timeout: the timeout response for %s exceeded its %s budget", req.URL.Path, budget)
+		DefaultResponse(c)
+	}
+}
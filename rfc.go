@@ -0,0 +1,117 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// hopByHopHeaders are the headers that are meaningful only for a single transport-level connection, and must not
+// be sent by an origin server (RFC 9110, section 7.6.1).
+var hopByHopHeaders = []string{
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type rfcCompliance struct {
+	retryAfter string
+}
+
+// respond calls respond with a writer enforcing the compliance rules.
+func (rc *rfcCompliance) respond(c *fox.Context, respond fox.HandlerFunc) {
+	rw := &rfcWriter{
+		ResponseWriter: c.Writer(),
+		retryAfter:     rc.retryAfter,
+		head:           c.Method() == http.MethodHead,
+	}
+	cp := c.CloneWith(rw, c.Request())
+	defer cp.Close()
+	respond(cp)
+}
+
+// rfcWriter is a fox.ResponseWriter that fixes up the timeout response before it is written.
+type rfcWriter struct {
+	fox.ResponseWriter
+	retryAfter string
+	head       bool
+}
+
+func (w *rfcWriter) WriteHeader(code int) {
+	if !w.Written() {
+		w.prepare(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *rfcWriter) Write(p []byte) (int, error) {
+	if !w.Written() {
+		if _, ok := w.Header()["Content-Type"]; !ok && len(p) > 0 && !w.head {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.head {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *rfcWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *rfcWriter) ReadFrom(src io.Reader) (int64, error) {
+	bufPtr := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufPtr)
+	return io.CopyBuffer(onlyWrite{w}, src, *bufPtr)
+}
+
+func (w *rfcWriter) prepare(code int) {
+	h := w.Header()
+	if (code == http.StatusServiceUnavailable || code == http.StatusTooManyRequests) && h.Get("Retry-After") == "" {
+		h.Set("Retry-After", w.retryAfter)
+	}
+	stripHopByHop(h)
+}
+
+// stripHopByHop removes the hop-by-hop headers from h, including the ones listed in the Connection header. The
+// "Connection: close" header is kept, since it is the standard way for a server to close the connection.
+func stripHopByHop(h http.Header) {
+	closeConn := false
+	for _, v := range h.Values("Connection") {
+		for token := range strings.SplitSeq(v, ",") {
+			token = textproto.TrimString(token)
+			if strings.EqualFold(token, "close") {
+				closeConn = true
+				continue
+			}
+			if token != "" {
+				h.Del(token)
+			}
+		}
+	}
+	h.Del("Connection")
+	if closeConn {
+		h.Set("Connection", "close")
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+func formatRetryAfter(seconds int64) string {
+	return strconv.FormatInt(max(seconds, 1), 10)
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithRFCCompliance(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Millisecond, WithRFCCompliance(1500*time.Millisecond), WithResponse(func(c *fox.Context) {
+		c.SetHeader("Connection", "X-Hop, keep-alive")
+		c.SetHeader("Keep-Alive", "timeout=5")
+		c.SetHeader("Upgrade", "h2c")
+		c.SetHeader("X-Hop", "foo")
+		c.SetHeader("X-End", "bar")
+		c.Writer().WriteHeader(http.StatusServiceUnavailable)
+		_, _ = c.Writer().Write([]byte("<html><body>unavailable</body></html>"))
+	}))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+	f.MustAdd(fox.MethodHead, "/foo", success201response)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, "bar", w.Header().Get("X-End"))
+	for _, name := range []string{"Connection", "Keep-Alive", "Upgrade", "X-Hop"} {
+		assert.Empty(t, w.Header().Values(name), name)
+	}
+	assert.Equal(t, "<html><body>unavailable</body></html>", w.Body.String())
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/foo", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestStripHopByHop(t *testing.T) {
+	h := http.Header{
+		"Connection":        {"close, X-Foo"},
+		"X-Foo":             {"foo"},
+		"Transfer-Encoding": {"chunked"},
+		"Content-Type":      {"text/plain"},
+	}
+	stripHopByHop(h)
+	assert.Equal(t, http.Header{"Connection": {"close"}, "Content-Type": {"text/plain"}}, h)
+}
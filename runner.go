@@ -0,0 +1,82 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"sync"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// plan is the handler serving the requests of a route, resolved once for the route, see run.
+type plan struct {
+	// applied are the rules the plan was resolved with, see Timeout.Apply.
+	applied *appliedRules
+	h       fox.HandlerFunc
+}
+
+// run returns the handler applying the timeout logic to next. The router applies the middleware once per route, but
+// without telling which, so the mode of the route is resolved from its annotations and the configuration on its
+// first request, and the handler of the mode is kept for the next requests: a route whose requests are always passed
+// through is served with a single function call, instead of branching on every request. The plans are kept by
+// route, since a handler may serve several routes, e.g. when shared by the handlers of unmatched requests, and
+// resolved again if other rules are applied with Apply.
+func (t *Timeout) run(next fox.HandlerFunc) fox.HandlerFunc {
+	timed := t.handle(next)
+	bypass := func(c *fox.Context) {
+		t.passthrough(c, next)
+	}
+	var plans sync.Map // *fox.Route -> *plan
+	return func(c *fox.Context) {
+		if s := t.cfg.handlerScope; s != 0 && c.Scope()&s == 0 {
+			next(c)
+			return
+		}
+		r := c.Route()
+		applied := t.applied.Load()
+		v, ok := plans.Load(r)
+		if !ok || v.(*plan).applied != applied {
+			p := &plan{applied: applied, h: timed}
+			if t.bypassable(t.route(r)) {
+				p.h = bypass
+			}
+			plans.Store(r, p)
+			v = p
+		}
+		v.(*plan).h(c)
+	}
+}
+
+// bypassable reports whether the requests of route r are passed through to the handler whatever the request, i.e.
+// whether the route disables its timeout with NoTimeout, and neither the configuration nor the annotations of the
+// route can set a timeout, a connection deadline or a writer for some of its requests.
+func (t *Timeout) bypassable(r *fox.Route) bool {
+	if r == nil || t.outer {
+		return false
+	}
+	if dt, ok := unwrapRouteTimeout(r, hKey{}); !ok || dt > 0 {
+		return false
+	}
+	for m := range r.Methods() {
+		if dt, ok := unwrapRouteTimeout(r, methodKey{method: m}); ok && dt > 0 {
+			return false
+		}
+	}
+	cfg := t.cfg
+	if cfg.headerTimeout != nil || cfg.resolver != nil || cfg.flags != nil || cfg.policy != nil ||
+		cfg.scopeTimeouts != nil || cfg.requestAge != nil || cfg.unbounded != nil || len(cfg.bypass) > 0 ||
+		((cfg.trusted != nil || cfg.trustedNets != nil) && r.Annotation(tKey{}) != nil) || r.Annotation(cKey{}) != nil {
+		return false
+	}
+	if cfg.readDeadline > 0 || cfg.writeDeadline > 0 || cfg.idleWrite > 0 || cfg.bodyTimeout > 0 {
+		return false
+	}
+	for _, k := range [...]any{rKey{}, wKey{}, iKey{}, pKey{}, dKey{}} {
+		if _, ok := unwrapRouteTimeout(r, k); ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout_Bypassable(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []Option
+		ropt []fox.RouteOption
+		want bool
+	}{
+		{name: "no timeout", ropt: []fox.RouteOption{OverrideHandler(NoTimeout)}, want: true},
+		{name: "global timeout"},
+		{name: "route timeout", ropt: []fox.RouteOption{OverrideHandler(time.Second)}},
+		{
+			name: "method timeout",
+			ropt: []fox.RouteOption{OverrideHandler(NoTimeout), OverrideMethod(http.MethodGet, time.Second)},
+		},
+		{
+			name: "header timeout",
+			opts: []Option{WithHeaderTimeout("X-Timeout", 0, time.Second)},
+			ropt: []fox.RouteOption{OverrideHandler(NoTimeout)},
+		},
+		{
+			name: "trusted timeout",
+			opts: []Option{WithTrustedBypass(func(*http.Request) bool { return true })},
+			ropt: []fox.RouteOption{OverrideHandler(NoTimeout), OverrideTrusted(time.Second)},
+		},
+		{
+			name: "write deadline",
+			ropt: []fox.RouteOption{OverrideHandler(NoTimeout), OverrideWrite(time.Second)},
+		},
+		{
+			name: "default read deadline",
+			opts: []Option{WithReadDeadline(time.Second)},
+			ropt: []fox.RouteOption{OverrideHandler(NoTimeout)},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter()
+			require.NoError(t, err)
+			r := f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {}, tc.ropt...)
+			assert.Equal(t, tc.want, New(time.Second, tc.opts...).bypassable(r))
+		})
+	}
+}
+
+func TestMiddleware_Plan(t *testing.T) {
+	var writers []http.ResponseWriter
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second)))
+	require.NoError(t, err)
+	handler := func(c *fox.Context) {
+		writers = append(writers, c.Writer())
+		_ = c.String(http.StatusOK, "ok")
+	}
+	f.MustAdd(fox.MethodGet, "/bypass", handler, OverrideHandler(NoTimeout))
+	f.MustAdd(fox.MethodGet, "/timed", handler)
+
+	for range 2 {
+		for _, path := range []string{"/bypass", "/timed"} {
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+	}
+	require.Len(t, writers, 4)
+	for i, w := range writers {
+		_, wrapped := w.(*timeoutWriter)
+		assert.Equal(t, i%2 == 1, wrapped)
+	}
+}
+
+func TestMiddleware_PlanSharedHandler(t *testing.T) {
+	tm := New(time.Second)
+	// The same handler serves both routes, as the handlers of unmatched requests do.
+	h := tm.run(func(c *fox.Context) {})
+	f, err := fox.NewRouter()
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/a", h, OverrideHandler(NoTimeout))
+	f.MustAdd(fox.MethodGet, "/b", h, OverrideHandler(NoTimeout))
+
+	w := httptest.NewRecorder()
+	reqA := httptest.NewRequest(http.MethodGet, "/a", nil)
+	reqB := httptest.NewRequest(http.MethodGet, "/b", nil)
+	same := testing.AllocsPerRun(100, func() {
+		f.ServeHTTP(w, reqA)
+		f.ServeHTTP(w, reqA)
+	})
+	// The plan of each route is kept, so that alternating between the routes does not resolve them again.
+	alternating := testing.AllocsPerRun(100, func() {
+		f.ServeHTTP(w, reqA)
+		f.ServeHTTP(w, reqB)
+	})
+	assert.Equal(t, same, alternating)
+}
@@ -0,0 +1,241 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// selfCheckTimeout is the timeout of the route timing out during the self-check, and selfCheckSlack how late its
+// timeout response may be before it is reported.
+const (
+	selfCheckTimeout = 20 * time.Millisecond
+	selfCheckSlack   = time.Second
+)
+
+// Severity is the severity of a [Finding].
+type Severity uint8
+
+const (
+	// Warning is a finding degrading some features of the middleware, which still enforces the handler timeouts.
+	Warning Severity = iota + 1
+	// Error is a finding preventing the middleware from enforcing the handler timeouts.
+	Error
+)
+
+// String returns the name of the severity.
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Finding is a problem found by [Timeout.SelfCheck].
+type Finding struct {
+	// Check is the name of the check that failed: "pool", "clock", "listener", "writer", "deadline" or "timeout".
+	Check    string
+	Message  string
+	Severity Severity
+}
+
+// String returns the finding in the form "severity: check: message".
+func (f Finding) String() string {
+	return f.Severity.String() + ": " + f.Check + ": " + f.Message
+}
+
+// Findings are the findings of [Timeout.SelfCheck].
+type Findings []Finding
+
+// Err returns an error listing the findings of severity [Error], or nil if there is none.
+func (fs Findings) Err() error {
+	var msgs []string
+	for _, f := range fs {
+		if f.Severity == Error {
+			msgs = append(msgs, f.Check+": "+f.Message)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("timeout: self-check failed: %s", strings.Join(msgs, "; "))
+}
+
+// SelfCheck exercises the buffer pool, the clock, the writer wrapping and the connection deadlines of t against a
+// loopback listener, and returns the problems found, if any. It is intended to be run during the service startup, to
+// fail fast on a misconfiguration, typically with:
+//
+//	if err := t.SelfCheck(ctx).Err(); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// The checks are run through the middleware of t, with the same options, so the few requests served by SelfCheck
+// are observed, counted and reported like any other. The connection deadlines are only checked against the loopback
+// listener, which may support features that the listener of the application does not. SelfCheck is bounded by
+// ctx: if ctx is done before the checks complete, the remaining checks are reported as failed.
+func (t *Timeout) SelfCheck(ctx context.Context) Findings {
+	var fs Findings
+	fs = append(fs, t.checkPool()...)
+	clock := t.checkClock(ctx)
+	fs = append(fs, clock...)
+	fs = append(fs, t.checkServe(ctx, clock.Err() == nil)...)
+	return fs
+}
+
+// checkPool checks that the buffer pool hands out distinct, non-nil buffers.
+func (t *Timeout) checkPool() Findings {
+	a, b := t.cfg.pool.Get(), t.cfg.pool.Get()
+	switch {
+	case a == nil || b == nil:
+		return Findings{{Check: "pool", Message: "the buffer pool returns nil buffers", Severity: Error}}
+	case a == b:
+		return Findings{{Check: "pool", Message: "the buffer pool hands out a buffer in use", Severity: Error}}
+	}
+	t.putBuffer(a)
+	t.putBuffer(b)
+	return nil
+}
+
+// checkClock checks that the clock is in sync with the wall clock, and that its timers fire.
+func (t *Timeout) checkClock(ctx context.Context) Findings {
+	var fs Findings
+	if drift := t.now().Sub(time.Now()).Abs(); drift > selfCheckSlack {
+		fs = append(fs, Finding{
+			Check:    "clock",
+			Message:  fmt.Sprintf("the clock drifts from the wall clock by %s", drift),
+			Severity: Warning,
+		})
+	}
+	timer := t.cfg.clock.NewTimer(time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+	case <-time.After(selfCheckSlack):
+		fs = append(fs, Finding{Check: "clock", Message: "the timers of the clock do not fire", Severity: Error})
+	case <-ctx.Done():
+		fs = append(fs, Finding{Check: "clock", Message: "interrupted: " + ctx.Err().Error(), Severity: Error})
+	}
+	return fs
+}
+
+// checkServe serves a request completing in time and, if timers is true, a request timing out, through the
+// middleware of t behind a loopback listener.
+func (t *Timeout) checkServe(ctx context.Context, timers bool) Findings {
+	var (
+		mu sync.Mutex
+		fs Findings
+	)
+	report := func(f Finding) {
+		mu.Lock()
+		fs = append(fs, f)
+		mu.Unlock()
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return Findings{{Check: "listener", Message: err.Error(), Severity: Error}}
+	}
+	stop := make(chan struct{})
+	f, err := fox.NewRouter(fox.WithMiddleware(t.Middleware()))
+	if err != nil {
+		_ = l.Close()
+		return Findings{{Check: "listener", Message: err.Error(), Severity: Error}}
+	}
+	f.MustAdd(fox.MethodGet, "/ok", func(c *fox.Context) {
+		if _, ok := c.Writer().(*timeoutWriter); !ok {
+			report(Finding{Check: "writer", Message: "the response writer is not wrapped by the middleware", Severity: Error})
+		}
+		if _, ok := c.Request().Context().Deadline(); !ok {
+			report(Finding{Check: "deadline", Message: "the handler context has no deadline", Severity: Error})
+		}
+		caps := Capabilities(c.Writer())
+		deadline := time.Now().Add(time.Minute)
+		if err := c.Writer().SetReadDeadline(deadline); err != nil || !caps.ReadDeadline {
+			report(Finding{Check: "deadline", Message: "read deadlines unsupported on this listener", Severity: Warning})
+		}
+		if err := c.Writer().SetWriteDeadline(deadline); err != nil || !caps.WriteDeadline {
+			report(Finding{Check: "deadline", Message: "write deadlines unsupported on this listener", Severity: Warning})
+		}
+		_ = c.String(http.StatusOK, "ok")
+	}, OverrideHandler(time.Minute))
+	f.MustAdd(fox.MethodGet, "/timeout", func(c *fox.Context) {
+		select {
+		case <-c.Request().Context().Done():
+		case <-stop:
+		}
+	}, OverrideHandler(selfCheckTimeout))
+
+	srv := &http.Server{Handler: f}
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	defer func() {
+		close(stop)
+		_ = srv.Close()
+	}()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func(path string) (int, string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+l.Addr().String()+path, nil)
+		if err != nil {
+			return 0, "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), err
+	}
+
+	code, body, err := get("/ok")
+	switch {
+	case err != nil:
+		report(Finding{Check: "writer", Message: "request failed: " + err.Error(), Severity: Error})
+	case code != http.StatusOK || body != "ok":
+		report(Finding{
+			Check:    "writer",
+			Message:  fmt.Sprintf("unexpected response %d %q to a request completing in time", code, body),
+			Severity: Error,
+		})
+	}
+
+	if timers {
+		start := time.Now()
+		code, _, err = get("/timeout")
+		switch elapsed := time.Since(start); {
+		case err != nil && ctx.Err() != nil:
+			report(Finding{Check: "timeout", Message: "interrupted: " + ctx.Err().Error(), Severity: Error})
+		case err != nil:
+			report(Finding{Check: "timeout", Message: "request failed: " + err.Error(), Severity: Error})
+		case code == http.StatusOK:
+			report(Finding{Check: "timeout", Message: "a request exceeding its timeout was not timed out", Severity: Error})
+		case elapsed > selfCheckTimeout+selfCheckSlack:
+			report(Finding{
+				Check:    "timeout",
+				Message:  fmt.Sprintf("the timeout response was sent %s after the deadline", elapsed-selfCheckTimeout),
+				Severity: Warning,
+			})
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return fs
+}
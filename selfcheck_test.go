@@ -0,0 +1,82 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stoppedClock is a clock whose time never passes.
+type stoppedClock struct {
+	now time.Time
+}
+
+func (c stoppedClock) Now() time.Time {
+	return c.now
+}
+
+func (c stoppedClock) NewTimer(time.Duration) Timer {
+	return stoppedTimer{}
+}
+
+func (c stoppedClock) WithDeadline(parent context.Context, _ time.Time) (context.Context, context.CancelFunc) {
+	return context.WithCancel(parent)
+}
+
+type stoppedTimer struct{}
+
+func (stoppedTimer) C() <-chan time.Time      { return nil }
+func (stoppedTimer) Stop() bool               { return true }
+func (stoppedTimer) Reset(time.Duration) bool { return false }
+
+// sharedPool is a BufferPool handing out the same buffer to every caller.
+type sharedPool struct {
+	buf *bytes.Buffer
+}
+
+func (p sharedPool) Get() *bytes.Buffer { return p.buf }
+func (p sharedPool) Put(*bytes.Buffer)  {}
+
+func TestTimeout_SelfCheck(t *testing.T) {
+	t.Run("healthy", func(t *testing.T) {
+		fs := New(time.Second).SelfCheck(context.Background())
+		assert.Empty(t, fs)
+		assert.NoError(t, fs.Err())
+	})
+
+	t.Run("without global timeout", func(t *testing.T) {
+		fs := New(NoTimeout).SelfCheck(context.Background())
+		assert.Empty(t, fs)
+	})
+
+	t.Run("shared pool", func(t *testing.T) {
+		fs := New(time.Second, WithBufferPool(sharedPool{buf: new(bytes.Buffer)})).SelfCheck(context.Background())
+		require.NotEmpty(t, fs)
+		assert.Equal(t, "pool", fs[0].Check)
+		assert.Equal(t, Error, fs[0].Severity)
+		assert.ErrorContains(t, fs.Err(), "hands out a buffer in use")
+	})
+
+	t.Run("stopped clock", func(t *testing.T) {
+		fs := New(time.Second, WithClock(stoppedClock{now: time.Now().Add(-time.Hour)})).SelfCheck(context.Background())
+		require.GreaterOrEqual(t, len(fs), 2)
+		assert.Equal(t, Finding{Check: "clock", Message: fs[0].Message, Severity: Warning}, fs[0])
+		assert.Equal(t, "error: clock: the timers of the clock do not fire", fs[1].String())
+		assert.Error(t, fs.Err())
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		fs := New(time.Second).SelfCheck(ctx)
+		assert.ErrorContains(t, fs.Err(), "interrupted")
+	})
+}
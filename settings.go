@@ -0,0 +1,132 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// Setting is a route option of this package set on a route, as returned by [RouteSettings].
+type Setting struct {
+	// Option is the name of the route option, e.g. "OverrideHandler", or "OverrideMethod(POST)" for the options
+	// keyed by method.
+	Option string
+	// Value is the value of the option, formatted for humans, e.g. "2s", "strict" or "none" for a disabled timeout.
+	Value string
+}
+
+// optionNames are the names of the route options setting the annotations of this package.
+var optionNames = map[any]string{
+	hKey{}: "OverrideHandler",
+	rKey{}: "OverrideRead",
+	wKey{}: "OverrideWrite",
+	sKey{}: "OverrideStrictness",
+	fKey{}: "OverrideFallbackRoute",
+	aKey{}: "OverrideAbandon",
+	mKey{}: "OverrideStreaming",
+	eKey{}: "OverrideEventSink",
+	bKey{}: "ObserveBudget",
+	iKey{}: "OverrideWriteIdle",
+	pKey{}: "OverrideWritePerMB",
+	dKey{}: "OverrideBody",
+	oKey{}: "OverrideResponse",
+	qKey{}: "OverrideSpillQuota",
+	cKey{}: "Scope",
+	tKey{}: "OverrideTrusted",
+	lKey{}: "OverrideMaxConcurrent",
+	kKey{}: "OverrideHeartbeat",
+	xKey{}: "OverrideProfile",
+}
+
+// RouteSettings returns the route options of this package set on r, sorted by option name, so that the timeout
+// policy of the routes can be listed and reviewed, see also the timeouttest.CoverageReport function. Since the
+// options of the middleware are not known from the route, the routes with no setting use the timeout policy of the
+// middleware serving them.
+func RouteSettings(r *fox.Route) []Setting {
+	var settings []Setting
+	for _, key := range annotationKeys {
+		if v := r.Annotation(key); v != nil {
+			settings = append(settings, Setting{Option: optionNames[key], Value: formatSetting(key, v)})
+		}
+	}
+	for method := range r.Methods() {
+		if dt, ok := r.Annotation(methodKey{method: method}).(time.Duration); ok {
+			settings = append(settings, Setting{Option: "OverrideMethod(" + method + ")", Value: formatTimeout(dt)})
+		}
+	}
+	slices.SortFunc(settings, func(a, b Setting) int {
+		return cmp.Compare(a.Option, b.Option)
+	})
+	return settings
+}
+
+// formatSetting formats the value v of the annotation key for humans.
+func formatSetting(key, v any) string {
+	switch v := v.(type) {
+	case time.Duration:
+		switch key {
+		case hKey{}, tKey{}:
+			return formatTimeout(v)
+		}
+		return v.String()
+	case Strictness:
+		return v.String()
+	case AbandonPolicy:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case *Timeout:
+		return formatTimeout(time.Duration(v.dt.Load()))
+	case TimeoutProfile:
+		return formatProfile(v)
+	case *admission:
+		s := strconv.Itoa(v.limit)
+		if v.wait > 0 {
+			s += ", queue " + v.wait.String()
+		}
+		return s
+	default:
+		// A custom event sink or response handler.
+		return "custom"
+	}
+}
+
+// formatTimeout formats a handler timeout, where a value <= 0 disables the timeout.
+func formatTimeout(dt time.Duration) string {
+	if dt <= 0 {
+		return "none"
+	}
+	return dt.String()
+}
+
+// formatProfile formats the name and the set timeouts of a profile.
+func formatProfile(p TimeoutProfile) string {
+	var parts []string
+	add := func(name string, dt time.Duration, format func(time.Duration) string) {
+		if dt != 0 {
+			parts = append(parts, name+"="+format(dt))
+		}
+	}
+	add("handler", p.Handler, formatTimeout)
+	add("read", p.Read, time.Duration.String)
+	add("write", p.Write, time.Duration.String)
+	add("firstByte", p.FirstByte, formatTimeout)
+	add("body", p.Body, formatTimeout)
+	s := strings.Join(parts, " ")
+	if p.Name != "" {
+		s = strings.TrimSpace(p.Name + " " + s)
+	}
+	return s
+}
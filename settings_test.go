@@ -0,0 +1,43 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionNames(t *testing.T) {
+	for _, key := range annotationKeys {
+		assert.NotEmpty(t, optionNames[key], "%T has no option name", key)
+	}
+}
+
+func TestRouteSettings(t *testing.T) {
+	f, err := fox.NewRouter()
+	require.NoError(t, err)
+	route := f.MustAdd([]string{http.MethodGet, http.MethodPost}, "/foo", func(c *fox.Context) {},
+		OverrideWrite(time.Minute),
+		OverrideHandler(NoTimeout),
+		OverrideMethod(http.MethodPost, 2*time.Second),
+		OverrideStrictness(Strict),
+		OverrideTrusted(0),
+	)
+	assert.Equal(t, []Setting{
+		{Option: "OverrideHandler", Value: "none"},
+		{Option: "OverrideMethod(POST)", Value: "2s"},
+		{Option: "OverrideStrictness", Value: "strict"},
+		{Option: "OverrideTrusted", Value: "none"},
+		{Option: "OverrideWrite", Value: "1m0s"},
+	}, RouteSettings(route))
+
+	route = f.MustAdd(fox.MethodGet, "/bar", func(c *fox.Context) {})
+	assert.Empty(t, RouteSettings(route))
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// ErrShed is the error passed to the response handler set with [WithErrorResponse] for a request rejected by the
+// admission control, see [WithMaxRequestAge] and [WithAdmissionQueue].
+var ErrShed = errors.New("timeout: request shed")
+
+// defaultShedRetryAfter is the default Retry-After delay of the shed responses.
+const defaultShedRetryAfter = time.Second
+
+// shed writes the response of a request rejected by the admission control before its handler runs, so that clients
+// can tell an overloaded server, which they should back off from, apart from a slow handler. The response is written
+// by the handler set with [WithShedResponse], or else by the timeout response handler, with the status code set with
+// [WithShedStatusCode] and the Retry-After delay set with [WithShedRetryAfter]. The requests rejected while draining
+// get a 503 status code and close their connection instead.
+func (t *Timeout) shed(c *fox.Context, reason ShedReason) {
+	t.routeCounters(t.routeKey(c)).shed.Add(1)
+	t.shedCounts[reason].Add(1)
+	if t.cfg.shedRetry > 0 {
+		c.Writer().Header().Set("Retry-After", formatRetryAfter(int64((t.cfg.shedRetry+time.Second-1)/time.Second)))
+	}
+	// The built-in responses read the status code from the request context, see timeoutDetails.
+	req := c.Request()
+	status := cmp.Or(t.cfg.shedStatus, http.StatusTooManyRequests)
+	if reason == ShedDraining {
+		// The client should retry on another instance, over a new connection.
+		status = http.StatusServiceUnavailable
+		c.Writer().Header().Set("Connection", "close")
+	}
+	info := &responseInfo{status: status, err: ErrShed}
+	cp := c.CloneWith(c.Writer(), req.WithContext(context.WithValue(req.Context(), responseKey{}, info)))
+	defer cp.Close()
+	respond := t.response()
+	if t.cfg.shedResp != nil {
+		respond = t.cfg.shedResp
+	}
+	if !req.ProtoAtLeast(1, 1) {
+		t.respondHTTP10(cp, respond)
+		return
+	}
+	respond(cp)
+}
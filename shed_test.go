@@ -0,0 +1,68 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout_Shed(t *testing.T) {
+	cases := []struct {
+		name       string
+		opts       []Option
+		code       int
+		body       string
+		retryAfter string
+	}{
+		{
+			name:       "default response",
+			opts:       []Option{WithStatusCode(http.StatusGatewayTimeout)},
+			code:       http.StatusTooManyRequests,
+			body:       http.StatusText(http.StatusTooManyRequests) + "\n",
+			retryAfter: "1",
+		},
+		{
+			name:       "json response",
+			opts:       []Option{PresetAPI(), WithShedStatusCode(http.StatusServiceUnavailable), WithShedRetryAfter(1500 * time.Millisecond)},
+			code:       http.StatusServiceUnavailable,
+			body:       `{"error":"Service Unavailable"}`,
+			retryAfter: "2",
+		},
+		{
+			name: "custom response",
+			opts: []Option{
+				WithShedResponse(func(c *fox.Context) { _ = c.String(http.StatusTooEarly, "busy") }),
+				WithShedRetryAfter(0),
+			},
+			code: http.StatusTooEarly,
+			body: "busy",
+		},
+		{
+			name:       "invalid status code",
+			opts:       []Option{WithShedStatusCode(http.StatusOK)},
+			code:       http.StatusTooManyRequests,
+			body:       http.StatusText(http.StatusTooManyRequests) + "\n",
+			retryAfter: "1",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tm := New(time.Second, tc.opts...)
+			w := httptest.NewRecorder()
+			c := fox.NewTestContextOnly(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+			tm.shed(c, ShedAge)
+			assert.Equal(t, tc.code, w.Code)
+			assert.Equal(t, tc.body, w.Body.String())
+			assert.Equal(t, tc.retryAfter, w.Header().Get("Retry-After"))
+		})
+	}
+}
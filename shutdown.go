@@ -0,0 +1,32 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// shutdown is the graceful shutdown of the server, see WithShutdownContext.
+type shutdown struct {
+	ctx   context.Context
+	drain time.Duration
+	once  sync.Once
+	// end is the drain deadline, set once the shutdown is first observed.
+	end time.Time
+}
+
+// deadline returns the drain deadline, and whether the server is shutting down. The drain deadline is the drain
+// duration from the first call after the shutdown began, now.
+func (s *shutdown) deadline(now time.Time) (time.Time, bool) {
+	if s.ctx.Err() == nil {
+		return time.Time{}, false
+	}
+	s.once.Do(func() {
+		s.end = now.Add(s.drain)
+	})
+	return s.end, true
+}
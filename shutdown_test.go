@@ -0,0 +1,76 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithShutdownContext(t *testing.T) {
+	t.Run("reject long-running requests", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		tm := New(time.Second, WithShutdownContext(ctx, 100*time.Millisecond))
+		f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/slow", success201response)
+		f.MustAdd(fox.MethodGet, "/fast", success201response, OverrideHandler(50*time.Millisecond))
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		cancel()
+		w = httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "close", w.Header().Get("Connection"))
+
+		w = httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		assert.Equal(t, ShedCounters{Draining: 1}, tm.ShedCounts())
+		assert.Equal(t, uint64(1), tm.Stats()["/slow"].Shed)
+	})
+
+	t.Run("clamp running requests", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Minute, WithShutdownContext(ctx, 20*time.Millisecond))))
+		require.NoError(t, err)
+		started := make(chan struct{})
+		cause := make(chan error, 1)
+		f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+			close(started)
+			<-c.Request().Context().Done()
+			cause <- context.Cause(c.Request().Context())
+		})
+
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		}()
+		<-started
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the request was not timed out at the drain deadline")
+		}
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "close", w.Header().Get("Connection"))
+		var de *DeadlineError
+		assert.ErrorAs(t, <-cause, &de)
+	})
+}
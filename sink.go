@@ -0,0 +1,87 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncEventSink is an [EventSink] dispatching the events to another sink from a pool of worker goroutines, so
+// that a heavy sink, such as one reporting the events over HTTP, never adds latency to the request path. The events
+// are queued in a bounded queue: when the queue is full, Emit drops the event instead of blocking, and the dropped
+// events are counted, see [AsyncEventSink.Dropped]. An AsyncEventSink is safe for concurrent use and may be shared
+// by several middleware. See [WithEventSink].
+type AsyncEventSink struct {
+	sink    EventSink
+	queue   chan Event
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Uint64
+}
+
+// NewAsyncEventSink returns an [AsyncEventSink] dispatching the events to sink with the given number of workers,
+// queuing up to queue events. A queue or workers <= 0 is treated as 1. Since the workers run concurrently, sink
+// must be safe for concurrent use, and receives the events out of order if workers > 1. The sink must be closed
+// with [AsyncEventSink.Close] to release the workers.
+func NewAsyncEventSink(sink EventSink, queue, workers int) *AsyncEventSink {
+	s := &AsyncEventSink{
+		sink:  sink,
+		queue: make(chan Event, max(queue, 1)),
+	}
+	for range max(workers, 1) {
+		s.wg.Add(1)
+		go s.work()
+	}
+	return s
+}
+
+func (s *AsyncEventSink) work() {
+	defer s.wg.Done()
+	for ev := range s.queue {
+		s.sink.Emit(ev)
+	}
+}
+
+// Emit queues ev for dispatch, or drops it if the queue is full or the sink is closed.
+func (s *AsyncEventSink) Emit(ev Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Add(1)
+		return
+	}
+	select {
+	case s.queue <- ev:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full or the sink was closed. A steadily
+// increasing count means the queue is too small for the bursts of events, or the workers too slow for their rate.
+func (s *AsyncEventSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Len returns the number of events waiting in the queue.
+func (s *AsyncEventSink) Len() int {
+	return len(s.queue)
+}
+
+// Close stops accepting events, and waits for the queued events to be dispatched. It is typically called during
+// the graceful shutdown of the server, once the requests are drained.
+func (s *AsyncEventSink) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+	s.wg.Wait()
+}
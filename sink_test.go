@@ -0,0 +1,67 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncEventSink(t *testing.T) {
+	release := make(chan struct{})
+	var emitted atomic.Int64
+	sink := NewAsyncEventSink(EventSinkFunc(func(ev Event) {
+		<-release
+		emitted.Add(1)
+	}), 2, 1)
+
+	// The worker blocks on the first event, the next two fill the queue, and the last one is dropped.
+	sink.Emit(Event{Kind: EventTimeout})
+	require.Eventually(t, func() bool { return sink.Len() == 0 }, time.Second, time.Millisecond)
+	sink.Emit(Event{Kind: EventTimeout})
+	sink.Emit(Event{Kind: EventTimeout})
+	sink.Emit(Event{Kind: EventTimeout})
+	assert.Equal(t, 2, sink.Len())
+	assert.Equal(t, uint64(1), sink.Dropped())
+
+	close(release)
+	sink.Close()
+	assert.Equal(t, int64(3), emitted.Load())
+	sink.Emit(Event{Kind: EventTimeout})
+	assert.Equal(t, uint64(2), sink.Dropped())
+	sink.Close()
+}
+
+func TestAsyncEventSink_Middleware(t *testing.T) {
+	events := make(chan Event, 1)
+	sink := NewAsyncEventSink(EventSinkFunc(func(ev Event) {
+		time.Sleep(50 * time.Millisecond)
+		events <- ev
+	}), 16, 2)
+	defer sink.Close()
+
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, WithEventSink(sink))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	// The slow sink does not delay the response.
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	ev := <-events
+	assert.Equal(t, EventTimeout, ev.Kind)
+	assert.Equal(t, "/slow", ev.Pattern)
+}
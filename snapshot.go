@@ -0,0 +1,196 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// Snapshot is a point in time copy of the configuration and counters of a [Timeout], as returned by
+// [Timeout.Snapshot]. It does not share memory with the Timeout, so it is not affected by later requests.
+type Snapshot struct {
+	// Routes holds the counters of each route pattern, rewritten by the [WithRouteNormalizer] function, that served
+	// at least one request under a timeout. Requests that did not match a route are counted under the empty
+	// pattern.
+	Routes map[string]RouteCounters
+	// Timeout is the global timeout the middleware is created with.
+	Timeout time.Duration
+	// Outer reports whether the middleware bounds the entire middleware chain, see [Outer].
+	Outer bool
+	// Streaming reports whether the streaming mode is enabled by default, see [WithStreaming].
+	Streaming bool
+	// InFlight is the number of requests being served under a timeout.
+	InFlight int64
+	// PeakInFlight is the highest number of requests served concurrently under a timeout since the middleware was
+	// created.
+	PeakInFlight int64
+	// Orphaned is the number of handlers still running after timing out, see [Timeout.Orphaned].
+	Orphaned int64
+	// PeakOrphaned is the highest number of handlers still running after timing out since the middleware was
+	// created.
+	PeakOrphaned int64
+	// LateWrites holds the call sites that wrote to the response after their handler timed out, see
+	// [Timeout.LateWrites].
+	LateWrites []LateWrite
+}
+
+// RouteCounters are the counters of the requests served under a timeout for a route.
+type RouteCounters struct {
+	// Requests is the number of requests served, whatever their outcome.
+	Requests uint64 `json:"requests"`
+	// Completed is the number of requests whose handler completed within its deadline.
+	Completed uint64 `json:"completed"`
+	// TimedOut is the number of requests whose handler exceeded its deadline.
+	TimedOut uint64 `json:"timedOut"`
+	// ClientGone is the number of requests canceled before their handler completed.
+	ClientGone uint64 `json:"clientGone"`
+	// Panicked is the number of requests whose handler panicked. They are not counted by any other counter.
+	Panicked uint64 `json:"panicked"`
+	// OverBudget is the number of requests whose handler ran for longer than the budget set with [ObserveBudget],
+	// whatever their outcome.
+	OverBudget uint64 `json:"overBudget"`
+	// Shed is the number of requests rejected by the admission control before their handler runs, such as the
+	// requests older than the maximum set with [WithMaxRequestAge]. They are not counted by any other counter.
+	Shed uint64 `json:"shed"`
+	// MaxLatency is the longest time taken to serve a request, including the timeout response if any, whatever its
+	// outcome, except the requests whose handler panicked. It is encoded in nanoseconds in JSON.
+	MaxLatency time.Duration `json:"maxLatency"`
+	// InFlight is the number of requests being served. It is only tracked with [WithInFlightByRoute].
+	InFlight int64 `json:"inFlight"`
+	// PeakInFlight is the highest number of requests served concurrently. It is only tracked with
+	// [WithInFlightByRoute].
+	PeakInFlight int64 `json:"peakInFlight"`
+}
+
+type routeCounters struct {
+	requests   atomic.Uint64
+	completed  atomic.Uint64
+	timedOut   atomic.Uint64
+	clientGone atomic.Uint64
+	overBudget atomic.Uint64
+	shed       atomic.Uint64
+	panicked   atomic.Uint64
+	maxLatency atomic.Int64
+	inFlight   gauge
+	// spilled is the disk space used by the spilled responses of the route, see OverrideSpillQuota.
+	spilled atomic.Int64
+	// sizeHint is the recent size of the responses of the route, see observeSize.
+	sizeHint atomic.Int64
+}
+
+// gauge is a gauge recording its high-water mark.
+type gauge struct {
+	cur  atomic.Int64
+	peak atomic.Int64
+}
+
+func (g *gauge) inc() {
+	n := g.cur.Add(1)
+	for {
+		peak := g.peak.Load()
+		if n <= peak || g.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+func (g *gauge) dec() {
+	g.cur.Add(-1)
+}
+
+// observe records the latency of a request, if it is the longest so far.
+func (rc *routeCounters) observe(elapsed time.Duration) {
+	for {
+		cur := rc.maxLatency.Load()
+		if int64(elapsed) <= cur || rc.maxLatency.CompareAndSwap(cur, int64(elapsed)) {
+			return
+		}
+	}
+}
+
+func (rc *routeCounters) add(o Outcome) {
+	switch o {
+	case OutcomeCompleted:
+		rc.completed.Add(1)
+	case OutcomeTimedOut:
+		rc.timedOut.Add(1)
+	case OutcomeClientGone:
+		rc.clientGone.Add(1)
+	}
+}
+
+// Snapshot returns a copy of the configuration and counters of t. It is intended for tests asserting on the state
+// of the middleware, e.g. that exactly 3 requests timed out on a given route.
+func (t *Timeout) Snapshot() Snapshot {
+	s := Snapshot{
+		Timeout:   t.timeout(),
+		Outer:     t.outer,
+		Streaming: t.cfg.streaming,
+	}
+	s.InFlight, s.PeakInFlight = t.InFlight()
+	s.Orphaned, s.PeakOrphaned = t.Orphaned()
+	s.LateWrites = t.LateWrites()
+	s.Routes = t.Stats()
+	return s
+}
+
+// Stats returns the counters of each route pattern, rewritten by the [WithRouteNormalizer] function, that served at
+// least one request under a timeout, as in [Snapshot.Routes], including the routes delegated to a [Scope]. See
+// [Timeout.StatsHandler] to serve them.
+func (t *Timeout) Stats() map[string]RouteCounters {
+	routes := make(map[string]RouteCounters)
+	t.counters.Range(func(key, value any) bool {
+		rc := value.(*routeCounters)
+		routes[key.(string)] = RouteCounters{
+			Requests:     rc.requests.Load(),
+			Completed:    rc.completed.Load(),
+			TimedOut:     rc.timedOut.Load(),
+			ClientGone:   rc.clientGone.Load(),
+			Panicked:     rc.panicked.Load(),
+			OverBudget:   rc.overBudget.Load(),
+			Shed:         rc.shed.Load(),
+			MaxLatency:   time.Duration(rc.maxLatency.Load()),
+			InFlight:     rc.inFlight.cur.Load(),
+			PeakInFlight: rc.inFlight.peak.Load(),
+		}
+		return true
+	})
+	t.scopes.Range(func(key, _ any) bool {
+		for pattern, rc := range key.(*Timeout).Stats() {
+			routes[pattern] = routes[pattern].merge(rc)
+		}
+		return true
+	})
+	return routes
+}
+
+// StatsHandler returns a [fox.HandlerFunc] rendering the counters of each route returned by [Timeout.Stats] as a
+// JSON object keyed by route pattern, e.g. to serve a lightweight /debug/timeouts endpoint without a metrics stack.
+// The endpoint discloses the routes of the application, so it should not be exposed publicly. The encoding and the
+// redacted fields are set with [WithMarshaler] and [WithFieldRedaction].
+func (t *Timeout) StatsHandler() fox.HandlerFunc {
+	return func(c *fox.Context) {
+		t.render(c, t.Stats())
+	}
+}
+
+// InFlight returns the number of requests being served under a timeout, and the highest number of requests served
+// concurrently under a timeout since the middleware was created. Unlike [Timeout.Snapshot], it does not allocate, so
+// it is cheap enough to be polled frequently, e.g. to export a capacity signal.
+func (t *Timeout) InFlight() (current, peak int64) {
+	return t.inFlight.cur.Load(), t.inFlight.peak.Load()
+}
+
+// routeCounters returns the counters of the given route pattern.
+func (t *Timeout) routeCounters(pattern string) *routeCounters {
+	if rc, ok := t.counters.Load(pattern); ok {
+		return rc.(*routeCounters)
+	}
+	rc, _ := t.counters.LoadOrStore(pattern, new(routeCounters))
+	return rc.(*routeCounters)
+}
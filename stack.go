@@ -0,0 +1,52 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+// maxStackDump is the maximum size of the dump of all goroutines taken by goroutineStack.
+const maxStackDump = 64 << 20
+
+// goroutineID returns the identifier of the calling goroutine, as printed in its stack trace.
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	// The trace starts with "goroutine 42 [running]:".
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		id, _ := strconv.ParseUint(string(b[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
+
+// goroutineStack returns the stack trace of the goroutine with the given identifier, or nil if it no longer exists.
+// It dumps the stack traces of all goroutines, which stops the world.
+func goroutineStack(id uint64) []byte {
+	if id == 0 {
+		return nil
+	}
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackDump {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	header := []byte("goroutine " + strconv.FormatUint(id, 10) + " [")
+	for trace := range bytes.SplitSeq(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(trace, header) {
+			return bytes.Clone(bytes.TrimRight(trace, "\n"))
+		}
+	}
+	return nil
+}
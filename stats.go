@@ -0,0 +1,171 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// StatsBatch is a batch of per-route aggregates flushed to a [StatsExporter], covering the requests served under a
+// timeout between Start and End.
+type StatsBatch struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Version is the application version set with [WithVersionLabel], if any.
+	Version string `json:"version,omitempty"`
+	// Routes holds the aggregates of the routes that served or were serving requests over the period, sorted by
+	// pattern.
+	Routes []RouteStats `json:"routes"`
+}
+
+// RouteStats are the aggregates of a route over the period of a [StatsBatch]. The counters are the increase over
+// the period, see [RouteCounters] for their meaning, so they can be summed over time by the telemetry pipeline.
+type RouteStats struct {
+	// Pattern is the route pattern, rewritten by the [WithRouteNormalizer] function.
+	Pattern    string `json:"pattern"`
+	Requests   uint64 `json:"requests"`
+	Completed  uint64 `json:"completed"`
+	TimedOut   uint64 `json:"timedOut"`
+	ClientGone uint64 `json:"clientGone"`
+	Panicked   uint64 `json:"panicked"`
+	OverBudget uint64 `json:"overBudget"`
+	Shed       uint64 `json:"shed"`
+	// InFlight is the number of requests being served at the end of the period. It is only tracked with
+	// [WithInFlightByRoute].
+	InFlight int64 `json:"inFlight"`
+}
+
+// StatsExporter ships the per-route aggregates of the middleware to an external telemetry pipeline, for
+// deployments that do not scrape Prometheus metrics, see [Timeout.ExportStats]. ExportStats is called from a
+// single goroutine and may block for up to the flush interval.
+type StatsExporter interface {
+	ExportStats(ctx context.Context, batch StatsBatch) error
+}
+
+// The StatsExporterFunc type is an adapter to allow the use of ordinary functions as [StatsExporter].
+type StatsExporterFunc func(ctx context.Context, batch StatsBatch) error
+
+// ExportStats calls f(ctx, batch).
+func (f StatsExporterFunc) ExportStats(ctx context.Context, batch StatsBatch) error {
+	return f(ctx, batch)
+}
+
+// NopStatsExporter returns a [StatsExporter] discarding every batch, e.g. to disable the export in some
+// environments without changing the wiring.
+func NopStatsExporter() StatsExporter {
+	return StatsExporterFunc(func(context.Context, StatsBatch) error { return nil })
+}
+
+// JSONStatsExporter returns a [StatsExporter] posting each batch as a JSON document to url. The export fails if
+// the response status is not 2xx. If client is nil, [http.DefaultClient] is used.
+func JSONStatsExporter(url string, client *http.Client) StatsExporter {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &jsonStatsExporter{url: url, client: client}
+}
+
+type jsonStatsExporter struct {
+	url    string
+	client *http.Client
+}
+
+func (e *jsonStatsExporter) ExportStats(ctx context.Context, batch StatsBatch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body so that the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("timeout: stats export failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ExportStats flushes the per-route aggregates of t to exporter every interval, until ctx is done. It blocks, so it
+// is typically started in its own goroutine. A batch that fails to be exported is not lost: its counters are
+// carried over to the next batch. Export errors are logged with the logger set with [WithLogger], if any. Once ctx is
+// done, a last batch is flushed, bounded by interval, so that the requests served since the previous flush are
+// not lost on shutdown.
+func (t *Timeout) ExportStats(ctx context.Context, exporter StatsExporter, interval time.Duration) {
+	prev := make(map[string]RouteCounters)
+	start := t.now()
+	flush := func(ctx context.Context) {
+		end := t.now()
+		cur := t.Snapshot().Routes
+		batch := StatsBatch{Start: start, End: end, Version: t.cfg.version, Routes: statsDelta(prev, cur)}
+		if err := exporter.ExportStats(ctx, batch); err != nil {
+			if t.cfg.logger != nil {
+				t.cfg.logger.LogAttrs(ctx, slog.LevelWarn, "stats export failed", slog.String("error", err.Error()))
+			}
+			return
+		}
+		prev, start = cur, end
+	}
+
+	timer := t.cfg.clock.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C():
+			fctx, cancel := context.WithTimeout(ctx, interval)
+			flush(fctx)
+			cancel()
+			timer.Reset(interval)
+		case <-ctx.Done():
+			fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), interval)
+			flush(fctx)
+			cancel()
+			return
+		}
+	}
+}
+
+// statsDelta returns the increase of the counters of each route from prev to cur, skipping the idle routes.
+func statsDelta(prev, cur map[string]RouteCounters) []RouteStats {
+	routes := make([]RouteStats, 0, len(cur))
+	for pattern, rc := range cur {
+		p := prev[pattern]
+		rs := RouteStats{
+			Pattern:    pattern,
+			Requests:   rc.Requests - p.Requests,
+			Completed:  rc.Completed - p.Completed,
+			TimedOut:   rc.TimedOut - p.TimedOut,
+			ClientGone: rc.ClientGone - p.ClientGone,
+			Panicked:   rc.Panicked - p.Panicked,
+			OverBudget: rc.OverBudget - p.OverBudget,
+			Shed:       rc.Shed - p.Shed,
+			InFlight:   rc.InFlight,
+		}
+		if rs == (RouteStats{Pattern: pattern}) {
+			continue
+		}
+		routes = append(routes, rs)
+	}
+	slices.SortFunc(routes, func(a, b RouteStats) int {
+		return strings.Compare(a.Pattern, b.Pattern)
+	})
+	return routes
+}
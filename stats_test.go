@@ -0,0 +1,102 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout_ExportStats(t *testing.T) {
+	tm := New(time.Second, WithVersionLabel("v1"))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+	f.MustAdd(fox.MethodGet, "/bar", success201response)
+	serve := func(path string) {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	batches := make(chan StatsBatch)
+	failures := 1
+	exporter := StatsExporterFunc(func(ctx context.Context, batch StatsBatch) error {
+		if failures > 0 {
+			failures--
+			return errors.New("unavailable")
+		}
+		batches <- batch
+		return nil
+	})
+
+	serve("/foo")
+	serve("/foo")
+	serve("/bar")
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tm.ExportStats(ctx, exporter, 10*time.Millisecond)
+	}()
+
+	// The first export fails, so its counters are carried over to the next batch.
+	batch := <-batches
+	assert.Equal(t, "v1", batch.Version)
+	assert.True(t, batch.End.After(batch.Start))
+	assert.Equal(t, []RouteStats{
+		{Pattern: "/bar", Requests: 1, Completed: 1},
+		{Pattern: "/foo", Requests: 2, Completed: 2},
+	}, batch.Routes)
+
+	serve("/foo")
+	// A batch may be flushed while the request is served, so the batches are summed until it is accounted for.
+	var sum RouteStats
+	for sum.Completed == 0 {
+		for _, rs := range (<-batches).Routes {
+			assert.Equal(t, "/foo", rs.Pattern)
+			sum.Requests += rs.Requests
+			sum.Completed += rs.Completed
+		}
+	}
+	assert.Equal(t, uint64(1), sum.Requests)
+
+	cancel()
+	// The last batch is flushed on shutdown.
+	<-batches
+	<-stopped
+}
+
+func TestJSONStatsExporter(t *testing.T) {
+	var got StatsBatch
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	batch := StatsBatch{
+		Start:  time.Unix(0, 0).UTC(),
+		End:    time.Unix(60, 0).UTC(),
+		Routes: []RouteStats{{Pattern: "/foo", Requests: 3, TimedOut: 1, Completed: 2}},
+	}
+	require.NoError(t, JSONStatsExporter(srv.URL+"/stats", nil).ExportStats(context.Background(), batch))
+	assert.Equal(t, batch, got)
+	assert.Error(t, JSONStatsExporter(srv.URL+"/fail", srv.Client()).ExportStats(context.Background(), batch))
+	assert.NoError(t, NopStatsExporter().ExportStats(context.Background(), batch))
+}
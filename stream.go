@@ -0,0 +1,52 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// ConnectStreamingTypes are the content types of the streaming calls of the Connect, gRPC and gRPC-Web protocols,
+// as registered by [WithConnectStreaming]. The unary calls of the Connect protocol use other content types (e.g.
+// "application/proto" or "application/json"), and remain served under the handler timeout. The gRPC and gRPC-Web
+// protocols use the same content types for their unary and streaming calls, which cannot be told apart.
+var ConnectStreamingTypes = []string{
+	"application/connect+proto",
+	"application/connect+json",
+	"application/grpc",
+	"application/grpc+proto",
+	"application/grpc+json",
+	"application/grpc-web",
+	"application/grpc-web+proto",
+	"application/grpc-web+json",
+	"application/grpc-web-text",
+	"application/grpc-web-text+proto",
+}
+
+// streamBypass returns the idle write timeout of the request if its content type is registered with
+// WithStreamingBypass.
+func (t *Timeout) streamBypass(c *fox.Context) (time.Duration, bool) {
+	if len(t.cfg.bypass) == 0 {
+		return 0, false
+	}
+	ct := c.Request().Header.Get("Content-Type")
+	if ct == "" {
+		return 0, false
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return 0, false
+	}
+	idle, ok := t.cfg.bypass[mt]
+	return idle, ok
+}
+
+func normalizeMediaType(mt string) string {
+	return strings.ToLower(strings.TrimSpace(mt))
+}
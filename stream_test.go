@@ -0,0 +1,70 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_WithConnectStreaming(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond,
+		WithConnectStreaming(time.Second),
+		WithStreamingBypass(map[string]time.Duration{"text/event-stream": 0}),
+		WithWriteDeadline(time.Hour),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodPost, "/rpc", func(c *fox.Context) {
+		_, deadline := c.Request().Context().Deadline()
+		_, _ = c.Writer().Write([]byte("first"))
+		time.Sleep(50 * time.Millisecond)
+		if deadline {
+			return
+		}
+		_, _ = c.Writer().Write([]byte(" second"))
+	})
+
+	cases := []struct {
+		name        string
+		contentType string
+		wantCode    int
+		wantBody    string
+		wantWrites  int
+		wantIdle    time.Duration
+	}{
+		{name: "connect stream", contentType: "application/connect+proto", wantCode: http.StatusOK, wantBody: "first second", wantWrites: 2, wantIdle: time.Second},
+		{name: "grpc-web with parameters", contentType: "Application/GRPC-Web+proto; charset=utf-8", wantCode: http.StatusOK, wantBody: "first second", wantWrites: 2, wantIdle: time.Second},
+		{name: "custom without idle deadline", contentType: "text/event-stream", wantCode: http.StatusOK, wantBody: "first second"},
+		{name: "connect unary", contentType: "application/proto", wantCode: http.StatusServiceUnavailable, wantWrites: 1, wantIdle: time.Hour},
+		{name: "no content type", wantCode: http.StatusServiceUnavailable, wantWrites: 1, wantIdle: time.Hour},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader("payload"))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			w := &slidingRecorder{ResponseRecorder: httptest.NewRecorder()}
+			start := time.Now()
+			f.ServeHTTP(w, req)
+			assert.Equal(t, tc.wantCode, w.Code)
+			if tc.wantBody != "" {
+				assert.Equal(t, tc.wantBody, w.Body.String())
+			}
+			require.Len(t, w.deadlines, tc.wantWrites)
+			for _, d := range w.deadlines {
+				assert.WithinDuration(t, start.Add(tc.wantIdle), d, 200*time.Millisecond)
+			}
+		})
+	}
+}
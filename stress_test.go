@@ -0,0 +1,282 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stress is the number of requests sent to each mode by TestStress. A small default keeps the regular runs fast,
+// run e.g. "go test -race -run TestStress -stress 5000" to hammer the races between the commit, the timeout, the
+// panics and the client aborts.
+var stress = flag.Int("stress", 64, "number of requests sent to each mode by TestStress")
+
+// checkedPool is a BufferPool reporting the buffers put twice, put without being handed out, and written after
+// being put back.
+type checkedPool struct {
+	mu   sync.Mutex
+	free []*bytes.Buffer
+	out  map[*bytes.Buffer]struct{}
+	lens map[*bytes.Buffer]int
+	errs []string
+}
+
+func newCheckedPool() *checkedPool {
+	return &checkedPool{
+		out:  make(map[*bytes.Buffer]struct{}),
+		lens: make(map[*bytes.Buffer]int),
+	}
+}
+
+func (p *checkedPool) Get() *bytes.Buffer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var buf *bytes.Buffer
+	if n := len(p.free); n > 0 {
+		buf = p.free[n-1]
+		p.free = p.free[:n-1]
+		if buf.Len() != p.lens[buf] {
+			p.errs = append(p.errs, fmt.Sprintf("buffer %p written after being put back", buf))
+		}
+		delete(p.lens, buf)
+	} else {
+		buf = new(bytes.Buffer)
+	}
+	p.out[buf] = struct{}{}
+	return buf
+}
+
+func (p *checkedPool) Put(buf *bytes.Buffer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.out[buf]; !ok {
+		p.errs = append(p.errs, fmt.Sprintf("buffer %p put twice or not handed out", buf))
+		return
+	}
+	delete(p.out, buf)
+	p.lens[buf] = buf.Len()
+	p.free = append(p.free, buf)
+}
+
+// check returns the errors found so far, including the pooled buffers written since they were put back, and the
+// number of buffers still handed out.
+func (p *checkedPool) check() (errs []string, out int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	errs = append(errs, p.errs...)
+	for _, buf := range p.free {
+		if buf.Len() != p.lens[buf] {
+			errs = append(errs, fmt.Sprintf("buffer %p written after being put back", buf))
+		}
+	}
+	return errs, len(p.out)
+}
+
+// countingWriter is a http.ResponseWriter counting the responses emitted, and the writes made once the request is
+// served.
+type countingWriter struct {
+	mu      sync.Mutex
+	header  http.Header
+	code    int
+	headers int
+	late    int
+	done    bool
+}
+
+func (w *countingWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeader(code)
+}
+
+func (w *countingWriter) writeHeader(code int) {
+	if w.done {
+		w.late++
+	}
+	if code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols {
+		return
+	}
+	w.headers++
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		w.late++
+	}
+	if w.code == 0 {
+		w.writeHeader(http.StatusOK)
+	}
+	return len(p), nil
+}
+
+func (w *countingWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		w.late++
+	}
+	if w.code == 0 {
+		w.writeHeader(http.StatusOK)
+	}
+}
+
+func (w *countingWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.done = true
+}
+
+// stressBehavior is a handler behavior picked at random by TestStress.
+type stressBehavior uint8
+
+const (
+	behaviorComplete stressBehavior = iota
+	behaviorSlow
+	behaviorIgnoreContext
+	behaviorFlush
+	behaviorPanic
+	behaviorPanicAfterWrite
+	behaviorAbort
+	behaviorCount
+)
+
+// stressHandler returns a handler completing, panicking or being aborted around the deadline dt, depending on the
+// behavior picked for the request.
+func stressHandler(dt time.Duration) fox.HandlerFunc {
+	return func(c *fox.Context) {
+		behavior := stressBehavior(c.Request().Header.Get("X-Behavior")[0] - '0')
+		delay := rand.N(2 * dt)
+		wait := func() {
+			// The context is left untouched with ConnDeadline, so the wait must be bounded.
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-c.Request().Context().Done():
+			}
+		}
+
+		switch behavior {
+		case behaviorSlow, behaviorAbort:
+			wait()
+		case behaviorIgnoreContext:
+			time.Sleep(delay)
+		case behaviorFlush:
+			_, _ = c.Writer().WriteString("chunk")
+			_ = c.Writer().FlushError()
+			wait()
+		case behaviorPanic:
+			wait()
+			panic("stress")
+		case behaviorPanicAfterWrite:
+			_, _ = c.Writer().WriteString("partial")
+			wait()
+			panic("stress")
+		}
+		if c.Writer().Written() {
+			_, _ = c.Writer().WriteString("done")
+			return
+		}
+		_ = c.String(http.StatusOK, "done")
+	}
+}
+
+func TestStress(t *testing.T) {
+	const dt = 5 * time.Millisecond
+	modes := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "buffered"},
+		{name: "write gated", opts: []Option{WithEnforcement(WriteGated)}},
+		{name: "conn deadline", opts: []Option{WithEnforcement(ConnDeadline)}},
+		{name: "streaming", opts: []Option{WithStreaming()}},
+		{name: "rfc compliance", opts: []Option{WithRFCCompliance(time.Second)}},
+	}
+
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			pool := newCheckedPool()
+			// The buffer of a timeout response exceeding its budget is never returned to the pool, so the budget must
+			// hold under load.
+			opts := append([]Option{WithBufferPool(pool), WithResponseBudget(10 * time.Second)}, mode.opts...)
+			tm := New(dt, opts...)
+			f, err := fox.NewRouter(fox.WithMiddleware(
+				fox.RecoveryWithFunc(slog.DiscardHandler, func(c *fox.Context, err any) {
+					if !c.Writer().Written() {
+						c.Writer().WriteHeader(http.StatusInternalServerError)
+					}
+				}),
+				tm.Middleware(),
+			))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/stress", stressHandler(dt))
+
+			var (
+				wg       sync.WaitGroup
+				failures atomic.Int64
+			)
+			for range *stress {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					behavior := stressBehavior(rand.N(behaviorCount))
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+					if behavior == behaviorAbort {
+						time.AfterFunc(rand.N(2*dt), cancel)
+					}
+					req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/stress", nil)
+					req.Header.Set("X-Behavior", string(rune('0'+behavior)))
+					w := &countingWriter{header: make(http.Header)}
+					f.ServeHTTP(w, req)
+					w.finish()
+
+					w.mu.Lock()
+					defer w.mu.Unlock()
+					if w.headers != 1 || w.late != 0 {
+						failures.Add(1)
+						t.Errorf("behavior %d: got %d responses and %d late writes, want exactly one response", behavior, w.headers, w.late)
+					}
+				}()
+			}
+			wg.Wait()
+
+			// The handlers running past their deadline put their buffer back once they return.
+			require.Eventually(t, func() bool {
+				current, _ := tm.Orphaned()
+				_, out := pool.check()
+				return current == 0 && out == 0
+			}, 5*time.Second, time.Millisecond)
+			errs, _ := pool.check()
+			assert.Empty(t, errs)
+			assert.Zero(t, failures.Load())
+		})
+	}
+}
@@ -9,39 +9,100 @@ package timeout
 
 import (
 	"bytes"
+	"cmp"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"log/slog"
 	"maps"
 	"net/http"
+	"net/textproto"
 	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"runtime/trace"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fox-toolkit/fox"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
-var (
-	bufp = sync.Pool{
-		New: func() any {
-			return bytes.NewBuffer(nil)
-		},
-	}
+type (
+	entryKey    struct{}
+	activeKey   struct{}
+	scopeKey    struct{}
+	responseKey struct{}
 )
 
+// scope describes the timeout a handler is served under.
+type scope struct {
+	t        *Timeout
+	deadline time.Time
+	// pattern is the pattern of the route and timeout the handler timeout, see Reason.
+	pattern string
+	timeout time.Duration
+	// expired is set when the handler reports that a deadline it derived expired, see ReportError.
+	expired atomic.Bool
+	// ext is the context of the handler if its deadline can be extended, and extended the total extension granted,
+	// see Extend.
+	ext      *deadlineContext
+	extended atomic.Int64
+	// idle is the idle timeout of the handler, and bound the deadline it cannot be reset past, see Heartbeat.
+	idle  time.Duration
+	bound time.Time
+}
+
 // Timeout is a middleware that ensure HTTP handlers don't exceed the configured timeout duration.
 type Timeout struct {
 	cfg *config
-	dt  time.Duration
+	// unbounded records the routes for which an EventUnboundedRoute has been emitted.
+	unbounded sync.Map
+	// counters holds the *routeCounters of each route pattern.
+	counters sync.Map
+	// history holds the *routeHistory of each route pattern, see WithReportRetention.
+	history sync.Map
+	// lateSites holds the *lateSite of each callSite writing after its handler timed out, see LateWrites.
+	lateSites sync.Map
+	// scopes holds the *Timeout of each scope whose routes were served by t, see Scope.
+	scopes sync.Map
+	// inFlight tracks the requests being served under a timeout.
+	inFlight gauge
+	// spilled is the disk space used by the spilled responses, see WithSpillQuota.
+	spilled atomic.Int64
+	// shedCounts are the numbers of requests rejected by the admission control, indexed by ShedReason.
+	shedCounts [ShedDraining + 1]atomic.Uint64
+	// orphaned tracks the handlers still running after timing out.
+	orphaned gauge
+	// dt is the global timeout, and resp the timeout response replacing the configured one, if set, see
+	// SetTimeout and SetResponse.
+	dt   atomic.Int64
+	resp atomic.Pointer[fox.HandlerFunc]
+	// applied are the rules applied with Apply, if any.
+	applied atomic.Pointer[appliedRules]
+	// changes records the changes made with SetTimeout, SetResponse and Apply.
+	changes changeLog
+	outer   bool
 }
 
 // Middleware returns a [fox.MiddlewareFunc] that runs handlers with the given time limit.
 //
 // The middleware calls the next handler to handle each request, but if a call runs for longer than its time limit,
 // the handler responds with a 503 Service Unavailable error and the given message in its body (if a custom response
-// handler is not configured). After such a timeout, writes by the handler to its ResponseWriter will return [http.ErrHandlerTimeout].
+// handler is not configured). After such a timeout, writes by the handler to its ResponseWriter will return a
+// [*TimeoutWriteError] matching [http.ErrHandlerTimeout].
 //
-// The timeout middleware supports the [http.Pusher] interface but does not support the [http.Hijacker] or [http.Flusher] interfaces.
+// The timeout middleware supports the [http.Pusher] interface but does not support the [http.Hijacker] interface, nor the
+// [http.Flusher] interface unless the streaming mode is enabled (see [WithStreaming]). Requests asking to upgrade the
+// connection (e.g. WebSocket handshakes, with the "Connection: Upgrade" header) bypass the timeout, so that their
+// handler can hijack the connection, although the [OverrideRead] and [OverrideWrite] deadlines still apply.
 //
 // Individual routes can override the timeout duration using the [OverrideHandler] option. It's also possible to set the read
 // and write deadline for individual route using the [OverrideRead] and [OverrideWrite] option.
@@ -50,109 +111,1454 @@ func Middleware(dt time.Duration, opts ...Option) fox.MiddlewareFunc {
 	return create(dt, opts...).run
 }
 
+// New returns a [Timeout] that runs handlers with the given time limit. It behaves as [Middleware], but gives access
+// to the methods of the Timeout, such as [Timeout.Prewarm]. The middleware is obtained with [Timeout.Middleware].
+func New(dt time.Duration, opts ...Option) *Timeout {
+	return create(dt, opts...)
+}
+
+// Middleware returns the [fox.MiddlewareFunc] of t. See [Middleware] for details.
+func (t *Timeout) Middleware() fox.MiddlewareFunc {
+	return t.run
+}
+
+// SetTimeout atomically replaces the global timeout of t, given to [New], so that it can be adjusted at runtime
+// (e.g. during an incident) without restarting the server. It applies to the requests starting after the call,
+// and does not affect the timeouts of the routes set by other sources, such as [OverrideHandler]. If d <= 0, the
+// handlers that have no other timeout are no longer bounded. The change is recorded, see [Timeout.Changes].
+func (t *Timeout) SetTimeout(d time.Duration) {
+	old := time.Duration(t.dt.Swap(int64(d)))
+	t.changes.record(t.now(), "timeout", old.String(), d.String(), changeSource())
+}
+
+// SetResponse atomically replaces the handler writing the timeout response, as configured with [WithResponse]. It
+// applies to the timeouts occurring after the call. If h is nil, the response configured when t was created is
+// restored. The change is recorded, see [Timeout.Changes].
+func (t *Timeout) SetResponse(h fox.HandlerFunc) {
+	var hp *fox.HandlerFunc
+	if h != nil {
+		hp = &h
+	}
+	old := t.cfg.resp
+	if p := t.resp.Swap(hp); p != nil {
+		old = *p
+	}
+	t.changes.record(t.now(), "response", handlerName(old), handlerName(t.response()), changeSource())
+}
+
+// timeout returns the global timeout of t.
+func (t *Timeout) timeout() time.Duration {
+	return time.Duration(t.dt.Load())
+}
+
+// response returns the handler writing the timeout response.
+func (t *Timeout) response() fox.HandlerFunc {
+	if h := t.resp.Load(); h != nil {
+		return *h
+	}
+	return t.cfg.resp
+}
+
+// Prewarm pre-populates the pools of response buffers with n buffers of bufSize bytes, and, if the middleware is
+// configured with [WithCompression], the pool of gzip writers with n writers. It is intended to be called before
+// accepting traffic (e.g. during a readiness check) to avoid an allocation storm in the first seconds after a
+// deploy at high request rate. Pooled items not used are eventually released by the garbage collector, so Prewarm
+// should be called shortly before the traffic is expected.
+func (t *Timeout) Prewarm(n, bufSize int) {
+	for range n {
+		t.putBuffer(bytes.NewBuffer(make([]byte, 0, max(bufSize, 0))))
+		if t.cfg.compression != nil {
+			gzipPool.Put(gzip.NewWriter(io.Discard))
+		}
+	}
+}
+
+// Outer returns a [fox.MiddlewareFunc] that bounds the execution of the entire middleware chain that follows it
+// (e.g. authentication, logging and the handler), rather than only the handler. It is intended to be registered
+// first, and can be combined with [Middleware] registered closer to the handler: since deadlines nest, the handler
+// is then bounded by whichever of the chain and the handler deadline expires first.
+//
+// Outer uses the same writer and buffering machinery as [Middleware] and accepts the same options, but the chain
+// budget dt is measured from the chain entry, which is recorded and available with [EntryTime]. The budget is not
+// affected by the [OverrideHandler], [OverrideRead] and [OverrideWrite] route options, which remain the concern of
+// [Middleware], although [WithHostTimeouts] still applies. If dt <= 0, this is a passthrough middleware.
+func Outer(dt time.Duration, opts ...Option) fox.MiddlewareFunc {
+	t := create(dt, opts...)
+	t.outer = true
+	return func(next fox.HandlerFunc) fox.HandlerFunc {
+		h := t.run(next)
+		return func(c *fox.Context) {
+			req := c.Request()
+			c.SetRequest(req.WithContext(context.WithValue(req.Context(), entryKey{}, t.now())))
+			h(c)
+		}
+	}
+}
+
+// EntryTime returns the time at which the request entered the middleware chain, as recorded by [Outer]. It reports
+// false if the request was not served behind [Outer]. Together with the time spent in the handler, it allows
+// telling apart slow middleware running before the handler from slow handlers.
+func EntryTime(c *fox.Context) (time.Time, bool) {
+	entry, ok := c.Request().Context().Value(entryKey{}).(time.Time)
+	return entry, ok
+}
+
+// Deadline returns the deadline of the handler serving c, as enforced by the middleware. It reports false if the
+// request is not served under a timeout. Unlike the deadline of the request context, it is also known when the
+// context is left undisturbed (see [Never]), so templates and render helpers that only have access to c can adapt
+// to the remaining budget.
+func Deadline(c *fox.Context) (time.Time, bool) {
+	if s, ok := c.Request().Context().Value(scopeKey{}).(*scope); ok {
+		return s.end(), true
+	}
+	return time.Time{}, false
+}
+
+// ReportError reports an error encountered by the handler serving c. If err is or wraps
+// [context.DeadlineExceeded], typically because the handler derived a context with a shorter timeout than the
+// middleware and gave up when it expired, the request is classified as timed out once the handler returns: its
+// response is discarded in favor of the timeout response, and an [EventTimeout] is emitted with [Event.Internal]
+// set. This way, internal deadline failures are responded to and reported consistently with the deadline enforced
+// by the middleware. It reports whether err was classified as a timeout, which is never the case if the request is
+// not served under a timeout.
+func ReportError(c *fox.Context, err error) bool {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	s, ok := c.Request().Context().Value(scopeKey{}).(*scope)
+	if !ok {
+		return false
+	}
+	s.expired.Store(true)
+	return true
+}
+
+// ErrTimeout is the cause of the cancellation of the handler context once the deadline enforced by the middleware
+// is exceeded, as returned by [context.Cause], so that handlers and downstream libraries can tell that the request
+// was killed by the middleware, rather than by the client or an upstream deadline. It implements [net.Error] with
+// Timeout reporting true, matches [context.DeadlineExceeded] with [errors.Is], and unwraps to
+// [http.ErrHandlerTimeout]. The error of the handler context, as returned by its Err method, remains
+// [context.DeadlineExceeded]. See [Reason] for a message naming the route and its timeout.
+var ErrTimeout error = timeoutError{}
+
+type timeoutError struct{}
+
+// Error returns the error message.
+func (timeoutError) Error() string {
+	return "timeout: handler timed out"
+}
+
+// Timeout reports true, see net.Error.
+func (timeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary reports true, see net.Error.
+func (timeoutError) Temporary() bool {
+	return true
+}
+
+// Unwrap returns http.ErrHandlerTimeout.
+func (timeoutError) Unwrap() error {
+	return http.ErrHandlerTimeout
+}
+
+// Is reports whether target is context.DeadlineExceeded.
+func (timeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// DeadlineError is the error returned by [Checkpoint] once the deadline of the handler has passed. It matches
+// [context.DeadlineExceeded] and [ErrTimeout] with [errors.Is].
+type DeadlineError struct {
+	// Deadline is the deadline of the handler, see [Deadline].
+	Deadline time.Time
+}
+
+// Error returns the error message.
+func (e *DeadlineError) Error() string {
+	return "timeout: handler deadline exceeded"
+}
+
+// Is reports whether target is [context.DeadlineExceeded] or [ErrTimeout].
+func (e *DeadlineError) Is(target error) bool {
+	return target == context.DeadlineExceeded || target == ErrTimeout
+}
+
+// Checkpoint reports whether the handler serving c should stop. It is intended to be called in the tight loops of
+// CPU-bound handlers, which cannot select on the request context, to make them cancellation-aware. It returns a
+// [*DeadlineError] once the deadline enforced by the middleware has passed, including when the context is left
+// undisturbed (see [Never]), or the cause of the cancellation of the request context, e.g. when the client goes
+// away or the server base context is canceled on shutdown. Otherwise, it returns nil. Checkpoint only compares the
+// current time with the deadline and checks the request context, so it is cheap enough to be called frequently.
+func Checkpoint(c *fox.Context) error {
+	ctx := c.Request().Context()
+	if s, ok := ctx.Value(scopeKey{}).(*scope); ok {
+		if deadline := s.end(); !s.t.now().Before(deadline) {
+			return &DeadlineError{Deadline: deadline}
+		}
+	}
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return nil
+}
+
+// Reason returns a human-readable reason of the cancellation of ctx, the handler context or a context derived from
+// it, e.g. "route /slow exceeded 2s handler timeout", so that the downstream libraries only logging ctx.Err() can be
+// given a richer message. If ctx was not canceled by the middleware, e.g. because the client went away, the reason is
+// the cause of the cancellation (see [context.Cause]), prefixed by the route. It returns the empty string if ctx is
+// not canceled.
+func Reason(ctx context.Context) string {
+	if ctx.Err() == nil {
+		return ""
+	}
+	cause := context.Cause(ctx)
+	s, ok := ctx.Value(scopeKey{}).(*scope)
+	if !ok {
+		return cause.Error()
+	}
+	route := "request"
+	if s.pattern != "" {
+		route = "route " + s.pattern
+	}
+	switch {
+	case cause != ErrTimeout:
+		return route + ": " + cause.Error()
+	case s.idle > 0 && s.end().Before(s.bound):
+		return route + " exceeded " + s.idle.String() + " idle timeout"
+	default:
+		return route + " exceeded " + s.timeout.String() + " handler timeout"
+	}
+}
+
 func create(dt time.Duration, opts ...Option) *Timeout {
 	cfg := defaultConfig()
 	for _, opt := range opts {
 		opt.apply(cfg)
 	}
 
-	return &Timeout{
-		dt:  dt,
-		cfg: cfg,
+	if cfg.profileDt != 0 {
+		dt = cfg.profileDt
 	}
+	t := &Timeout{cfg: cfg}
+	t.dt.Store(int64(dt))
+	return t
 }
 
-// run is the internal handler that applies the timeout logic.
-func (t *Timeout) run(next fox.HandlerFunc) fox.HandlerFunc {
+// handle is the internal handler that applies the timeout logic, resolving the mode of the route on every request.
+func (t *Timeout) handle(next fox.HandlerFunc) fox.HandlerFunc {
+	// scoped holds the handlers of the scopes wrapping next, see delegate.
+	var scoped sync.Map
 	return func(c *fox.Context) {
-		t.setDeadline(c)
-		dt := t.resolveTimeout(c)
-		if dt <= 0 {
+		if s := t.scoped(c); s != nil {
+			t.delegate(s, c, &scoped, next)
+			return
+		}
+		if !t.cfg.reentrant && c.Request().Context().Value(activeKey{}) == t {
+			// The request is redispatched by a handler already running under this middleware, so the deadline
+			// and writer in place are reused.
 			next(c)
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(c.Request().Context(), dt)
-		defer cancel()
-
-		req := c.Request().WithContext(ctx)
-		done := make(chan struct{})
-		panicChan := make(chan any, 1)
-
-		w := c.Writer()
-		buf := bufp.Get().(*bytes.Buffer)
-		defer bufp.Put(buf)
-		buf.Reset()
+		if t.cfg.skipper != nil && t.cfg.skipper(c) {
+			t.passthrough(c, next)
+			return
+		}
+		if ra := t.cfg.requestAge; ra != nil && ra.expired(c.Request().Header, t.now()) {
+			// The client has most likely given up on the request already.
+			t.shed(c, ShedAge)
+			return
+		}
 
-		tw := &timeoutWriter{
-			w:       w,
-			headers: make(http.Header),
-			req:     req,
-			code:    http.StatusOK,
-			buf:     buf,
+		t.setDeadline(c)
+		if isUpgrade(c.Request()) {
+			// The connection is about to be hijacked, which the buffered writer cannot support.
+			t.passthrough(c, next)
+			return
+		}
+		if idle, ok := t.streamBypass(c); ok {
+			// The request streams its response, which is only bounded by its idle write deadline.
+			if idle > 0 {
+				cp := c.CloneWith(&slidingWriter{ResponseWriter: c.Writer(), idle: idle}, c.Request())
+				defer cp.Close()
+				c = cp
+			}
+			t.passthrough(c, next)
+			return
+		}
+		if idle, ok := t.idleWrite(c); ok {
+			cp := c.CloneWith(&slidingWriter{ResponseWriter: c.Writer(), idle: idle}, c.Request())
+			defer cp.Close()
+			c = cp
+		} else if perMB, ok := t.writePerMB(c); ok {
+			cp := c.CloneWith(&sizedWriter{ResponseWriter: c.Writer(), perMB: perMB}, c.Request())
+			defer cp.Close()
+			c = cp
+		}
+		if dt, ok := t.bodyTimeout(c); ok && hasBody(c.Request()) {
+			req := c.Request()
+			rr := req.WithContext(req.Context())
+			rr.Body = &timedBody{ReadCloser: req.Body, w: c.Writer(), dt: dt}
+			c.SetRequest(rr)
+		}
+		dt, clamp := t.resolveTimeout(c)
+		if t.cfg.debugger != nil {
+			dt = t.cfg.debugger.stretch(dt)
+		}
+		if dt <= 0 {
+			if t.cfg.unbounded == nil {
+				t.passthrough(c, next)
+				return
+			}
+			start := t.now()
+			t.passthrough(c, next)
+			t.detectUnbounded(c, t.since(start))
+			return
+		}
+		if a := t.cfg.relax; a != nil && !t.outer {
+			if r := a.relaxed(t.routeKey(c)); r != nil {
+				t.shadow(c, next, r, dt)
+				return
+			}
+		}
+		// probe reports whether the request probes the half-open circuit breaker of its route, until it is settled.
+		probe := false
+		if b := t.cfg.breaker; b != nil && !t.outer {
+			key := t.routeKey(c)
+			var ok bool
+			if ok, probe = b.allow(key, t.now()); !ok {
+				t.reject(c, dt)
+				return
+			}
+			defer func() {
+				if probe {
+					// The request was not served to completion, e.g. because it was shed or its handler panicked.
+					b.release(key)
+				}
+			}()
+		}
+		if s := t.cfg.shutdown; s != nil {
+			now := t.now()
+			if end, ok := s.deadline(now); ok && now.Add(dt).After(end) {
+				// The handler would hold up the shutdown.
+				t.shed(c, ShedDraining)
+				return
+			}
+		}
+		if a := t.cfg.admission; a != nil {
+			if reason := a.acquire(c.Request().Context(), t.cfg.clock); reason != 0 {
+				t.shed(c, reason)
+				return
+			}
+			defer a.release(t.cfg.clock)
+		}
+		if a := t.routeAdmission(c); a != nil {
+			if reason := a.acquire(c.Request().Context(), t.cfg.clock); reason != 0 {
+				t.shed(c, reason)
+				return
+			}
+			defer a.release(t.cfg.clock)
 		}
 
-		cp := c.CloneWith(tw, req)
+		if replay := t.replayBody(c); replay != nil {
+			defer replay.release()
+		}
+		var outcome *Outcome
+		if t.cfg.logOutcome {
+			// The request is left in place once served, so that the logging middleware running before can read
+			// the outcome from its context. The outcome stays OutcomePanicked if serve panics.
+			outcome = new(Outcome)
+			*outcome = OutcomePanicked
+			req := c.Request()
+			c.SetRequest(req.WithContext(context.WithValue(req.Context(), outcomeKey{}, outcome)))
+		}
 
-		go func() {
+		start := t.now()
+		pattern := t.routeKey(c)
+		counters := t.routeCounters(pattern)
+		counters.requests.Add(1)
+		t.inFlight.inc()
+		defer t.inFlight.dec()
+		if t.cfg.routeInFlight {
+			counters.inFlight.inc()
+			defer counters.inFlight.dec()
+		}
+		rec := t.cfg.recorder
+		if rec != nil {
+			rec.record(RecordStart, pattern, t.deadline(start, dt))
+		}
+		// A response whose headers were sent without its body is aborted once the request is accounted for, see
+		// FlushHeaders.
+		var abort bool
+		defer func() {
+			if abort {
+				panic(http.ErrAbortHandler)
+			}
+		}()
+		var span oteltrace.Span
+		if t.cfg.tracer != nil {
+			span = t.startSpan(c, pattern, dt, clamp)
 			defer func() {
-				cp.Close()
 				if p := recover(); p != nil {
-					panicChan <- p
+					endSpanPanic(span, p, t.since(start))
+					panic(p)
 				}
 			}()
-			next(cp)
-			close(done)
+		}
+		h := next
+		if t.cfg.chaos != nil && t.cfg.chaos.pick() {
+			h = t.cfg.chaos.wrap(next, t.cfg.clock)
+		}
+		// If the handler panics, its start record is left unmatched.
+		panicked := true
+		defer func() {
+			if panicked {
+				counters.panicked.Add(1)
+			}
 		}()
+		var res result
+		switch t.cfg.enforcement {
+		case ConnDeadline:
+			res = t.serveConn(c, h, start, dt)
+		case WriteGated:
+			res = t.serveGated(c, h, start, dt, t.respond)
+		case Soft:
+			res = t.serveSoft(c, h, start, dt)
+		default:
+			res = t.serve(c, h, start, dt, t.respond)
+		}
+		panicked = false
+		abort = res.abort
+		if rec != nil {
+			kind := RecordDone
+			if res.timedOut {
+				kind = RecordTimeout
+			}
+			rec.record(kind, pattern, t.deadline(start, dt))
+		}
+		o := res.outcome()
+		if outcome != nil {
+			*outcome = o
+		}
+		elapsed := t.since(start)
+		counters.add(o)
+		counters.observe(elapsed)
+		if a := t.cfg.adaptive; a != nil && !t.outer && o != OutcomeClientGone {
+			a.observe(pattern, elapsed)
+		}
+		relaxed := false
+		if a := t.cfg.relax; a != nil && !t.outer && o != OutcomeClientGone {
+			relaxed = a.observe(pattern, o == OutcomeTimedOut, t.now())
+		}
+		opened := false
+		if b := t.cfg.breaker; b != nil && !t.outer && o != OutcomeClientGone {
+			opened = b.observe(pattern, probe, o == OutcomeTimedOut, t.now())
+			probe = false
+		}
+		budget, _ := unwrapRouteTimeout(t.route(c.Route()), bKey{})
+		if budget > 0 && elapsed > budget {
+			counters.overBudget.Add(1)
+		}
+		t.recordReport(pattern, t.now(), elapsed, dt, res.timedOut)
+		if span != nil {
+			endSpan(span, o, t.since(start))
+		}
+		if t.cfg.metrics != nil {
+			obs := Observation{
+				Pattern: pattern,
+				Method:  c.Method(),
+				Version: t.cfg.version,
+				Timeout: dt,
+				Elapsed: elapsed,
+				Budget:  budget,
+				Outcome: o,
+				Clamp:   clamp,
+			}
+			if res.timedOut {
+				obs.TraceID = traceID(c.Request().Context(), span)
+			}
+			if o == OutcomeTimedOut {
+				obs.Overshoot = res.overshoot
+			}
+			t.cfg.metrics.Observe(obs)
+		}
+		var fields map[string]any
+		if res.timedOut && t.cfg.enricher != nil {
+			// The enricher is called once for all the telemetry of the timeout.
+			fields = t.cfg.enricher(c)
+		}
+		if hook := t.cfg.onTimeout; res.timedOut && (res.canceled || hook != nil) {
+			if res.canceled {
+				hook = t.cfg.onCanceled
+			}
+			if hook != nil {
+				hook(c, TimeoutInfo{
+					Pattern:   pattern,
+					Timeout:   dt,
+					Elapsed:   t.since(start),
+					Buffered:  res.buffered,
+					Partial:   res.partial,
+					ID:        res.id,
+					Fields:    fields,
+					Stack:     res.stack,
+					Overshoot: res.overshoot,
+					Clamp:     clamp,
+				})
+			}
+		}
+		if w := t.cfg.warn; w != nil && o == OutcomeCompleted {
+			if elapsed := t.since(start); elapsed >= w.threshold {
+				w.fn(c, elapsed)
+			}
+		}
+		if res.timedOut && t.cfg.logger != nil {
+			t.logTimeout(c, pattern, dt, t.since(start), res, fields)
+		}
+		switch {
+		case res.timedOut:
+			kind := EventTimeout
+			if res.canceled {
+				kind = EventCanceled
+			}
+			t.emit(c, Event{
+				Kind:      kind,
+				Timeout:   dt,
+				Clamp:     clamp,
+				Elapsed:   t.since(start),
+				Overshoot: res.overshoot,
+				Internal:  res.internal,
+				ID:        res.id,
+				Fields:    fields,
+				Stack:     res.stack,
+			})
+		case res.headerTooLarge:
+			t.emit(c, Event{
+				Kind:    EventHeaderTooLarge,
+				Timeout: dt,
+				Clamp:   clamp,
+				Elapsed: t.since(start),
+			})
+		}
+		if relaxed {
+			t.emit(c, Event{
+				Kind:    EventRelaxed,
+				Timeout: dt,
+				Clamp:   clamp,
+			})
+		}
+		if opened {
+			t.emit(c, Event{
+				Kind:    EventBreakerOpen,
+				Timeout: dt,
+				Clamp:   clamp,
+			})
+		}
+	}
+}
 
-		select {
-		case p := <-panicChan:
-			panic(p)
-		case <-done:
-			tw.mu.Lock()
-			defer tw.mu.Unlock()
-			dst := w.Header()
-			maps.Copy(dst, tw.headers)
+// result is the outcome of serving a request under a timeout.
+type result struct {
+	// overshoot is how long after the deadline the timeout response was written.
+	overshoot time.Duration
+	timedOut  bool
+	// canceled reports whether the handler was abandoned because the request was canceled rather than because
+	// of the deadline.
+	canceled bool
+	// internal reports whether the handler reported the expiration of a deadline it derived, see ReportError.
+	internal       bool
+	headerTooLarge bool
+	// buffered reports whether the handler had started its response when it was abandoned.
+	buffered bool
+	// partial reports whether the partial response of the handler was sent instead of the timeout response.
+	partial bool
+	// abort reports whether the response must be aborted, its headers being sent without its body, see
+	// FlushHeaders.
+	abort bool
+	// id is the identifier of the timeout event, see WithEventID.
+	id string
+	// stack is the stack trace of the handler goroutine when it was abandoned, see WithStackDump.
+	stack []byte
+}
+
+// outcome returns the outcome of a request that did not panic.
+func (r result) outcome() Outcome {
+	switch {
+	case r.canceled:
+		return OutcomeClientGone
+	case r.timedOut:
+		return OutcomeTimedOut
+	default:
+		return OutcomeCompleted
+	}
+}
+
+// serve calls next with a buffered writer and a context that expires after dt. If next completes in time, its
+// buffered response is written to the client. Otherwise, respond is called to write the timeout response.
+func (t *Timeout) serve(c *fox.Context, next fox.HandlerFunc, start time.Time, dt time.Duration, respond fox.HandlerFunc) result {
+	deadline := t.deadline(start, dt)
+	auditMonotonic("deadline", deadline)
+	parent := c.Request().Context()
+	if t.cfg.contextFilter != nil {
+		parent = filterContext{Context: parent, keep: t.cfg.contextFilter}
+	}
+	if t.cfg.traceRegions && trace.IsEnabled() {
+		var task *trace.Task
+		parent, task = trace.NewTask(parent, traceName(c))
+		defer task.End()
+	}
+
+	sc := &scope{t: t, deadline: deadline, pattern: c.Pattern(), timeout: dt}
+	limit := t.cfg.maxExtension
+	if idle, _ := unwrapRouteTimeout(t.route(c.Route()), kKey{}); idle > 0 && idle < dt {
+		// The deadline is reset by the heartbeats of the handler, up to the deadline of the handler timeout.
+		sc.deadline = t.deadline(start, idle)
+		sc.idle = idle
+		sc.bound = deadline
+		limit += deadline.Sub(sc.deadline)
+	}
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if limit > 0 {
+		sc.ext, cancel = withExtendableDeadline(parent, t.cfg.clock, sc.deadline, limit, ErrTimeout)
+		ctx = sc.ext
+	} else {
+		ctx, cancel = withDeadlineCause(t.cfg.clock, parent, deadline, ErrTimeout)
+	}
+	defer cancel()
+	var (
+		firstByte       <-chan time.Time
+		cancelFirstByte context.CancelCauseFunc
+	)
+	fb := t.firstByte(c)
+	if fb > 0 && fb < dt {
+		timer := t.cfg.clock.NewTimer(fb)
+		defer timer.Stop()
+		firstByte = timer.C()
+		ctx, cancelFirstByte = context.WithCancelCause(ctx)
+		defer cancelFirstByte(nil)
+	}
+
+	var (
+		draining    <-chan struct{}
+		drained     <-chan time.Time
+		drainEnd    time.Time
+		cancelDrain context.CancelCauseFunc
+	)
+	if s := t.cfg.shutdown; s != nil {
+		draining = s.ctx.Done()
+		ctx, cancelDrain = context.WithCancelCause(ctx)
+		defer cancelDrain(nil)
+	}
+
+	pol := t.resolvePolicy(c)
+	closeConn := pol.closeConn
+	hctx := ctx
+	if pol.keepContext {
+		// The handler must be left undisturbed, including after the request completes.
+		hctx = context.WithoutCancel(parent)
+	}
+	hctx = context.WithValue(hctx, scopeKey{}, sc)
+	if !t.cfg.reentrant {
+		hctx = context.WithValue(hctx, activeKey{}, t)
+	}
+	req := c.Request().WithContext(hctx)
+	var cont *continueBody
+	if ct := t.cfg.continueTO; ct > 0 && expectsContinue(req) {
+		cont = &continueBody{ReadCloser: req.Body, w: c.Writer(), timeout: ct}
+		if dt, ok := t.connDeadline(c, rKey{}, t.cfg.readDeadline); ok && !t.outer {
+			cont.restore = start.Add(dt)
+		}
+		req.Body = cont
+	}
+	var body *poisonBody
+	if pol.cancelBody && hasBody(req) {
+		body = &poisonBody{ReadCloser: req.Body}
+		req.Body = body
+	}
+	var idle *idleBody
+	if t.cfg.requestTimeout && hasBody(req) {
+		idle = &idleBody{ReadCloser: req.Body}
+		req.Body = idle
+	}
+	w := c.Writer()
+	rc := t.routeCounters(t.routeKey(c))
+	buf := t.getSizedBuffer(rc)
+	defer t.putBuffer(buf)
+
+	// The writer is recycled only once the handler is known to have returned, since nothing else holds it by then.
+	tw := writerPool.Get().(*timeoutWriter)
+	recycle := false
+	defer func() {
+		if recycle {
+			putWriter(tw)
+		}
+	}()
+	*tw = timeoutWriter{
+		w:       w,
+		headers: tw.headers,
+		exit:    tw.exit,
+		req:     req,
+		code:    http.StatusOK,
+		buf:     buf,
+		ctx:     ctx,
+		stream:  t.streaming(c),
+		limit:   t.cfg.responseLimit,
+		sites:   &t.lateSites,
+		dt:      dt,
+		t:       t,
+		start:   start,
+		pattern: c.Pattern(),
+		cont:    cont,
+	}
+	if _, idle := t.idleWrite(c); tw.stream && !idle && !t.outer {
+		if wdt, ok := t.writeDeadline(c); ok {
+			// The deadline set by setDeadline, which the streamed writes must not postpone.
+			tw.wdeadline = time.Now().Add(wdt)
+		}
+	}
+	if l := t.cfg.responseLimit; l != nil && l.strategy == OverflowSpill {
+		tw.spillDir = t.cfg.spillDir
+		tw.quota = t.spillQuota(c)
+	}
+	defer func() {
+		// The arbiter is settled by now, so the handler can no longer write to the file.
+		if tw.spill != nil {
+			tw.spill.remove()
+		}
+		tw.quota.release()
+	}()
+
+	if sw, ok := w.(*sizedWriter); ok && !tw.stream {
+		// The response is written at once when the handler is done, so its size is known by then.
+		sw.whole = true
+	}
+
+	cp := c.CloneWith(tw, req)
+
+	if t.cfg.profileLabels {
+		tw.labels = handlerLabels(req.Context(), t.routeKey(c), req.Method, dt, deadline)
+	}
+
+	var (
+		orph orphan
+		// gid is the identifier of the handler goroutine, see WithStackDump.
+		gid atomic.Uint64
+	)
+	go func() {
+		defer func() {
+			if orph.finish() {
+				t.orphaned.dec()
+				if t.cfg.profileLabels {
+					orphanProfile().Remove(&orph)
+				}
+				if t.cfg.onLate != nil {
+					t.cfg.onLate(cp, max(t.since(sc.end()), 0))
+				}
+			}
+			cp.Close()
+			p := recover()
+			if p != nil && tw.arb.Abandoned() {
+				if pol.logOrphanPanic {
+					log.Printf("timeout: panic serving %s after timeout: %v\n%s", req.URL.Path, p, debug.Stack())
+				}
+				return
+			}
+			// This is the last access to tw, which may be recycled as soon as the exit is received.
+			tw.exit <- p
+		}()
+		if t.cfg.traceRegions {
+			defer trace.StartRegion(ctx, traceName(cp)).End()
+		}
+		if tw.labels != nil {
+			pprof.SetGoroutineLabels(tw.labels)
+		}
+		if pol.stackDump {
+			gid.Store(goroutineID())
+		}
+		next(cp)
+	}()
+
+	// complete writes the response of the handler, once it is done.
+	complete := func() result {
+		if tw.through {
+			// The handler wrote its response directly to the client.
+			return result{}
+		}
+		rc.observeSize(tw.n)
+		if sc.expired.Load() {
+			// The handler gave up on a deadline it derived, its response is replaced as if it timed out.
+			tw.arb.Abandon(http.ErrHandlerTimeout)
+			defer tw.arb.Release()
+			tw.unbound()
+			id := t.eventID(w, tw.sent)
+			if !tw.sent {
+				t.writeTimeout(c, respond, start, sc.end(), dt, context.DeadlineExceeded)
+			}
+			// The headers were sent with FlushHeaders, but not the body.
+			abort := tw.early && !tw.stream
+			return result{timedOut: true, internal: true, buffered: tw.written, id: id, abort: abort}
+		}
+		// The handler is done, so nothing else can settle the arbiter.
+		tw.arb.Commit()
+		defer tw.arb.Release()
+		if idle != nil && idle.expired && !tw.sent {
+			// The client did not send the body in time, the response of the handler to the read error is replaced.
+			w.Header().Set("Connection", "close")
+			http.Error(w, http.StatusText(http.StatusRequestTimeout), http.StatusRequestTimeout)
+			return result{}
+		}
+		if tw.stream {
+			tw.sendHeaderLocked()
+			if tw.budget {
+				w.Header().Set(t.cfg.budgetTrailer, formatMillis(max(t.until(sc.end()), 0)))
+			}
+			return result{}
+		}
+		if tw.early {
+			// The status and headers were sent with FlushHeaders, only the body is left.
+			tw.writeBodyLocked(w)
+			return result{}
+		}
+		if t.cfg.preCommit != nil {
+			if err := t.cfg.preCommit(c); err != nil {
+				// The response of the handler is vetoed, and replaced as if it timed out.
+				id := t.eventID(w, false)
+				t.writeTimeout(c, respond, start, sc.end(), dt, err)
+				return result{timedOut: true, internal: true, buffered: tw.written, id: id}
+			}
+		}
+		if n := t.cfg.maxHeaderBytes; n > 0 && headerSize(tw.headers) > n {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return result{headerTooLarge: true}
+		}
+		dst := w.Header()
+		maps.Copy(dst, tw.headers)
+		if t.cfg.elapsedHeader != "" {
+			dst.Set(t.cfg.elapsedHeader, formatMillis(t.since(start)))
+		}
+		body := tw.buf
+		if t.cfg.compression != nil && tw.spill == nil {
+			zbuf := t.getBuffer()
+			defer t.putBuffer(zbuf)
+			if t.cfg.compression.compress(zbuf, req, dst, tw.code, tw.buf.Bytes(), t.until(sc.end())) {
+				body = zbuf
+			}
+		}
+		trailer := t.declareBudgetTrailer(req, dst)
+		if tw.spill != nil {
+			if !trailer && dst.Get("Content-Length") == "" {
+				dst.Set("Content-Length", strconv.FormatInt(tw.spill.size, 10))
+			}
 			w.WriteHeader(tw.code)
-			_, _ = w.Write(tw.buf.Bytes())
+			_ = tw.spill.writeTo(w)
+		} else {
+			w.WriteHeader(tw.code)
+			_, _ = w.Write(body.Bytes())
+		}
+		if trailer {
+			dst.Set(t.cfg.budgetTrailer, formatMillis(max(t.until(sc.end()), 0)))
+		}
+		return result{}
+	}
+
+	var err error
+wait:
+	for {
+		select {
+		case p := <-tw.exit:
+			if p != nil {
+				panic(p)
+			}
+			recycle = true
+			return complete()
+		case <-firstByte:
+			// The handler did not start its response in time, unless it did meanwhile.
+			firstByte = nil
+			if !tw.arb.abandonIf(func() bool { return !tw.written }, http.ErrHandlerTimeout) {
+				continue
+			}
+			err = http.ErrHandlerTimeout
+			cancelFirstByte(&DeadlineError{Deadline: start.Add(fb)})
+			break wait
+		case <-draining:
+			// The server is shutting down, so the deadline is clamped to the drain deadline.
+			draining = nil
+			if end, _ := t.cfg.shutdown.deadline(t.now()); end.Before(sc.end()) {
+				drainEnd = end
+				timer := t.cfg.clock.NewTimer(t.until(end))
+				defer timer.Stop()
+				drained = timer.C()
+			}
+		case <-drained:
+			drained = nil
+			// The handler may have taken over its response, which is then left to complete.
+			if !tw.arb.Abandon(http.ErrHandlerTimeout) {
+				continue
+			}
+			err = http.ErrHandlerTimeout
+			closeConn = true
+			cancelDrain(&DeadlineError{Deadline: drainEnd})
+			break wait
 		case <-ctx.Done():
-			tw.mu.Lock()
-			defer tw.mu.Unlock()
-			switch err := ctx.Err(); err {
-			case context.DeadlineExceeded:
-				tw.err = http.ErrHandlerTimeout
-			default:
-				tw.err = err
+			err = handlerErr(ctx.Err())
+			if grace := t.cfg.gracePeriod; grace > 0 && err == http.ErrHandlerTimeout && !tw.stream {
+				// The handler may be about to complete, in which case its response is worth the wait. In streaming mode,
+				// the writes of the handler fail past the deadline, so its response would be truncated anyway.
+				timer := t.cfg.clock.NewTimer(grace)
+				select {
+				case p := <-tw.exit:
+					timer.Stop()
+					if p != nil {
+						panic(p)
+					}
+					recycle = true
+					return complete()
+				case <-timer.C():
+				}
+			}
+			// The handler is still running, so only the handler itself can have settled the arbiter, by taking over the
+			// response, which is then left to complete.
+			if !tw.arb.Abandon(err) {
+				if p := <-tw.exit; p != nil {
+					panic(p)
+				}
+				recycle = true
+				return result{}
+			}
+			break wait
+		}
+	}
+	defer tw.arb.Release()
+	tw.unbound()
+	orphaned := false
+	if err == http.ErrHandlerTimeout {
+		// The gauge is incremented first, so that it never goes negative if the handler returns meanwhile.
+		t.orphaned.inc()
+		if t.cfg.profileLabels {
+			orphanProfile().Add(&orph, 1)
+		}
+		if orphaned = orph.abandon(); !orphaned {
+			t.orphaned.dec()
+			if t.cfg.profileLabels {
+				orphanProfile().Remove(&orph)
+			}
+		}
+	}
+	if closeConn && !tw.sent {
+		w.Header().Set("Connection", "close")
+	}
+	id := t.eventID(w, tw.sent)
+	res := result{timedOut: true, canceled: err != http.ErrHandlerTimeout}
+	// In streaming mode, the response may already be under way, in which case it is ended as is, unless it is a
+	// JSON array that can be closed properly.
+	if ja := tw.array; ja != nil && ja.open() && err == http.ErrHandlerTimeout {
+		ja.truncate(w, tw, req)
+		res.partial = !tw.sent || tw.early
+	} else if tw.early && !tw.stream {
+		// The headers were sent with FlushHeaders, so the body is either truncated safely, or the response aborted.
+		res.partial = err == http.ErrHandlerTimeout && tw.truncateEarly(w)
+		res.abort = !res.partial
+	} else if !tw.sent {
+		if pf := t.cfg.partial; pf != nil && err == http.ErrHandlerTimeout && tw.n > 0 {
+			pf.flush(w, tw, req)
+			res.partial = true
+		} else if res.canceled {
+			t.writeCanceled(c)
+		} else {
+			t.writeTimeout(c, respond, start, sc.end(), dt, context.Cause(ctx))
+		}
+	}
+	if orphaned && pol.stackDump {
+		// The stack is dumped once the timeout response is written, so as not to delay it.
+		res.stack = goroutineStack(gid.Load())
+	}
+	auditMonotonic("timeout start", start)
+	res.overshoot = max(t.since(sc.end()), 0)
+	res.buffered = tw.written
+	res.id = id
+	if body != nil {
+		body.poison()
+		drained := false
+		if d := t.cfg.bodyDrain; d != nil {
+			// The body is drained before the timeout response is sent, since the server would otherwise discard it
+			// itself when sending the response, regardless of the limits of the drain.
+			drained = body.drain(w, d)
+		}
+		if !drained {
+			// Unblock any read in progress. Errors are ignored for the same reason as in setDeadline.
+			_ = w.SetReadDeadline(time.Now())
+		}
+	}
+	if t.cfg.traceRegions {
+		trace.Log(ctx, "timeout", dt.String())
+	}
+	return res
+}
+
+// logTimeout logs the timeout of the handler serving c with the logger configured with WithLogger.
+func (t *Timeout) logTimeout(c *fox.Context, pattern string, dt, elapsed time.Duration, res result, fields map[string]any) {
+	attrs := []slog.Attr{
+		slog.String("method", c.Method()),
+		slog.String("path", c.Path()),
+		slog.String("pattern", pattern),
+		slog.Duration("timeout", dt),
+		slog.Duration("elapsed", elapsed),
+	}
+	if res.id != "" {
+		attrs = append(attrs, slog.String("id", res.id))
+	}
+	if ip, err := t.clientIP(c); err == nil {
+		attrs = append(attrs, slog.String("client_ip", ip.String()))
+	}
+	if res.canceled {
+		attrs = append(attrs, slog.Bool("canceled", true))
+	}
+	if t.cfg.version != "" {
+		attrs = append(attrs, slog.String("version", t.cfg.version))
+	}
+	if len(fields) > 0 {
+		group := make([]any, 0, len(fields))
+		for _, k := range slices.Sorted(maps.Keys(fields)) {
+			group = append(group, slog.Any(k, fields[k]))
+		}
+		attrs = append(attrs, slog.Group("fields", group...))
+	}
+	t.cfg.logger.LogAttrs(c.Request().Context(), slog.LevelWarn, "handler timeout", attrs...)
+}
+
+// eventID generates the identifier of a timeout event and, unless the response is already sent, sets it in the
+// response header configured with WithEventID. It returns an empty string if no generator is configured.
+func (t *Timeout) eventID(w http.ResponseWriter, sent bool) string {
+	if t.cfg.eventID == nil {
+		return ""
+	}
+	id := t.cfg.eventID.generate()
+	if !sent && t.cfg.eventID.header != "" {
+		w.Header().Set(t.cfg.eventID.header, id)
+	}
+	return id
+}
+
+// isUpgrade reports whether r asks to upgrade the connection to another protocol, such as WebSocket.
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for token := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(textproto.TrimString(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handlerErr returns the error returned to a handler writing its response after its context is done.
+func handlerErr(err error) error {
+	if err == context.DeadlineExceeded {
+		return http.ErrHandlerTimeout
+	}
+	return err
+}
+
+// writeTimeout calls respond to write the timeout response, enforcing the RFC compliance if enabled. The error is
+// the cause of the handler context being done, see WithErrorResponse. The handler started at start and was due by
+// deadline, see WithResponseFunc.
+func (t *Timeout) writeTimeout(c *fox.Context, respond fox.HandlerFunc, start, deadline time.Time, dt time.Duration, err error) {
+	if t.cfg.retryAfter != nil {
+		if d := t.cfg.retryAfter(c); d > 0 {
+			c.Writer().Header().Set("Retry-After", formatRetryAfter(int64((d+time.Second-1)/time.Second)))
+		}
+	}
+	// The built-in responses read the details of the timeout from the request context, see timeoutDetails.
+	req := c.Request()
+	status := cmp.Or(t.cfg.statusCode, http.StatusServiceUnavailable)
+	if !t.outer {
+		status = cmp.Or(t.cfg.policy.status(c.Pattern(), c.Method()), status)
+	}
+	info := &responseInfo{
+		status:    status,
+		timeout:   dt,
+		err:       err,
+		pattern:   t.routeKey(c),
+		elapsed:   t.since(start),
+		overshoot: max(t.since(deadline), 0),
+	}
+	cp := c.CloneWith(c.Writer(), req.WithContext(context.WithValue(req.Context(), responseKey{}, info)))
+	defer cp.Close()
+	c = cp
+	if budget := t.responseBudget(c); budget > 0 {
+		bounded := respond
+		respond = func(c *fox.Context) { t.respondBounded(c, bounded, budget) }
+	}
+	if !req.ProtoAtLeast(1, 1) {
+		inner := respond
+		respond = func(c *fox.Context) { t.respondHTTP10(c, inner) }
+	}
+	if t.cfg.rfc != nil {
+		t.cfg.rfc.respond(c, respond)
+		return
+	}
+	respond(c)
+}
+
+// respond writes the timeout response, either by dispatching to the fallback route configured with
+// [OverrideFallbackRoute], or by calling the configured response handler.
+func (t *Timeout) respond(c *fox.Context) {
+	if r := t.route(c.Route()); r != nil && !t.outer {
+		if pattern, ok := r.Annotation(fKey{}).(string); ok {
+			if fallback := lookupFallback(c, pattern); fallback != nil {
+				req := c.Request()
+				if req.GetBody != nil {
+					if body, err := req.GetBody(); err == nil {
+						rr := req.WithContext(req.Context())
+						rr.Body = body
+						c.SetRequest(rr)
+						defer c.SetRequest(req)
+					}
+				}
+				budget := t.cfg.fallbackBudget
+				if t.cfg.debugger != nil {
+					budget = t.cfg.debugger.stretch(budget)
+				}
+				t.serve(c, fallback.Handle, t.now(), budget, t.routeResponse(c))
+				return
+			}
+		}
+	}
+	t.routeResponse(c)(c)
+}
+
+// spillQuota returns the quota of the spilled response of the request, or nil if it is not limited.
+func (t *Timeout) spillQuota(c *fox.Context) *spillQuota {
+	q := &spillQuota{global: &t.spilled, globalLimit: t.cfg.spillQuota}
+	if r := t.route(c.Route()); r != nil {
+		if n, ok := r.Annotation(qKey{}).(int64); ok && n > 0 {
+			q.route, q.routeLimit = &t.routeCounters(t.routeKey(c)).spilled, n
+		}
+	}
+	if q.globalLimit == 0 && q.routeLimit == 0 {
+		return nil
+	}
+	return q
+}
+
+// routeResponse returns the handler writing the timeout response of the route, set with [OverrideResponse], or the
+// configured response handler.
+func (t *Timeout) routeResponse(c *fox.Context) fox.HandlerFunc {
+	if r := t.route(c.Route()); r != nil {
+		if h, ok := r.Annotation(oKey{}).(fox.HandlerFunc); ok {
+			return h
+		}
+	}
+	return t.response()
+}
+
+// replayBody wraps the request body so that it can be replayed to the fallback handler, if the body replay is
+// enabled and the route has a fallback. It returns nil if the body is not wrapped.
+func (t *Timeout) replayBody(c *fox.Context) *replayBody {
+	if t.cfg.replay == nil || t.outer {
+		return nil
+	}
+	r := t.route(c.Route())
+	if r == nil || r.Annotation(fKey{}) == nil {
+		return nil
+	}
+	req := c.Request()
+	if !hasBody(req) || req.GetBody != nil {
+		return nil
+	}
+
+	replay := newReplayBody(req.Body, t.cfg.replay.memLimit, t.cfg.replay.maxSize)
+	rr := req.WithContext(req.Context())
+	rr.Body = replay.reader()
+	rr.GetBody = replay.getBody
+	c.SetRequest(rr)
+	return replay
+}
+
+// lookupFallback returns the route registered with the given pattern that handles the request method, or nil
+// if there is none.
+func lookupFallback(c *fox.Context, pattern string) *fox.Route {
+	for r := range c.Router().Iter().Routes(pattern) {
+		for method := range r.Methods() {
+			if method == c.Method() {
+				return r
 			}
-			t.cfg.resp(c)
 		}
 	}
+	return nil
+}
+
+// streaming reports whether the request is served in streaming mode.
+func (t *Timeout) streaming(c *fox.Context) bool {
+	if r := t.route(c.Route()); r != nil {
+		if enable, ok := r.Annotation(mKey{}).(bool); ok {
+			return enable
+		}
+	}
+	return t.cfg.streaming
+}
+
+// policy holds the behaviors applied to a handler that exceeded its deadline.
+type policy struct {
+	cancelBody     bool
+	closeConn      bool
+	logOrphanPanic bool
+	keepContext    bool
+	stackDump      bool
+}
+
+func (t *Timeout) resolvePolicy(c *fox.Context) policy {
+	level := Standard
+	if t.cfg.keepBody {
+		level = Lenient
+	}
+	if r := t.route(c.Route()); r != nil {
+		if s, ok := r.Annotation(sKey{}).(Strictness); ok {
+			level = s
+		}
+	}
+
+	var pol policy
+	switch level {
+	case Lenient:
+	case Strict:
+		pol = policy{cancelBody: true, closeConn: true, logOrphanPanic: true, stackDump: true}
+	default:
+		pol = policy{cancelBody: true}
+	}
+
+	if r := t.route(c.Route()); r != nil {
+		switch r.Annotation(aKey{}) {
+		case Immediate:
+			pol.cancelBody, pol.keepContext = true, false
+		case Graceful:
+			pol.cancelBody, pol.keepContext = false, false
+		case Never:
+			pol.cancelBody, pol.keepContext = false, true
+		}
+	}
+	pol.stackDump = pol.stackDump || t.cfg.stackDump
+	return pol
+}
+
+// declareBudgetTrailer declares the trailer set with WithBudgetTrailer in the header dst of the response to req, and
+// reports whether it did. Trailers require a chunked response, which is not possible if the handler set the
+// Content-Length.
+func (t *Timeout) declareBudgetTrailer(req *http.Request, dst http.Header) bool {
+	if t.cfg.budgetTrailer == "" || !req.ProtoAtLeast(1, 1) || dst.Get("Content-Length") != "" {
+		return false
+	}
+	dst.Add("Trailer", t.cfg.budgetTrailer)
+	return true
+}
+
+// passthrough calls next without enforcing any timeout.
+func (t *Timeout) passthrough(c *fox.Context, next fox.HandlerFunc) {
+	if !t.cfg.traceRegions || !trace.IsEnabled() {
+		next(c)
+		return
+	}
+
+	req := c.Request()
+	ctx, task := trace.NewTask(req.Context(), traceName(c))
+	defer task.End()
+	c.SetRequest(req.WithContext(ctx))
+	defer c.SetRequest(req)
+	defer trace.StartRegion(ctx, traceName(c)).End()
+	next(c)
 }
 
-func (t *Timeout) resolveTimeout(c *fox.Context) time.Duration {
-	if dt, ok := unwrapRouteTimeout(c.Route(), hKey{}); ok {
-		return dt
+// detectUnbounded emits an EventUnboundedRoute, once per route, if a request served without timeout had a body
+// or lasted longer than the thresholds configured with WithUnboundedRouteDetection.
+func (t *Timeout) detectUnbounded(c *fox.Context, elapsed time.Duration) {
+	r := c.Route()
+	if r == nil {
+		return
+	}
+
+	th := t.cfg.unbounded
+	if (th.bodySize <= 0 || c.Request().ContentLength <= th.bodySize) && (th.duration <= 0 || elapsed <= th.duration) {
+		return
+	}
+	if _, loaded := t.unbounded.LoadOrStore(r, struct{}{}); loaded {
+		return
 	}
-	return t.dt
+	t.emit(c, Event{
+		Kind:    EventUnboundedRoute,
+		Elapsed: elapsed,
+	})
+}
+
+// deadline returns the absolute deadline for a handler started at start and allowed to run for dt, rounded up
+// to the configured precision. The rounding is done with time.Time.Add so the monotonic clock reading is preserved.
+func (t *Timeout) deadline(start time.Time, dt time.Duration) time.Time {
+	return roundUp(start.Add(dt), t.cfg.precision)
 }
 
 func (t *Timeout) setDeadline(c *fox.Context) {
+	if t.outer {
+		return
+	}
 	// Errors are intentionally ignored: the underlying connection may not support deadlines
 	// (e.g., http.ErrNotSupported), and there's no actionable recovery in this context.
-	if dt, ok := unwrapRouteTimeout(c.Route(), rKey{}); ok {
+	if dt, ok := t.connDeadline(c, rKey{}, t.cfg.readDeadline); ok {
 		_ = c.Writer().SetReadDeadline(time.Now().Add(dt))
 	}
-	if dt, ok := unwrapRouteTimeout(c.Route(), wKey{}); ok {
+	if _, ok := t.idleWrite(c); ok {
+		// The write deadline is set before each write, see slidingWriter.
+		return
+	}
+	if _, ok := t.streamBypass(c); ok {
+		// The response is only bounded by its idle write deadline, if any.
+		return
+	}
+	if dt, ok := t.writeDeadline(c); ok {
 		_ = c.Writer().SetWriteDeadline(time.Now().Add(dt))
 	}
 }
 
+// writeDeadline returns the write deadline of the connection set for the route, if any, including the extension set
+// with WithWriteDeadlineExtension.
+func (t *Timeout) writeDeadline(c *fox.Context) (time.Duration, bool) {
+	dt, ok := t.connDeadline(c, wKey{}, t.cfg.writeDeadline)
+	if !ok {
+		return 0, false
+	}
+	if ext := t.cfg.writeExtension; ext != nil {
+		dt += min(max(ext.estimate(c.Request()), 0), ext.limit)
+	}
+	return dt, true
+}
+
+// connDeadline returns the connection deadline set for the route under k, or def if the route has none and def is
+// positive.
+func (t *Timeout) connDeadline(c *fox.Context, k any, def time.Duration) (time.Duration, bool) {
+	return t.routeDeadline(t.route(c.Route()), c.Pattern(), c.Method(), k, def)
+}
+
+// routeDeadline is connDeadline for the requests with the given method served by the route r. The deadlines set for
+// the route take precedence over the ones set by the policy, see WithPolicy.
+func (t *Timeout) routeDeadline(r *fox.Route, pattern, method string, k any, def time.Duration) (time.Duration, bool) {
+	if dt, ok := unwrapRouteTimeout(r, k); ok {
+		return dt, true
+	}
+	if dt, ok := t.cfg.policy.deadline(pattern, method, k); ok {
+		return dt, true
+	}
+	return def, def > 0
+}
+
+// idleWrite returns the sliding write deadline of the route, if any, see OverrideWriteIdle.
+func (t *Timeout) idleWrite(c *fox.Context) (time.Duration, bool) {
+	if t.outer {
+		return 0, false
+	}
+	dt, ok := t.connDeadline(c, iKey{}, t.cfg.idleWrite)
+	return dt, ok && dt > 0
+}
+
+// bodyTimeout returns the time allowed to read the request body of the route, if any, see OverrideBody.
+func (t *Timeout) bodyTimeout(c *fox.Context) (time.Duration, bool) {
+	if t.outer {
+		return 0, false
+	}
+	dt, ok := t.connDeadline(c, dKey{}, t.cfg.bodyTimeout)
+	return dt, ok && dt > 0
+}
+
+// writePerMB returns the write deadline per megabyte of response of the route, if any, see OverrideWritePerMB.
+func (t *Timeout) writePerMB(c *fox.Context) (time.Duration, bool) {
+	if t.outer {
+		return 0, false
+	}
+	dt, ok := unwrapRouteTimeout(t.route(c.Route()), pKey{})
+	return dt, ok && dt > 0
+}
+
+// routeKey returns the route pattern used to label the telemetry for the request.
+func (t *Timeout) routeKey(c *fox.Context) string {
+	return t.patternKey(c.Pattern())
+}
+
+// patternKey returns the key of the telemetry of the given route pattern, see WithRouteNormalizer.
+func (t *Timeout) patternKey(pattern string) string {
+	if t.cfg.normalize != nil {
+		return t.cfg.normalize(pattern)
+	}
+	return pattern
+}
+
+// traceName returns the name of the runtime/trace task and region for the request.
+func traceName(c *fox.Context) string {
+	if pattern := c.Pattern(); pattern != "" {
+		return pattern
+	}
+	return "timeout"
+}
+
+// headerSize returns the size in bytes of the header block h would be written as, ignoring the status line.
+func headerSize(h http.Header) int {
+	var n int
+	for k, vv := range h {
+		for _, v := range vv {
+			n += len(k) + len(v) + 4 // ": " and CRLF
+		}
+	}
+	return n
+}
+
+// formatMillis formats d as a decimal number of milliseconds, e.g. "12.345ms".
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64) + "ms"
+}
+
+// monotonic reports whether tm carries a monotonic clock reading. Deadlines and elapsed times must be computed
+// from such times so that wall clock jumps (e.g. NTP adjustments) cannot prematurely time out a request.
+func monotonic(tm time.Time) bool {
+	return tm != tm.Round(0)
+}
+
+func roundUp(tm time.Time, p time.Duration) time.Time {
+	if p <= 0 {
+		return tm
+	}
+	if rem := time.Duration(tm.UnixNano() % int64(p)); rem > 0 {
+		return tm.Add(p - rem)
+	}
+	return tm
+}
+
 func checkWriteHeaderCode(code int) {
 	if code < 100 || code > 999 {
 		panic(fmt.Sprintf("invalid status code %d", code))
 	}
 }
 
+// skipCaller reports whether fn is a function of this package, of fox or of the standard library, which are involved
+// in a write but not responsible for it.
+func skipCaller(fn string) bool {
+	if strings.HasPrefix(fn, "github.com/fox-toolkit/timeout.") || strings.HasPrefix(fn, "github.com/fox-toolkit/fox.") {
+		return true
+	}
+	if strings.HasPrefix(fn, "main.") {
+		return false
+	}
+	// The import path of the standard library packages has no dot in its first element.
+	first, _, _ := strings.Cut(fn, "/")
+	if first == fn {
+		first, _, _ = strings.Cut(fn, ".")
+	}
+	return !strings.Contains(first, ".")
+}
+
+// relevantCaller returns the frame of the function responsible for the ongoing write.
 func relevantCaller() runtime.Frame {
 	pc := make([]uintptr, 16)
 	n := runtime.Callers(1, pc)
@@ -160,7 +1566,7 @@ func relevantCaller() runtime.Frame {
 	var frame runtime.Frame
 	for {
 		f, more := frames.Next()
-		if !strings.HasPrefix(f.Function, "github.com/fox-toolkit/timeout.") {
+		if !skipCaller(f.Function) {
 			return f
 		}
 		if !more {
@@ -6,12 +6,25 @@ package timeout
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -72,6 +85,56 @@ func panicResponse(c *fox.Context) {
 	panic("test")
 }
 
+func TestMiddleware_WithSafeResponse(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithSafeResponse(panicResponse))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, fmt.Sprintf("%s\n", http.StatusText(http.StatusServiceUnavailable)), w.Body.String())
+
+	f, err = fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithSafeResponse(timeoutResponse))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestTimeout, w.Code)
+}
+
+func TestMiddleware_WithSafeResponseDeadline(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     []Option
+		deadline time.Duration
+	}{
+		{name: "default", opts: []Option{WithFallbackBudget(time.Minute)}, deadline: 100 * time.Millisecond},
+		{name: "custom", opts: []Option{WithSafeResponseDeadline(2 * time.Second)}, deadline: 2 * time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]Option{WithSafeResponse(timeoutResponse)}, tc.opts...)
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, opts...)))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+			w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+			start := time.Now()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+			assert.Equal(t, http.StatusRequestTimeout, w.Code)
+			assert.WithinDuration(t, start.Add(tc.deadline), w.writeDeadline, 50*time.Millisecond)
+		})
+	}
+}
+
 func TestMiddleware_WithPanic(t *testing.T) {
 	f, err := fox.NewRouter(
 		fox.WithMiddleware(
@@ -116,6 +179,7 @@ func TestMiddleware_ErrNotSupported(t *testing.T) {
 		assert.ErrorIs(t, hijErr, http.ErrNotSupported)
 		assert.ErrorIs(t, c.Writer().SetReadDeadline(time.Now()), http.ErrNotSupported)
 		assert.ErrorIs(t, c.Writer().SetWriteDeadline(time.Now()), http.ErrNotSupported)
+		assert.ErrorIs(t, c.Writer().EnableFullDuplex(), http.ErrNotSupported)
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
@@ -123,6 +187,155 @@ func TestMiddleware_ErrNotSupported(t *testing.T) {
 	f.ServeHTTP(w, req)
 }
 
+func TestMiddleware_ResponseController(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20 * time.Millisecond)))
+	require.NoError(t, err)
+	at := time.Now().Add(time.Minute)
+	errs := make(chan error, 3)
+	release := make(chan struct{})
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		rc := http.NewResponseController(c.Writer())
+		require.NoError(t, rc.SetReadDeadline(at))
+		require.NoError(t, rc.SetWriteDeadline(at))
+		_ = c.String(http.StatusOK, "ok")
+	})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+		<-release
+		rc := http.NewResponseController(c.Writer())
+		errs <- rc.SetReadDeadline(at)
+		errs <- rc.SetWriteDeadline(at)
+		errs <- rc.EnableFullDuplex()
+	})
+
+	w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, at, w.readDeadline)
+	assert.Equal(t, at, w.writeDeadline)
+
+	// Once the handler timed out, the connection belongs to the timeout response.
+	w = &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	close(release)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	for range 3 {
+		assert.ErrorIs(t, <-errs, http.ErrHandlerTimeout)
+	}
+	assert.True(t, w.readDeadline.IsZero())
+	assert.True(t, w.writeDeadline.IsZero())
+}
+
+func TestMiddleware_UpgradeBypass(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Millisecond)))
+	require.NoError(t, err)
+	hijacked := make(chan error, 1)
+	f.MustAdd(fox.MethodGet, "/ws", func(c *fox.Context) {
+		conn, _, err := c.Writer().Hijack()
+		if err == nil {
+			_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+			_ = conn.Close()
+		}
+		hijacked <- err
+	})
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.NoError(t, <-hijacked)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+}
+
+func TestIsUpgrade(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.False(t, isUpgrade(req))
+	req.Header.Set("Connection", "Upgrade")
+	assert.False(t, isUpgrade(req))
+	req.Header.Set("Upgrade", "websocket")
+	assert.True(t, isUpgrade(req))
+	req.Header.Set("Connection", "keep-alive")
+	assert.False(t, isUpgrade(req))
+}
+
+func TestCapabilities(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(1 * time.Second)))
+	require.NoError(t, err)
+
+	caps := make(chan WriterCapabilities, 1)
+	f.MustAdd(fox.MethodGet, "/timed", func(c *fox.Context) {
+		caps <- Capabilities(c.Writer())
+	})
+	f.MustAdd(fox.MethodGet, "/untimed", func(c *fox.Context) {
+		caps <- Capabilities(c.Writer())
+	}, OverrideHandler(NoTimeout))
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/timed", nil))
+	assert.Equal(t, WriterCapabilities{
+		Push:          true,
+		ReadFrom:      true,
+		ReadDeadline:  true,
+		WriteDeadline: true,
+		FullDuplex:    true,
+	}, <-caps)
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/untimed", nil))
+	assert.Equal(t, WriterCapabilities{
+		Flush:         true,
+		Hijack:        true,
+		Push:          true,
+		ReadFrom:      true,
+		ReadDeadline:  true,
+		WriteDeadline: true,
+		FullDuplex:    true,
+	}, <-caps)
+}
+
+func TestMiddleware_WithStreaming(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond, WithStreaming())))
+	require.NoError(t, err)
+	errs := make(chan error, 2)
+	f.MustAdd(fox.MethodGet, "/events", func(c *fox.Context) {
+		c.SetHeader("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(c.Writer(), "data: 1\n\n")
+		errs <- c.Writer().FlushError()
+		<-c.Request().Context().Done()
+		_, err := io.WriteString(c.Writer(), "data: 2\n\n")
+		errs <- err
+	})
+	f.MustAdd(fox.MethodGet, "/late", success201response, OverrideHandler(time.Millisecond))
+	f.MustAdd(fox.MethodGet, "/buffered", func(c *fox.Context) {
+		errs <- c.Writer().FlushError()
+		_ = c.String(http.StatusOK, "ok")
+	}, OverrideStreaming(false))
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+	require.NoError(t, <-errs)
+	assert.ErrorIs(t, <-errs, http.ErrHandlerTimeout)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "data: 1\n\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/late", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/buffered", nil))
+	assert.ErrorIs(t, <-errs, http.ErrNotSupported)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, w.Flushed)
+}
+
 func TestMiddleware_WithHandlerTimeout(t *testing.T) {
 	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(1 * time.Millisecond)))
 	require.NoError(t, err)
@@ -184,6 +397,62 @@ func TestMiddleware_WithReadTimeout(t *testing.T) {
 	assert.True(t, called)
 }
 
+func TestMiddleware_WithBodyTimeout(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithBodyTimeout(50*time.Millisecond))))
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	read := func(c *fox.Context) {
+		// The body is read lazily, after the body timeout would have expired if measured from the request start.
+		time.Sleep(100 * time.Millisecond)
+		_, err := io.ReadAll(c.Request().Body)
+		errs <- err
+		c.Writer().WriteHeader(http.StatusOK)
+	}
+	f.MustAdd(fox.MethodPost, "/foo", read)
+	f.MustAdd(fox.MethodPost, "/bar", read, OverrideBody(NoTimeout))
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	t.Run("complete in time", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/foo", "text/plain", strings.NewReader("hello"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.NoError(t, <-errs)
+	})
+
+	t.Run("exceeded", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		defer pw.Close()
+		go func() {
+			_, _ = pw.Write([]byte("hello"))
+		}()
+		resp, err := http.Post(srv.URL+"/foo", "text/plain", pr)
+		if err == nil {
+			resp.Body.Close()
+		}
+		err = <-errs
+		var bodyErr *BodyTimeoutError
+		require.ErrorAs(t, err, &bodyErr)
+		assert.Equal(t, 50*time.Millisecond, bodyErr.Duration)
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	})
+
+	t.Run("route disabled", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		go func() {
+			_, _ = pw.Write([]byte("hello"))
+			time.Sleep(200 * time.Millisecond)
+			pw.Close()
+		}()
+		resp, err := http.Post(srv.URL+"/bar", "text/plain", pr)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.NoError(t, <-errs)
+	})
+}
+
 func TestMiddleware_WithWriteTimeout(t *testing.T) {
 	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(NoTimeout)))
 	require.NoError(t, err)
@@ -211,24 +480,2890 @@ func TestMiddleware_WithWriteTimeout(t *testing.T) {
 	assert.Less(t, n, int64(10*1024*1024))
 }
 
-func ExampleOverrideHandler() {
-	f, err := fox.NewRouter(
-		fox.WithMiddleware(Middleware(2 * time.Second)),
-	)
-	if err != nil {
-		panic(err)
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (r *deadlineRecorder) SetReadDeadline(deadline time.Time) error {
+	r.readDeadline = deadline
+	return nil
+}
+
+func (r *deadlineRecorder) SetWriteDeadline(deadline time.Time) error {
+	r.writeDeadline = deadline
+	return nil
+}
+
+func TestMiddleware_WithWriteDeadlineExtension(t *testing.T) {
+	estimate := func(r *http.Request) time.Duration {
+		d, _ := time.ParseDuration(r.Header.Get("X-Client-RTT"))
+		return d
 	}
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(NoTimeout, WithWriteDeadlineExtension(estimate, time.Second))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {}, OverrideWrite(time.Second))
 
-	f.MustAdd(fox.MethodGet, "/hello/{name}", func(c *fox.Context) {
-		_ = c.String(http.StatusOK, fmt.Sprintf("hello %s\n", c.Param("name")))
+	cases := []struct {
+		name string
+		rtt  string
+		want time.Duration
+	}{
+		{name: "no estimate", want: time.Second},
+		{name: "extended", rtt: "300ms", want: 1300 * time.Millisecond},
+		{name: "capped", rtt: "5s", want: 2 * time.Second},
+		{name: "negative", rtt: "-1s", want: time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			req.Header.Set("X-Client-RTT", tc.rtt)
+			w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+			start := time.Now()
+			f.ServeHTTP(w, req)
+			assert.WithinDuration(t, start.Add(tc.want), w.writeDeadline, 100*time.Millisecond)
+		})
+	}
+}
+
+func TestMiddleware_WithConnDeadlines(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(NoTimeout, WithReadDeadline(time.Second), WithWriteDeadline(2*time.Second))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/default", func(c *fox.Context) {})
+	f.MustAdd(fox.MethodGet, "/override", func(c *fox.Context) {}, OverrideRead(3*time.Second), OverrideWrite(4*time.Second))
+
+	cases := []struct {
+		name      string
+		path      string
+		wantRead  time.Duration
+		wantWrite time.Duration
+	}{
+		{name: "default", path: "/default", wantRead: time.Second, wantWrite: 2 * time.Second},
+		{name: "route override", path: "/override", wantRead: 3 * time.Second, wantWrite: 4 * time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+			start := time.Now()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.WithinDuration(t, start.Add(tc.wantRead), w.readDeadline, 100*time.Millisecond)
+			assert.WithinDuration(t, start.Add(tc.wantWrite), w.writeDeadline, 100*time.Millisecond)
+		})
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(NoTimeout)))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {})
+		w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.True(t, w.readDeadline.IsZero())
+		assert.True(t, w.writeDeadline.IsZero())
 	})
+}
 
-	f.MustAdd(fox.MethodGet, "/long", func(c *fox.Context) {
-		time.Sleep(10 * time.Second)
-		c.Writer().WriteHeader(http.StatusOK)
-	}, OverrideHandler(12*time.Second))
+type slidingRecorder struct {
+	*httptest.ResponseRecorder
+	deadlines []time.Time
+}
 
-	f.MustAdd(fox.MethodGet, "/no-timeout", func(c *fox.Context) {
+func (r *slidingRecorder) SetWriteDeadline(deadline time.Time) error {
+	r.deadlines = append(r.deadlines, deadline)
+	return nil
+}
+
+func TestMiddleware_WithIdleWriteTimeout(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 100*1024)
+	handler := func(c *fox.Context) {
+		_, _ = c.Writer().Write(body)
+	}
+
+	cases := []struct {
+		name string
+		dt   time.Duration
+		opts []fox.RouteOption
+		want int
+	}{
+		// The response is split into 32KB chunks, each preceded by a new deadline.
+		{name: "passthrough", dt: NoTimeout, want: 4},
+		{name: "buffered", dt: time.Second, want: 4},
+		{name: "absolute write deadline ignored", dt: time.Second, opts: []fox.RouteOption{OverrideWrite(time.Hour)}, want: 4},
+		{name: "route disabled", dt: time.Second, opts: []fox.RouteOption{OverrideWriteIdle(NoTimeout)}, want: 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(tc.dt, WithIdleWriteTimeout(time.Second))))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/foo", handler, tc.opts...)
+
+			w := &slidingRecorder{ResponseRecorder: httptest.NewRecorder()}
+			start := time.Now()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+			assert.Equal(t, body, w.Body.Bytes())
+			require.Len(t, w.deadlines, tc.want)
+			for _, d := range w.deadlines {
+				assert.WithinDuration(t, start.Add(time.Second), d, 100*time.Millisecond)
+			}
+		})
+	}
+
+	t.Run("route override", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(NoTimeout)))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/foo", handler, OverrideWriteIdle(time.Hour))
+
+		w := &slidingRecorder{ResponseRecorder: httptest.NewRecorder()}
+		start := time.Now()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		require.Len(t, w.deadlines, 4)
+		assert.WithinDuration(t, start.Add(time.Hour), w.deadlines[3], 100*time.Millisecond)
+	})
+
+	t.Run("capped by conn deadline enforcement", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithEnforcement(ConnDeadline), WithIdleWriteTimeout(time.Hour))))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/foo", handler)
+
+		w := &slidingRecorder{ResponseRecorder: httptest.NewRecorder()}
+		start := time.Now()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		require.NotEmpty(t, w.deadlines)
+		for _, d := range w.deadlines {
+			assert.WithinDuration(t, start.Add(time.Second), d, 100*time.Millisecond)
+		}
+	})
+}
+
+func TestMiddleware_OverrideWritePerMB(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 3<<20)
+
+	cases := []struct {
+		name    string
+		dt      time.Duration
+		opts    []Option
+		handler fox.HandlerFunc
+		want    []time.Duration
+	}{
+		{
+			name: "buffered size at commit",
+			dt:   time.Minute,
+			handler: func(c *fox.Context) {
+				_, _ = c.Writer().Write(body)
+			},
+			want: []time.Duration{time.Second, 3 * time.Second},
+		},
+		{
+			name: "buffered small response",
+			dt:   time.Minute,
+			handler: func(c *fox.Context) {
+				_ = c.String(http.StatusOK, "ok")
+			},
+			want: []time.Duration{time.Second, time.Second},
+		},
+		{
+			name: "passthrough with content length",
+			dt:   NoTimeout,
+			handler: func(c *fox.Context) {
+				c.SetHeader("Content-Length", strconv.Itoa(len(body)+1))
+				_, _ = c.Writer().Write(body)
+				_, _ = c.Writer().Write([]byte("x"))
+			},
+			want: []time.Duration{time.Second, 4 * time.Second},
+		},
+		{
+			name: "passthrough without content length",
+			dt:   NoTimeout,
+			handler: func(c *fox.Context) {
+				_, _ = c.Writer().Write(body)
+			},
+			want: []time.Duration{time.Second},
+		},
+		{
+			name: "capped by conn deadline enforcement",
+			dt:   2 * time.Second,
+			opts: []Option{WithEnforcement(ConnDeadline)},
+			handler: func(c *fox.Context) {
+				c.SetHeader("Content-Length", strconv.Itoa(len(body)))
+				_, _ = c.Writer().Write(body)
+			},
+			want: []time.Duration{time.Second, 2 * time.Second},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(tc.dt, append(tc.opts, WithWriteDeadline(time.Second))...)))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/foo", tc.handler, OverrideWritePerMB(time.Second))
+
+			w := &slidingRecorder{ResponseRecorder: httptest.NewRecorder()}
+			start := time.Now()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+			require.Len(t, w.deadlines, len(tc.want))
+			for i, want := range tc.want {
+				assert.WithinDuration(t, start.Add(want), w.deadlines[i], 100*time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestMiddleware_WithEnforcementConnDeadline(t *testing.T) {
+	var infos []TimeoutInfo
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Millisecond,
+		WithEnforcement(ConnDeadline),
+		WithOnTimeout(func(c *fox.Context, info TimeoutInfo) {
+			infos = append(infos, info)
+		}),
+	)))
+	require.NoError(t, err)
+
+	var ctxDeadline bool
+	f.MustAdd(fox.MethodGet, "/fast", func(c *fox.Context) {
+		_, ctxDeadline = c.Request().Context().Deadline()
+		_ = c.String(http.StatusCreated, "created")
+	})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		time.Sleep(100 * time.Millisecond)
+		_ = c.String(http.StatusCreated, "created")
+	})
+	f.MustAdd(fox.MethodGet, "/write", func(c *fox.Context) {}, OverrideWrite(10*time.Millisecond))
+
+	t.Run("completed", func(t *testing.T) {
+		w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+		start := time.Now()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.False(t, ctxDeadline)
+		assert.WithinDuration(t, start.Add(50*time.Millisecond), w.writeDeadline, 20*time.Millisecond)
+		assert.Empty(t, infos)
+	})
+
+	t.Run("earlier route write deadline", func(t *testing.T) {
+		w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+		start := time.Now()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/write", nil))
+		assert.WithinDuration(t, start.Add(10*time.Millisecond), w.writeDeadline, 5*time.Millisecond)
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		// The recorder ignores the write deadline, so the response of the handler goes through.
+		assert.Equal(t, http.StatusCreated, w.Code)
+		require.Len(t, infos, 1)
+		assert.Equal(t, "/slow", infos[0].Pattern)
+	})
+}
+
+func TestMiddleware_WithEnforcementSoft(t *testing.T) {
+	var infos []TimeoutInfo
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond,
+		WithEnforcement(Soft),
+		WithOnTimeout(func(c *fox.Context, info TimeoutInfo) {
+			infos = append(infos, info)
+		}),
+	)))
+	require.NoError(t, err)
+
+	var ctxErr error
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		time.Sleep(40 * time.Millisecond)
+		ctxErr = c.Request().Context().Err()
+		_ = c.String(http.StatusCreated, "created")
+	})
+
+	w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "created", w.Body.String())
+	assert.NoError(t, ctxErr)
+	assert.True(t, w.writeDeadline.IsZero())
+	require.Len(t, infos, 1)
+	assert.Equal(t, "/slow", infos[0].Pattern)
+	assert.GreaterOrEqual(t, infos[0].Overshoot, 20*time.Millisecond)
+	assert.Equal(t, "soft", Soft.String())
+}
+
+func TestMiddleware_WithDebuggerDetection(t *testing.T) {
+	t.Setenv("TIMEOUT_DEBUGGER", "1")
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Millisecond, WithDebuggerDetection())))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestDebuggerDetector(t *testing.T) {
+	var calls int
+	attached := true
+	d := &debuggerDetector{attached: func() bool {
+		calls++
+		return attached
+	}}
+	assert.Equal(t, 100*time.Second, d.stretch(time.Second))
+	attached = false
+	assert.Equal(t, 100*time.Second, d.stretch(time.Second))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, time.Duration(0), d.stretch(0))
+	assert.Equal(t, time.Duration(math.MaxInt64/100*100), d.stretch(math.MaxInt64))
+
+	d.checkedAt.Store(0)
+	assert.Equal(t, time.Second, d.stretch(time.Second))
+	assert.Equal(t, 2, calls)
+}
+
+func TestTimeout_Snapshot(t *testing.T) {
+	tm := New(20*time.Millisecond, WithStreaming())
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/fast", success201response)
+
+	before := tm.Snapshot()
+	for range 3 {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+	s := tm.Snapshot()
+	assert.Equal(t, 20*time.Millisecond, s.Timeout)
+	assert.False(t, s.Outer)
+	assert.True(t, s.Streaming)
+	assert.Equal(t, RouteCounters{Requests: 3, TimedOut: 3}, withoutLatency(s.Routes["/slow"]))
+	assert.Equal(t, RouteCounters{Requests: 1, Completed: 1}, withoutLatency(s.Routes["/fast"]))
+	assert.Empty(t, before.Routes)
+}
+
+func TestTimeout_Stats(t *testing.T) {
+	tm := New(time.Second)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/panic", func(c *fox.Context) {
+		panic("boom")
+	})
+	f.MustAdd(fox.MethodGet, "/sleep", func(c *fox.Context) {
+		time.Sleep(5 * time.Millisecond)
+	})
+	f.MustAdd(fox.MethodGet, "/debug/timeouts", tm.StatsHandler(), OverrideHandler(NoTimeout))
+
+	assert.Panics(t, func() {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	})
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/sleep", nil))
+
+	stats := tm.Stats()
+	assert.Equal(t, RouteCounters{Requests: 1, Panicked: 1}, stats["/panic"])
+	assert.GreaterOrEqual(t, stats["/sleep"].MaxLatency, 5*time.Millisecond)
+	assert.Equal(t, RouteCounters{Requests: 1, Completed: 1}, withoutLatency(stats["/sleep"]))
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/timeouts", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var got map[string]RouteCounters
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, stats, got)
+}
+
+// withoutLatency returns rc without its latency, which is not deterministic.
+func withoutLatency(rc RouteCounters) RouteCounters {
+	rc.MaxLatency = 0
+	return rc
+}
+
+func TestObserveBudget(t *testing.T) {
+	tm := New(20 * time.Millisecond)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/tight", success201response, ObserveBudget(time.Millisecond))
+	f.MustAdd(fox.MethodGet, "/loose", success201response, ObserveBudget(time.Second))
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	}, ObserveBudget(5*time.Millisecond))
+
+	for _, path := range []string{"/tight", "/tight", "/loose", "/slow"} {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	s := tm.Snapshot()
+	assert.Equal(t, RouteCounters{Requests: 2, Completed: 2, OverBudget: 2}, withoutLatency(s.Routes["/tight"]))
+	assert.Equal(t, RouteCounters{Requests: 1, Completed: 1}, withoutLatency(s.Routes["/loose"]))
+	assert.Equal(t, RouteCounters{Requests: 1, TimedOut: 1, OverBudget: 1}, withoutLatency(s.Routes["/slow"]))
+}
+
+func TestTimeout_InFlight(t *testing.T) {
+	tm := New(time.Second, WithInFlightByRoute())
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		started <- struct{}{}
+		<-release
 		c.Writer().WriteHeader(http.StatusOK)
-	}, OverrideHandler(NoTimeout))
+	})
+	f.MustAdd(fox.MethodGet, "/bar", success201response)
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+		}()
+		<-started
+	}
+
+	cur, peak := tm.InFlight()
+	assert.Equal(t, int64(3), cur)
+	assert.Equal(t, int64(3), peak)
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bar", nil))
+	s := tm.Snapshot()
+	assert.Equal(t, int64(3), s.InFlight)
+	assert.Equal(t, int64(4), s.PeakInFlight)
+	assert.Equal(t, int64(3), s.Routes["/foo"].InFlight)
+	assert.Equal(t, int64(1), s.Routes["/bar"].PeakInFlight)
+
+	close(release)
+	wg.Wait()
+	s = tm.Snapshot()
+	assert.Equal(t, int64(0), s.InFlight)
+	assert.Equal(t, int64(4), s.PeakInFlight)
+	assert.Equal(t, int64(0), s.Routes["/foo"].InFlight)
+	assert.Equal(t, int64(3), s.Routes["/foo"].PeakInFlight)
+}
+
+func TestTimeout_Prewarm(t *testing.T) {
+	tm := New(50*time.Millisecond, WithCompression(0, 0))
+	tm.Prewarm(8, 4096)
+	tm.Prewarm(0, -1)
+
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+}
+
+func TestTimeout_SetTimeout(t *testing.T) {
+	tm := New(20 * time.Millisecond)
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		time.Sleep(50 * time.Millisecond)
+		_ = c.String(http.StatusOK, "done")
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	tm.SetTimeout(time.Second)
+	assert.Equal(t, time.Second, tm.Snapshot().Timeout)
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	tm.SetTimeout(NoTimeout)
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestTimeout_SetResponse(t *testing.T) {
+	tm := New(10*time.Millisecond, WithResponse(timeoutResponse))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+
+	tm.SetResponse(func(c *fox.Context) {
+		http.Error(c.Writer(), "degraded", http.StatusGatewayTimeout)
+	})
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, "degraded\n", w.Body.String())
+
+	tm.SetResponse(nil)
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	want := httptest.NewRecorder()
+	timeoutResponse(fox.NewTestContextOnly(want, httptest.NewRequest(http.MethodGet, "/foo", nil)))
+	assert.Equal(t, want.Code, w.Code)
+	assert.Equal(t, want.Body.String(), w.Body.String())
+}
+
+func TestMiddleware_WithErrorResponse(t *testing.T) {
+	errs := make(chan error, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond, WithErrorResponse(func(c *fox.Context, err error) {
+		errs <- err
+		code := http.StatusServiceUnavailable
+		if !errors.Is(err, context.DeadlineExceeded) {
+			code = 499
+		}
+		c.Writer().WriteHeader(code)
+	}))))
+	require.NoError(t, err)
+	started := make(chan struct{}, 1)
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		started <- struct{}{}
+		<-c.Request().Context().Done()
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.ErrorIs(t, <-errs, context.DeadlineExceeded)
+	<-started
+
+	// The canceled requests get the canceled response.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go func() {
+		<-started
+		cancel(errors.New("client gone"))
+	}()
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/slow", nil))
+	assert.Equal(t, StatusClientClosedRequest, w.Code)
+	assert.Empty(t, errs)
+}
+
+func TestMiddleware_WithPreCommit(t *testing.T) {
+	errCommit := errors.New("commit failed")
+	var (
+		events []Event
+		errs   []error
+	)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second,
+		WithPreCommit(func(c *fox.Context) error {
+			if c.Request().Header.Get("X-Fail") != "" {
+				return errCommit
+			}
+			return nil
+		}),
+		WithErrorResponse(func(c *fox.Context, err error) {
+			errs = append(errs, err)
+			DefaultResponse(c)
+		}),
+		WithEventSink(EventSinkFunc(func(e Event) { events = append(events, e) })),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, events)
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	req.Header.Set("X-Fail", "1")
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotContains(t, w.Body.String(), http.StatusText(http.StatusCreated))
+	assert.Equal(t, []error{errCommit}, errs)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventTimeout, events[0].Kind)
+	assert.True(t, events[0].Internal)
+}
+
+//go:noinline
+func blockedHandler(stop <-chan struct{}) {
+	<-stop
+}
+
+func TestMiddleware_WithStackDump(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	infos := make(chan TimeoutInfo, 1)
+	events := make(chan Event, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(
+		10*time.Millisecond,
+		WithStackDump(),
+		WithOnTimeout(func(c *fox.Context, info TimeoutInfo) {
+			infos <- info
+		}),
+		WithEventSink(EventSinkFunc(func(ev Event) {
+			if ev.Kind == EventTimeout {
+				events <- ev
+			}
+		})),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		blockedHandler(stop)
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	info := <-infos
+	assert.True(t, bytes.HasPrefix(info.Stack, []byte("goroutine ")))
+	assert.Contains(t, string(info.Stack), "timeout.blockedHandler")
+	assert.Equal(t, info.Stack, (<-events).Stack)
+}
+
+func TestMiddleware_StrictStackDump(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	infos := make(chan TimeoutInfo, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(
+		10*time.Millisecond,
+		WithOnTimeout(func(c *fox.Context, info TimeoutInfo) {
+			infos <- info
+		}),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/strict", func(c *fox.Context) {
+		blockedHandler(stop)
+	}, OverrideStrictness(Strict))
+	f.MustAdd(fox.MethodGet, "/standard", func(c *fox.Context) {
+		blockedHandler(stop)
+	})
+
+	// The Strict level captures the stack trace without WithStackDump.
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/strict", nil))
+	assert.Contains(t, string((<-infos).Stack), "timeout.blockedHandler")
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/standard", nil))
+	assert.Nil(t, (<-infos).Stack)
+}
+
+func TestGoroutineStack(t *testing.T) {
+	assert.NotZero(t, goroutineID())
+	assert.Nil(t, goroutineStack(0))
+
+	ids := make(chan uint64)
+	stop := make(chan struct{})
+	go func() {
+		ids <- goroutineID()
+		blockedHandler(stop)
+	}()
+	id := <-ids
+	assert.NotEqual(t, goroutineID(), id)
+	assert.Eventually(t, func() bool {
+		return bytes.Contains(goroutineStack(id), []byte("timeout.blockedHandler"))
+	}, time.Second, time.Millisecond)
+	close(stop)
+}
+
+func TestMiddleware_WithTrustedBypass(t *testing.T) {
+	trusted := func(r *http.Request) bool {
+		return r.Header.Get("X-Internal") == "1"
+	}
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithTrustedBypass(trusted))))
+	require.NoError(t, err)
+	explained := make(chan []Step, 1)
+	handler := func(c *fox.Context) {
+		explained <- ResolveExplain(c)
+		_ = c.String(http.StatusOK, "ok")
+	}
+	f.MustAdd(fox.MethodGet, "/backfill", handler, OverrideTrusted(NoTimeout))
+	f.MustAdd(fox.MethodGet, "/extend", handler, OverrideTrusted(time.Minute))
+	f.MustAdd(fox.MethodGet, "/plain", handler)
+
+	cases := []struct {
+		name    string
+		path    string
+		trusted bool
+		want    *Step
+	}{
+		{name: "exempted", path: "/backfill", trusted: true},
+		{name: "extended", path: "/extend", trusted: true, want: &Step{Source: SourceTrusted, Timeout: time.Minute, Found: true, Applied: true}},
+		{name: "untrusted", path: "/extend", want: &Step{Source: SourceGlobal, Timeout: time.Second, Found: true, Applied: true}},
+		{name: "not designated", path: "/plain", trusted: true, want: &Step{Source: SourceGlobal, Timeout: time.Second, Found: true, Applied: true}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.trusted {
+				req.Header.Set("X-Internal", "1")
+			}
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			steps := <-explained
+			if tc.want == nil {
+				assert.Nil(t, steps)
+				return
+			}
+			for _, step := range steps {
+				if step.Applied {
+					assert.Equal(t, *tc.want, step)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddleware_WithOnTimeout(t *testing.T) {
+	var infos []TimeoutInfo
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond, WithOnTimeout(func(c *fox.Context, info TimeoutInfo) {
+		infos = append(infos, info)
+	}))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/partial/{id}", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, "partial")
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/silent", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/fast", success201response)
+
+	for _, path := range []string{"/partial/1", "/silent", "/fast"} {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	require.Len(t, infos, 2)
+	assert.Equal(t, "/partial/{id}", infos[0].Pattern)
+	assert.Equal(t, 20*time.Millisecond, infos[0].Timeout)
+	assert.GreaterOrEqual(t, infos[0].Elapsed, 20*time.Millisecond)
+	assert.True(t, infos[0].Buffered)
+	assert.Equal(t, "/silent", infos[1].Pattern)
+	assert.False(t, infos[1].Buffered)
+}
+
+func TestMiddleware_WithEventID(t *testing.T) {
+	events := make(chan Event, 1)
+	var infos []TimeoutInfo
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond,
+		WithEventID("X-Timeout-Id", func() string { return "evt-1" }),
+		WithEventSink(EventSinkFunc(func(ev Event) { events <- ev })),
+		WithOnTimeout(func(c *fox.Context, info TimeoutInfo) { infos = append(infos, info) }),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/fast", success201response)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "evt-1", w.Header().Get("X-Timeout-Id"))
+	assert.Equal(t, "evt-1", (<-events).ID)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "evt-1", infos[0].ID)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("X-Timeout-Id"))
+}
+
+func TestNewEventID(t *testing.T) {
+	id := NewEventID()
+	assert.Len(t, id, 32)
+	assert.NotEqual(t, id, NewEventID())
+}
+
+func TestMiddleware_WithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond, WithLogger(logger))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/slow/{id}", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/fast", success201response)
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Zero(t, buf.Len())
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow/1", nil))
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "WARN", entry["level"])
+	assert.Equal(t, "handler timeout", entry["msg"])
+	assert.Equal(t, http.MethodGet, entry["method"])
+	assert.Equal(t, "/slow/1", entry["path"])
+	assert.Equal(t, "/slow/{id}", entry["pattern"])
+	assert.Equal(t, float64(20*time.Millisecond), entry["timeout"])
+	assert.GreaterOrEqual(t, entry["elapsed"], float64(20*time.Millisecond))
+	assert.NotContains(t, entry, "canceled")
+}
+
+func TestMiddleware_WithEnricher(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		events []Event
+		infos  []TimeoutInfo
+		calls  int
+	)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond,
+		WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+		WithEventSink(EventSinkFunc(func(e Event) { events = append(events, e) })),
+		WithOnTimeout(func(c *fox.Context, info TimeoutInfo) { infos = append(infos, info) }),
+		WithEnricher(func(c *fox.Context) map[string]any {
+			calls++
+			return map[string]any{"user": c.Request().Header.Get("X-User"), "arm": "b"}
+		}),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/fast", success201response)
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Zero(t, calls)
+
+	req := httptest.NewRequest(http.MethodGet, "/slow", nil)
+	req.Header.Set("X-User", "42")
+	f.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, 1, calls)
+	want := map[string]any{"user": "42", "arm": "b"}
+	require.Len(t, events, 1)
+	assert.Equal(t, want, events[0].Fields)
+	require.Len(t, infos, 1)
+	assert.Equal(t, want, infos[0].Fields)
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, want, entry["fields"])
+}
+
+func TestCheckpoint(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20 * time.Millisecond)))
+	require.NoError(t, err)
+	errs := make(chan error, 2)
+	iterations := make(chan int, 1)
+	f.MustAdd(fox.MethodGet, "/cpu", func(c *fox.Context) {
+		errs <- Checkpoint(c)
+		n := 0
+		for {
+			if err := Checkpoint(c); err != nil {
+				errs <- err
+				break
+			}
+			n++
+		}
+		iterations <- n
+	}, OverrideAbandon(Never))
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cpu", nil))
+	assert.NoError(t, <-errs)
+	err = <-errs
+	var de *DeadlineError
+	require.ErrorAs(t, err, &de)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, de.Deadline.IsZero())
+	assert.Positive(t, <-iterations)
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cause := errors.New("shutdown")
+		cancel(cause)
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		c := fox.NewTestContextOnly(httptest.NewRecorder(), req)
+		assert.ErrorIs(t, Checkpoint(c), cause)
+	})
+}
+
+func TestReason(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10 * time.Millisecond)))
+	require.NoError(t, err)
+	reasons := make(chan string, 2)
+	handler := func(c *fox.Context) {
+		ctx := c.Request().Context()
+		reasons <- Reason(ctx)
+		<-ctx.Done()
+		reasons <- Reason(context.WithValue(ctx, struct{}{}, "derived"))
+	}
+	f.MustAdd(fox.MethodGet, "/slow/{id}", handler)
+	f.MustAdd(fox.MethodGet, "/poll", handler, OverrideHandler(time.Second), OverrideHeartbeat(10*time.Millisecond))
+
+	cases := []struct {
+		name string
+		path string
+		ctx  context.Context
+		want string
+	}{
+		{name: "handler timeout", path: "/slow/1", ctx: context.Background(), want: "route /slow/{id} exceeded 10ms handler timeout"},
+		{name: "idle timeout", path: "/poll", ctx: context.Background(), want: "route /poll exceeded 10ms idle timeout"},
+		{
+			name: "canceled",
+			path: "/slow/1",
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancelCause(context.Background())
+				cancel(errors.New("client went away"))
+				return ctx
+			}(),
+			want: "route /slow/{id}: client went away",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequestWithContext(tc.ctx, http.MethodGet, tc.path, nil))
+			if tc.ctx.Err() == nil {
+				assert.Empty(t, <-reasons)
+			} else {
+				<-reasons
+			}
+			assert.Equal(t, tc.want, <-reasons)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Empty(t, Reason(ctx))
+	cancel()
+	assert.Equal(t, "context canceled", Reason(ctx))
+}
+
+func TestMiddleware_WithWarnThreshold(t *testing.T) {
+	var warned []time.Duration
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Millisecond, WithWarnThreshold(5*time.Millisecond, func(c *fox.Context, elapsed time.Duration) {
+		warned = append(warned, elapsed)
+	}))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/degraded", success201response)
+	f.MustAdd(fox.MethodGet, "/fast", func(c *fox.Context) {
+		c.Writer().WriteHeader(http.StatusOK)
+	})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+
+	for _, path := range []string{"/degraded", "/fast", "/slow"} {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	require.Len(t, warned, 1)
+	assert.GreaterOrEqual(t, warned[0], 10*time.Millisecond)
+}
+
+func TestMiddleware_WithGracePeriod(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, WithGracePeriod(100*time.Millisecond))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/late", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+		time.Sleep(5 * time.Millisecond)
+		_ = c.String(http.StatusOK, "late")
+	})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		time.Sleep(200 * time.Millisecond)
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/late", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "late", w.Body.String())
+
+	w = httptest.NewRecorder()
+	start := time.Now()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.GreaterOrEqual(t, time.Since(start), 110*time.Millisecond)
+}
+
+func TestMiddleware_WithResponseBudget(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slowResponse := func(c *fox.Context) {
+		c.SetHeader("X-Template", "rendered")
+		<-release
+		_ = c.String(http.StatusGatewayTimeout, "too late")
+	}
+	slow := func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	}
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond,
+		WithResponse(func(c *fox.Context) {
+			c.SetHeader("X-Custom", "1")
+			_ = c.String(http.StatusGatewayTimeout, "custom")
+		}),
+		WithResponseBudget(20*time.Millisecond),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/custom", slow)
+	f.MustAdd(fox.MethodGet, "/slow-response", slow, OverrideResponse(slowResponse))
+
+	// The response of a response handler returning in time is sent as it is.
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/custom", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-Custom"))
+	assert.Equal(t, "custom", w.Body.String())
+
+	// A response handler exceeding its budget is replaced by the default response.
+	w = httptest.NewRecorder()
+	begin := time.Now()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow-response", nil))
+	assert.Less(t, time.Since(begin), time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Header().Get("X-Template"))
+	assert.Equal(t, "Service Unavailable\n", w.Body.String())
+
+	t.Run("panic", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, WithResponse(func(c *fox.Context) {
+			panic("boom")
+		}))))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/slow", slow)
+		assert.PanicsWithValue(t, "boom", func() {
+			f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		})
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, WithResponseBudget(NoTimeout), WithResponse(func(c *fox.Context) {
+			// The response handler runs on the request goroutine, writing through.
+			_, bounded := c.Writer().(*timeoutWriter)
+			assert.False(t, bounded)
+			DefaultResponse(c)
+		}))))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/slow", slow)
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+func TestMiddleware_WithResponseFunc(t *testing.T) {
+	respond := WithResponseFunc(func(c *fox.Context, info TimeoutInfo) {
+		msg := "please retry"
+		if info.Overshoot >= 50*time.Millisecond {
+			msg = "request abandoned"
+		}
+		_ = c.String(http.StatusServiceUnavailable, fmt.Sprintf("%s %s %s", info.Pattern, info.Timeout, msg))
+	})
+
+	cases := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{name: "at the deadline", opts: []Option{respond}, want: "/slow 10ms please retry"},
+		{
+			name: "after the grace period",
+			opts: []Option{respond, WithGracePeriod(50 * time.Millisecond)},
+			want: "/slow 10ms request abandoned",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var info TimeoutInfo
+			opts := append(tc.opts, WithOnTimeout(func(c *fox.Context, i TimeoutInfo) { info = i }))
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, opts...)))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+				time.Sleep(100 * time.Millisecond)
+			})
+
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, tc.want, w.Body.String())
+			assert.GreaterOrEqual(t, info.Elapsed, 10*time.Millisecond+info.Overshoot)
+		})
+	}
+}
+
+func TestErrTimeout(t *testing.T) {
+	var ne net.Error
+	require.ErrorAs(t, ErrTimeout, &ne)
+	assert.True(t, ne.Timeout())
+	assert.ErrorIs(t, ErrTimeout, http.ErrHandlerTimeout)
+	assert.ErrorIs(t, ErrTimeout, context.DeadlineExceeded)
+	assert.ErrorIs(t, &DeadlineError{}, ErrTimeout)
+
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "deadline"},
+		{name: "extendable deadline", opts: []Option{WithMaxExtension(time.Second)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, tc.opts...)))
+			require.NoError(t, err)
+			causes := make(chan error, 2)
+			f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+				ctx, cancel := context.WithCancel(c.Request().Context())
+				defer cancel()
+				<-ctx.Done()
+				causes <- context.Cause(c.Request().Context())
+				causes <- context.Cause(ctx)
+			})
+
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, ErrTimeout, <-causes)
+			assert.Equal(t, ErrTimeout, <-causes)
+		})
+	}
+}
+
+func TestMiddleware_WithFirstByteTimeout(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithFirstByteTimeout(20*time.Millisecond))))
+	require.NoError(t, err)
+	causes := make(chan error, 1)
+	f.MustAdd(fox.MethodGet, "/stuck", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+		causes <- context.Cause(c.Request().Context())
+	})
+	f.MustAdd(fox.MethodGet, "/started", func(c *fox.Context) {
+		c.Writer().WriteHeader(http.StatusOK)
+		time.Sleep(60 * time.Millisecond)
+		_, _ = c.Writer().Write([]byte("done"))
+	})
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stuck", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	cause := <-causes
+	assert.ErrorIs(t, cause, context.DeadlineExceeded)
+	var de *DeadlineError
+	assert.ErrorAs(t, cause, &de)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/started", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "done", w.Body.String())
+}
+
+func TestMiddleware_WithContextFilter(t *testing.T) {
+	type credentialsKey struct{}
+	type tenantKey struct{}
+
+	f, err := fox.NewRouter(fox.WithMiddleware(
+		Outer(time.Second),
+		func(next fox.HandlerFunc) fox.HandlerFunc {
+			return func(c *fox.Context) {
+				ctx := context.WithValue(c.Request().Context(), credentialsKey{}, "secret")
+				ctx = context.WithValue(ctx, tenantKey{}, "acme")
+				c.SetRequest(c.Request().WithContext(ctx))
+				next(c)
+			}
+		},
+		Middleware(20*time.Millisecond, WithContextFilter(func(key any) bool {
+			return key == tenantKey{}
+		})),
+	))
+	require.NoError(t, err)
+	type values struct {
+		credentials, tenant any
+		entry, deadline     bool
+	}
+	got := make(chan values, 1)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		ctx := c.Request().Context()
+		_, entry := EntryTime(c)
+		_, deadline := Deadline(c)
+		got <- values{credentials: ctx.Value(credentialsKey{}), tenant: ctx.Value(tenantKey{}), entry: entry, deadline: deadline}
+		<-ctx.Done()
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, values{tenant: "acme", entry: true, deadline: true}, <-got)
+}
+
+func TestParseTimeoutHeader(t *testing.T) {
+	cases := []struct {
+		value string
+		grpc  bool
+		want  time.Duration
+		ok    bool
+	}{
+		{value: "100m", grpc: true, want: 100 * time.Millisecond, ok: true},
+		{value: "2S", grpc: true, want: 2 * time.Second, ok: true},
+		{value: "1H", grpc: true, want: time.Hour, ok: true},
+		{value: "500u", grpc: true, want: 500 * time.Microsecond, ok: true},
+		{value: "1.5s", grpc: true, want: 1500 * time.Millisecond, ok: true},
+		{value: "1.5s", want: 1500 * time.Millisecond, ok: true},
+		{value: "300ms", want: 300 * time.Millisecond, ok: true},
+		{value: " 2.5 ", want: 2500 * time.Millisecond, ok: true},
+		// The units of the grpc-timeout format are only accepted from the grpc-timeout header.
+		{value: "5m", want: 5 * time.Minute, ok: true},
+		{value: "2S"},
+		{value: "0m", grpc: true},
+		{value: "-1s"},
+		{value: "0"},
+		{value: "1e300"},
+		{value: "NaN"},
+		{value: "soon"},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s grpc=%t", tc.value, tc.grpc), func(t *testing.T) {
+			got, ok := parseTimeoutHeader(tc.value, tc.grpc)
+			assert.Equal(t, tc.ok, ok)
+			if tc.ok {
+				assert.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMiddleware_WithHeaderTimeout(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithHeaderTimeout("Grpc-Timeout", 0, time.Second))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	req.Header.Set("Grpc-Timeout", "1m")
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// In any other header, "1m" is a minute.
+	f, err = fox.NewRouter(fox.WithMiddleware(Middleware(time.Minute, WithHeaderTimeout("X-Request-Timeout", 0, time.Hour))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		time.Sleep(5 * time.Millisecond)
+		success201response(c)
+	})
+	req = httptest.NewRequest(http.MethodGet, "/foo", nil)
+	req.Header.Set("X-Request-Timeout", "1m")
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestMiddleware_WithHeaderTimeoutCannotExtend(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Minute, WithHeaderTimeout("X-Request-Timeout", 0, time.Hour))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	}, OverrideHandler(20*time.Millisecond))
+
+	// The client cannot extend the timeout of the route.
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	req.Header.Set("X-Request-Timeout", "30s")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	// But it can shorten the global timeout.
+	f.MustAdd(fox.MethodGet, "/bar", func(c *fox.Context) {
+		dl, ok := c.Request().Context().Deadline()
+		require.True(t, ok)
+		assert.Less(t, time.Until(dl), time.Second)
+		success201response(c)
+	})
+	req = httptest.NewRequest(http.MethodGet, "/bar", nil)
+	req.Header.Set("X-Request-Timeout", "500ms")
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestMiddleware_WithStatusCode(t *testing.T) {
+	slow := func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	}
+
+	cases := []struct {
+		name       string
+		opts       []Option
+		code       int
+		body       string
+		retryAfter string
+	}{
+		{
+			name: "default response",
+			opts: []Option{WithStatusCode(http.StatusGatewayTimeout), WithRetryAfter(1500 * time.Millisecond)},
+			code: http.StatusGatewayTimeout,
+			body: http.StatusText(http.StatusGatewayTimeout) + "\n",
+			// Rounded up to the second.
+			retryAfter: "2",
+		},
+		{
+			name: "json response",
+			opts: []Option{PresetAPI(), WithStatusCode(http.StatusGatewayTimeout)},
+			code: http.StatusGatewayTimeout,
+			body: `{"error":"Gateway Timeout"}`,
+		},
+		{
+			name: "invalid status code",
+			opts: []Option{WithStatusCode(http.StatusOK)},
+			code: http.StatusServiceUnavailable,
+			body: http.StatusText(http.StatusServiceUnavailable) + "\n",
+		},
+		{
+			name: "custom response",
+			opts: []Option{
+				WithStatusCode(http.StatusGatewayTimeout),
+				WithResponse(func(c *fox.Context) { c.Writer().WriteHeader(http.StatusTooManyRequests) }),
+				WithRetryAfterFunc(func(c *fox.Context) time.Duration { return time.Minute }),
+			},
+			code:       http.StatusTooManyRequests,
+			retryAfter: "60",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, tc.opts...)))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/foo", slow)
+
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+			assert.Equal(t, tc.code, w.Code)
+			assert.Equal(t, tc.body, w.Body.String())
+			assert.Equal(t, tc.retryAfter, w.Header().Get("Retry-After"))
+		})
+	}
+}
+
+type deadlineBody struct {
+	data []byte
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	if len(b.data) > 0 {
+		n := copy(p, b.data)
+		b.data = b.data[n:]
+		return n, nil
+	}
+	return 0, &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+}
+
+func (b *deadlineBody) Close() error {
+	return nil
+}
+
+func TestMiddleware_WithAutoRequestTimeout(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithAutoRequestTimeout())))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodPost, "/upload", func(c *fox.Context) {
+		if _, err := io.ReadAll(c.Request().Body); err != nil {
+			http.Error(c.Writer(), err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c.Writer().WriteHeader(http.StatusCreated)
+	}, OverrideRead(time.Millisecond))
+
+	t.Run("no byte received", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/upload", &deadlineBody{})
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusRequestTimeout, w.Code)
+		assert.Equal(t, "close", w.Header().Get("Connection"))
+	})
+
+	t.Run("partial body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/upload", &deadlineBody{data: []byte("partial")})
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("complete body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("complete"))
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+}
+
+func TestMiddleware_WithVersionLabel(t *testing.T) {
+	events := make(chan Event, 1)
+	var observations []Observation
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond,
+		WithVersionLabel("v42"),
+		WithEventSink(EventSinkFunc(func(ev Event) { events <- ev })),
+		WithMetrics(MetricsRecorderFunc(func(o Observation) { observations = append(observations, o) })),
+	)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	ev := <-events
+	assert.Equal(t, "v42", ev.Version)
+	require.Len(t, observations, 1)
+	assert.Equal(t, "v42", observations[0].Version)
+	assert.Equal(t, ev.Overshoot, observations[0].Overshoot)
+}
+
+func TestMiddleware_WithSkipper(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithSkipper(func(c *fox.Context) bool {
+		return c.Header("X-Probe") != ""
+	}))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response, OverrideWrite(time.Second))
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.False(t, w.writeDeadline.IsZero())
+
+	req.Header.Set("X-Probe", "1")
+	w = &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.True(t, w.writeDeadline.IsZero())
+}
+
+func TestMiddleware_WithPrecision(t *testing.T) {
+	precision := 10 * time.Millisecond
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithPrecision(precision))))
+	require.NoError(t, err)
+
+	deadlines := make(chan time.Time, 1)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		deadline, _ := c.Request().Context().Deadline()
+		deadlines <- deadline
+		time.Sleep(100 * time.Millisecond)
+		c.Writer().WriteHeader(http.StatusOK)
+	})
+
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	deadline := <-deadlines
+	// The 50µs timeout is rounded up to the next 10ms boundary, so the deadline is aligned but never earlier
+	// than the configured timeout, and never later than timeout + precision.
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Zero(t, deadline.UnixNano()%int64(precision))
+	assert.True(t, monotonic(deadline))
+	assert.False(t, deadline.Before(start.Add(50*time.Microsecond)))
+	assert.True(t, deadline.Before(start.Add(50*time.Microsecond+precision+time.Millisecond)))
+}
+
+func TestRoundUp(t *testing.T) {
+	base := time.Unix(0, 0)
+	cases := []struct {
+		name      string
+		tm        time.Time
+		precision time.Duration
+		want      time.Time
+	}{
+		{name: "disabled", tm: base.Add(1234), precision: 0, want: base.Add(1234)},
+		{name: "already aligned", tm: base.Add(20 * time.Millisecond), precision: 10 * time.Millisecond, want: base.Add(20 * time.Millisecond)},
+		{name: "round up", tm: base.Add(11 * time.Millisecond), precision: 10 * time.Millisecond, want: base.Add(20 * time.Millisecond)},
+		{name: "smaller than precision", tm: base.Add(50 * time.Microsecond), precision: 10 * time.Millisecond, want: base.Add(10 * time.Millisecond)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.True(t, tc.want.Equal(roundUp(tc.tm, tc.precision)))
+		})
+	}
+}
+
+func TestMiddleware_WithEventSink(t *testing.T) {
+	var events []Event
+	sink := EventSinkFunc(func(ev Event) {
+		events = append(events, ev)
+	})
+
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithEventSink(sink), WithRuntimeStats())))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo/{id}", success201response)
+
+	req := httptest.NewRequest(http.MethodGet, "/foo/1", nil)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Len(t, events, 1)
+	ev := events[0]
+	assert.Equal(t, EventTimeout, ev.Kind)
+	assert.Equal(t, "timeout", ev.Kind.String())
+	assert.Equal(t, http.MethodGet, ev.Method)
+	assert.Equal(t, "/foo/1", ev.Path)
+	assert.Equal(t, "/foo/{id}", ev.Pattern)
+	assert.Equal(t, 50*time.Microsecond, ev.Timeout)
+	assert.GreaterOrEqual(t, ev.Elapsed, 50*time.Microsecond)
+	assert.GreaterOrEqual(t, ev.Overshoot, time.Duration(0))
+	assert.Less(t, ev.Overshoot, ev.Elapsed)
+	require.NotNil(t, ev.Runtime)
+	assert.Positive(t, ev.Runtime.Goroutines)
+	assert.Positive(t, ev.Runtime.HeapInUse)
+}
+
+func TestMiddleware_OverrideEventSink(t *testing.T) {
+	var global, route []Event
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithEventSink(EventSinkFunc(func(ev Event) {
+		global = append(global, ev)
+	})))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+	f.MustAdd(fox.MethodGet, "/bar", success201response, OverrideEventSink(EventSinkFunc(func(ev Event) {
+		route = append(route, ev)
+	})))
+	f.MustAdd(fox.MethodGet, "/baz", success201response, OverrideEventSink(nil))
+
+	for _, path := range []string{"/foo", "/bar", "/baz"} {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	require.Len(t, global, 1)
+	assert.Equal(t, "/foo", global[0].Pattern)
+	require.Len(t, route, 1)
+	assert.Equal(t, "/bar", route[0].Pattern)
+}
+
+func TestMiddleware_WithRedaction(t *testing.T) {
+	var events []Event
+	sink := EventSinkFunc(func(ev Event) {
+		events = append(events, ev)
+	})
+
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithEventSink(sink), WithRedaction([]string{"x-api-key"}, []string{"email"}))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	req := httptest.NewRequest(http.MethodGet, "/foo?email=john@example.com&access_token=secret&page=1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	require.Len(t, events, 1)
+	ev := events[0]
+	assert.Equal(t, Redacted, ev.Header.Get("Authorization"))
+	assert.Equal(t, Redacted, ev.Header.Get("X-Api-Key"))
+	assert.Equal(t, "text/plain", ev.Header.Get("Accept"))
+	assert.Equal(t, Redacted, ev.Query.Get("email"))
+	assert.Equal(t, Redacted, ev.Query.Get("access_token"))
+	assert.Equal(t, "1", ev.Query.Get("page"))
+	assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+}
+
+func TestMiddleware_WithRouteNormalizer(t *testing.T) {
+	var events []Event
+	sink := EventSinkFunc(func(ev Event) {
+		events = append(events, ev)
+	})
+
+	normalizer := func(pattern string) string {
+		return strings.Replace(pattern, "/v2/", "/{version}/", 1)
+	}
+
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithEventSink(sink), WithRouteNormalizer(normalizer))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/api/v2/users", success201response)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/users", nil)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	require.Len(t, events, 1)
+	assert.Equal(t, "/api/{version}/users", events[0].Pattern)
+}
+
+func TestReportError(t *testing.T) {
+	var events []Event
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithEventSink(EventSinkFunc(func(ev Event) {
+		events = append(events, ev)
+	})))))
+	require.NoError(t, err)
+	reported := make(chan bool, 1)
+	f.MustAdd(fox.MethodGet, "/internal", func(c *fox.Context) {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+		reported <- ReportError(c, fmt.Errorf("query: %w", ctx.Err()))
+		_ = c.String(http.StatusInternalServerError, "query failed\n")
+	})
+	f.MustAdd(fox.MethodGet, "/other", func(c *fox.Context) {
+		reported <- ReportError(c, io.ErrUnexpectedEOF)
+		_ = c.String(http.StatusBadGateway, "bad gateway\n")
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/internal", nil))
+	assert.True(t, <-reported)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventTimeout, events[0].Kind)
+	assert.True(t, events[0].Internal)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.False(t, <-reported)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Len(t, events, 1)
+
+	c := fox.NewTestContextOnly(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.False(t, ReportError(c, context.DeadlineExceeded))
+}
+
+func TestMiddleware_WithBodyCancellation(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50 * time.Millisecond)))
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	f.MustAdd(fox.MethodPost, "/foo", func(c *fox.Context) {
+		_, err := io.ReadAll(c.Request().Body)
+		errs <- err
+	})
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	// The client never sends the body, so the handler blocks on read until the body is canceled.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/foo", pr)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	select {
+	case err := <-errs:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler still blocked on body read")
+	}
+}
+
+func TestPoisonBody(t *testing.T) {
+	body := &poisonBody{ReadCloser: io.NopCloser(strings.NewReader("hello"))}
+	buf := make([]byte, 2)
+	n, err := body.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	body.poison()
+	n, err = body.Read(buf)
+	assert.ErrorIs(t, err, http.ErrHandlerTimeout)
+	assert.Zero(t, n)
+}
+
+func TestMiddleware_WithBodyDrain(t *testing.T) {
+	cases := []struct {
+		name   string
+		opts   []Option
+		reused bool
+	}{
+		{name: "without drain"},
+		{name: "drained", opts: []Option{WithBodyDrain(1<<20, time.Second)}, reused: true},
+		{name: "over limit", opts: []Option{WithBodyDrain(1<<10, time.Second)}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond, tc.opts...)))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodPost, "/upload", func(c *fox.Context) {
+				// The handler times out without reading the body.
+				<-c.Request().Context().Done()
+			})
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+			client := srv.Client()
+
+			reused := make([]bool, 0, 2)
+			for range 2 {
+				trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+					reused = append(reused, info.Reused)
+				}}
+				req, err := http.NewRequestWithContext(
+					httptrace.WithClientTrace(context.Background(), trace),
+					http.MethodPost,
+					srv.URL+"/upload",
+					strings.NewReader(strings.Repeat("a", 64<<10)),
+				)
+				require.NoError(t, err)
+				resp, err := client.Do(req)
+				require.NoError(t, err)
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+				assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+			}
+			assert.Equal(t, []bool{false, tc.reused}, reused)
+		})
+	}
+}
+
+func TestMiddleware_OverrideStrictness(t *testing.T) {
+	cases := []struct {
+		name      string
+		opts      []fox.RouteOption
+		wantClose bool
+		wantBody  bool
+	}{
+		{name: "default", wantBody: true},
+		{name: "lenient", opts: []fox.RouteOption{OverrideStrictness(Lenient)}},
+		{name: "standard", opts: []fox.RouteOption{OverrideStrictness(Standard)}, wantBody: true},
+		{name: "strict", opts: []fox.RouteOption{OverrideStrictness(Strict)}, wantClose: true, wantBody: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50 * time.Microsecond)))
+			require.NoError(t, err)
+
+			poisoned := make(chan bool, 1)
+			f.MustAdd(fox.MethodPost, "/foo", func(c *fox.Context) {
+				_, ok := c.Request().Body.(*poisonBody)
+				poisoned <- ok
+				time.Sleep(10 * time.Millisecond)
+			}, tc.opts...)
+
+			req := httptest.NewRequest(http.MethodPost, "/foo", strings.NewReader("hello"))
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, tc.wantClose, w.Header().Get("Connection") == "close")
+			assert.Equal(t, tc.wantBody, <-poisoned)
+		})
+	}
+}
+
+func TestMiddleware_WithHostTimeouts(t *testing.T) {
+	hosts := map[string]time.Duration{
+		"api.example.com":        1 * time.Second,
+		"*.example.com":          50 * time.Microsecond,
+		"*.internal.example.com": 1 * time.Second,
+	}
+
+	cases := []struct {
+		name string
+		host string
+		opts []fox.RouteOption
+		want int
+	}{
+		{name: "exact host", host: "api.example.com", want: http.StatusCreated},
+		{name: "exact host with port", host: "API.example.com:8080", want: http.StatusCreated},
+		{name: "wildcard", host: "www.example.com", want: http.StatusServiceUnavailable},
+		{name: "most specific wildcard", host: "db.internal.example.com", want: http.StatusCreated},
+		{name: "apex does not match wildcard", host: "example.com", want: http.StatusCreated},
+		{name: "route annotation wins", host: "www.example.com", opts: []fox.RouteOption{OverrideHandler(NoTimeout)}, want: http.StatusCreated},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(1*time.Second, WithHostTimeouts(hosts))))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/foo", success201response, tc.opts...)
+
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			req.Host = tc.host
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.want, w.Code)
+		})
+	}
+}
+
+func TestMiddleware_WithPatternTimeouts(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithPatternTimeouts(map[string]time.Duration{
+		"/users/{uid}/*":        time.Second,
+		"/users/{id}/orders/*":  50 * time.Microsecond,
+		"/users/{id}/orders/me": time.Second,
+	}))))
+	require.NoError(t, err)
+	for _, pattern := range []string{"/users/{id}", "/users/{id}/profile", "/users/{id}/orders/{oid}", "/users/{id}/orders/me"} {
+		f.MustAdd(fox.MethodGet, pattern, success201response)
+	}
+	f.MustAdd(fox.MethodGet, "/users/{id}/avatar", success201response, OverrideHandler(50*time.Microsecond))
+
+	cases := []struct {
+		path string
+		want int
+	}{
+		{path: "/users/1", want: http.StatusServiceUnavailable},
+		{path: "/users/1/profile", want: http.StatusCreated},
+		{path: "/users/1/orders/2", want: http.StatusServiceUnavailable},
+		{path: "/users/1/orders/me", want: http.StatusCreated},
+		{path: "/users/1/avatar", want: http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.want, w.Code)
+		})
+	}
+}
+
+func TestMiddleware_WithRouteTimeout(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond,
+		WithPatternTimeouts(map[string]time.Duration{"/api/*": time.Second}),
+		WithRouteTimeout("/api/reports/*", 50*time.Microsecond),
+		WithRouteTimeout("/api/reports/*", time.Second),
+		WithRouteTimeout("/api/*", 50*time.Microsecond),
+		WithRouteTimeout("/healthz", NoTimeout),
+	)))
+	require.NoError(t, err)
+	for _, pattern := range []string{"/api/users", "/api/reports/{id}", "/healthz"} {
+		f.MustAdd(fox.MethodGet, pattern, success201response)
+	}
+	f.MustAdd(fox.MethodGet, "/api/reports/{id}/pdf", success201response, OverrideHandler(50*time.Microsecond))
+
+	cases := []struct {
+		path string
+		want int
+	}{
+		{path: "/api/users", want: http.StatusServiceUnavailable},
+		{path: "/api/reports/1", want: http.StatusCreated},
+		{path: "/api/reports/1/pdf", want: http.StatusServiceUnavailable},
+		{path: "/healthz", want: http.StatusCreated},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.want, w.Code)
+		})
+	}
+}
+
+func TestMiddleware_WithMethodTimeout(t *testing.T) {
+	applied := make(chan Step, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithMethodTimeout(http.MethodPost, 3*time.Second))))
+	require.NoError(t, err)
+	handler := func(c *fox.Context) {
+		for _, step := range ResolveExplain(c) {
+			if step.Applied {
+				applied <- step
+			}
+		}
+	}
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodPut}
+	f.MustAdd(methods, "/foo", handler, OverrideMethod(http.MethodPut, 4*time.Second))
+	f.MustAdd(methods, "/bar", handler, OverrideHandler(2*time.Second), OverrideMethod(http.MethodPut, 4*time.Second))
+
+	cases := []struct {
+		method string
+		path   string
+		want   Step
+	}{
+		{method: http.MethodGet, path: "/foo", want: Step{Source: SourceGlobal, Timeout: time.Second, Found: true, Applied: true}},
+		{method: http.MethodPost, path: "/foo", want: Step{Source: SourceMethod, Timeout: 3 * time.Second, Found: true, Applied: true}},
+		{method: http.MethodPut, path: "/foo", want: Step{Source: SourceRoute, Timeout: 4 * time.Second, Found: true, Applied: true}},
+		{method: http.MethodGet, path: "/bar", want: Step{Source: SourceRoute, Timeout: 2 * time.Second, Found: true, Applied: true}},
+		{method: http.MethodPost, path: "/bar", want: Step{Source: SourceRoute, Timeout: 2 * time.Second, Found: true, Applied: true}},
+		{method: http.MethodPut, path: "/bar", want: Step{Source: SourceRoute, Timeout: 4 * time.Second, Found: true, Applied: true}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+			assert.Equal(t, tc.want, <-applied)
+		})
+	}
+}
+
+func TestMiddleware_WithFlagProvider(t *testing.T) {
+	var calls atomic.Int32
+	provider := func(route, flag string) (time.Duration, bool) {
+		calls.Add(1)
+		assert.Equal(t, "http-timeout", flag)
+		if route == "/flagged" {
+			return 3 * time.Second, true
+		}
+		return 0, false
+	}
+	applied := make(chan Step, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithFlagProvider("http-timeout", time.Hour, provider))))
+	require.NoError(t, err)
+	handler := func(c *fox.Context) {
+		for _, step := range ResolveExplain(c) {
+			if step.Applied {
+				applied <- step
+			}
+		}
+	}
+	f.MustAdd(fox.MethodGet, "/flagged", handler, OverrideHandler(2*time.Second))
+	f.MustAdd(fox.MethodGet, "/other", handler, OverrideHandler(2*time.Second))
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/flagged", nil))
+	assert.Equal(t, Step{Source: SourceFlag, Timeout: 3 * time.Second, Found: true, Applied: true}, <-applied)
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.Equal(t, Step{Source: SourceRoute, Timeout: 2 * time.Second, Found: true, Applied: true}, <-applied)
+
+	// The flags are cached by route: the middleware and ResolveExplain evaluated each of them once.
+	n := calls.Load()
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/flagged", nil))
+	<-applied
+	assert.Equal(t, n, calls.Load())
+	assert.Equal(t, "flag", SourceFlag.String())
+}
+
+func TestMiddleware_WithTimeoutResolver(t *testing.T) {
+	resolver := func(c *fox.Context) time.Duration {
+		switch c.Header("X-Tier") {
+		case "gold":
+			return 5 * time.Second
+		case "internal":
+			return NoTimeout
+		default:
+			return -1
+		}
+	}
+	applied := make(chan Step, 1)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithTimeoutResolver(resolver))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		for _, step := range ResolveExplain(c) {
+			if step.Applied {
+				applied <- step
+			}
+		}
+		_, ok := Deadline(c)
+		if !ok {
+			applied <- Step{}
+		}
+	}, OverrideHandler(2*time.Second))
+
+	cases := []struct {
+		tier string
+		want Step
+	}{
+		{tier: "gold", want: Step{Source: SourceResolver, Timeout: 5 * time.Second, Found: true, Applied: true}},
+		{tier: "free", want: Step{Source: SourceRoute, Timeout: 2 * time.Second, Found: true, Applied: true}},
+		{tier: "internal", want: Step{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.tier, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			req.Header.Set("X-Tier", tc.tier)
+			f.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.want, <-applied)
+		})
+	}
+	assert.Equal(t, "resolver", SourceResolver.String())
+}
+
+func TestMiddleware_WithHandlerScopeTimeout(t *testing.T) {
+	applied := make(chan Step, 1)
+	f, err := fox.NewRouter(
+		fox.WithMiddleware(Middleware(time.Second, WithHandlerScopeTimeout(fox.NoRouteHandler|fox.NoMethodHandler, 10*time.Millisecond))),
+		fox.WithNoRouteHandler(func(c *fox.Context) {
+			// e.g. looking up suggestions in a database.
+			for _, step := range ResolveExplain(c) {
+				if step.Applied {
+					applied <- step
+				}
+			}
+			<-c.Request().Context().Done()
+		}),
+		fox.WithNoMethodHandler(func(c *fox.Context) {
+			<-c.Request().Context().Done()
+		}),
+	)
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, Step{Source: SourceHandlerScope, Timeout: 10 * time.Millisecond, Found: true, Applied: true}, <-applied)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/foo", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "handler-scope", SourceHandlerScope.String())
+}
+
+func TestMiddleware_WithHandlerScope(t *testing.T) {
+	deadlines := make(chan bool, 1)
+	handler := func(c *fox.Context) {
+		_, ok := Deadline(c)
+		deadlines <- ok
+	}
+	f, err := fox.NewRouter(
+		fox.WithMiddleware(Middleware(time.Second, WithHandlerScope(fox.RouteHandler))),
+		fox.WithNoRouteHandler(handler),
+	)
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", handler)
+
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+	assert.True(t, <-deadlines)
+	f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.False(t, <-deadlines)
+}
+func TestFlagTimeouts(t *testing.T) {
+	var dt time.Duration
+	ft := &flagTimeouts{
+		provider: func(route, flag string) (time.Duration, bool) { return dt, dt > 0 },
+		ttl:      time.Minute,
+	}
+	now := time.Now()
+	_, ok := ft.lookup("/foo", now)
+	assert.False(t, ok)
+
+	dt = time.Second
+	_, ok = ft.lookup("/foo", now.Add(59*time.Second))
+	assert.False(t, ok)
+	got, ok := ft.lookup("/foo", now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, got)
+}
+
+func TestSkipCaller(t *testing.T) {
+	assert.True(t, skipCaller("github.com/fox-toolkit/timeout.(*timeoutWriter).Write"))
+	assert.True(t, skipCaller("github.com/fox-toolkit/fox.(*Context).String"))
+	assert.True(t, skipCaller("io.WriteString"))
+	assert.True(t, skipCaller("encoding/json.(*Encoder).Encode"))
+	assert.False(t, skipCaller("main.handler"))
+	assert.False(t, skipCaller("github.com/acme/api.(*Server).reports"))
+}
+
+func TestNormalizeParams(t *testing.T) {
+	assert.Equal(t, "/users/{}/orders/{}", normalizeParams("/users/{id}/orders/{oid}"))
+	assert.Equal(t, "/files/*{}", normalizeParams("/files/*{path}"))
+	assert.Equal(t, "/static", normalizeParams("/static"))
+}
+
+func TestMiddleware_OverrideResponse(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50 * time.Microsecond)))
+	require.NoError(t, err)
+	slow := func(c *fox.Context) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	f.MustAdd(fox.MethodGet, "/api", slow, OverrideResponse(func(c *fox.Context) {
+		_ = c.Blob(http.StatusGatewayTimeout, "application/json", []byte(`{"error":"timeout"}`))
+	}))
+	f.MustAdd(fox.MethodGet, "/page", slow, OverrideResponse(nil))
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.JSONEq(t, `{"error":"timeout"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/page", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "Service Unavailable\n", w.Body.String())
+}
+
+func TestMiddleware_OverrideFallbackRoute(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithFallbackBudget(time.Second))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/cached", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, "cached\n")
+	}, OverrideHandler(NoTimeout))
+	f.MustAdd(fox.MethodGet, "/page", success201response, OverrideFallbackRoute("/cached"))
+	f.MustAdd(fox.MethodGet, "/missing", success201response, OverrideFallbackRoute("/unknown"))
+
+	cases := []struct {
+		name   string
+		path   string
+		status int
+		body   string
+	}{
+		{name: "fallback", path: "/page", status: http.StatusOK, body: "cached\n"},
+		{name: "fallback not found", path: "/missing", status: http.StatusServiceUnavailable, body: "Service Unavailable\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, tc.body, w.Body.String())
+		})
+	}
+
+	t.Run("fallback exceeds its budget", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, WithFallbackBudget(50*time.Microsecond))))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+			time.Sleep(10 * time.Millisecond)
+			_ = c.String(http.StatusOK, "slow\n")
+		})
+		f.MustAdd(fox.MethodGet, "/page", success201response, OverrideFallbackRoute("/slow"))
+
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "Service Unavailable\n", w.Body.String())
+	})
+}
+
+func TestMiddleware_WithBodyReplay(t *testing.T) {
+	cases := []struct {
+		name     string
+		memLimit int64
+		maxSize  int64
+		body     string
+	}{
+		{name: "in memory", memLimit: 1024, maxSize: 1024, body: "foobar"},
+		{name: "spilled", memLimit: 2, maxSize: 1024, body: "foobar"},
+		{name: "too large", memLimit: 2, maxSize: 2, body: "bar"},
+		{name: "disabled", body: "bar"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithBodyReplay(tc.memLimit, tc.maxSize))))
+			require.NoError(t, err)
+			read := make(chan struct{})
+			f.MustAdd(fox.MethodPost, "/echo", func(c *fox.Context) {
+				<-read
+				body, _ := io.ReadAll(c.Request().Body)
+				_ = c.String(http.StatusOK, string(body))
+			}, OverrideHandler(NoTimeout))
+			f.MustAdd(fox.MethodPost, "/upload", func(c *fox.Context) {
+				_, _ = io.ReadFull(c.Request().Body, make([]byte, 3))
+				close(read)
+				<-c.Request().Context().Done()
+			}, OverrideHandler(20*time.Millisecond), OverrideFallbackRoute("/echo"), OverrideAbandon(Graceful))
+
+			req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("foobar"))
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.body, w.Body.String())
+		})
+	}
+}
+
+func TestReplayBody(t *testing.T) {
+	replay := newReplayBody(io.NopCloser(strings.NewReader("foobar")), 2, 1024)
+	first := replay.reader()
+	buf := make([]byte, 4)
+	n, err := io.ReadFull(first, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "foob", string(buf[:n]))
+
+	second, err := replay.getBody()
+	require.NoError(t, err)
+	_, err = first.Read(buf)
+	assert.ErrorIs(t, err, errReplayed)
+	body, err := io.ReadAll(second)
+	require.NoError(t, err)
+	assert.Equal(t, "foobar", string(body))
+
+	third, err := replay.getBody()
+	require.NoError(t, err)
+	body, err = io.ReadAll(third)
+	require.NoError(t, err)
+	assert.Equal(t, "foobar", string(body))
+
+	replay.release()
+	_, err = replay.getBody()
+	assert.ErrorIs(t, err, errReplayTooLarge)
+	_, err = third.Read(buf)
+	assert.ErrorIs(t, err, errReplayed)
+}
+
+// stallingBody returns its data, then blocks until unblock is closed before returning io.EOF.
+type stallingBody struct {
+	data    []byte
+	unblock chan struct{}
+}
+
+func (b *stallingBody) Read(p []byte) (int, error) {
+	if len(b.data) > 0 {
+		n := copy(p, b.data)
+		b.data = b.data[n:]
+		return n, nil
+	}
+	<-b.unblock
+	return 0, io.EOF
+}
+
+func TestReplayBody_StalledRead(t *testing.T) {
+	body := &stallingBody{data: []byte("foo"), unblock: make(chan struct{})}
+	replay := newReplayBody(io.NopCloser(body), 1024, 1024)
+	first := replay.reader()
+	buf := make([]byte, 8)
+	n, err := first.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(buf[:n]))
+
+	stalled := make(chan error, 1)
+	go func() {
+		_, err := first.Read(buf)
+		stalled <- err
+	}()
+
+	// Taking over the body does not wait for the stalled read, and the recorded bytes are readable right away.
+	second, err := replay.getBody()
+	require.NoError(t, err)
+	n, err = second.Read(make([]byte, 3))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	close(body.unblock)
+	assert.ErrorIs(t, <-stalled, errReplayed)
+	_, err = second.Read(buf)
+	assert.ErrorIs(t, err, io.EOF)
+	replay.release()
+}
+
+func TestMiddleware_WithBodyReplayStalled(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithBodyReplay(1024, 1024), WithFallbackBudget(time.Second))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodPost, "/echo", func(c *fox.Context) {
+		buf := make([]byte, 3)
+		n, _ := io.ReadFull(c.Request().Body, buf)
+		_ = c.String(http.StatusOK, string(buf[:n]))
+	}, OverrideHandler(NoTimeout))
+	f.MustAdd(fox.MethodPost, "/upload", func(c *fox.Context) {
+		// The client sends the first bytes of the body, and stalls.
+		_, _ = io.ReadAll(c.Request().Body)
+	}, OverrideHandler(20*time.Millisecond), OverrideFallbackRoute("/echo"))
+
+	body := &stallingBody{data: []byte("foo"), unblock: make(chan struct{})}
+	defer close(body.unblock)
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	f.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "foo", w.Body.String())
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestMiddleware_WithElapsedHeader(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(1*time.Second, WithElapsedHeader("X-Handler-Duration"))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	value, ok := strings.CutSuffix(w.Header().Get("X-Handler-Duration"), "ms")
+	require.True(t, ok)
+	ms, err := strconv.ParseFloat(value, 64)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, ms, 10.0)
+}
+
+func TestMiddleware_WithBudgetTrailer(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithBudgetTrailer("X-Budget-Remaining"))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+	f.MustAdd(fox.MethodGet, "/sized", func(c *fox.Context) {
+		c.SetHeader("Content-Length", "3")
+		_ = c.String(http.StatusOK, "foo")
+	})
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/foo")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	value, ok := strings.CutSuffix(resp.Trailer.Get("X-Budget-Remaining"), "ms")
+	require.True(t, ok)
+	ms, err := strconv.ParseFloat(value, 64)
+	require.NoError(t, err)
+	assert.Greater(t, ms, 0.0)
+	assert.Less(t, ms, 1000.0)
+
+	resp, err = http.Get(srv.URL + "/sized")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Trailer.Get("X-Budget-Remaining"))
+}
+
+func TestMiddleware_WithBudgetTrailerStreaming(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithStreaming(), WithBudgetTrailer("X-Budget-Remaining"))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/events", func(c *fox.Context) {
+		c.SetHeader("Content-Type", "text/event-stream")
+		for range 3 {
+			_, _ = c.Writer().WriteString("data: tick\n\n")
+			require.NoError(t, c.Writer().FlushError())
+		}
+	})
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	// The trailer is declared before the first chunk of the stream.
+	assert.Contains(t, resp.Trailer, "X-Budget-Remaining")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("data: tick\n\n", 3), string(body))
+
+	value, ok := strings.CutSuffix(resp.Trailer.Get("X-Budget-Remaining"), "ms")
+	require.True(t, ok)
+	ms, err := strconv.ParseFloat(value, 64)
+	require.NoError(t, err)
+	assert.Greater(t, ms, 0.0)
+	assert.Less(t, ms, 1000.0)
+}
+
+func TestMiddleware_WithStreamingStalledClient(t *testing.T) {
+	done := make(chan struct{})
+	f, err := fox.NewRouter(fox.WithMiddleware(func(next fox.HandlerFunc) fox.HandlerFunc {
+		return func(c *fox.Context) {
+			defer close(done)
+			next(c)
+		}
+	}, Middleware(100*time.Millisecond, WithStreaming())))
+	require.NoError(t, err)
+	chunk := bytes.Repeat([]byte("a"), 64*1024)
+	f.MustAdd(fox.MethodGet, "/stream", func(c *fox.Context) {
+		for {
+			if _, err := c.Writer().Write(chunk); err != nil {
+				return
+			}
+		}
+	})
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	// The client never reads the response, so the writes of the handler end up blocked on the connection.
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /stream HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, err)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the middleware is blocked by the write of the handler")
+	}
+}
+
+func TestMiddleware_WithMaxHeaderBytes(t *testing.T) {
+	var events []Event
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithMaxHeaderBytes(64), WithEventSink(EventSinkFunc(func(ev Event) {
+		events = append(events, ev)
+	})))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/small", func(c *fox.Context) {
+		c.SetHeader("X-Foo", "bar")
+		_ = c.String(http.StatusOK, "ok")
+	})
+	f.MustAdd(fox.MethodGet, "/large", func(c *fox.Context) {
+		c.SetHeader("X-Foo", strings.Repeat("a", 64))
+		_ = c.String(http.StatusOK, "ok")
+	})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/small", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "bar", w.Header().Get("X-Foo"))
+	assert.Empty(t, events)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/large", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Header().Get("X-Foo"))
+	require.Len(t, events, 1)
+	assert.Equal(t, EventHeaderTooLarge, events[0].Kind)
+	assert.Equal(t, "/large", events[0].Pattern)
+}
+
+func TestMiddleware_WithChaos(t *testing.T) {
+	t.Run("forced timeout", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond, WithChaos(1, 0))))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+			_ = c.String(http.StatusOK, "ok")
+		})
+		f.MustAdd(fox.MethodGet, "/never", func(c *fox.Context) {
+			_ = c.String(http.StatusOK, "ok")
+		}, OverrideAbandon(Never))
+
+		for _, path := range []string{"/foo", "/never"} {
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		}
+	})
+
+	t.Run("extra delay", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithChaos(1, 10*time.Millisecond))))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+			_ = c.String(http.StatusOK, "ok")
+		})
+
+		start := time.Now()
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond, WithChaos(0, 0))))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+			_ = c.String(http.StatusOK, "ok")
+		})
+
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestFormatMillis(t *testing.T) {
+	assert.Equal(t, "0.050ms", formatMillis(50*time.Microsecond))
+	assert.Equal(t, "123.457ms", formatMillis(123456789*time.Nanosecond))
+	assert.Equal(t, "2000.000ms", formatMillis(2*time.Second))
+}
+
+func TestMiddleware_WithUnboundedRouteDetection(t *testing.T) {
+	var events []Event
+	sink := EventSinkFunc(func(ev Event) {
+		events = append(events, ev)
+	})
+
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(NoTimeout, WithEventSink(sink), WithUnboundedRouteDetection(4, 5*time.Millisecond))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodPost, "/upload", func(c *fox.Context) {
+		c.Writer().WriteHeader(http.StatusOK)
+	})
+	f.MustAdd(fox.MethodGet, "/slow", success201response)
+	f.MustAdd(fox.MethodGet, "/fast", func(c *fox.Context) {
+		c.Writer().WriteHeader(http.StatusOK)
+	})
+	f.MustAdd(fox.MethodGet, "/bounded", success201response, OverrideHandler(time.Second))
+
+	for range 2 {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("hello world")))
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bounded", nil))
+	}
+
+	require.Len(t, events, 2)
+	assert.Equal(t, EventUnboundedRoute, events[0].Kind)
+	assert.Equal(t, "/upload", events[0].Pattern)
+	assert.Equal(t, int64(11), events[0].ContentLength)
+	assert.Equal(t, EventUnboundedRoute, events[1].Kind)
+	assert.Equal(t, "/slow", events[1].Pattern)
+	assert.GreaterOrEqual(t, events[1].Elapsed, 10*time.Millisecond)
+}
+
+func TestMiddleware_OverrideAbandon(t *testing.T) {
+	cases := []struct {
+		name         string
+		opts         []fox.RouteOption
+		wantCanceled bool
+		wantBody     bool
+	}{
+		{name: "immediate", opts: []fox.RouteOption{OverrideAbandon(Immediate)}, wantCanceled: true, wantBody: true},
+		{name: "graceful", opts: []fox.RouteOption{OverrideAbandon(Graceful)}, wantCanceled: true},
+		{name: "never", opts: []fox.RouteOption{OverrideAbandon(Never)}},
+		{name: "immediate wins over lenient", opts: []fox.RouteOption{OverrideStrictness(Lenient), OverrideAbandon(Immediate)}, wantCanceled: true, wantBody: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50 * time.Microsecond)))
+			require.NoError(t, err)
+
+			type observation struct {
+				canceled bool
+				poisoned bool
+				writeErr error
+			}
+			observed := make(chan observation, 1)
+			f.MustAdd(fox.MethodPost, "/foo", func(c *fox.Context) {
+				time.Sleep(20 * time.Millisecond)
+				_, poisoned := c.Request().Body.(*poisonBody)
+				_, writeErr := c.Writer().Write([]byte("late"))
+				observed <- observation{
+					canceled: c.Request().Context().Err() != nil,
+					poisoned: poisoned,
+					writeErr: writeErr,
+				}
+			}, tc.opts...)
+
+			req := httptest.NewRequest(http.MethodPost, "/foo", strings.NewReader("hello"))
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+			obs := <-observed
+			assert.Equal(t, tc.wantCanceled, obs.canceled)
+			assert.Equal(t, tc.wantBody, obs.poisoned)
+			assert.ErrorIs(t, obs.writeErr, http.ErrHandlerTimeout)
+		})
+	}
+}
+
+func TestMiddleware_Redispatch(t *testing.T) {
+	cases := []struct {
+		name       string
+		opts       []Option
+		wantNested bool
+	}{
+		{name: "reuse deadline and writer"},
+		{name: "reentrant", opts: []Option{Reentrant()}, wantNested: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter()
+			require.NoError(t, err)
+
+			// Route.HandleMiddleware only applies the route-specific middleware.
+			mw := fox.WithMiddleware(Middleware(time.Second, tc.opts...))
+			nested := make(chan bool, 1)
+			f.MustAdd(fox.MethodGet, "/target", func(c *fox.Context) {
+				tw, ok := c.Writer().(*timeoutWriter)
+				require.True(t, ok)
+				_, ok = tw.w.(*timeoutWriter)
+				nested <- ok
+				_ = c.String(http.StatusOK, "target\n")
+			}, mw)
+			f.MustAdd(fox.MethodGet, "/redispatch", func(c *fox.Context) {
+				c.Router().Route(fox.MethodGet, "/target").HandleMiddleware(c)
+			}, mw)
+
+			req := httptest.NewRequest(http.MethodGet, "/redispatch", nil)
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "target\n", w.Body.String())
+			assert.Equal(t, tc.wantNested, <-nested)
+		})
+	}
+}
+
+func TestMiddleware_WithTraceRegions(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(1*time.Second, WithTraceRegions())))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/traced/{id}", success201response)
+	f.MustAdd(fox.MethodGet, "/untimed/{id}", success201response, OverrideHandler(NoTimeout))
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, trace.Start(buf))
+	for _, path := range []string{"/traced/1", "/untimed/1"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+	trace.Stop()
+
+	assert.Contains(t, buf.String(), "/traced/{id}")
+	assert.Contains(t, buf.String(), "/untimed/{id}")
+}
+
+func TestOuter(t *testing.T) {
+	slow := func(next fox.HandlerFunc) fox.HandlerFunc {
+		return func(c *fox.Context) {
+			time.Sleep(20 * time.Millisecond)
+			next(c)
+		}
+	}
+
+	t.Run("bounds the chain", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Outer(5*time.Millisecond), slow, Middleware(1*time.Second)))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/foo", success201response, OverrideHandler(NoTimeout))
+
+		req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("chain completes within budget", func(t *testing.T) {
+		f, err := fox.NewRouter(fox.WithMiddleware(Outer(1*time.Second), slow, Middleware(1*time.Second)))
+		require.NoError(t, err)
+		f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+		req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, fmt.Sprintf("%s\n", http.StatusText(http.StatusCreated)), w.Body.String())
+	})
+}
+
+func TestOuter_ElapsedAccounting(t *testing.T) {
+	var events []Event
+	sink := EventSinkFunc(func(ev Event) {
+		events = append(events, ev)
+	})
+
+	var entry time.Time
+	slow := func(next fox.HandlerFunc) fox.HandlerFunc {
+		return func(c *fox.Context) {
+			var ok bool
+			entry, ok = EntryTime(c)
+			assert.True(t, ok)
+			time.Sleep(20 * time.Millisecond)
+			next(c)
+		}
+	}
+
+	f, err := fox.NewRouter(fox.WithMiddleware(Outer(NoTimeout), slow, Middleware(50*time.Microsecond, WithEventSink(sink))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.False(t, entry.Before(start))
+	require.Len(t, events, 1)
+	assert.GreaterOrEqual(t, events[0].Total, events[0].Elapsed+20*time.Millisecond)
+}
+
+func TestPresets(t *testing.T) {
+	cases := []struct {
+		name        string
+		opts        []Option
+		contentType string
+		body        string
+	}{
+		{name: "api", opts: []Option{PresetAPI()}, contentType: fox.MIMEApplicationJSONCharsetUTF8, body: `{"error":"Service Unavailable"}`},
+		{name: "web", opts: []Option{PresetWeb()}, contentType: fox.MIMETextHTMLCharsetUTF8, body: "<h1>Service Unavailable</h1>"},
+		{name: "streaming", opts: []Option{PresetStreaming()}, contentType: "text/plain; charset=utf-8", body: "Service Unavailable"},
+		{name: "overridden", opts: []Option{PresetAPI(), WithResponse(DefaultResponse)}, contentType: "text/plain; charset=utf-8", body: "Service Unavailable"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter(fox.WithMiddleware(Middleware(50*time.Microsecond, tc.opts...)))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/foo", success201response)
+
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, tc.contentType, w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Body.String(), tc.body)
+		})
+	}
+}
+
+func TestPresets_Modes(t *testing.T) {
+	large := strings.Repeat("<p>page</p>", 200)
+	cases := []struct {
+		name      string
+		opts      []Option
+		streaming bool
+		encoding  string
+	}{
+		{name: "api", opts: []Option{WithStreaming(), PresetAPI()}},
+		{name: "web", opts: []Option{PresetWeb()}, encoding: "gzip"},
+		{name: "streaming", opts: []Option{PresetStreaming()}, streaming: true},
+		{name: "overridden", opts: []Option{PresetStreaming(), WithEnforcement(ConnDeadline)}, streaming: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tm := New(time.Second, tc.opts...)
+			assert.Equal(t, tc.streaming, tm.Snapshot().Streaming)
+			f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+			require.NoError(t, err)
+			f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+				_ = c.String(http.StatusOK, large)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.encoding, w.Header().Get("Content-Encoding"))
+		})
+	}
+
+	cfg := defaultConfig()
+	PresetStreaming().apply(cfg)
+	assert.Equal(t, 30*time.Second, cfg.idleWrite)
+	assert.Equal(t, Buffered, cfg.enforcement)
+}
+
+func TestMonotonic(t *testing.T) {
+	now := time.Now()
+	assert.True(t, monotonic(now))
+	assert.True(t, monotonic(roundUp(now, 10*time.Millisecond)))
+
+	// A wall clock reading, e.g. after a one hour NTP adjustment, has no monotonic reading.
+	skewed := now.Round(0).Add(-time.Hour)
+	assert.False(t, monotonic(skewed))
+	assert.False(t, monotonic(roundUp(skewed, 10*time.Millisecond)))
+}
+
+func ExampleOverrideHandler() {
+	f, err := fox.NewRouter(
+		fox.WithMiddleware(Middleware(2 * time.Second)),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	f.MustAdd(fox.MethodGet, "/hello/{name}", func(c *fox.Context) {
+		_ = c.String(http.StatusOK, fmt.Sprintf("hello %s\n", c.Param("name")))
+	})
+
+	f.MustAdd(fox.MethodGet, "/long", func(c *fox.Context) {
+		time.Sleep(10 * time.Second)
+		c.Writer().WriteHeader(http.StatusOK)
+	}, OverrideHandler(12*time.Second))
+
+	f.MustAdd(fox.MethodGet, "/no-timeout", func(c *fox.Context) {
+		c.Writer().WriteHeader(http.StatusOK)
+	}, OverrideHandler(NoTimeout))
+}
+
+func TestMiddleware_WithFlightRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flight")
+	rec, err := NewFlightRecorder(path, 3)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = rec.Close() })
+
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second, WithFlightRecorder(rec))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/ok", func(c *fox.Context) {})
+	f.MustAdd(fox.MethodGet, "/slow", success201response, OverrideHandler(time.Millisecond))
+
+	for _, path := range []string{"/ok", "/slow"} {
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	records, err := ReadFlightRecorder(path)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, RecordDone, records[0].Kind)
+	assert.Equal(t, "/ok", records[0].Pattern)
+	assert.Equal(t, RecordStart, records[1].Kind)
+	assert.Equal(t, RecordTimeout, records[2].Kind)
+	assert.Equal(t, "/slow", records[2].Pattern)
+	assert.Equal(t, uint64(2), records[0].Seq)
+	assert.Equal(t, records[1].Deadline, records[2].Deadline)
+	assert.False(t, records[2].Time.Before(records[2].Deadline))
+
+	require.NoError(t, os.WriteFile(path, []byte("foo"), 0o644))
+	_, err = ReadFlightRecorder(path)
+	assert.ErrorIs(t, err, ErrInvalidRecorder)
+}
+
+func TestDeadline(t *testing.T) {
+	deadlines := make(chan time.Time, 2)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second)))
+	require.NoError(t, err)
+	handler := func(c *fox.Context) {
+		deadline, ok := Deadline(c)
+		if ok {
+			deadlines <- deadline
+		}
+	}
+	f.MustAdd(fox.MethodGet, "/foo", handler)
+	f.MustAdd(fox.MethodGet, "/never", handler, OverrideAbandon(Never))
+	f.MustAdd(fox.MethodGet, "/none", handler, OverrideHandler(NoTimeout))
+
+	for _, path := range []string{"/foo", "/never", "/none"} {
+		before := time.Now()
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		if path == "/none" {
+			assert.Empty(t, deadlines)
+			continue
+		}
+		deadline := <-deadlines
+		assert.WithinDuration(t, before.Add(time.Second), deadline, 100*time.Millisecond)
+	}
+}
+
+// BenchmarkMiddleware measures the cost of the middleware for a route served without timeout, a handler completing
+// well before its deadline, and a handler timing out.
+func BenchmarkMiddleware(b *testing.B) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second)))
+	require.NoError(b, err)
+	ok := func(c *fox.Context) {
+		_ = c.String(http.StatusOK, "ok")
+	}
+	f.MustAdd(fox.MethodGet, "/passthrough", ok, OverrideHandler(NoTimeout))
+	f.MustAdd(fox.MethodGet, "/fast", ok)
+	f.MustAdd(fox.MethodGet, "/timeout", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	}, OverrideHandler(time.Microsecond))
+
+	for _, path := range []string{"/passthrough", "/fast", "/timeout"} {
+		b.Run(strings.TrimPrefix(path, "/"), func(b *testing.B) {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			b.ReportAllocs()
+			for b.Loop() {
+				f.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
+
+func TestMiddleware_RecycledWriter(t *testing.T) {
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Second)))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		assert.Empty(t, c.Writer().Header())
+		assert.Equal(t, http.StatusOK, c.Writer().Status())
+		assert.False(t, c.Writer().Written())
+		c.Writer().Header().Set("X-Foo", "bar")
+		_ = c.String(http.StatusCreated, "created")
+	})
+
+	for range 10 {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "bar", w.Header().Get("X-Foo"))
+		assert.Equal(t, "created", w.Body.String())
+	}
 }
@@ -0,0 +1,100 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// TimeoutProfile bundles the timeouts of the phases of a request, so that a timeout policy can be declared once,
+// named, and reused across the middleware and the route registrations, see [WithProfile] and [OverrideProfile]. A
+// zero field is unset, and leaves the corresponding timeout to the other options.
+type TimeoutProfile struct {
+	// Name identifies the profile in the diagnostic outputs, such as [RouteSettings].
+	Name string
+	// Handler is the handler timeout, as set with [OverrideHandler]. A negative value disables the timeout.
+	Handler time.Duration
+	// Read is the read deadline of the connection, as set with [OverrideRead] or [WithReadDeadline]. It must be
+	// positive if set.
+	Read time.Duration
+	// Write is the write deadline of the connection, as set with [OverrideWrite] or [WithWriteDeadline]. It must be
+	// positive if set, and not shorter than the handler timeout, see [ValidateRoute].
+	Write time.Duration
+	// FirstByte is the time allowed to the handler to start its response, as set with [WithFirstByteTimeout]. A
+	// negative value disables the first-byte timeout.
+	FirstByte time.Duration
+	// Body bounds the time taken to read the request body, as set with [OverrideBody] or [WithBodyTimeout]. A
+	// negative value disables the body timeout.
+	Body time.Duration
+}
+
+// OverrideProfile returns a RouteOption that applies the timeouts of p to a specific route, in place of the
+// [OverrideHandler], [OverrideRead], [OverrideWrite] and [OverrideBody] route options, and of the first-byte timeout
+// set with [WithFirstByteTimeout]. These route options take precedence over the profile, so that a route can reuse a
+// profile but adjust one of its timeouts. The profile is copied, and later changes to p do not affect the route.
+func OverrideProfile(p TimeoutProfile) fox.RouteOption {
+	return fox.WithAnnotation(xKey{}, p)
+}
+
+// WithProfile applies the timeouts of p to the middleware, as the [WithReadDeadline], [WithWriteDeadline],
+// [WithFirstByteTimeout] and [WithBodyTimeout] options would. The Handler timeout of p, if set, replaces the timeout
+// given to [Middleware] or [New]. The options applied after this one take precedence.
+func WithProfile(p TimeoutProfile) Option {
+	return optionFunc(func(c *config) {
+		if p.Handler != 0 {
+			c.profileDt = p.Handler
+		}
+		if p.Read != 0 {
+			c.readDeadline = max(p.Read, 0)
+		}
+		if p.Write != 0 {
+			c.writeDeadline = max(p.Write, 0)
+		}
+		if p.FirstByte != 0 {
+			c.firstByte = max(p.FirstByte, 0)
+		}
+		if p.Body != 0 {
+			c.bodyTimeout = max(p.Body, 0)
+		}
+	})
+}
+
+// profileTimeout returns the timeout of the profile set on r for the annotation key k, if any.
+func profileTimeout(r *fox.Route, k any) (time.Duration, bool) {
+	if r == nil {
+		return 0, false
+	}
+	p, ok := r.Annotation(xKey{}).(TimeoutProfile)
+	if !ok {
+		return 0, false
+	}
+	var dt time.Duration
+	switch k.(type) {
+	case hKey:
+		dt = p.Handler
+	case rKey:
+		dt = p.Read
+	case wKey:
+		dt = p.Write
+	case dKey:
+		dt = p.Body
+	case yKey:
+		dt = p.FirstByte
+	}
+	return dt, dt != 0
+}
+
+// firstByte returns the time allowed to the handler of the route to start its response, if any, see
+// WithFirstByteTimeout.
+func (t *Timeout) firstByte(c *fox.Context) time.Duration {
+	if !t.outer {
+		if dt, ok := profileTimeout(t.route(c.Route()), yKey{}); ok {
+			return max(dt, 0)
+		}
+	}
+	return t.cfg.firstByte
+}
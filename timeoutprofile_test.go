@@ -0,0 +1,75 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverrideProfile(t *testing.T) {
+	api := TimeoutProfile{
+		Name:      "api",
+		Handler:   time.Minute,
+		Write:     2 * time.Minute,
+		FirstByte: 20 * time.Millisecond,
+	}
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(time.Hour)))
+	require.NoError(t, err)
+	causes := make(chan error, 1)
+	stuck := f.MustAdd(fox.MethodGet, "/stuck", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+		causes <- context.Cause(c.Request().Context())
+	}, OverrideProfile(api))
+	tuned := f.MustAdd(fox.MethodGet, "/tuned", func(c *fox.Context) {}, OverrideProfile(api), OverrideHandler(time.Second))
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stuck", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	var de *DeadlineError
+	assert.ErrorAs(t, <-causes, &de)
+
+	dt, ok := unwrapRouteTimeout(stuck, hKey{})
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, dt)
+	dt, ok = unwrapRouteTimeout(tuned, hKey{})
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, dt)
+	_, ok = unwrapRouteTimeout(stuck, rKey{})
+	assert.False(t, ok)
+
+	// The write deadline of the profile is shorter than the handler timeout of the route.
+	assert.NoError(t, ValidateRoute(stuck))
+	_, err = Add(f, fox.MethodGet, "/slow", func(c *fox.Context) {}, OverrideProfile(api), OverrideHandler(time.Hour))
+	assert.ErrorIs(t, err, ErrInvalidRouteOption)
+
+	assert.Contains(t, RouteSettings(stuck), Setting{Option: "OverrideProfile", Value: "api handler=1m0s write=2m0s firstByte=20ms"})
+}
+
+func TestWithProfile(t *testing.T) {
+	tm := New(time.Second, WithProfile(TimeoutProfile{
+		Handler:   time.Minute,
+		Read:      time.Second,
+		FirstByte: -1,
+		Body:      3 * time.Second,
+	}), WithBodyTimeout(4*time.Second))
+	assert.Equal(t, time.Minute, time.Duration(tm.dt.Load()))
+	assert.Equal(t, time.Second, tm.cfg.readDeadline)
+	assert.Zero(t, tm.cfg.writeDeadline)
+	assert.Zero(t, tm.cfg.firstByte)
+	assert.Equal(t, 4*time.Second, tm.cfg.bodyTimeout)
+
+	tm = New(time.Second, WithProfile(TimeoutProfile{Name: "unset"}))
+	assert.Equal(t, time.Second, time.Duration(tm.dt.Load()))
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeouttest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fox-toolkit/timeout"
+)
+
+// Clock is a fake [timeout.Clock] whose time only moves forward when advanced, so that the timeout behavior of a
+// handler can be tested deterministically, without sleeping, see [timeout.WithClock]. It is safe for concurrent
+// use.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters map[*waiter]struct{}
+}
+
+// waiter is a timer or a context waiting for the clock to reach at.
+type waiter struct {
+	at   time.Time
+	fire func(now time.Time)
+}
+
+// NewClock returns a fake clock set to start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start, waiters: make(map[*waiter]struct{})}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, firing the timers and expiring the contexts whose deadline is reached.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var due []*waiter
+	for w := range c.waiters {
+		if !w.at.After(now) {
+			due = append(due, w)
+			delete(c.waiters, w)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, w := range due {
+		w.fire(now)
+	}
+}
+
+// Waiters returns the number of timers and contexts waiting for the clock to advance. Tests can poll it to know
+// when the code under test is blocked on the clock.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// NewTimer creates a new timer firing once the clock is advanced by at least d.
+func (c *Clock) NewTimer(d time.Duration) timeout.Timer {
+	ft := &fakeTimer{clock: c, ch: make(chan time.Time, 1)}
+	ft.Reset(d)
+	return ft
+}
+
+// WithDeadline returns a copy of parent that is canceled with [context.DeadlineExceeded] once the clock reaches
+// deadline.
+func (c *Clock) WithDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	return c.WithDeadlineCause(parent, deadline, nil)
+}
+
+// WithDeadlineCause is like [Clock.WithDeadline], but also sets the cause of the returned context once the clock
+// reaches deadline, as [context.WithDeadlineCause] does. A nil cause defaults to [context.DeadlineExceeded].
+func (c *Clock) WithDeadlineCause(parent context.Context, deadline time.Time, cause error) (context.Context, context.CancelFunc) {
+	if cause == nil {
+		cause = context.DeadlineExceeded
+	}
+	fc := &fakeContext{deadline: deadline, done: make(chan struct{})}
+	fc.values, fc.cancelCause = context.WithCancelCause(parent)
+	if pd, ok := parent.Deadline(); ok && pd.Before(deadline) {
+		fc.deadline = pd
+	}
+
+	w := &waiter{at: deadline, fire: func(time.Time) { fc.cancel(context.DeadlineExceeded, cause) }}
+	c.schedule(w)
+	stop := context.AfterFunc(parent, func() {
+		fc.cancel(parent.Err(), context.Cause(parent))
+	})
+	return fc, func() {
+		stop()
+		c.unschedule(w)
+		fc.cancel(context.Canceled, context.Canceled)
+	}
+}
+
+// schedule registers w, or fires it right away if its time is already reached.
+func (c *Clock) schedule(w *waiter) {
+	c.mu.Lock()
+	now := c.now
+	if w.at.After(now) {
+		c.waiters[w] = struct{}{}
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	w.fire(now)
+}
+
+// unschedule removes w, and reports whether it was still waiting.
+func (c *Clock) unschedule(w *waiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.waiters[w]
+	delete(c.waiters, w)
+	return ok
+}
+
+type fakeTimer struct {
+	clock *Clock
+	ch    chan time.Time
+	mu    sync.Mutex
+	w     *waiter
+}
+
+func (ft *fakeTimer) C() <-chan time.Time {
+	return ft.ch
+}
+
+func (ft *fakeTimer) Stop() bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.w == nil {
+		return false
+	}
+	ok := ft.clock.unschedule(ft.w)
+	ft.w = nil
+	return ok
+}
+
+func (ft *fakeTimer) Reset(d time.Duration) bool {
+	active := ft.Stop()
+	// Like a time.Timer, a stopped or reset timer does not deliver a stale value.
+	select {
+	case <-ft.ch:
+	default:
+	}
+
+	ft.mu.Lock()
+	w := &waiter{at: ft.clock.Now().Add(d), fire: func(now time.Time) {
+		select {
+		case ft.ch <- now:
+		default:
+		}
+	}}
+	ft.w = w
+	ft.mu.Unlock()
+	ft.clock.schedule(w)
+	return active
+}
+
+type fakeContext struct {
+	// values is a child of the parent context canceled with the cause just before done is closed, in which the
+	// values are looked up, so that context.Cause reports the cause.
+	values      context.Context
+	cancelCause context.CancelCauseFunc
+	deadline    time.Time
+	done        chan struct{}
+	mu          sync.Mutex
+	err         error
+}
+
+func (fc *fakeContext) Deadline() (time.Time, bool) {
+	return fc.deadline, true
+}
+
+func (fc *fakeContext) Done() <-chan struct{} {
+	return fc.done
+}
+
+func (fc *fakeContext) Err() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.err
+}
+
+func (fc *fakeContext) Value(key any) any {
+	return fc.values.Value(key)
+}
+
+func (fc *fakeContext) cancel(err, cause error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.err != nil {
+		return
+	}
+	fc.cancelCause(cause)
+	fc.err = err
+	close(fc.done)
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeouttest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/fox-toolkit/timeout"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock_Timer(t *testing.T) {
+	clock := NewClock(time.Unix(0, 0))
+	timer := clock.NewTimer(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, time.Unix(1, 0), <-timer.C())
+	assert.False(t, timer.Stop())
+
+	assert.False(t, timer.Reset(time.Second))
+	assert.True(t, timer.Stop())
+	clock.Advance(time.Hour)
+	select {
+	case <-timer.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+	assert.Zero(t, clock.Waiters())
+}
+
+func TestClock_WithDeadline(t *testing.T) {
+	clock := NewClock(time.Unix(0, 0))
+	ctx, cancel := clock.WithDeadline(context.Background(), time.Unix(10, 0))
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(10, 0), deadline)
+	assert.Equal(t, 1, clock.Waiters())
+
+	clock.Advance(9 * time.Second)
+	assert.NoError(t, ctx.Err())
+	clock.Advance(time.Second)
+	<-ctx.Done()
+	assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+
+	ctx, cancel = clock.WithDeadline(context.Background(), time.Unix(20, 0))
+	cancel()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	assert.Zero(t, clock.Waiters())
+}
+
+func TestClock_Middleware(t *testing.T) {
+	clock := NewClock(time.Unix(0, 0))
+	tm := timeout.New(time.Minute, timeout.WithClock(clock))
+	f, err := fox.NewRouter(fox.WithMiddleware(tm.Middleware()))
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {
+		close(started)
+		remaining, _ := timeout.Remaining(c)
+		assert.Equal(t, time.Minute, remaining)
+		<-c.Request().Context().Done()
+		assert.True(t, errors.Is(c.Request().Context().Err(), context.DeadlineExceeded))
+		assert.Equal(t, timeout.ErrTimeout, context.Cause(c.Request().Context()))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.ServeHTTP(w, req)
+	}()
+
+	<-started
+	clock.Advance(59 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("request timed out early")
+	default:
+	}
+	clock.Advance(time.Second)
+	<-done
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeouttest
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/fox-toolkit/timeout"
+)
+
+// CoverageReport lists every route registered with f along with its timeout policy, as set by the route options of
+// the timeout package (see [timeout.RouteSettings]), in a deterministic format suitable for golden-file tests, so
+// that the changes of the timeout policy show up in the diffs of code reviews. The routes are sorted by pattern,
+// methods and name, and each one is listed on a line holding its methods, pattern, name and number of matchers, if
+// any, followed by one indented line per route option, e.g.:
+//
+//	GET /users/{id} (name=user)
+//		OverrideHandler: 2s
+//		OverrideStrictness: strict
+//	POST /users
+//		default
+//
+// The routes with no route option use the policy of the middleware serving them, and are marked as "default".
+func CoverageReport(f *fox.Router) string {
+	type entry struct {
+		header   string
+		pattern  string
+		methods  string
+		name     string
+		settings []timeout.Setting
+	}
+	var entries []entry
+	for route := range f.Iter().All() {
+		methods := slices.Sorted(route.Methods())
+		e := entry{
+			pattern:  route.Pattern(),
+			methods:  strings.Join(methods, ","),
+			name:     route.Name(),
+			settings: timeout.RouteSettings(route),
+		}
+		var b strings.Builder
+		if e.methods != "" {
+			b.WriteString(e.methods + " ")
+		}
+		b.WriteString(e.pattern)
+		if e.name != "" {
+			b.WriteString(" (name=" + e.name + ")")
+		}
+		if n := route.MatchersLen(); n > 0 {
+			b.WriteString(" [" + strconv.Itoa(n) + " matchers]")
+		}
+		e.header = b.String()
+		entries = append(entries, e)
+	}
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		return cmp.Or(
+			cmp.Compare(a.pattern, b.pattern),
+			cmp.Compare(a.methods, b.methods),
+			cmp.Compare(a.name, b.name),
+			cmp.Compare(a.header, b.header),
+		)
+	})
+
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e.header + "\n")
+		if len(e.settings) == 0 {
+			b.WriteString("\tdefault\n")
+			continue
+		}
+		for _, s := range e.settings {
+			b.WriteString("\t" + s.Option + ": " + s.Value + "\n")
+		}
+	}
+	return b.String()
+}
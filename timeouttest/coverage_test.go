@@ -0,0 +1,49 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeouttest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/fox-toolkit/timeout"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoverageReport(t *testing.T) {
+	f, err := fox.NewRouter()
+	require.NoError(t, err)
+	noop := func(c *fox.Context) {}
+	f.MustAdd(fox.MethodGet, "/users/{id}", noop,
+		fox.WithName("user"),
+		timeout.OverrideHandler(2*time.Second),
+		timeout.OverrideStrictness(timeout.Strict),
+		timeout.OverrideMethod(http.MethodGet, time.Second),
+	)
+	f.MustAdd([]string{http.MethodPost, http.MethodPut}, "/users", noop, timeout.OverrideHandler(timeout.NoTimeout), timeout.OverrideMaxConcurrent(4, time.Second))
+	f.MustAdd(fox.MethodGet, "/health", noop)
+	f.MustAdd(fox.MethodGet, "/health", noop, fox.WithHeaderMatcher("X-Probe", "1"), timeout.OverrideResponse(noop))
+	f.MustAdd(fox.MethodGet, "/admin/*{any}", noop, timeout.Scope(5*time.Second), timeout.OverrideStreaming(true))
+
+	want := `GET /admin/*{any}
+	OverrideStreaming: true
+	Scope: 5s
+GET /health
+	default
+GET /health [1 matchers]
+	OverrideResponse: custom
+POST,PUT /users
+	OverrideHandler: none
+	OverrideMaxConcurrent: 4, queue 1s
+GET /users/{id} (name=user)
+	OverrideHandler: 2s
+	OverrideMethod(GET): 1s
+	OverrideStrictness: strict
+`
+	assert.Equal(t, want, CoverageReport(f))
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+// Package timeouttest provides utilities to test handlers served behind the timeout middleware.
+package timeouttest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// settle is how long the handler runs before its request context is canceled, so that it has a chance to
+// enter whatever blocking operation it performs.
+const settle = 10 * time.Millisecond
+
+// TestingT is the subset of [testing.TB] used by the assertions of this package.
+type TestingT interface {
+	Errorf(format string, args ...any)
+}
+
+type tHelper interface {
+	Helper()
+}
+
+// AssertCancels asserts that handler returns within the given duration after the deadline of its request context
+// is exceeded. It helps to prove that a handler is cancellation-aware before relying on the timeout middleware to
+// stop it. The handler is called with a GET request to "/" whose deadline expires shortly after the handler starts.
+// It returns whether the assertion succeeded.
+func AssertCancels(t TestingT, handler fox.HandlerFunc, within time.Duration) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), settle)
+	defer cancel()
+
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	c := fox.NewTestContextOnly(httptest.NewRecorder(), req)
+
+	done := make(chan any, 1)
+	go func() {
+		defer func() {
+			done <- recover()
+		}()
+		handler(c)
+	}()
+
+	select {
+	case p := <-done:
+		return checkPanic(t, p)
+	case <-ctx.Done():
+	}
+
+	timer := time.NewTimer(within)
+	defer timer.Stop()
+	select {
+	case p := <-done:
+		return checkPanic(t, p)
+	case <-timer.C:
+		t.Errorf("handler did not return within %s after its context was canceled", within)
+		return false
+	}
+}
+
+func checkPanic(t TestingT, p any) bool {
+	if p != nil {
+		t.Errorf("handler panicked: %v", p)
+		return false
+	}
+	return true
+}
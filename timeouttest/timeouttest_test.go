@@ -0,0 +1,68 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeouttest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockT struct {
+	errors []string
+}
+
+func (m *mockT) Errorf(format string, args ...any) {
+	m.errors = append(m.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertCancels(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler fox.HandlerFunc
+		want    bool
+	}{
+		{
+			name: "context aware",
+			handler: func(c *fox.Context) {
+				select {
+				case <-c.Request().Context().Done():
+				case <-time.After(time.Second):
+				}
+			},
+			want: true,
+		},
+		{
+			name:    "fast handler",
+			handler: func(c *fox.Context) {},
+			want:    true,
+		},
+		{
+			name: "ignores cancellation",
+			handler: func(c *fox.Context) {
+				time.Sleep(200 * time.Millisecond)
+			},
+			want: false,
+		},
+		{
+			name: "panic",
+			handler: func(c *fox.Context) {
+				panic("boom")
+			},
+			want: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := new(mockT)
+			assert.Equal(t, tc.want, AssertCancels(mt, tc.handler, 50*time.Millisecond))
+			assert.Equal(t, tc.want, len(mt.errors) == 0)
+		})
+	}
+}
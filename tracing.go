@@ -0,0 +1,84 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/fox-toolkit/timeout"
+
+// Span attribute keys set by the middleware configured with [WithTracing]. Durations are in seconds.
+const (
+	AttrTimeoutConfigured = attribute.Key("timeout.configured")
+	AttrTimeoutElapsed    = attribute.Key("timeout.elapsed")
+	AttrTimeoutOutcome    = attribute.Key("timeout.outcome")
+	// AttrTimeoutClamped is set to "min" or "max" when the configured timeout was clamped, see [WithMinTimeout] and
+	// [WithMaxTimeout].
+	AttrTimeoutClamped = attribute.Key("timeout.clamped")
+)
+
+// traceID returns the hex-encoded identifier of the trace of span if not nil, or else of the span context of ctx, or
+// an empty string if there is none.
+func traceID(ctx context.Context, span trace.Span) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if span != nil {
+		sc = span.SpanContext()
+	}
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// startSpan starts the span of the request served by c under the timeout dt, and installs its context as the
+// request context, so that the spans of the handler are children of it.
+func (t *Timeout) startSpan(c *fox.Context, pattern string, dt time.Duration, clamp Clamp) trace.Span {
+	req := c.Request()
+	attrs := []attribute.KeyValue{AttrTimeoutConfigured.Float64(dt.Seconds())}
+	if clamp != ClampNone {
+		attrs = append(attrs, AttrTimeoutClamped.String(clamp.String()))
+	}
+	ctx, span := t.cfg.tracer.Start(
+		req.Context(),
+		"timeout "+pattern,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attrs...),
+	)
+	c.SetRequest(req.WithContext(ctx))
+	return span
+}
+
+// endSpan records the outcome of the request on span and ends it.
+func endSpan(span trace.Span, o Outcome, elapsed time.Duration) {
+	span.SetAttributes(
+		AttrTimeoutElapsed.Float64(elapsed.Seconds()),
+		AttrTimeoutOutcome.String(o.String()),
+	)
+	if o == OutcomeTimedOut {
+		span.AddEvent("timeout", trace.WithAttributes(AttrTimeoutElapsed.Float64(elapsed.Seconds())))
+		span.SetStatus(codes.Error, http.ErrHandlerTimeout.Error())
+	}
+	span.End()
+}
+
+// endSpanPanic records on span that the handler panicked with p, which is about to be propagated, and ends it.
+func endSpanPanic(span trace.Span, p any, elapsed time.Duration) {
+	span.SetAttributes(
+		AttrTimeoutElapsed.Float64(elapsed.Seconds()),
+		AttrTimeoutOutcome.String(OutcomePanicked.String()),
+	)
+	span.AddEvent("panic", trace.WithAttributes(attribute.String("panic.value", fmt.Sprint(p))))
+	span.SetStatus(codes.Error, "handler panicked")
+	span.End()
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestMiddleware_WithTracing(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(20*time.Millisecond, WithTracing(tp))))
+	require.NoError(t, err)
+	parents := make(chan trace.SpanContext, 1)
+	f.MustAdd(fox.MethodGet, "/fast", func(c *fox.Context) {
+		parents <- trace.SpanContextFromContext(c.Request().Context())
+		_ = c.String(http.StatusOK, "ok")
+	})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+	f.MustAdd(fox.MethodGet, "/panic", func(c *fox.Context) {
+		panic("boom")
+	})
+
+	t.Run("completed", func(t *testing.T) {
+		exp.Reset()
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+		spans := exp.GetSpans().Snapshots()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "timeout /fast", spans[0].Name())
+		assert.Equal(t, spans[0].SpanContext().SpanID(), (<-parents).SpanID())
+		configured, ok := spanAttr(spans[0], AttrTimeoutConfigured)
+		require.True(t, ok)
+		assert.Equal(t, 0.02, configured.AsFloat64())
+		outcome, _ := spanAttr(spans[0], AttrTimeoutOutcome)
+		assert.Equal(t, "completed", outcome.AsString())
+		assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		exp.Reset()
+		f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		spans := exp.GetSpans().Snapshots()
+		require.Len(t, spans, 1)
+		elapsed, ok := spanAttr(spans[0], AttrTimeoutElapsed)
+		require.True(t, ok)
+		assert.GreaterOrEqual(t, elapsed.AsFloat64(), 0.02)
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+		assert.Equal(t, http.ErrHandlerTimeout.Error(), spans[0].Status().Description)
+		require.Len(t, spans[0].Events(), 1)
+		assert.Equal(t, "timeout", spans[0].Events()[0].Name)
+	})
+
+	t.Run("panicked", func(t *testing.T) {
+		exp.Reset()
+		assert.Panics(t, func() {
+			f.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+		})
+		spans := exp.GetSpans().Snapshots()
+		require.Len(t, spans, 1)
+		outcome, _ := spanAttr(spans[0], AttrTimeoutOutcome)
+		assert.Equal(t, "panicked", outcome.AsString())
+		require.Len(t, spans[0].Events(), 1)
+		assert.Equal(t, "panic", spans[0].Events()[0].Name)
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+	})
+}
+
+func TestObservation_TraceID(t *testing.T) {
+	observed := make(chan Observation, 2)
+	f, err := fox.NewRouter(fox.WithMiddleware(Middleware(10*time.Millisecond, WithMetrics(MetricsRecorderFunc(func(o Observation) {
+		observed <- o
+	})))))
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/fast", func(c *fox.Context) {})
+	f.MustAdd(fox.MethodGet, "/slow", func(c *fox.Context) {
+		<-c.Request().Context().Done()
+	})
+
+	tid := trace.TraceID{0x01, 0x02, 0x03}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: trace.SpanID{0x01}, TraceFlags: trace.FlagsSampled})
+	for _, path := range []string{"/fast", "/slow"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = req.WithContext(trace.ContextWithSpanContext(req.Context(), sc))
+		f.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Empty(t, (<-observed).TraceID)
+	assert.Equal(t, tid.String(), (<-observed).TraceID)
+}
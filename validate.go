@@ -0,0 +1,111 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fox-toolkit/fox"
+)
+
+// ErrInvalidRouteOption is returned by [ValidateRoute] when the route options of this package set on a route are
+// invalid, or do not make sense together.
+var ErrInvalidRouteOption = errors.New("timeout: invalid route option")
+
+// ValidateRoute checks the route options of this package set on r. It returns an error wrapping
+// [ErrInvalidRouteOption] if:
+//   - [OverrideRead] or [OverrideWrite] is set to a value <= 0, which would expire the deadline of the connection
+//     right away.
+//   - [OverrideWritePerMB] or [ObserveBudget] is set to a negative value.
+//   - [OverrideWrite] is set, but shorter than the timeout of the route, or the route has no timeout, so that
+//     the write deadline expires before the timeout response can be sent. A sliding write deadline set with
+//     [OverrideWriteIdle] takes precedence, so the write deadline is not checked in this case.
+//   - [OverrideHeartbeat] is set, but not shorter than the timeout of the route, or the route has no timeout.
+//   - [ObserveBudget] is set, but not shorter than the timeout of the route, so that it is never exceeded.
+//
+// The timeout of the route is the one set with [OverrideHandler] or [OverrideMethod] for each method of the route.
+// The combinations are not checked if the route has none, since the timeout of the middleware is not known. See
+// [Add] and [MustAdd] to validate a route at registration, and [ValidateRoutes] to validate the routes registered
+// elsewhere.
+func ValidateRoute(r *fox.Route) error {
+	write, hasWrite := unwrapRouteTimeout(r, wKey{})
+	if dt, ok := unwrapRouteTimeout(r, rKey{}); ok && dt <= 0 {
+		return fmt.Errorf("%w: read deadline must be positive, got %s", ErrInvalidRouteOption, dt)
+	}
+	if hasWrite && write <= 0 {
+		return fmt.Errorf("%w: write deadline must be positive, got %s", ErrInvalidRouteOption, write)
+	}
+	if dt, ok := unwrapRouteTimeout(r, pKey{}); ok && dt < 0 {
+		return fmt.Errorf("%w: write deadline per megabyte must not be negative, got %s", ErrInvalidRouteOption, dt)
+	}
+	budget, _ := unwrapRouteTimeout(r, bKey{})
+	if budget < 0 {
+		return fmt.Errorf("%w: budget must not be negative, got %s", ErrInvalidRouteOption, budget)
+	}
+	if idle, _ := unwrapRouteTimeout(r, iKey{}); idle > 0 {
+		// The write deadline is not applied.
+		hasWrite = false
+	}
+	heartbeat, _ := unwrapRouteTimeout(r, kKey{})
+
+	for method := range r.Methods() {
+		dt, ok := unwrapRouteTimeout(r, methodKey{method: method})
+		if !ok {
+			if dt, ok = unwrapRouteTimeout(r, hKey{}); !ok {
+				continue
+			}
+		}
+		switch {
+		case hasWrite && (dt <= 0 || write < dt):
+			return fmt.Errorf("%w: write deadline %s expires before the timeout response of %s requests can be sent",
+				ErrInvalidRouteOption, write, method)
+		case heartbeat > 0 && (dt <= 0 || heartbeat >= dt):
+			return fmt.Errorf("%w: heartbeat idle timeout %s is not shorter than the timeout of %s requests",
+				ErrInvalidRouteOption, heartbeat, method)
+		case budget > 0 && dt > 0 && budget >= dt:
+			return fmt.Errorf("%w: budget %s is not shorter than the timeout %s of %s requests",
+				ErrInvalidRouteOption, budget, dt, method)
+		}
+	}
+	return nil
+}
+
+// ValidateRoutes validates, as [ValidateRoute] does, all routes registered with f, and returns an error naming the
+// first invalid route, if any. It is intended to be called once all routes are registered, e.g. when they are
+// registered across many packages.
+func ValidateRoutes(f *fox.Router) error {
+	for route := range f.Iter().All() {
+		if err := ValidateRoute(route); err != nil {
+			return fmt.Errorf("route %q: %w", route.Pattern(), err)
+		}
+	}
+	return nil
+}
+
+// Add registers a new route with f, as [fox.Router.Add] does, but returns an error wrapping [ErrInvalidRouteOption]
+// if the route options of this package are invalid, see [ValidateRoute], in which case the route is not registered.
+func Add(f *fox.Router, methods []string, pattern string, handler fox.HandlerFunc, opts ...fox.RouteOption) (*fox.Route, error) {
+	route, err := f.NewRoute(methods, pattern, handler, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = ValidateRoute(route); err != nil {
+		return nil, err
+	}
+	if err = f.AddRoute(route); err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+// MustAdd is a convenience wrapper for [Add] that panics on error.
+func MustAdd(f *fox.Router, methods []string, pattern string, handler fox.HandlerFunc, opts ...fox.RouteOption) *fox.Route {
+	route, err := Add(f, methods, pattern, handler, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return route
+}
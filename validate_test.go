@@ -0,0 +1,80 @@
+// Copyright 2023 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/fox-toolkit/timeout/blob/master/LICENSE.txt.
+
+package timeout
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fox-toolkit/fox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRoute(t *testing.T) {
+	cases := []struct {
+		name    string
+		opts    []fox.RouteOption
+		wantErr bool
+	}{
+		{name: "no option"},
+		{name: "valid", opts: []fox.RouteOption{OverrideHandler(time.Second), OverrideWrite(2 * time.Second), OverrideRead(time.Second), OverrideHeartbeat(100 * time.Millisecond), ObserveBudget(500 * time.Millisecond)}},
+		{name: "zero read deadline", opts: []fox.RouteOption{OverrideRead(0)}, wantErr: true},
+		{name: "negative write deadline", opts: []fox.RouteOption{OverrideWrite(-time.Second)}, wantErr: true},
+		{name: "negative write deadline per megabyte", opts: []fox.RouteOption{OverrideWritePerMB(-time.Second)}, wantErr: true},
+		{name: "negative budget", opts: []fox.RouteOption{ObserveBudget(-time.Second)}, wantErr: true},
+		{name: "write deadline without timeout", opts: []fox.RouteOption{OverrideHandler(NoTimeout), OverrideWrite(time.Millisecond)}, wantErr: true},
+		{name: "write deadline shorter than timeout", opts: []fox.RouteOption{OverrideHandler(time.Second), OverrideWrite(time.Millisecond)}, wantErr: true},
+		{name: "write deadline shorter than method timeout", opts: []fox.RouteOption{OverrideMethod(http.MethodGet, time.Minute), OverrideWrite(time.Second)}, wantErr: true},
+		{name: "write deadline with idle write", opts: []fox.RouteOption{OverrideHandler(NoTimeout), OverrideWrite(time.Millisecond), OverrideWriteIdle(time.Second)}},
+		{name: "write deadline with global timeout", opts: []fox.RouteOption{OverrideWrite(time.Millisecond)}},
+		{name: "heartbeat not shorter than timeout", opts: []fox.RouteOption{OverrideHandler(time.Second), OverrideHeartbeat(time.Second)}, wantErr: true},
+		{name: "heartbeat without timeout", opts: []fox.RouteOption{OverrideHandler(NoTimeout), OverrideHeartbeat(time.Second)}, wantErr: true},
+		{name: "budget not shorter than timeout", opts: []fox.RouteOption{OverrideHandler(time.Second), ObserveBudget(2 * time.Second)}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fox.NewRouter()
+			require.NoError(t, err)
+			route, err := Add(f, []string{http.MethodGet}, "/foo", func(c *fox.Context) {}, tc.opts...)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidRouteOption)
+				assert.Nil(t, route)
+				assert.Nil(t, f.Route(fox.MethodGet, "/foo"))
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, f.Route(fox.MethodGet, "/foo"))
+		})
+	}
+}
+
+func TestMustAdd(t *testing.T) {
+	f, err := fox.NewRouter()
+	require.NoError(t, err)
+	assert.NotNil(t, MustAdd(f, fox.MethodGet, "/foo", func(c *fox.Context) {}, OverrideRead(time.Second)))
+	assert.PanicsWithError(t, "timeout: invalid route option: read deadline must be positive, got 0s", func() {
+		MustAdd(f, fox.MethodGet, "/bar", func(c *fox.Context) {}, OverrideRead(0))
+	})
+}
+
+func TestValidateRoutes(t *testing.T) {
+	f, err := fox.NewRouter()
+	require.NoError(t, err)
+	f.MustAdd(fox.MethodGet, "/foo", func(c *fox.Context) {}, OverrideHandler(time.Second))
+	require.NoError(t, ValidateRoutes(f))
+
+	f.MustAdd(fox.MethodGet, "/bar", func(c *fox.Context) {}, OverrideHandler(NoTimeout), OverrideWrite(time.Millisecond))
+	err = ValidateRoutes(f)
+	assert.ErrorIs(t, err, ErrInvalidRouteOption)
+	assert.ErrorContains(t, err, `route "/bar"`)
+
+	// Apply rejects the rules making a route invalid.
+	tm := New(time.Second)
+	err = tm.Apply(f, []Rule{{Pattern: "/foo", Options: []fox.RouteOption{OverrideWrite(time.Millisecond)}}})
+	assert.ErrorIs(t, err, ErrInvalidRouteOption)
+	assert.Nil(t, tm.route(f.Route(fox.MethodGet, "/foo")).Annotation(wKey{}))
+}
@@ -10,12 +10,16 @@ package timeout
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"io"
 	"log"
+	"maps"
 	"net"
 	"net/http"
 	"path"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fox-toolkit/fox"
@@ -30,20 +34,128 @@ var copyBufPool = sync.Pool{
 	},
 }
 
+// WriterCapabilities reports which optional features of a [fox.ResponseWriter] are available to a handler.
+type WriterCapabilities struct {
+	// Flush reports whether FlushError may succeed.
+	Flush bool
+	// Hijack reports whether Hijack may succeed.
+	Hijack bool
+	// Push reports whether Push may succeed.
+	Push bool
+	// ReadFrom reports whether ReadFrom is supported.
+	ReadFrom bool
+	// ReadDeadline reports whether SetReadDeadline may succeed.
+	ReadDeadline bool
+	// WriteDeadline reports whether SetWriteDeadline may succeed.
+	WriteDeadline bool
+	// FullDuplex reports whether EnableFullDuplex may succeed.
+	FullDuplex bool
+}
+
+// Capabilities reports which optional features of w survive the wrapping done by the middleware, so handlers can
+// feature-detect at runtime instead of relying on errors matching [http.ErrNotSupported]. Features reported as
+// available for a writer that is not managed by this middleware may still be unsupported by the underlying
+// connection.
+func Capabilities(w fox.ResponseWriter) WriterCapabilities {
+	if cw, ok := w.(interface{ capabilities() WriterCapabilities }); ok {
+		return cw.capabilities()
+	}
+	return WriterCapabilities{
+		Flush:         true,
+		Hijack:        true,
+		Push:          true,
+		ReadFrom:      true,
+		ReadDeadline:  true,
+		WriteDeadline: true,
+		FullDuplex:    true,
+	}
+}
+
 type onlyWrite struct {
 	io.Writer
 }
 
 type timeoutWriter struct {
 	w       fox.ResponseWriter
-	err     error
 	headers http.Header
 	req     *http.Request
 	buf     *bytes.Buffer
+	arb     Arbiter
 	code    int
-	mu      sync.RWMutex
 	written bool
-	n       int
+	// ctx is the context expiring at the deadline, writes going through to w in streaming mode fail once it is done.
+	ctx context.Context
+	// stream enables the streaming mode, where writes go through to w.
+	stream bool
+	// sent reports whether the status and headers are sent to w, in streaming mode or with FlushHeaders.
+	sent bool
+	// early reports whether the status and headers were sent ahead of the buffered body, and trailer is the name of
+	// the trailer then declared to mark a truncated body, see FlushHeaders.
+	early   bool
+	trailer string
+	// budget reports whether the budget trailer was declared along with the headers in streaming mode, see
+	// WithBudgetTrailer.
+	budget bool
+	// wdeadline is the write deadline of the connection set for the route or by the handler, if any, and bounded
+	// reports whether a streamed write capped it to the handler deadline, see boundLocked.
+	wdeadline time.Time
+	bounded   bool
+	n         int
+	// limit is the limit of the buffered response, see WithMaxResponseBuffer.
+	limit *responseLimit
+	// spill holds the buffered response once it exceeded the limit, see OverflowSpill.
+	spill *spillFile
+	// spillDir is the directory of the spill file, see WithSpillDir.
+	spillDir string
+	// quota reserves the disk space of the spill file, see WithSpillQuota and OverrideSpillQuota.
+	quota *spillQuota
+	// sites holds the call sites writing after the handler timed out, and late reports whether a write of this
+	// request is already attributed, see LateWrites.
+	sites *sync.Map
+	late  atomic.Bool
+	// array is the JSON array written by the handler, if any, see JSONArray.
+	array *JSONArray
+	// dt is the handler timeout, and through reports whether the handler took over the response, see ServeContent.
+	dt      time.Duration
+	through bool
+	// t is the middleware serving the request, started at start for the route of the given pattern, see
+	// TimeoutWriteError.
+	t       *Timeout
+	start   time.Time
+	pattern string
+	// labels is the context holding the profiler labels of the handler goroutine, see WithProfileLabels.
+	labels context.Context
+	// cont is the body of a request expecting a 100 Continue interim response, if any, see WithContinueTimeout.
+	cont *continueBody
+	// exit receives the value recovered from the handler goroutine once it exits, nil if the handler returned.
+	exit chan any
+}
+
+// writerPool holds the timeoutWriter, with their header map and exit channel, of the handlers known to have returned.
+var writerPool = sync.Pool{
+	New: func() any {
+		return &timeoutWriter{headers: make(http.Header), exit: make(chan any, 1)}
+	},
+}
+
+// putWriter returns tw to the pool, keeping only its emptied header map and its exit channel. The handler writing to
+// tw must have returned, and its exit must have been received.
+func putWriter(tw *timeoutWriter) {
+	headers, exit := tw.headers, tw.exit
+	clear(headers)
+	*tw = timeoutWriter{headers: headers, exit: exit}
+	writerPool.Put(tw)
+}
+
+func (tw *timeoutWriter) capabilities() WriterCapabilities {
+	return WriterCapabilities{
+		Flush:         tw.stream,
+		Push:          true,
+		ReadFrom:      true,
+		ReadDeadline:  true,
+		WriteDeadline: true,
+		FullDuplex:    true,
+	}
 }
 
 func (tw *timeoutWriter) Status() int {
@@ -59,16 +171,33 @@ func (tw *timeoutWriter) Size() int {
 }
 
 func (tw *timeoutWriter) WriteString(s string) (int, error) {
-	tw.mu.Lock()
-	defer tw.mu.Unlock()
-	if tw.err != nil {
-		return 0, tw.err
+	if err := tw.arb.Acquire(); err != nil {
+		return 0, tw.lateWrite(err)
 	}
+	defer tw.arb.Release()
 	if !tw.written {
 		tw.writeHeaderLocked(http.StatusOK)
 	}
+	if !tw.stream {
+		if err := tw.overflowLocked(len(s)); err != nil {
+			return 0, err
+		}
+	}
 
-	n, err := io.WriteString(tw.buf, s)
+	var (
+		n   int
+		err error
+	)
+	if tw.stream {
+		if err = tw.expired(); err != nil {
+			return 0, tw.lateWrite(err)
+		}
+		tw.boundLocked()
+		tw.sendHeaderLocked()
+		n, err = io.WriteString(tw.w, s)
+	} else {
+		n, err = io.WriteString(tw.bufferLocked(), s)
+	}
 	tw.n += n
 	return n, err
 }
@@ -82,25 +211,61 @@ func (tw *timeoutWriter) Header() http.Header {
 }
 
 func (tw *timeoutWriter) Write(p []byte) (int, error) {
-	tw.mu.Lock()
-	defer tw.mu.Unlock()
-	if tw.err != nil {
-		return 0, tw.err
+	if err := tw.arb.Acquire(); err != nil {
+		return 0, tw.lateWrite(err)
 	}
+	defer tw.arb.Release()
+	return tw.writeLocked(p)
+}
+
+// writeLocked writes p to the client in streaming mode, or to the buffer otherwise. The caller must hold the
+// arbiter.
+func (tw *timeoutWriter) writeLocked(p []byte) (int, error) {
 	if !tw.written {
 		tw.writeHeaderLocked(http.StatusOK)
 	}
+	if !tw.stream {
+		if err := tw.overflowLocked(len(p)); err != nil {
+			return 0, err
+		}
+	}
 
-	n, err := tw.buf.Write(p)
+	var (
+		n   int
+		err error
+	)
+	if tw.stream {
+		if err = tw.expired(); err != nil {
+			return 0, tw.lateWrite(err)
+		}
+		tw.boundLocked()
+		tw.sendHeaderLocked()
+		n, err = tw.w.Write(p)
+	} else {
+		n, err = tw.bufferLocked().Write(p)
+	}
 	tw.n += n
 	return n, err
 }
 
+// sendHeaderLocked sends the status and headers to the underlying writer, once, in streaming mode.
+func (tw *timeoutWriter) sendHeaderLocked() {
+	if tw.sent {
+		return
+	}
+	tw.sent = true
+	dst := tw.w.Header()
+	maps.Copy(dst, tw.headers)
+	if tw.stream && tw.t != nil {
+		// The trailer must be declared before the first write of the streamed body.
+		tw.budget = tw.t.declareBudgetTrailer(tw.req, dst)
+	}
+	tw.w.WriteHeader(tw.code)
+}
+
 func (tw *timeoutWriter) writeHeaderLocked(code int) {
 	checkWriteHeaderCode(code)
 	switch {
-	case tw.err != nil:
-		return
 	case tw.written:
 		caller := relevantCaller()
 		log.Printf("http: superfluous response.WriteHeader call from %s (%s:%d)", caller.Function, path.Base(caller.File), caller.Line)
@@ -111,11 +276,37 @@ func (tw *timeoutWriter) writeHeaderLocked(code int) {
 }
 
 func (tw *timeoutWriter) WriteHeader(code int) {
-	tw.mu.Lock()
-	defer tw.mu.Unlock()
+	if err := tw.arb.Acquire(); err != nil {
+		checkWriteHeaderCode(code)
+		_ = tw.lateWrite(err)
+		return
+	}
+	defer tw.arb.Release()
+	if informational(code) && !tw.written {
+		tw.writeInterimLocked(code)
+		return
+	}
 	tw.writeHeaderLocked(code)
 }
 
+// informational reports whether code is the status code of an interim response, such as 100 Continue or 103 Early
+// Hints, which can be sent any number of times ahead of the final response. 101 Switching Protocols is a final
+// response.
+func informational(code int) bool {
+	return code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols
+}
+
+// writeInterimLocked sends an interim response to the client right away, with the headers set so far, rather than
+// buffering it as the final response. Since the server only sends 100 Continue to a client expecting it, and before
+// the body is read, the handler may send it explicitly to start the body-start window, see WithContinueTimeout.
+func (tw *timeoutWriter) writeInterimLocked(code int) {
+	maps.Copy(tw.w.Header(), tw.headers)
+	tw.w.WriteHeader(code)
+	if code == http.StatusContinue && tw.cont != nil {
+		tw.cont.arm()
+	}
+}
+
 func (tw *timeoutWriter) ReadFrom(src io.Reader) (n int64, err error) {
 	bufPtr := copyBufPool.Get().(*[]byte)
 	buf := *bufPtr
@@ -126,21 +317,216 @@ func (tw *timeoutWriter) ReadFrom(src io.Reader) (n int64, err error) {
 }
 
 func (tw *timeoutWriter) FlushError() error {
-	return fox.ErrNotSupported()
+	if !tw.stream {
+		return fox.ErrNotSupported()
+	}
+	if err := tw.arb.Acquire(); err != nil {
+		return tw.lateWrite(err)
+	}
+	defer tw.arb.Release()
+	if err := tw.expired(); err != nil {
+		return tw.lateWrite(err)
+	}
+	if !tw.written {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	tw.boundLocked()
+	tw.sendHeaderLocked()
+	return tw.w.FlushError()
+}
+
+// boundLocked caps the write deadline of the connection to the handler deadline before a streamed write, so that a
+// client not reading the response cannot block the write, and with it the timeout waiting on the arbiter, past the
+// deadline. An earlier write deadline, set for the route or by the handler, is kept. The caller must hold the
+// arbiter.
+func (tw *timeoutWriter) boundLocked() {
+	d, ok := tw.ctx.Deadline()
+	if !ok || tw.t == nil {
+		return
+	}
+	// The handler deadline follows the clock of the middleware, while the connection deadlines are wall clock times.
+	deadline := time.Now().Add(tw.t.until(d))
+	if !tw.wdeadline.IsZero() && tw.wdeadline.Before(deadline) {
+		deadline = tw.wdeadline
+	}
+	first := !tw.bounded
+	tw.bounded = true
+	// The writers deriving the write deadline from the response must not postpone it past the limit.
+	switch w := tw.w.(type) {
+	case *slidingWriter:
+		w.limit = deadline
+	case *sizedWriter:
+		w.limit = deadline
+		if first {
+			// The deadline derived from the size of the response, if known, is set along with the headers.
+			_ = w.ResponseWriter.SetWriteDeadline(deadline)
+		}
+	default:
+		// Errors are ignored for the same reason as in setDeadline.
+		_ = tw.w.SetWriteDeadline(deadline)
+	}
+}
+
+// unbound gives the writes ending a streamed response past the handler deadline, such as the end of a truncated JSON
+// array or the last chunk written by the server, the write deadline of the safe response, since the write deadline
+// of the connection was capped to the handler deadline, see boundLocked.
+func (tw *timeoutWriter) unbound() {
+	if !tw.bounded || tw.ctx.Err() == nil {
+		return
+	}
+	// Errors are ignored for the same reason as in setDeadline.
+	_ = tw.w.SetWriteDeadline(time.Now().Add(tw.t.cfg.safeDeadline))
+}
+
+// expired returns a non-nil error if the deadline is exceeded or the request canceled, even though the arbiter may
+// not be settled yet. This prevents a handler from starting a streamed response while the timeout is being handled.
+func (tw *timeoutWriter) expired() error {
+	if err := tw.ctx.Err(); err != nil {
+		return handlerErr(err)
+	}
+	return nil
 }
 
 func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, fox.ErrNotSupported()
 }
 
-func (tw *timeoutWriter) SetReadDeadline(_ time.Time) error {
-	return fox.ErrNotSupported()
+// SetReadDeadline sets the read deadline of the underlying connection, as long as the handler did not time out, so
+// that handlers using [http.ResponseController] for per-request deadlines keep working under the middleware.
+func (tw *timeoutWriter) SetReadDeadline(deadline time.Time) error {
+	if err := tw.arb.Acquire(); err != nil {
+		return err
+	}
+	defer tw.arb.Release()
+	return tw.w.SetReadDeadline(deadline)
 }
 
-func (tw *timeoutWriter) SetWriteDeadline(_ time.Time) error {
-	return fox.ErrNotSupported()
+// SetWriteDeadline sets the write deadline of the underlying connection, as long as the handler did not time out.
+// For a buffered response, the deadline bounds the write of the response once the handler is done.
+func (tw *timeoutWriter) SetWriteDeadline(deadline time.Time) error {
+	if err := tw.arb.Acquire(); err != nil {
+		return err
+	}
+	defer tw.arb.Release()
+	tw.wdeadline = deadline
+	return tw.w.SetWriteDeadline(deadline)
 }
 
+// EnableFullDuplex enables the full duplex mode of the underlying connection, as long as the handler did not time
+// out.
 func (tw *timeoutWriter) EnableFullDuplex() error {
-	return fox.ErrNotSupported()
+	if err := tw.arb.Acquire(); err != nil {
+		return err
+	}
+	defer tw.arb.Release()
+	return tw.w.EnableFullDuplex()
+}
+
+// slidingChunk is the size of the chunks a write is split into by slidingWriter, so that a large write making steady
+// progress is not cut off.
+const slidingChunk = 32 * 1024
+
+// slidingWriter is a fox.ResponseWriter advancing the write deadline of the connection before each write, so that
+// only a client that stalls for longer than idle is cut off. The deadline never goes past limit, if not zero.
+type slidingWriter struct {
+	fox.ResponseWriter
+	limit time.Time
+	idle  time.Duration
+}
+
+// advance sets the write deadline to idle from now. Errors are ignored for the same reason as in setDeadline.
+func (w *slidingWriter) advance() {
+	deadline := time.Now().Add(w.idle)
+	if !w.limit.IsZero() && deadline.After(w.limit) {
+		deadline = w.limit
+	}
+	_ = w.ResponseWriter.SetWriteDeadline(deadline)
+}
+
+func (w *slidingWriter) Write(p []byte) (int, error) {
+	var n int
+	for {
+		chunk := p[:min(len(p), slidingChunk)]
+		w.advance()
+		nn, err := w.ResponseWriter.Write(chunk)
+		n += nn
+		if err != nil {
+			return n, err
+		}
+		p = p[len(chunk):]
+		if len(p) == 0 {
+			return n, nil
+		}
+	}
+}
+
+func (w *slidingWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *slidingWriter) ReadFrom(src io.Reader) (int64, error) {
+	bufPtr := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufPtr)
+	return io.CopyBuffer(onlyWrite{w}, src, *bufPtr)
+}
+
+func (w *slidingWriter) FlushError() error {
+	w.advance()
+	return w.ResponseWriter.FlushError()
+}
+
+// sizedWriter is a fox.ResponseWriter setting the write deadline of the connection from the size of the response,
+// allowing perMB for each started megabyte. The size is read from the Content-Length header when the header is
+// written or, if whole is set, from the first write, which is then the whole response. The deadline never goes past
+// limit, if not zero.
+type sizedWriter struct {
+	fox.ResponseWriter
+	limit time.Time
+	perMB time.Duration
+	whole bool
+	// pending reports whether the header is written, but the size is unknown.
+	pending bool
+}
+
+// setDeadline sets the write deadline for a response of size bytes. Errors are ignored for the same reason as in
+// setDeadline.
+func (w *sizedWriter) setDeadline(size int64) {
+	mb := max((size+(1<<20)-1)>>20, 1)
+	deadline := time.Now().Add(time.Duration(mb) * w.perMB)
+	if !w.limit.IsZero() && deadline.After(w.limit) {
+		deadline = w.limit
+	}
+	_ = w.ResponseWriter.SetWriteDeadline(deadline)
+}
+
+func (w *sizedWriter) WriteHeader(code int) {
+	if !w.Written() {
+		if size, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && size >= 0 {
+			w.setDeadline(size)
+		} else {
+			w.pending = w.whole
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sizedWriter) Write(p []byte) (int, error) {
+	if !w.Written() {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.pending {
+		w.pending = false
+		w.setDeadline(int64(len(p)))
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *sizedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *sizedWriter) ReadFrom(src io.Reader) (int64, error) {
+	bufPtr := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufPtr)
+	return io.CopyBuffer(onlyWrite{w}, src, *bufPtr)
 }